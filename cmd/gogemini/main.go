@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime/debug"
@@ -17,12 +21,17 @@ import (
 	"github.com/ubuygold/gogemini/internal/admin"
 	"github.com/ubuygold/gogemini/internal/auth"
 	"github.com/ubuygold/gogemini/internal/balancer"
+	"github.com/ubuygold/gogemini/internal/concurrency"
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
+	"github.com/ubuygold/gogemini/internal/httpclient"
 	"github.com/ubuygold/gogemini/internal/keymanager"
 	"github.com/ubuygold/gogemini/internal/logger"
+	"github.com/ubuygold/gogemini/internal/model"
 	"github.com/ubuygold/gogemini/internal/proxy"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 	"github.com/ubuygold/gogemini/internal/scheduler"
+	"github.com/ubuygold/gogemini/internal/tracing"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,6 +42,42 @@ var indexHTML []byte
 
 var newDBService = db.NewService
 
+// listenAndServe and listenAndServeTLS start the HTTP server; they are variables so tests can observe which is used.
+var (
+	listenAndServe    = (*http.Server).ListenAndServe
+	listenAndServeTLS = (*http.Server).ListenAndServeTLS
+)
+
+// serve starts the server over HTTPS when a certificate is configured, and plain HTTP otherwise.
+// It listens on unixSocket when set, and on server.Addr otherwise.
+func serve(server *http.Server, unixSocket string, tlsCfg config.TLSConfig) error {
+	if unixSocket != "" {
+		listener, err := listenUnix(unixSocket)
+		if err != nil {
+			return err
+		}
+		if tlsCfg.Enabled() {
+			return server.ServeTLS(listener, tlsCfg.CertFile, tlsCfg.KeyFile)
+		}
+		return server.Serve(listener)
+	}
+	if tlsCfg.Enabled() {
+		return listenAndServeTLS(server, tlsCfg.CertFile, tlsCfg.KeyFile)
+	}
+	return listenAndServe(server)
+}
+
+// listenUnix listens on the Unix domain socket at path, replacing a socket left behind by a
+// previous run. The socket file is removed again when the listener is closed on shutdown.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale unix socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
 // customRecovery is a middleware that recovers from panics and handles http.ErrAbortHandler gracefully.
 func customRecovery(log *slog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -56,6 +101,219 @@ func customRecovery(log *slog.Logger) gin.HandlerFunc {
 	}
 }
 
+// requestIDHeader is the header used to receive and echo request IDs.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMiddleware assigns every request an ID, honoring one supplied by the client,
+// and stores it in both the gin context and the request context for log correlation.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		c.Set("request_id", requestID)
+		c.Request = c.Request.WithContext(logger.ContextWithRequestID(c.Request.Context(), requestID))
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// accessLogMiddleware logs every request once it has been served. The Gemini key suffix is
+// filled in by the proxy handlers through the requestlog entry placed in the request context.
+func accessLogMiddleware(log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		entry := &requestlog.Entry{}
+		c.Request = c.Request.WithContext(requestlog.WithEntry(c.Request.Context(), entry))
+
+		c.Next()
+
+		clientKeySuffix := ""
+		if apiKey, ok := c.Get(auth.APIKeyContextKey); ok {
+			if k, ok := apiKey.(*model.APIKey); ok {
+				clientKeySuffix = safeKeySuffix(k.Key)
+			}
+		}
+		logger.FromContext(c.Request.Context(), log).Info("Request served",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"bytes", max(c.Writer.Size(), 0),
+			"client_ip", c.ClientIP(),
+			"client_key_suffix", clientKeySuffix,
+			"gemini_key_suffix", entry.KeySuffix,
+		)
+	}
+}
+
+// debugLogMiddleware is gin's request logger for debug mode, in gin's default format without
+// colors. gin.Logger records the query string before AuthMiddleware removes a client key from it,
+// so the query parameters of the given client key sources are redacted here instead.
+func debugLogMiddleware(sources []string) gin.HandlerFunc {
+	var queryKeys []string
+	for _, entry := range sources {
+		if source, err := config.ParseKeySource(entry); err == nil && source.Kind == config.KeySourceQuery {
+			queryKeys = append(queryKeys, source.Name)
+		}
+	}
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			redactQuery(param.Path, queryKeys),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// redactQuery replaces the values of the named query parameters in a path with query string.
+// A query string that cannot be parsed is dropped, as it may hold a key.
+func redactQuery(path string, names []string) string {
+	base, rawQuery, found := strings.Cut(path, "?")
+	if !found || len(names) == 0 {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return base + "?REDACTED"
+	}
+	redacted := false
+	for _, name := range names {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return path
+	}
+	return base + "?" + query.Encode()
+}
+
+// safeKeySuffix returns the last 4 characters of a key, or the full key if it's shorter.
+func safeKeySuffix(key string) string {
+	if len(key) > 4 {
+		return key[len(key)-4:]
+	}
+	return key
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers preflight requests
+// before they reach authentication, since browsers send preflights without credentials.
+func corsMiddleware(cfg config.CORSConfig) gin.HandlerFunc {
+	allowAll := false
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowAll && !allowed[origin] {
+			if preflight {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// The origin is echoed rather than "*" so that credentialed requests are accepted.
+		c.Header("Access-Control-Allow-Origin", origin)
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Header("Access-Control-Expose-Headers", requestIDHeader)
+		c.Next()
+	}
+}
+
+// requestTimeoutBody is the response to requests cut off by server.request_timeout.
+const requestTimeoutBody = `{"error":"Request timed out"}`
+
+// withRequestTimeout bounds requests to the timeout server.request_timeout sets for their path,
+// answering 503 when it is exceeded. The handler's request context is cancelled at the deadline.
+// Responses are buffered until the handler returns, so paths that stream must be exempted
+// through server.request_timeout_overrides; requests without a timeout go straight to h.
+func withRequestTimeout(h http.Handler, cfg config.ServerConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := cfg.RequestTimeoutFor(r.URL.Path)
+		if timeout <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// Headers the handler sets replace this one; it only describes requestTimeoutBody.
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		http.TimeoutHandler(h, timeout, requestTimeoutBody).ServeHTTP(w, r)
+	})
+}
+
+// newRequestID returns a random 16-byte hex-encoded identifier.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// seedGeminiKeys adds the configured Gemini keys to the database with the given status.
+// BatchAddGeminiKeys skips keys that are already stored, so seeding is safe to repeat on every start.
+func seedGeminiKeys(dbService db.Service, keys []string, status string, log *slog.Logger) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	added, err := dbService.BatchAddGeminiKeys(keys, status)
+	if err != nil {
+		return fmt.Errorf("failed to seed gemini keys: %w", err)
+	}
+	log.Info("Seeded Gemini keys from configuration", "added", added, "configured", len(keys))
+	return nil
+}
+
+// setTrustedProxies makes the router honour X-Forwarded-For only from the configured reverse
+// proxies when resolving client IPs. Without configuration no proxy is trusted, rather than
+// gin's default of trusting every peer, which would let clients pick their own IP.
+func setTrustedProxies(router *gin.Engine, cfg *config.Config) error {
+	return router.SetTrustedProxies(cfg.Access.TrustedProxies)
+}
+
+// readyzHandler reports whether the server can serve traffic, which requires a reachable database.
+// The probe is unauthenticated, so the database error is logged rather than returned.
+func readyzHandler(dbHealth interface{ Err() error }, log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := dbHealth.Err(); err != nil {
+			log.Warn("Readiness check failed, database unavailable", "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "database": "unavailable"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "database": "ok"})
+	}
+}
+
 func setupAndRunServer(cfg *config.Config, log *slog.Logger, dbService db.Service) error {
 	var err error
 	indexHTML, err = webUI.ReadFile("dist/index.html")
@@ -64,53 +322,105 @@ func setupAndRunServer(cfg *config.Config, log *slog.Logger, dbService db.Servic
 		return err
 	}
 
+	// Tracing stays a no-op unless enabled in the config.
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Error("Error setting up tracing", "error", err)
+		return err
+	}
+	if cfg.Tracing.Enabled {
+		log.Info("OpenTelemetry tracing enabled", "service_name", cfg.Tracing.ServiceName)
+	}
+
+	// All upstream requests share a single connection pool.
+	upstreamTransport := httpclient.NewTransport(cfg.Upstream)
+
+	// Seed configured Gemini keys before the KeyManager loads them.
+	if err := seedGeminiKeys(dbService, cfg.GeminiKeys, cfg.Proxy.NewKeyDefaultStatus, log); err != nil {
+		log.Error("Error seeding Gemini keys", "error", err)
+		return err
+	}
+
 	// Initialize the central KeyManager
-	keyManager, err := keymanager.NewKeyManager(dbService, cfg, log)
+	keyManager, err := keymanager.NewKeyManager(dbService, cfg, upstreamTransport, log)
 	if err != nil {
 		log.Error("Error creating KeyManager", "error", err)
 		return err
 	}
 
+	// Watch the database connection so /readyz reports outages and reconnects are retried with backoff.
+	healthInterval := cfg.Database.HealthCheckInterval
+	if healthInterval <= 0 {
+		healthInterval = config.DefaultDBHealthCheckInterval
+	}
+	dbHealth := db.NewHealthChecker(dbService, healthInterval, log)
+	dbHealth.Start()
+	defer dbHealth.Stop()
+
 	// Start the scheduler
 	s := scheduler.NewScheduler(dbService, cfg, keyManager)
 	s.Start()
 	log.Info("Scheduler started")
 
 	// Create the new SDK-based handler for Gemini
-	geminiHandler, err := balancer.NewBalancer(keyManager, log)
+	geminiHandler, err := balancer.NewBalancer(keyManager, cfg, upstreamTransport, log)
 	if err != nil {
 		log.Error("Error creating Gemini handler", "error", err)
 		return err
 	}
 
 	// Create the new reverse proxy for OpenAI
-	openaiProxy, err := proxy.NewOpenAIProxy(keyManager, cfg, log)
+	openaiProxy, err := proxy.NewOpenAIProxy(keyManager, cfg, upstreamTransport, log)
 	if err != nil {
 		log.Error("Error creating OpenAI proxy", "error", err)
 		return err
 	}
 
+	// Keep the most recent proxied requests for the admin API.
+	requestLog := requestlog.NewBuffer(cfg.Proxy.RequestLogSize)
+	geminiHandler.RequestLog = requestLog
+	openaiProxy.RequestLog = requestLog
+
 	// Create a Gin router
 	router := gin.New()
 	router.RedirectTrailingSlash = false
+	if err := setTrustedProxies(router, cfg); err != nil {
+		log.Error("Invalid trusted proxies", "error", err)
+		return err
+	}
 	// Use our custom recovery middleware instead of the default one.
 	router.Use(customRecovery(log))
+	router.Use(requestIDMiddleware())
+	if cfg.Logging.AccessLogEnabled() {
+		router.Use(accessLogMiddleware(log))
+	}
+	if cfg.CORS.Enabled {
+		router.Use(corsMiddleware(cfg.CORS))
+	}
 
 	// If debug mode is enabled, add the logger middleware
 	if cfg.Debug {
-		// This uses the default gin logger, which is fine for development.
-		router.Use(gin.Logger())
+		router.Use(debugLogMiddleware(cfg.Access.KeySources()))
 	}
 
+	// Build information and readiness for ops; served without authentication.
+	router.GET("/version", versionHandler)
+	router.GET("/readyz", readyzHandler(dbHealth, log))
+
 	// Setup admin routes
-	admin.SetupRoutes(router, dbService, keyManager, cfg)
+	admin.SetupRoutes(router, dbService, keyManager, s, requestLog, cfg)
+
+	// Restrict the proxy endpoints to access.proxy_allow_cidrs.
+	proxyAccess := auth.IPAllowMiddleware(cfg.Access.ProxyAllowCIDRs)
+	// Queue authenticated proxy requests beyond proxy.max_concurrent_requests, shared by all endpoints.
+	proxyLimit := concurrency.Middleware(concurrency.NewLimiter(cfg.Proxy.MaxConcurrentRequests, cfg.Proxy.QueueTimeout))
 
 	// Create a group for Gemini routes
 	geminiHandlerFunc := func(c *gin.Context) {
 		http.StripPrefix("/gemini", geminiHandler).ServeHTTP(c.Writer, c.Request)
 	}
 	geminiGroup := router.Group("/gemini")
-	geminiGroup.Use(auth.AuthMiddleware(dbService))
+	geminiGroup.Use(proxyAccess, auth.AuthMiddleware(dbService, cfg.Access.KeySources()), auth.RequireScope(auth.ScopeGemini), proxyLimit)
 	geminiGroup.GET("/*path", geminiHandlerFunc)
 	geminiGroup.POST("/*path", geminiHandlerFunc)
 
@@ -119,18 +429,24 @@ func setupAndRunServer(cfg *config.Config, log *slog.Logger, dbService db.Servic
 		http.StripPrefix("/openai", openaiProxy).ServeHTTP(c.Writer, c.Request)
 	}
 	openaiGroup := router.Group("/openai")
-	openaiGroup.Use(auth.AuthMiddleware(dbService))
+	openaiGroup.Use(auth.OpenAIErrors(), proxyAccess, auth.AuthMiddleware(dbService, cfg.Access.KeySources()), auth.RequireScope(auth.ScopeOpenAI), proxyLimit)
 	openaiGroup.GET("/*path", openaiHandlerFunc)
 	openaiGroup.POST("/*path", openaiHandlerFunc)
 
 	// Route POST /v1/embeddings to the same OpenAI proxy handler logic.
 	// This requires calling the openaiProxy directly to avoid the http.StripPrefix
 	// issue that would occur if we reused openaiHandlerFunc.
-	// The route is also protected by the same authentication middleware.
-	router.POST("/v1/embeddings", auth.AuthMiddleware(dbService), func(c *gin.Context) {
+	// The route is also protected by the same authentication middleware, but needs the embeddings scope.
+	router.POST("/v1/embeddings", auth.OpenAIErrors(), proxyAccess, auth.AuthMiddleware(dbService, cfg.Access.KeySources()), auth.RequireScope(auth.ScopeEmbeddings), proxyLimit, func(c *gin.Context) {
 		openaiProxy.ServeHTTP(c.Writer, c.Request)
 	})
 
+	// Anthropic Messages API, translated to the OpenAI-compatible endpoint and sent through openaiProxy.
+	anthropicHandler := proxy.NewAnthropicHandler(openaiProxy)
+	anthropicGroup := router.Group("/anthropic")
+	anthropicGroup.Use(proxyAccess, auth.AuthMiddleware(dbService, cfg.Access.KeySources()), auth.RequireScope(auth.ScopeAnthropic), proxyLimit)
+	anthropicGroup.POST("/v1/messages", gin.WrapH(anthropicHandler))
+
 	// Serve frontend
 	distFS, err := fs.Sub(webUI, "dist")
 	if err != nil {
@@ -158,7 +474,8 @@ func setupAndRunServer(cfg *config.Config, log *slog.Logger, dbService db.Servic
 		path := c.Request.URL.Path
 		if !strings.HasPrefix(path, "/api") &&
 			!strings.HasPrefix(path, "/gemini") &&
-			!strings.HasPrefix(path, "/openai") {
+			!strings.HasPrefix(path, "/openai") &&
+			!strings.HasPrefix(path, "/anthropic") {
 			handler(c)
 			return
 		}
@@ -168,13 +485,17 @@ func setupAndRunServer(cfg *config.Config, log *slog.Logger, dbService db.Servic
 	// Create and start the main server
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: router,
+		Handler: withRequestTimeout(router, cfg.Server),
 	}
 
 	// Graceful shutdown
 	go func() {
-		log.Info("Starting server", "port", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if cfg.Server.UnixSocket != "" {
+			log.Info("Starting server", "unix_socket", cfg.Server.UnixSocket, "tls", cfg.TLS.Enabled())
+		} else {
+			log.Info("Starting server", "port", cfg.Port, "tls", cfg.TLS.Enabled())
+		}
+		if err := serve(server, cfg.Server.UnixSocket, cfg.TLS); err != nil && err != http.ErrServerClosed {
 			log.Error("Failed to start server", "error", err)
 			// In a real app, you might want to signal the main goroutine to exit.
 			// For this refactoring, we'll just log it. The original os.Exit(1) is now handled in main.
@@ -187,17 +508,25 @@ func setupAndRunServer(cfg *config.Config, log *slog.Logger, dbService db.Servic
 	<-quit
 	log.Info("Shutting down server...")
 
+	// Stop handing out keys to new requests while in-flight ones finish.
+	keyManager.Drain()
+
 	// The context is used to inform the server it has 5 seconds to finish
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	// Close the handlers to stop their background tasks
+	shutdownErr := server.Shutdown(ctx)
+
+	// Close the handlers to stop their background tasks once no requests are using them
 	keyManager.Close()
 	openaiProxy.Close()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Warn("Failed to flush traces", "error", err)
+	}
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Error("Server forced to shutdown", "error", err)
-		return err
+	if shutdownErr != nil {
+		log.Error("Server forced to shutdown", "error", shutdownErr)
+		return shutdownErr
 	}
 
 	log.Info("Server exiting")
@@ -214,8 +543,13 @@ func main() {
 	}
 
 	// Setup logger
-	log := logger.New(cfg.Debug)
-	log.Info("Logger initialized", "debug_mode", cfg.Debug)
+	log, err := logger.NewWithOptions(os.Stdout, logger.Options{Format: cfg.Logging.Format, Level: cfg.Logging.Level, Debug: cfg.Debug})
+	if err != nil {
+		slog.Error("Error setting up logger", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(log)
+	log.Info("Logger initialized", "debug_mode", cfg.Debug, "format", cfg.Logging.Format)
 	if warning != "" {
 		log.Warn(warning)
 	}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"os"
+	"runtime"
+	"runtime/debug"
 
 	"github.com/ubuygold/gogemini/internal/admin"
 	"github.com/ubuygold/gogemini/internal/config"
@@ -26,10 +33,12 @@ import (
 	"github.com/ubuygold/gogemini/internal/logger"
 	"github.com/ubuygold/gogemini/internal/model"
 	"github.com/ubuygold/gogemini/internal/proxy"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 	"github.com/ubuygold/gogemini/internal/scheduler"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockDBService is a mock implementation of the db.Service interface.
@@ -48,21 +57,25 @@ func (m *MockDBService) CreateGeminiKey(key *model.GeminiKey) error {
 	args := m.Called(key)
 	return args.Error(0)
 }
-func (m *MockDBService) BatchAddGeminiKeys(keys []string) error {
-	args := m.Called(keys)
-	return args.Error(0)
+func (m *MockDBService) BatchAddGeminiKeys(keys []string, status string) (int64, error) {
+	args := m.Called(keys, status)
+	return args.Get(0).(int64), args.Error(1)
 }
 func (m *MockDBService) BatchDeleteGeminiKeys(ids []uint) error {
 	args := m.Called(ids)
 	return args.Error(0)
 }
-func (m *MockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error) {
-	args := m.Called(page, limit, statusFilter, minFailureCount)
+func (m *MockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error) {
+	args := m.Called(page, limit, statusFilter, minFailureCount, sortBy, sortOrder)
 	if args.Get(0) == nil {
 		return nil, int64(args.Int(1)), args.Error(2)
 	}
 	return args.Get(0).([]model.GeminiKey), int64(args.Int(1)), args.Error(2)
 }
+func (m *MockDBService) GeminiKeyStatusCounts() (db.GeminiKeyCounts, error) {
+	args := m.Called()
+	return args.Get(0).(db.GeminiKeyCounts), args.Error(1)
+}
 func (m *MockDBService) GetGeminiKey(id uint) (*model.GeminiKey, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -74,6 +87,10 @@ func (m *MockDBService) UpdateGeminiKey(key *model.GeminiKey) error {
 	args := m.Called(key)
 	return args.Error(0)
 }
+func (m *MockDBService) UpdateGeminiKeyState(key *model.GeminiKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
 func (m *MockDBService) DeleteGeminiKey(id uint) error {
 	args := m.Called(id)
 	return args.Error(0)
@@ -135,6 +152,153 @@ func (m *MockDBService) FindAPIKeyByKey(key string) (*model.APIKey, error) {
 	}
 	return args.Get(0).(*model.APIKey), args.Error(1)
 }
+func (m *MockDBService) ListDeletedGeminiKeys() ([]model.GeminiKey, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.GeminiKey), args.Error(1)
+}
+func (m *MockDBService) RestoreGeminiKey(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+func (m *MockDBService) PurgeGeminiKey(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+func (m *MockDBService) ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error) {
+	args := m.Called(page, limit, statusFilter)
+	return args.Get(0).([]model.APIKey), args.Get(1).(int64), args.Error(2)
+}
+func (m *MockDBService) StreamGeminiKeys(fn func(model.GeminiKey) error) error {
+	args := m.Called(fn)
+	return args.Error(0)
+}
+func (m *MockDBService) ResetDailyGeminiUsage() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockDBService) DecayGeminiFailureCounts(olderThan time.Duration) error {
+	args := m.Called(olderThan)
+	return args.Error(0)
+}
+func (m *MockDBService) BatchIncrementGeminiUsage(counts map[string]int64) error {
+	args := m.Called(counts)
+	return args.Error(0)
+}
+func (m *MockDBService) BatchIncrementGeminiTokenUsage(tokens map[string]int64) error {
+	args := m.Called(tokens)
+	return args.Error(0)
+}
+func (m *MockDBService) ExpireStaleAPIKeys() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDBService) BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error) {
+	args := m.Called(ids, status)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDBService) ResetAllGeminiFailureCounts() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockDBService) Ping() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockDBService) PurgeDeletedGeminiKeys() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDBService) ResetMonthlyAPIUsage() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockDBService) FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error) {
+	args := m.Called(suffix)
+	return args.Get(0).([]model.GeminiKey), args.Error(1)
+}
+func (m *MockDBService) FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error) {
+	args := m.Called(suffix)
+	return args.Get(0).([]model.APIKey), args.Error(1)
+}
+func (m *MockDBService) PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error) {
+	args := m.Called(minRevivalFailures, disabledBefore, softDelete)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestServe(t *testing.T) {
+	originalPlain, originalTLS := listenAndServe, listenAndServeTLS
+	t.Cleanup(func() { listenAndServe, listenAndServeTLS = originalPlain, originalTLS })
+
+	var used, certFile, keyFile string
+	listenAndServe = func(s *http.Server) error {
+		used = "plain"
+		return nil
+	}
+	listenAndServeTLS = func(s *http.Server, cert, key string) error {
+		used, certFile, keyFile = "tls", cert, key
+		return nil
+	}
+	server := &http.Server{}
+
+	t.Run("uses TLS when certificate and key are set", func(t *testing.T) {
+		used = ""
+		err := serve(server, "", config.TLSConfig{CertFile: "server.crt", KeyFile: "server.key"})
+		assert.NoError(t, err)
+		assert.Equal(t, "tls", used)
+		assert.Equal(t, "server.crt", certFile)
+		assert.Equal(t, "server.key", keyFile)
+	})
+
+	t.Run("falls back to plaintext without TLS config", func(t *testing.T) {
+		used = ""
+		err := serve(server, "", config.TLSConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, "plain", used)
+	})
+
+	t.Run("serves requests over a unix socket", func(t *testing.T) {
+		// Socket paths are limited to about 100 bytes, which t.TempDir can exceed.
+		dir, err := os.MkdirTemp("", "gogemini")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		socket := filepath.Join(dir, "gogemini.sock")
+		// A socket left behind by a previous run is replaced.
+		stale, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		used = ""
+		unixServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("over unix"))
+		})}
+		served := make(chan error, 1)
+		go func() { served <- serve(unixServer, socket, config.TLSConfig{}) }()
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			resp, err = client.Get("http://gogemini/")
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "over unix", string(body))
+		assert.Empty(t, used, "the TCP listener is not used")
+
+		require.NoError(t, unixServer.Shutdown(context.Background()))
+		assert.ErrorIs(t, <-served, http.ErrServerClosed)
+		assert.NoFileExists(t, socket, "the socket is removed on shutdown")
+	})
+}
 
 func TestCustomRecovery_Panic(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -188,6 +352,380 @@ func TestCustomRecovery_AbortHandler(t *testing.T) {
 	assert.Contains(t, logBuf.String(), "Client connection aborted")
 }
 
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(corsMiddleware(config.CORSConfig{
+		AllowedOrigins:   []string{"https://dashboard.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+	}))
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(auth.AdminAuthMiddleware(map[string]string{"admin": "test-password"}))
+	adminGroup.GET("/gemini-keys", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	t.Run("allowed origin", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys", nil)
+		req.Header.Set("Origin", "https://dashboard.example.com")
+		req.SetBasicAuth("admin", "test-password")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "https://dashboard.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys", nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		req.SetBasicAuth("admin", "test-password")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("preflight is answered before admin auth", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, "/admin/gemini-keys", nil)
+		req.Header.Set("Origin", "https://dashboard.example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, "https://dashboard.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Authorization, Content-Type", rr.Header().Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("preflight from disallowed origin", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodOptions, "/admin/gemini-keys", nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+	})
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	slow := func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+		case <-time.After(time.Second):
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "done"})
+	}
+	router.GET("/admin/slow", slow)
+	router.GET("/fast", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	router.GET("/openai/stream", func(c *gin.Context) {
+		_, flushable := c.Writer.(http.Flusher)
+		assert.True(t, flushable, "streaming paths must keep a flushable writer")
+		time.Sleep(50 * time.Millisecond)
+		c.String(http.StatusOK, "data: done\n\n")
+		c.Writer.Flush()
+	})
+	handler := withRequestTimeout(router, config.ServerConfig{RequestTimeout: 20 * time.Millisecond})
+
+	t.Run("cuts off a slow handler", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/slow", nil))
+
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.JSONEq(t, `{"error":"Request timed out"}`, rr.Body.String())
+		assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+	})
+
+	t.Run("passes fast requests through", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+	})
+
+	t.Run("exempts streaming proxy paths by default", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openai/stream", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "data: done\n\n", rr.Body.String())
+		assert.True(t, rr.Flushed)
+	})
+
+	t.Run("overrides apply by longest prefix", func(t *testing.T) {
+		handler := withRequestTimeout(router, config.ServerConfig{
+			RequestTimeout:          -1,
+			RequestTimeoutOverrides: map[string]time.Duration{"/admin": 20 * time.Millisecond, "/openai": 0},
+		})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/slow", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openai/stream", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	clientIP := func(t *testing.T, cfg *config.Config, remoteAddr string) string {
+		router := gin.New()
+		require.NoError(t, setTrustedProxies(router, cfg))
+		router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+		req, _ := http.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	t.Run("uses access.trusted_proxies", func(t *testing.T) {
+		cfg := &config.Config{Access: config.AccessConfig{TrustedProxies: []string{"10.0.0.0/8"}}}
+		assert.Equal(t, "203.0.113.9", clientIP(t, cfg, "10.1.2.3:1234"))
+		assert.Equal(t, "192.0.2.1", clientIP(t, cfg, "192.0.2.1:1234"), "untrusted peers cannot set the client IP")
+	})
+
+	t.Run("trusts no proxy when unset", func(t *testing.T) {
+		assert.Equal(t, "192.0.2.1", clientIP(t, &config.Config{}, "192.0.2.1:1234"))
+		cfg := &config.Config{Access: config.AccessConfig{TrustedProxies: []string{}}}
+		assert.Equal(t, "10.1.2.3", clientIP(t, cfg, "10.1.2.3:1234"))
+	})
+
+	t.Run("allowlists cannot be bypassed with forwarding headers when unset", func(t *testing.T) {
+		router := gin.New()
+		require.NoError(t, setTrustedProxies(router, &config.Config{}))
+		router.GET("/ip", auth.IPAllowMiddleware([]string{"10.0.0.0/8"}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req, _ := http.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", "10.1.2.3")
+		req.Header.Set("X-Real-IP", "10.1.2.3")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("rejects invalid entries", func(t *testing.T) {
+		cfg := &config.Config{Access: config.AccessConfig{TrustedProxies: []string{"not-an-ip"}}}
+		assert.Error(t, setTrustedProxies(gin.New(), cfg))
+	})
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logBuf bytes.Buffer
+	testLogger := slog.New(slog.NewJSONHandler(&logBuf, nil))
+
+	router := gin.New()
+	router.Use(requestIDMiddleware())
+	router.GET("/", func(c *gin.Context) {
+		logger.FromContext(c.Request.Context(), testLogger).Info("handled request")
+		c.String(http.StatusOK, c.GetString("request_id"))
+	})
+
+	t.Run("generates a request ID", func(t *testing.T) {
+		logBuf.Reset()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		requestID := rr.Header().Get("X-Request-ID")
+		assert.NotEmpty(t, requestID)
+		assert.Equal(t, requestID, rr.Body.String())
+		assert.Contains(t, logBuf.String(), `"request_id":"`+requestID+`"`)
+	})
+
+	t.Run("honors an incoming request ID", func(t *testing.T) {
+		logBuf.Reset()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", "client-supplied-id")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, "client-supplied-id", rr.Header().Get("X-Request-ID"))
+		assert.Contains(t, logBuf.String(), `"request_id":"client-supplied-id"`)
+	})
+}
+
+func TestDebugLogMiddleware_RedactsQueryKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logBuf bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logBuf
+	t.Cleanup(func() { gin.DefaultWriter = defaultWriter })
+
+	router := gin.New()
+	router.Use(debugLogMiddleware([]string{"bearer", "query:key"}))
+	router.GET("/gemini/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/gemini/v1beta/models?alt=json&key=client-secret-key", nil))
+
+	assert.NotContains(t, logBuf.String(), "client-secret-key")
+	assert.Contains(t, logBuf.String(), "/gemini/v1beta/models?alt=json&key=REDACTED")
+}
+
+func TestRedactQuery(t *testing.T) {
+	assert.Equal(t, "/v1/models", redactQuery("/v1/models", []string{"key"}))
+	assert.Equal(t, "/v1/models?alt=sse", redactQuery("/v1/models?alt=sse", []string{"key"}))
+	assert.Equal(t, "/v1/models?key=secret", redactQuery("/v1/models?key=secret", nil))
+	assert.Equal(t, "/v1/models?REDACTED", redactQuery("/v1/models?key=%zz", []string{"key"}))
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logBuf bytes.Buffer
+	testLogger := slog.New(slog.NewJSONHandler(&logBuf, nil))
+
+	router := gin.New()
+	router.Use(requestIDMiddleware(), accessLogMiddleware(testLogger))
+	router.POST("/gemini/*path", func(c *gin.Context) {
+		// Stand in for the auth middleware and the proxy handler.
+		c.Set(auth.APIKeyContextKey, &model.APIKey{Key: "client-key-1234"})
+		requestlog.Begin(c.Request.Context(), time.Now(), c.Request.Method, "/v1beta/models").KeySuffix = "wxyz"
+		c.String(http.StatusCreated, "hello")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/gemini/v1beta/models", nil)
+	req.Header.Set("X-Request-ID", "access-log-test")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logBuf.Bytes(), &entry))
+	assert.Equal(t, "Request served", entry["msg"])
+	assert.Equal(t, "access-log-test", entry["request_id"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/gemini/v1beta/models", entry["path"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.Equal(t, float64(len("hello")), entry["bytes"])
+	assert.Contains(t, entry, "latency_ms")
+	assert.Equal(t, "1234", entry["client_key_suffix"])
+	assert.Equal(t, "wxyz", entry["gemini_key_suffix"])
+}
+
+func TestVersionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/version", versionHandler)
+
+	get := func() map[string]string {
+		req, _ := http.NewRequest(http.MethodGet, "/version", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return body
+	}
+
+	originalRead := readBuildInfo
+	t.Cleanup(func() { readBuildInfo = originalRead })
+
+	t.Run("defaults when build info is unavailable", func(t *testing.T) {
+		readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+
+		body := get()
+		assert.Equal(t, "unknown", body["version"])
+		assert.Equal(t, "unknown", body["commit"])
+		assert.Equal(t, "unknown", body["build_date"])
+		assert.Equal(t, runtime.Version(), body["go_version"])
+	})
+
+	t.Run("uses embedded vcs settings", func(t *testing.T) {
+		readBuildInfo = func() (*debug.BuildInfo, bool) {
+			return &debug.BuildInfo{
+				GoVersion: "go1.99",
+				Main:      debug.Module{Version: "v1.2.3"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: "abc123"},
+					{Key: "vcs.time", Value: "2026-01-02T03:04:05Z"},
+				},
+			}, true
+		}
+
+		assert.Equal(t, map[string]string{
+			"version":    "v1.2.3",
+			"commit":     "abc123",
+			"go_version": "go1.99",
+			"build_date": "2026-01-02T03:04:05Z",
+		}, get())
+	})
+}
+
+// stubHealth reports a fixed database health result.
+type stubHealth struct{ err error }
+
+func (s stubHealth) Err() error { return s.err }
+
+func TestReadyzHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	testLogger := slog.New(slog.NewJSONHandler(&logs, nil))
+	get := func(health stubHealth) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/readyz", readyzHandler(health, testLogger))
+		req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(stubHealth{})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status": "ready", "database": "ok"}`, rr.Body.String())
+
+	rr = get(stubHealth{err: errors.New("failed to ping database: connection refused")})
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"status": "unavailable", "database": "unavailable"}`, rr.Body.String())
+	assert.Contains(t, logs.String(), "connection refused", "the error is logged instead")
+}
+
+func TestSeedGeminiKeys(t *testing.T) {
+	dbService, err := db.NewService(config.DatabaseConfig{Type: "sqlite", DSN: filepath.Join(t.TempDir(), "seed.db")})
+	require.NoError(t, err)
+	var logs bytes.Buffer
+	testLogger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	require.NoError(t, seedGeminiKeys(dbService, []string{"seed-key-1", "seed-key-2"}, "active", testLogger))
+	keys, err := dbService.LoadActiveGeminiKeys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	// A restart with an overlapping key list only adds, and reports, the new key.
+	logs.Reset()
+	require.NoError(t, seedGeminiKeys(dbService, []string{"seed-key-2", "seed-key-3"}, "active", testLogger))
+	assert.Contains(t, logs.String(), `"added":1,"configured":2`)
+	keys, err = dbService.LoadActiveGeminiKeys()
+	require.NoError(t, err)
+	var stored []string
+	for _, k := range keys {
+		stored = append(stored, k.Key)
+	}
+	assert.ElementsMatch(t, []string{"seed-key-1", "seed-key-2", "seed-key-3"}, stored)
+
+	// Nothing to seed is a no-op.
+	assert.NoError(t, seedGeminiKeys(new(MockDBService), nil, "active", testLogger))
+}
+
 func TestAdminRoutesE2E(t *testing.T) {
 	// Create a temporary config file for the test
 	const tempConfig = `
@@ -215,7 +753,7 @@ admin:
 	assert.NoError(t, err)
 	// For this test, we don't need a real key manager, so we can use a mock.
 	mockKM := &mockKeyManager{}
-	admin.SetupRoutes(router, dbService, mockKM, cfg)
+	admin.SetupRoutes(router, dbService, mockKM, nil, nil, cfg)
 
 	// --- Test Cases ---
 
@@ -309,16 +847,16 @@ proxy:
 
 	// Setup all routes
 	// We use the real keyManager for the proxy/balancer tests, but the admin routes can use a mock.
-	keyManager, err := keymanager.NewKeyManager(dbService, cfg, log)
+	keyManager, err := keymanager.NewKeyManager(dbService, cfg, http.DefaultTransport, log)
 	assert.NoError(t, err)
-	admin.SetupRoutes(router, dbService, keyManager, cfg)
+	admin.SetupRoutes(router, dbService, keyManager, nil, nil, cfg)
 	assert.NoError(t, err)
 	defer keyManager.Close()
 
-	geminiHandler, err := balancer.NewBalancer(keyManager, log)
+	geminiHandler, err := balancer.NewBalancer(keyManager, cfg, http.DefaultTransport, log)
 	assert.NoError(t, err)
 	// No need to close geminiHandler, as its lifecycle is tied to the keyManager
-	openaiProxy, err := proxy.NewOpenAIProxy(keyManager, cfg, log)
+	openaiProxy, err := proxy.NewOpenAIProxy(keyManager, cfg, http.DefaultTransport, log)
 	assert.NoError(t, err)
 	// No need to close openaiProxy
 	s := scheduler.NewScheduler(dbService, cfg, keyManager)
@@ -329,14 +867,14 @@ proxy:
 		http.StripPrefix("/gemini", geminiHandler).ServeHTTP(c.Writer, c.Request)
 	}
 	geminiGroup := router.Group("/gemini")
-	geminiGroup.Use(auth.AuthMiddleware(dbService))
+	geminiGroup.Use(auth.AuthMiddleware(dbService, nil))
 	geminiGroup.Any("/*path", geminiHandlerFunc)
 
 	openaiHandlerFunc := func(c *gin.Context) {
 		http.StripPrefix("/openai", openaiProxy).ServeHTTP(c.Writer, c.Request)
 	}
 	openaiGroup := router.Group("/openai")
-	openaiGroup.Use(auth.AuthMiddleware(dbService))
+	openaiGroup.Use(auth.AuthMiddleware(dbService, nil))
 	openaiGroup.Any("/*path", openaiHandlerFunc)
 
 	// 2. Create a client API key via the admin endpoint
@@ -431,6 +969,7 @@ func TestSetupAndRunServer_Failure(t *testing.T) {
 		log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 		mockDB := new(MockDBService)
 		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{}, nil)
+		mockDB.On("Ping").Return(nil)
 
 		// We need to run the server briefly and capture its output
 		var logBuf bytes.Buffer
@@ -575,12 +1114,26 @@ admin:
 // mockKeyManager is a simple mock for tests that don't need key manager functionality.
 type mockKeyManager struct{}
 
-func (m *mockKeyManager) GetNextKey() (string, error) { return "", nil }
-func (m *mockKeyManager) HandleKeyFailure(key string) {}
-func (m *mockKeyManager) HandleKeySuccess(key string) {}
-func (m *mockKeyManager) ReviveDisabledKeys()         {}
-func (m *mockKeyManager) CheckAllKeysHealth()         {}
-func (m *mockKeyManager) GetAvailableKeyCount() int   { return 0 }
-func (m *mockKeyManager) TestKeyByID(id uint) error   { return nil }
-func (m *mockKeyManager) TestAllKeysAsync()           {}
-func (m *mockKeyManager) Close()                      {}
+func (m *mockKeyManager) GetNextKey(group, model string) (string, error) { return "", nil }
+func (m *mockKeyManager) GetKeyForSession(sessionID, group, model string) (string, error) {
+	return "", nil
+}
+func (m *mockKeyManager) HandleKeyFailure(key string, statusCode int, errorBody string) {}
+func (m *mockKeyManager) HandleKeySuccess(key string)                                   {}
+func (m *mockKeyManager) ReviveDisabledKeys()                                           {}
+func (m *mockKeyManager) CheckAllKeysHealth()                                           {}
+func (m *mockKeyManager) GetAvailableKeyCount() int                                     { return 0 }
+func (m *mockKeyManager) AvailabilityError() error                                      { return keymanager.ErrNoKeys }
+func (m *mockKeyManager) SuspendedError() error                                         { return nil }
+func (m *mockKeyManager) TestKeyByID(ctx context.Context, id uint) error                { return nil }
+func (m *mockKeyManager) TestAllKeysAsync()                                             {}
+func (m *mockKeyManager) TestKeysAsync(ids []uint)                                      {}
+func (m *mockKeyManager) ValidateRawKey(key string) error                               { return nil }
+func (m *mockKeyManager) ReloadKeys() (int, error)                                      { return 0, nil }
+func (m *mockKeyManager) Snapshot() []keymanager.KeyRuntimeInfo                         { return nil }
+func (m *mockKeyManager) LowAvailableKeyChecks() int64                                  { return 0 }
+func (m *mockKeyManager) PeekNextKey() (string, error)                                  { return "", nil }
+func (m *mockKeyManager) SetPaused(paused bool)                                         {}
+func (m *mockKeyManager) IsPaused() bool                                                { return false }
+func (m *mockKeyManager) Drain()                                                        {}
+func (m *mockKeyManager) Close()                                                        {}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+// Empty values fall back to the build info embedded by the Go toolchain.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// readBuildInfo is a variable so tests can simulate binaries without embedded build info.
+var readBuildInfo = debug.ReadBuildInfo
+
+// unknownBuildValue is reported for build details that are not available.
+const unknownBuildValue = "unknown"
+
+// buildInfo is the response body of GET /version.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+	BuildDate string `json:"build_date"`
+}
+
+// currentBuildInfo combines the ldflags-injected values with the toolchain's build info.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		BuildDate: buildDate,
+	}
+
+	if bi, ok := readBuildInfo(); ok && bi != nil {
+		if info.Version == "" && bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		if bi.GoVersion != "" {
+			info.GoVersion = bi.GoVersion
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = unknownBuildValue
+	}
+	if info.Commit == "" {
+		info.Commit = unknownBuildValue
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = unknownBuildValue
+	}
+	return info
+}
+
+// versionHandler serves GET /version. It is unauthenticated so deployments can be checked without a key.
+func versionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, currentBuildInfo())
+}
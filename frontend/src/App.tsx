@@ -6,6 +6,7 @@ import Navbar from './components/Navbar';
 
 function App() {
 	const [loggedIn, setLoggedIn] = useState(false);
+	const [username, setUsername] = useState('');
 	const [password, setPassword] = useState('');
 	const [activeTab, setActiveTab] = useState<'gemini' | 'client'>('gemini');
 	const [loading, setLoading] = useState(true); // Add loading state
@@ -14,7 +15,7 @@ function App() {
 		const checkLogin = async () => {
 			const sessionData = sessionStorage.getItem('adminSession');
 			if (sessionData) {
-				const { password: storedPassword, timestamp } = JSON.parse(sessionData);
+				const { username: storedUsername = 'admin', password: storedPassword, timestamp } = JSON.parse(sessionData);
 				const oneHour = 60 * 60 * 1000;
 
 				if (Date.now() - timestamp > oneHour) {
@@ -23,11 +24,12 @@ function App() {
 					try {
 						const response = await fetch('/admin/gemini-keys', {
 							headers: {
-								Authorization: `Basic ${btoa(`admin:${storedPassword}`)}`,
+								Authorization: `Basic ${btoa(`${storedUsername}:${storedPassword}`)}`,
 							},
 						});
 						if (response.ok) {
 							setLoggedIn(true);
+							setUsername(storedUsername);
 							setPassword(storedPassword);
 						} else {
 							sessionStorage.removeItem('adminSession');
@@ -44,17 +46,18 @@ function App() {
 		checkLogin();
 	}, []);
 
-	const handleLogin = async (password: string) => {
+	const handleLogin = async (username: string, password: string) => {
 		try {
 			const response = await fetch('/admin/gemini-keys', {
 				headers: {
-					Authorization: `Basic ${btoa(`admin:${password}`)}`,
+					Authorization: `Basic ${btoa(`${username}:${password}`)}`,
 				},
 			});
 			if (response.ok) {
 				setLoggedIn(true);
+				setUsername(username);
 				setPassword(password);
-				const sessionData = { password: password, timestamp: Date.now() };
+				const sessionData = { username: username, password: password, timestamp: Date.now() };
 				sessionStorage.setItem('adminSession', JSON.stringify(sessionData));
 			} else {
 				alert('Login failed');
@@ -67,6 +70,7 @@ function App() {
 
 	const handleLogout = () => {
 		setLoggedIn(false);
+		setUsername('');
 		setPassword('');
 		sessionStorage.removeItem('adminSession');
 	};
@@ -88,8 +92,8 @@ function App() {
 			/>
 			<div className="container mx-auto p-4">
 				<div className="mt-4">
-					{activeTab === 'gemini' && <GeminiKeyManager password={password} />}
-					{activeTab === 'client' && <ClientKeyManager password={password} />}
+					{activeTab === 'gemini' && <GeminiKeyManager username={username} password={password} />}
+					{activeTab === 'client' && <ClientKeyManager username={username} password={password} />}
 				</div>
 			</div>
 		</div>
@@ -1,4 +1,4 @@
-import { useState, useEffect } from 'react';
+import { useState, useEffect, useCallback } from 'react';
 
 type APIKey = {
   ID: number;
@@ -11,49 +11,71 @@ type APIKey = {
 };
 
 type ClientKeyManagerProps = {
+  username: string;
   password: string;
 };
 
-function ClientKeyManager({ password }: ClientKeyManagerProps) {
+function ClientKeyManager({ username, password }: ClientKeyManagerProps) {
   const [keys, setKeys] = useState<APIKey[]>([]);
   const [newKey, setNewKey] = useState('');
 
-  useEffect(() => {
-    fetchKeys();
-  }, []);
+  // Filtering and Pagination state
+  const [statusFilter, setStatusFilter] = useState('all');
+  const [currentPage, setCurrentPage] = useState(1);
+  const [totalPages, setTotalPages] = useState(1);
+  const [limit, setLimit] = useState(10); // Items per page
 
-  const fetchKeys = async () => {
-    const response = await fetch('/admin/client-keys', {
-      headers: {
-        Authorization: `Basic ${btoa(`admin:${password}`)}`,
-      },
+  const fetchKeys = useCallback(async (page: number) => {
+    const params = new URLSearchParams({
+      page: page.toString(),
+      limit: limit.toString(),
+      status: statusFilter,
     });
-    const data = await response.json();
-    setKeys(data);
-  };
+    try {
+      const response = await fetch(`/admin/client-keys?${params.toString()}`, {
+        headers: {
+          Authorization: `Basic ${btoa(`${username}:${password}`)}`,
+        },
+      });
+      if (!response.ok) {
+        throw new Error('Failed to fetch keys');
+      }
+      const data = await response.json();
+      setKeys(data.keys || []);
+      setTotalPages(Math.ceil(data.total / limit) || 1);
+      setCurrentPage(page);
+    } catch (error) {
+      console.error("Fetch keys error:", error);
+    }
+  }, [username, password, limit, statusFilter]);
+
+  // Fetch keys when page or filters change
+  useEffect(() => {
+    fetchKeys(currentPage);
+  }, [currentPage, fetchKeys, limit]);
 
   const createKey = async () => {
     await fetch('/admin/client-keys', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Basic ${btoa(`admin:${password}`)}`,
+        Authorization: `Basic ${btoa(`${username}:${password}`)}`,
       },
       body: JSON.stringify({ Key: newKey, Permissions: 'all' }),
     });
     setNewKey('');
-    fetchKeys();
+    fetchKeys(1);
   };
 
   const deleteKey = async (id: number) => {
     const response = await fetch(`/admin/client-keys/${id}`, {
       method: 'DELETE',
       headers: {
-        Authorization: `Basic ${btoa(`admin:${password}`)}`,
+        Authorization: `Basic ${btoa(`${username}:${password}`)}`,
       },
     });
     if (response.ok) {
-      fetchKeys();
+      fetchKeys(currentPage);
     } else {
       const error = await response.json();
       alert(`Failed to delete key: ${error.error}`);
@@ -67,12 +89,12 @@ function ClientKeyManager({ password }: ClientKeyManagerProps) {
     const response = await fetch(`/admin/client-keys/${id}/reset`, {
       method: 'POST',
       headers: {
-        Authorization: `Basic ${btoa(`admin:${password}`)}`,
+        Authorization: `Basic ${btoa(`${username}:${password}`)}`,
       },
     });
     if (response.ok) {
       alert('Key usage count reset successfully.');
-      fetchKeys();
+      fetchKeys(currentPage);
     } else {
       const error = await response.json();
       alert(`Failed to reset key: ${error.error}`);
@@ -122,7 +144,37 @@ function ClientKeyManager({ password }: ClientKeyManagerProps) {
 
       <div className="card bg-base-200 shadow-xl">
         <div className="card-body">
-          <h2 className="card-title">Client Keys</h2>
+          <div className="flex justify-between items-center">
+            <h2 className="card-title">Client Keys</h2>
+            <div className="flex gap-2">
+              <select
+                className="select select-bordered select-sm"
+                value={statusFilter}
+                onChange={(e) => {
+                  setStatusFilter(e.target.value);
+                  setCurrentPage(1);
+                }}
+              >
+                <option value="all">All</option>
+                <option value="active">Active</option>
+                <option value="disabled">Disabled</option>
+                <option value="expired">Expired</option>
+              </select>
+              <select
+                className="select select-bordered select-sm"
+                value={limit}
+                onChange={(e) => {
+                  setLimit(Number(e.target.value));
+                  setCurrentPage(1); // Reset to first page
+                }}
+              >
+                <option value={10}>10</option>
+                <option value={25}>25</option>
+                <option value={50}>50</option>
+                <option value={100}>100</option>
+              </select>
+            </div>
+          </div>
           <div className="overflow-x-auto">
             <table className="table w-full">
               <thead>
@@ -176,6 +228,27 @@ function ClientKeyManager({ password }: ClientKeyManagerProps) {
               </tbody>
             </table>
           </div>
+          <div className="flex justify-center mt-4">
+            <div className="join">
+              <button
+                className="join-item btn"
+                onClick={() => setCurrentPage((p) => Math.max(1, p - 1))}
+                disabled={currentPage === 1}
+              >
+                «
+              </button>
+              <button className="join-item btn">
+                Page {currentPage} of {totalPages}
+              </button>
+              <button
+                className="join-item btn"
+                onClick={() => setCurrentPage((p) => Math.min(totalPages, p + 1))}
+                disabled={currentPage === totalPages}
+              >
+                »
+              </button>
+            </div>
+          </div>
         </div>
       </div>
     </div>
@@ -5,16 +5,18 @@ import 'element-plus/dist/index.css';
 type GeminiKey = {
   ID: number;
   Key: string;
+  ProjectLabel: string;
   Status: string;
   FailureCount: number;
   UsageCount: number;
 };
 
 type GeminiKeyManagerProps = {
+  username: string;
   password: string;
 };
 
-function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
+function GeminiKeyManager({ username, password }: GeminiKeyManagerProps) {
   const [keys, setKeys] = useState<GeminiKey[]>([]);
   const [newKeys, setNewKeys] = useState('');
   const [selectedKeys, setSelectedKeys] = useState<number[]>([]);
@@ -36,7 +38,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
     try {
       const response = await fetch(`/admin/gemini-keys?${params.toString()}`, {
         headers: {
-          Authorization: `Basic ${btoa(`admin:${password}`)}`,
+          Authorization: `Basic ${btoa(`${username}:${password}`)}`,
         },
       });
       if (!response.ok) {
@@ -50,7 +52,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
       console.error("Fetch keys error:", error);
       // Optionally, handle the error in the UI
     }
-  }, [password, limit, statusFilter, failureCountFilter]);
+  }, [username, password, limit, statusFilter, failureCountFilter]);
 
   // Fetch keys when page or filters change
   useEffect(() => {
@@ -70,7 +72,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Basic ${btoa(`admin:${password}`)}`,
+        Authorization: `Basic ${btoa(`${username}:${password}`)}`,
       },
       body: JSON.stringify({ keys: keysToAdd }),
     });
@@ -85,7 +87,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
       method: 'DELETE',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Basic ${btoa(`admin:${password}`)}`,
+        Authorization: `Basic ${btoa(`${username}:${password}`)}`,
       },
       body: JSON.stringify({ ids: selectedKeys }),
     });
@@ -115,7 +117,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
       const response = await fetch(`/admin/gemini-keys/${id}/test`, {
         method: 'POST',
         headers: {
-          Authorization: `Basic ${btoa(`admin:${password}`)}`,
+          Authorization: `Basic ${btoa(`${username}:${password}`)}`,
         },
       });
       const data = await response.json();
@@ -134,7 +136,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
       const response = await fetch('/admin/gemini-keys/test', {
         method: 'POST',
         headers: {
-          Authorization: `Basic ${btoa(`admin:${password}`)}`,
+          Authorization: `Basic ${btoa(`${username}:${password}`)}`,
         },
       });
       if (!response.ok) {
@@ -153,7 +155,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
-          Authorization: `Basic ${btoa(`admin:${password}`)}`,
+          Authorization: `Basic ${btoa(`${username}:${password}`)}`,
         },
         body: JSON.stringify({ status: 'active' }),
       });
@@ -301,6 +303,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
                   </th>
                   <th>ID</th>
                   <th>Key</th>
+                  <th>Project</th>
                   <th>Status</th>
                   <th>Failure Count</th>
                   <th>Usage Count</th>
@@ -322,6 +325,7 @@ function GeminiKeyManager({ password }: GeminiKeyManagerProps) {
                     </th>
                     <td>{key.ID}</td>
                     <td>{key.Key}</td>
+                    <td>{key.ProjectLabel || '-'}</td>
                     <td>
                       <span
                         className={`badge ${
@@ -1,21 +1,29 @@
 import { useState } from 'react';
 
 type LoginProps = {
-  onLogin: (password: string) => void;
+  onLogin: (username: string, password: string) => void;
 };
 
 function Login({ onLogin }: LoginProps) {
+  const [username, setUsername] = useState('admin');
   const [password, setPassword] = useState('');
 
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault();
-    onLogin(password);
+    onLogin(username, password);
   };
 
   return (
     <div className="flex items-center justify-center h-screen">
       <form onSubmit={handleSubmit} className="p-8 bg-white rounded shadow-md">
         <h1 className="text-2xl font-bold text-gray-800 mb-4">Login</h1>
+        <input
+          type="text"
+          value={username}
+          onChange={(e) => setUsername(e.target.value)}
+          className="input input-bordered w-full mb-4"
+          placeholder="Username"
+        />
         <input
           type="password"
           value={password}
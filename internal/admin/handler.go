@@ -1,35 +1,69 @@
 package admin
 
 import (
+	"bufio"
+	"encoding/csv"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ubuygold/gogemini/internal/auth"
+	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
 	"github.com/ubuygold/gogemini/internal/keymanager"
 	"github.com/ubuygold/gogemini/internal/model"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 
 	"github.com/gin-gonic/gin"
 )
 
+// JobRunner looks up scheduler jobs by name; it is implemented by *scheduler.Scheduler.
+type JobRunner interface {
+	Job(name string) (func(), bool)
+}
+
 type Handler struct {
 	db         db.Service
 	KeyManager keymanager.Manager
+	Jobs       JobRunner
+	RequestLog *requestlog.Buffer
+	// NewKeyStatus is the status given to added Gemini keys; empty means active.
+	NewKeyStatus string
 }
 
 func NewHandler(dbService db.Service, km keymanager.Manager) *Handler {
 	return &Handler{db: dbService, KeyManager: km}
 }
 
+// newKeyStatus returns the status for Gemini keys added through the admin API.
+func (h *Handler) newKeyStatus() string {
+	if h.NewKeyStatus == "" {
+		return config.KeyStatusActive
+	}
+	return h.NewKeyStatus
+}
+
 // Gemini Key Handlers
 
 type CreateGeminiKeyRequest struct {
-	Key string `json:"key" binding:"required"`
+	Key              string `json:"key" binding:"required"`
+	Group            string `json:"group"`
+	ProjectLabel     string `json:"project_label"`
+	DisableThreshold int    `json:"disable_threshold"`
 }
 
 type UpdateGeminiKeyRequest struct {
-	Key    string `json:"key"`
-	Status string `json:"status"`
+	Key              string  `json:"key"`
+	Status           string  `json:"status"`
+	DailyQuota       *int64  `json:"daily_quota"`
+	Group            *string `json:"group"`
+	ProjectLabel     *string `json:"project_label"`
+	DisableThreshold *int    `json:"disable_threshold"`
 }
 
 func (h *Handler) ListGeminiKeysHandler(c *gin.Context) {
@@ -38,15 +72,26 @@ func (h *Handler) ListGeminiKeysHandler(c *gin.Context) {
 	statusFilter := c.DefaultQuery("status", "all")
 	minFailureCount, _ := strconv.Atoi(c.DefaultQuery("minFailureCount", "0"))
 
-	keys, total, err := h.db.ListGeminiKeys(page, limit, statusFilter, minFailureCount)
+	keys, total, err := h.db.ListGeminiKeys(page, limit, statusFilter, minFailureCount, c.Query("sortBy"), c.Query("sortOrder"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list gemini keys"})
+		if errors.Is(err, db.ErrInvalidSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list gemini keys"})
+		}
+		return
+	}
+	// The summary covers every key, whatever page or filter is shown.
+	summary, err := h.db.GeminiKeyStatusCounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count gemini keys"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"keys":  keys,
-		"total": total,
+		"keys":    keys,
+		"total":   total,
+		"summary": summary,
 	})
 }
 
@@ -56,10 +101,17 @@ func (h *Handler) CreateGeminiKeyHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.DisableThreshold < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "disable_threshold must not be negative"})
+		return
+	}
 
 	newKey := &model.GeminiKey{
-		Key:    req.Key,
-		Status: "active",
+		Key:              req.Key,
+		Status:           h.newKeyStatus(),
+		Group:            strings.TrimSpace(req.Group),
+		ProjectLabel:     strings.TrimSpace(req.ProjectLabel),
+		DisableThreshold: req.DisableThreshold,
 	}
 
 	if err := h.db.CreateGeminiKey(newKey); err != nil {
@@ -114,14 +166,37 @@ func (h *Handler) UpdateGeminiKeyHandler(c *gin.Context) {
 	if req.Key != "" {
 		key.Key = req.Key
 	}
-	if req.Status != "" {
+	if req.Status != "" && req.Status != key.Status {
 		key.Status = req.Status
+		key.DisabledReason = ""
+	}
+	if req.DailyQuota != nil {
+		if *req.DailyQuota < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "daily_quota must not be negative"})
+			return
+		}
+		key.DailyQuota = *req.DailyQuota
+	}
+	if req.Group != nil {
+		key.Group = strings.TrimSpace(*req.Group)
+	}
+	if req.ProjectLabel != nil {
+		key.ProjectLabel = strings.TrimSpace(*req.ProjectLabel)
+	}
+	if req.DisableThreshold != nil {
+		if *req.DisableThreshold < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "disable_threshold must not be negative"})
+			return
+		}
+		key.DisableThreshold = *req.DisableThreshold
 	}
 
 	if err := h.db.UpdateGeminiKey(key); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update gemini key"})
 		return
 	}
+	// Pick up the new secret, status and limits now rather than at the next periodic reload.
+	_, _ = h.KeyManager.ReloadKeys()
 	c.JSON(http.StatusOK, key)
 }
 
@@ -135,8 +210,61 @@ func (h *Handler) DeleteGeminiKeyHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete gemini key"})
 		return
 	}
+	// Stop handing out the deleted key right away; the periodic reload retries on failure.
+	_, _ = h.KeyManager.ReloadKeys()
 	c.JSON(http.StatusNoContent, nil)
 }
+
+func (h *Handler) ListDeletedGeminiKeysHandler(c *gin.Context) {
+	keys, err := h.db.ListDeletedGeminiKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deleted gemini keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func (h *Handler) RestoreGeminiKeyHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+	if err := h.db.RestoreGeminiKey(uint(id)); err != nil {
+		if errors.Is(err, db.ErrGeminiKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted gemini key not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore gemini key"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Key restored successfully"})
+}
+
+func (h *Handler) PurgeGeminiKeyHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+	if err := h.db.PurgeGeminiKey(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge gemini key"})
+		return
+	}
+	_, _ = h.KeyManager.ReloadKeys()
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// PurgeDeletedGeminiKeysHandler permanently removes every key in the trash.
+func (h *Handler) PurgeDeletedGeminiKeysHandler(c *gin.Context) {
+	purged, err := h.db.PurgeDeletedGeminiKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge deleted gemini keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
 func (h *Handler) BatchCreateGeminiKeysHandler(c *gin.Context) {
 	var req struct {
 		Keys []string `json:"keys"`
@@ -145,13 +273,137 @@ func (h *Handler) BatchCreateGeminiKeysHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
-	if err := h.db.BatchAddGeminiKeys(req.Keys); err != nil {
+	if _, err := h.db.BatchAddGeminiKeys(req.Keys, h.newKeyStatus()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to batch create gemini keys"})
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"message": "Keys created successfully"})
 }
 
+// ImportGeminiKeysHandler adds keys from a text/plain (one per line) or text/csv body.
+// Keys that already exist in the database are left untouched by BatchAddGeminiKeys.
+func (h *Handler) ImportGeminiKeysHandler(c *gin.Context) {
+	var (
+		keys []string
+		err  error
+	)
+	switch c.ContentType() {
+	case "text/plain":
+		keys, err = parsePlainKeys(c.Request.Body)
+	case "text/csv":
+		keys, err = parseCSVKeys(c.Request.Body)
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be text/plain or text/csv"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	added, err := h.db.BatchAddGeminiKeys(dedupeKeys(keys), h.newKeyStatus())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import gemini keys"})
+		return
+	}
+	// Duplicates within the upload and keys already stored are both skipped.
+	c.JSON(http.StatusCreated, gin.H{
+		"added":   added,
+		"skipped": int64(len(keys)) - added,
+	})
+}
+
+// ExportGeminiKeysHandler streams all Gemini keys as a CSV attachment.
+func (h *Handler) ExportGeminiKeysHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="gemini-keys.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write([]string{"id", "key", "status", "failure_count", "usage_count"}); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	err := h.db.StreamGeminiKeys(func(key model.GeminiKey) error {
+		return w.Write([]string{
+			strconv.FormatUint(uint64(key.ID), 10),
+			key.Key,
+			key.Status,
+			strconv.Itoa(key.FailureCount),
+			strconv.FormatInt(key.UsageCount, 10),
+		})
+	})
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		// Headers are already sent, so the error can only be recorded on the context.
+		_ = c.Error(err)
+	}
+}
+
+// parsePlainKeys reads one key per line, ignoring blank lines and surrounding whitespace.
+func parsePlainKeys(r io.Reader) ([]string, error) {
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return keys, nil
+}
+
+// parseCSVKeys reads keys from the first column of a CSV body. An optional second
+// priority column is validated but not stored, since keys do not have a priority yet.
+// A leading "key" header row is skipped.
+func parseCSVKeys(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var keys []string
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		key := strings.TrimSpace(record[0])
+		if key == "" || (line == 1 && strings.EqualFold(key, "key")) {
+			continue
+		}
+		if len(record) > 1 {
+			if priority := strings.TrimSpace(record[1]); priority != "" {
+				if _, err := strconv.Atoi(priority); err != nil {
+					return nil, fmt.Errorf("invalid priority %q on line %d", priority, line)
+				}
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// dedupeKeys returns keys with duplicates removed, preserving first-seen order.
+func dedupeKeys(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
 func (h *Handler) BatchDeleteGeminiKeysHandler(c *gin.Context) {
 	var req struct {
 		IDs []uint `json:"ids"`
@@ -164,9 +416,61 @@ func (h *Handler) BatchDeleteGeminiKeysHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to batch delete gemini keys"})
 		return
 	}
+	_, _ = h.KeyManager.ReloadKeys()
 	c.JSON(http.StatusOK, gin.H{"message": "Keys deleted successfully"})
 }
 
+// geminiKeyStatuses are the status values an admin may set on a Gemini key.
+var geminiKeyStatuses = []string{"active", "disabled", "dead", "pending"}
+
+type BatchUpdateGeminiKeyStatusRequest struct {
+	IDs    []uint `json:"ids"`
+	Status string `json:"status"`
+}
+
+// BatchUpdateGeminiKeyStatusHandler sets the status of several Gemini keys at once, e.g. to
+// re-enable keys after a provider incident, and reloads the key manager so it takes effect.
+func (h *Handler) BatchUpdateGeminiKeyStatusHandler(c *gin.Context) {
+	var req BatchUpdateGeminiKeyStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must not be empty"})
+		return
+	}
+	if !slices.Contains(geminiKeyStatuses, req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of " + strings.Join(geminiKeyStatuses, ", ")})
+		return
+	}
+	updated, err := h.db.BatchUpdateGeminiKeyStatus(req.IDs, req.Status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to batch update gemini key status"})
+		return
+	}
+
+	// Put re-enabled keys back into rotation (and drop disabled ones) without waiting for the periodic reload.
+	_, _ = h.KeyManager.ReloadKeys()
+	c.JSON(http.StatusOK, gin.H{"message": "Key statuses updated successfully", "updated": updated})
+}
+
+// ResetGeminiKeyFailuresHandler clears the failure counts of all Gemini keys, re-enables keys
+// the failure threshold disabled, and reloads the key manager so they rejoin the rotation.
+func (h *Handler) ResetGeminiKeyFailuresHandler(c *gin.Context) {
+	if err := h.db.ResetAllGeminiFailureCounts(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset gemini key failure counts"})
+		return
+	}
+
+	count, err := h.KeyManager.ReloadKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failure counts were reset but reloading keys failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Failure counts reset successfully", "active_keys": count})
+}
+
 func (h *Handler) TestGeminiKeyHandler(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -174,7 +478,7 @@ func (h *Handler) TestGeminiKeyHandler(c *gin.Context) {
 		return
 	}
 
-	err = h.KeyManager.TestKeyByID(uint(id))
+	err = h.KeyManager.TestKeyByID(c.Request.Context(), uint(id))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status": "failed",
@@ -186,26 +490,273 @@ func (h *Handler) TestGeminiKeyHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// ValidateGeminiKeyRequest is the body of ValidateGeminiKeyHandler.
+type ValidateGeminiKeyRequest struct {
+	Key string `json:"key" binding:"required"`
+}
+
+// ValidateGeminiKeyHandler tests a raw key against the upstream without storing it.
+// upstream_status is omitted when the upstream could not be reached.
+func (h *Handler) ValidateGeminiKeyHandler(c *gin.Context) {
+	var req ValidateGeminiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	err := h.KeyManager.ValidateRawKey(strings.TrimSpace(req.Key))
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"valid": true, "upstream_status": http.StatusOK})
+		return
+	}
+
+	resp := gin.H{"valid": false, "error": err.Error()}
+	var testErr *keymanager.KeyTestError
+	if errors.As(err, &testErr) {
+		resp["upstream_status"] = testErr.StatusCode
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type RotateGeminiKeyRequest struct {
+	Key string `json:"key" binding:"required"`
+}
+
+// RotateGeminiKeyHandler replaces a key's secret while keeping its ID and usage history.
+// The new secret is validated upstream first and nothing is changed if it is rejected.
+func (h *Handler) RotateGeminiKeyHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return
+	}
+	var req RotateGeminiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+	newKey := strings.TrimSpace(req.Key)
+
+	key, err := h.db.GetGeminiKey(uint(id))
+	if err != nil {
+		if errors.Is(err, db.ErrGeminiKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Gemini key not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve gemini key"})
+		}
+		return
+	}
+
+	if err := h.KeyManager.ValidateRawKey(newKey); err != nil {
+		resp := gin.H{"error": "New key failed validation: " + err.Error()}
+		var testErr *keymanager.KeyTestError
+		if errors.As(err, &testErr) {
+			resp["upstream_status"] = testErr.StatusCode
+		}
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	key.Key = newKey
+	key.Status = "active"
+	key.FailureCount = 0
+	key.LastFailedAt = time.Time{}
+	if err := h.db.UpdateGeminiKey(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update gemini key"})
+		return
+	}
+
+	// Stop handing out the old secret right away; the periodic reload retries on failure.
+	_, _ = h.KeyManager.ReloadKeys()
+	c.JSON(http.StatusOK, key)
+}
+
 func (h *Handler) TestAllGeminiKeysHandler(c *gin.Context) {
 	h.KeyManager.TestAllKeysAsync()
 	c.JSON(http.StatusAccepted, gin.H{"message": "Batch key test initiated in the background."})
 }
 
+type TestGeminiKeysBatchRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+// TestGeminiKeysBatchHandler tests only the given Gemini keys in the background, e.g. right
+// after importing them. Duplicate IDs are tested once.
+func (h *Handler) TestGeminiKeysBatchHandler(c *gin.Context) {
+	var req TestGeminiKeysBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	ids := make([]uint, 0, len(req.IDs))
+	seen := make(map[uint]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must not be empty"})
+		return
+	}
+
+	h.KeyManager.TestKeysAsync(ids)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Key test initiated in the background.",
+		"count":   len(ids),
+		"ids":     ids,
+	})
+}
+
+// GeminiKeysRuntimeHandler returns the key manager's in-memory view of the keys, which can
+// differ from the database between reloads, and how many availability checks found fewer keys
+// than proxy.min_available_keys_warn.
+func (h *Handler) GeminiKeysRuntimeHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"keys":                     h.KeyManager.Snapshot(),
+		"low_available_key_checks": h.KeyManager.LowAvailableKeyChecks(),
+	})
+}
+
+// PeekNextGeminiKeyHandler returns the suffix of the key that would be selected next,
+// without consuming it.
+func (h *Handler) PeekNextGeminiKeyHandler(c *gin.Context) {
+	suffix, err := h.KeyManager.PeekNextKey()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key_suffix": suffix})
+}
+
+// minSearchSuffixLength keeps key searches from listing most keys with a one-character suffix.
+const minSearchSuffixLength = 4
+
+// maskKey hides all but the last four characters of a key value.
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return strings.Repeat("*", len(key))
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// searchSuffix returns the suffix query parameter, or writes a 400 response if it is too short.
+func searchSuffix(c *gin.Context) (string, bool) {
+	suffix := strings.TrimSpace(c.Query("suffix"))
+	if len(suffix) < minSearchSuffixLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("suffix must be at least %d characters", minSearchSuffixLength)})
+		return "", false
+	}
+	return suffix, true
+}
+
+// SearchGeminiKeysHandler finds Gemini keys by the end of their value, e.g. a suffix seen in
+// the logs. Key values are masked in the response.
+func (h *Handler) SearchGeminiKeysHandler(c *gin.Context) {
+	suffix, ok := searchSuffix(c)
+	if !ok {
+		return
+	}
+	keys, err := h.db.FindGeminiKeysBySuffix(suffix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search gemini keys"})
+		return
+	}
+	for i := range keys {
+		keys[i].Key = maskKey(keys[i].Key)
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// ReloadGeminiKeysHandler reloads the key manager from the database immediately
+// instead of waiting for the periodic reload.
+func (h *Handler) ReloadGeminiKeysHandler(c *gin.Context) {
+	count, err := h.KeyManager.ReloadKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload gemini keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"active_keys": count})
+}
+
+// PauseProxyHandler stops handing out Gemini keys, so proxied requests fail fast with 503
+// until ResumeProxyHandler is called. Keys are left untouched.
+func (h *Handler) PauseProxyHandler(c *gin.Context) {
+	h.KeyManager.SetPaused(true)
+	c.JSON(http.StatusOK, gin.H{"paused": h.KeyManager.IsPaused()})
+}
+
+// ResumeProxyHandler hands out Gemini keys again after PauseProxyHandler.
+func (h *Handler) ResumeProxyHandler(c *gin.Context) {
+	h.KeyManager.SetPaused(false)
+	c.JSON(http.StatusOK, gin.H{"paused": h.KeyManager.IsPaused()})
+}
+
+// RunSchedulerJobHandler starts a scheduler job immediately in the background.
+func (h *Handler) RunSchedulerJobHandler(c *gin.Context) {
+	if h.Jobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Scheduler is not available"})
+		return
+	}
+	name := c.Param("job")
+	job, ok := h.Jobs.Job(name)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown job %q", name)})
+		return
+	}
+	go job()
+	c.JSON(http.StatusAccepted, gin.H{"message": fmt.Sprintf("Job %s started in the background.", name)})
+}
+
+// RecentRequestsHandler returns the most recently proxied requests, newest first.
+func (h *Handler) RecentRequestsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, h.RequestLog.Recent())
+}
+
 // Client Key Handlers
 
 type UpdateClientKeyRequest struct {
-	Key         string `json:"key"`
-	Status      string `json:"status"`
-	Permissions string `json:"permissions"`
+	Key          string  `json:"key"`
+	Status       string  `json:"status"`
+	Permissions  string  `json:"permissions"`
+	KeyGroup     *string `json:"key_group"`
+	MonthlyQuota *int    `json:"monthly_quota"`
 }
 
 func (h *Handler) ListClientKeysHandler(c *gin.Context) {
-	keys, err := h.db.ListAPIKeys()
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	statusFilter := c.DefaultQuery("status", "all")
+
+	keys, total, err := h.db.ListAPIKeysPaged(page, limit, statusFilter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list client keys"})
 		return
 	}
-	c.JSON(http.StatusOK, keys)
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys":  keys,
+		"total": total,
+	})
+}
+
+// SearchClientKeysHandler finds client keys by the end of their value. Key values are masked
+// in the response.
+func (h *Handler) SearchClientKeysHandler(c *gin.Context) {
+	suffix, ok := searchSuffix(c)
+	if !ok {
+		return
+	}
+	keys, err := h.db.FindAPIKeysBySuffix(suffix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search client keys"})
+		return
+	}
+	for i := range keys {
+		keys[i].Key = maskKey(keys[i].Key)
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
 }
 
 func (h *Handler) CreateClientKeyHandler(c *gin.Context) {
@@ -221,6 +772,71 @@ func (h *Handler) CreateClientKeyHandler(c *gin.Context) {
 	c.JSON(http.StatusCreated, key)
 }
 
+// GenerateClientKeyRequest is the optional body of GenerateClientKeyHandler.
+type GenerateClientKeyRequest struct {
+	Prefix       *string    `json:"prefix"`
+	Length       int        `json:"length"`
+	Permissions  string     `json:"permissions"`
+	RateLimit    int        `json:"rate_limit"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	KeyGroup     string     `json:"key_group"`
+	MonthlyQuota int        `json:"monthly_quota"`
+}
+
+// GenerateClientKeyHandler creates a client key with a securely random value.
+// The plaintext key is only returned in this response.
+func (h *Handler) GenerateClientKeyHandler(c *gin.Context) {
+	var req GenerateClientKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.RateLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rate_limit must not be negative"})
+		return
+	}
+	if req.MonthlyQuota < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "monthly_quota must not be negative"})
+		return
+	}
+
+	prefix := auth.DefaultKeyPrefix
+	if req.Prefix != nil {
+		prefix = *req.Prefix
+	}
+	length := req.Length
+	if length == 0 {
+		length = auth.DefaultKeyLength
+	}
+
+	value, err := auth.GenerateAPIKey(prefix, length)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidKeyLength) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("length must be between %d and %d", auth.MinKeyLength, auth.MaxKeyLength)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate client key"})
+		return
+	}
+
+	key := model.APIKey{
+		Key:          value,
+		Status:       "active",
+		Permissions:  req.Permissions,
+		RateLimit:    req.RateLimit,
+		KeyGroup:     strings.TrimSpace(req.KeyGroup),
+		MonthlyQuota: req.MonthlyQuota,
+	}
+	if req.ExpiresAt != nil {
+		key.ExpiresAt = *req.ExpiresAt
+	}
+	if err := h.db.CreateAPIKey(&key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create client key"})
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
 func (h *Handler) GetClientKeyHandler(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -270,6 +886,16 @@ func (h *Handler) UpdateClientKeyHandler(c *gin.Context) {
 	if req.Permissions != "" {
 		key.Permissions = req.Permissions
 	}
+	if req.KeyGroup != nil {
+		key.KeyGroup = strings.TrimSpace(*req.KeyGroup)
+	}
+	if req.MonthlyQuota != nil {
+		if *req.MonthlyQuota < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "monthly_quota must not be negative"})
+			return
+		}
+		key.MonthlyQuota = *req.MonthlyQuota
+	}
 
 	if err := h.db.UpdateAPIKey(key); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update client key"})
@@ -309,6 +935,7 @@ func (h *Handler) ResetClientKeyHandler(c *gin.Context) {
 	}
 
 	key.UsageCount = 0
+	key.UsageThisMonth = 0
 
 	if err := h.db.UpdateAPIKey(key); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update client key"})
@@ -1,17 +1,21 @@
 package admin
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
 	"github.com/ubuygold/gogemini/internal/keymanager"
 	"github.com/ubuygold/gogemini/internal/model"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -38,36 +42,117 @@ func (m *mockDBService) CreateGeminiKey(key *model.GeminiKey) error {
 	return args.Error(0)
 }
 
-func (m *mockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error) {
-	args := m.Called(page, limit, statusFilter, minFailureCount)
+func (m *mockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error) {
+	args := m.Called(page, limit, statusFilter, minFailureCount, sortBy, sortOrder)
 	return args.Get(0).([]model.GeminiKey), int64(args.Int(1)), args.Error(2)
 }
 
+func (m *mockDBService) GeminiKeyStatusCounts() (db.GeminiKeyCounts, error) {
+	args := m.Called()
+	return args.Get(0).(db.GeminiKeyCounts), args.Error(1)
+}
+
 func (m *mockDBService) UpdateGeminiKey(key *model.GeminiKey) error {
 	args := m.Called(key)
 	return args.Error(0)
 }
+func (m *mockDBService) UpdateGeminiKeyState(key *model.GeminiKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
 
 func (m *mockDBService) DeleteGeminiKey(id uint) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *mockDBService) BatchAddGeminiKeys(keys []string) error {
-	args := m.Called(keys)
+func (m *mockDBService) ListDeletedGeminiKeys() ([]model.GeminiKey, error) {
+	args := m.Called()
+	return args.Get(0).([]model.GeminiKey), args.Error(1)
+}
+
+func (m *mockDBService) RestoreGeminiKey(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockDBService) PurgeGeminiKey(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockDBService) ResetMonthlyAPIUsage() error {
+	args := m.Called()
 	return args.Error(0)
 }
 
+func (m *mockDBService) PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error) {
+	args := m.Called(minRevivalFailures, disabledBefore, softDelete)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockDBService) FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error) {
+	args := m.Called(suffix)
+	return args.Get(0).([]model.GeminiKey), args.Error(1)
+}
+
+func (m *mockDBService) FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error) {
+	args := m.Called(suffix)
+	return args.Get(0).([]model.APIKey), args.Error(1)
+}
+
+func (m *mockDBService) PurgeDeletedGeminiKeys() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockDBService) StreamGeminiKeys(fn func(model.GeminiKey) error) error {
+	args := m.Called(fn)
+	if keys, ok := args.Get(0).([]model.GeminiKey); ok {
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *mockDBService) BatchAddGeminiKeys(keys []string, status string) (int64, error) {
+	args := m.Called(keys, status)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (m *mockDBService) BatchDeleteGeminiKeys(ids []uint) error {
 	args := m.Called(ids)
 	return args.Error(0)
 }
 
+func (m *mockDBService) BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error) {
+	args := m.Called(ids, status)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockDBService) ResetAllGeminiFailureCounts() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *mockDBService) Ping() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 func (m *mockDBService) ListAPIKeys() ([]model.APIKey, error) {
 	args := m.Called()
 	return args.Get(0).([]model.APIKey), args.Error(1)
 }
 
+func (m *mockDBService) ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error) {
+	args := m.Called(page, limit, statusFilter)
+	return args.Get(0).([]model.APIKey), args.Get(1).(int64), args.Error(2)
+}
+
 func (m *mockDBService) CreateAPIKey(key *model.APIKey) error {
 	args := m.Called(key)
 	return args.Error(0)
@@ -96,23 +181,68 @@ type MockKeyManager struct {
 	mock.Mock
 }
 
-func (m *MockKeyManager) GetNextKey() (string, error) {
-	args := m.Called()
+func (m *MockKeyManager) GetNextKey(group, model string) (string, error) {
+	args := m.Called(group, model)
+	return args.String(0), args.Error(1)
+}
+func (m *MockKeyManager) GetKeyForSession(sessionID, group, model string) (string, error) {
+	args := m.Called(sessionID, group, model)
 	return args.String(0), args.Error(1)
 }
-func (m *MockKeyManager) HandleKeyFailure(key string) { m.Called(key) }
+
+func (m *MockKeyManager) HandleKeyFailure(key string, statusCode int, errorBody string) {
+	m.Called(key, statusCode, errorBody)
+}
 func (m *MockKeyManager) HandleKeySuccess(key string) { m.Called(key) }
 func (m *MockKeyManager) ReviveDisabledKeys()         { m.Called() }
 func (m *MockKeyManager) CheckAllKeysHealth()         { m.Called() }
 func (m *MockKeyManager) GetAvailableKeyCount() int   { args := m.Called(); return args.Int(0) }
-func (m *MockKeyManager) TestKeyByID(id uint) error   { args := m.Called(id); return args.Error(0) }
-func (m *MockKeyManager) TestAllKeysAsync()           { m.Called() }
-func (m *MockKeyManager) Close()                      { m.Called() }
+func (m *MockKeyManager) AvailabilityError() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockKeyManager) SuspendedError() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockKeyManager) TestKeyByID(ctx context.Context, id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+func (m *MockKeyManager) TestAllKeysAsync()        { m.Called() }
+func (m *MockKeyManager) TestKeysAsync(ids []uint) { m.Called(ids) }
+func (m *MockKeyManager) ValidateRawKey(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+func (m *MockKeyManager) ReloadKeys() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+func (m *MockKeyManager) Snapshot() []keymanager.KeyRuntimeInfo {
+	args := m.Called()
+	return args.Get(0).([]keymanager.KeyRuntimeInfo)
+}
+func (m *MockKeyManager) LowAvailableKeyChecks() int64 {
+	args := m.Called()
+	return args.Get(0).(int64)
+}
+func (m *MockKeyManager) PeekNextKey() (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+func (m *MockKeyManager) SetPaused(paused bool) { m.Called(paused) }
+func (m *MockKeyManager) IsPaused() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+func (m *MockKeyManager) Drain() { m.Called() }
+func (m *MockKeyManager) Close() { m.Called() }
 
 func setupTestRouter(dbService db.Service, km keymanager.Manager, cfg *config.Config) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	SetupRoutes(router, dbService, km, cfg)
+	SetupRoutes(router, dbService, km, nil, nil, cfg)
 	return router
 }
 
@@ -175,6 +305,104 @@ func TestKeyTestHandlers(t *testing.T) {
 		mockKM.AssertExpectations(t)
 	})
 
+	t.Run("TestGeminiKeysBatchHandler", func(t *testing.T) {
+		mockKM.On("TestKeysAsync", []uint{3, 5}).Return().Once()
+
+		body := `{"ids": [3, 5, 3]}`
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/test-batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		assert.JSONEq(t, `{"message": "Key test initiated in the background.", "count": 2, "ids": [3, 5]}`, resp.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("TestGeminiKeysBatchHandler rejects empty ids", func(t *testing.T) {
+		for _, body := range []string{`{"ids": []}`, `{}`, `{"ids": "1"}`} {
+			req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/test-batch", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.SetBasicAuth("admin", "test-password")
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+		}
+	})
+
+	t.Run("ReloadGeminiKeysHandler returns active key count", func(t *testing.T) {
+		mockKM.On("ReloadKeys").Return(7, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/reload", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var jsonResp map[string]int
+		err := json.Unmarshal(resp.Body.Bytes(), &jsonResp)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, jsonResp["active_keys"])
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("ReloadGeminiKeysHandler failure", func(t *testing.T) {
+		mockKM.On("ReloadKeys").Return(0, errors.New("db down")).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/reload", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("ValidateGeminiKeyHandler valid key", func(t *testing.T) {
+		mockKM.On("ValidateRawKey", "raw-key").Return(nil).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/validate", strings.NewReader(`{"key": "raw-key"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"valid": true, "upstream_status": 200}`, resp.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("ValidateGeminiKeyHandler invalid key", func(t *testing.T) {
+		mockKM.On("ValidateRawKey", "bad-key").Return(&keymanager.KeyTestError{StatusCode: http.StatusBadRequest, Body: "API key not valid"}).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/validate", strings.NewReader(`{"key": "bad-key"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var jsonResp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &jsonResp))
+		assert.Equal(t, false, jsonResp["valid"])
+		assert.Equal(t, float64(http.StatusBadRequest), jsonResp["upstream_status"])
+		assert.Contains(t, jsonResp["error"], "API key not valid")
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("ValidateGeminiKeyHandler missing key", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/validate", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockKM.AssertNumberOfCalls(t, "ValidateRawKey", 2) // only the two subtests above
+	})
+
 	t.Run("TestGeminiKeyHandler invalid id", func(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/abc/test", nil)
 		req.SetBasicAuth("admin", "test-password")
@@ -265,12 +493,60 @@ func TestCreateGeminiKeyHandler(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, resp.Code)
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("CreateGeminiKeyHandler sets disable threshold", func(t *testing.T) {
+		mockDB.On("CreateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == "new-key" && k.DisableThreshold == 10
+		})).Return(nil).Once()
+
+		body := `{"key": "new-key", "disable_threshold": 10}`
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("CreateGeminiKeyHandler sets project label", func(t *testing.T) {
+		mockDB.On("CreateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == "new-key" && k.ProjectLabel == "project-foo"
+		})).Return(nil).Once()
+
+		body := `{"key": "new-key", "project_label": " project-foo "}`
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		var created model.GeminiKey
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+		assert.Equal(t, "project-foo", created.ProjectLabel)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("CreateGeminiKeyHandler rejects negative disable threshold", func(t *testing.T) {
+		body := `{"key": "new-key", "disable_threshold": -1}`
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
 }
 
 func TestUpdateGeminiKeyHandler(t *testing.T) {
 	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
 	mockDB := &mockDBService{}
-	router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+	mockKM := &MockKeyManager{}
+	mockKM.On("ReloadKeys").Return(1, nil)
+	router := setupTestRouter(mockDB, mockKM, cfg)
 
 	t.Run("UpdateGeminiKeyHandler success", func(t *testing.T) {
 		existingKey := &model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "old-key", Status: "active"}
@@ -290,6 +566,94 @@ func TestUpdateGeminiKeyHandler(t *testing.T) {
 		assert.Equal(t, "new-key", updatedKey.Key)
 		assert.Equal(t, "disabled", updatedKey.Status)
 		mockDB.AssertExpectations(t)
+		mockKM.AssertCalled(t, "ReloadKeys")
+	})
+
+	t.Run("UpdateGeminiKeyHandler sets daily quota", func(t *testing.T) {
+		existingKey := &model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "old-key", Status: "active"}
+		mockDB.On("GetGeminiKey", uint(1)).Return(existingKey, nil).Once()
+		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.DailyQuota == 1500 && k.Key == "old-key"
+		})).Return(nil).Once()
+
+		body := `{"daily_quota": 1500}`
+		req, _ := http.NewRequest(http.MethodPut, "/admin/gemini-keys/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("UpdateGeminiKeyHandler sets disable threshold", func(t *testing.T) {
+		existingKey := &model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "old-key", Status: "active"}
+		mockDB.On("GetGeminiKey", uint(1)).Return(existingKey, nil).Once()
+		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.DisableThreshold == 1 && k.Key == "old-key"
+		})).Return(nil).Once()
+
+		body := `{"disable_threshold": 1}`
+		req, _ := http.NewRequest(http.MethodPut, "/admin/gemini-keys/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("UpdateGeminiKeyHandler sets project label", func(t *testing.T) {
+		existingKey := &model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "old-key", Status: "active", ProjectLabel: "project-foo"}
+		mockDB.On("GetGeminiKey", uint(1)).Return(existingKey, nil).Once()
+		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.ProjectLabel == "project-bar" && k.Key == "old-key"
+		})).Return(nil).Once()
+
+		body := `{"project_label": "project-bar"}`
+		req, _ := http.NewRequest(http.MethodPut, "/admin/gemini-keys/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("UpdateGeminiKeyHandler keeps project label when omitted", func(t *testing.T) {
+		existingKey := &model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "old-key", Status: "active", ProjectLabel: "project-foo"}
+		mockDB.On("GetGeminiKey", uint(1)).Return(existingKey, nil).Once()
+		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.ProjectLabel == "project-foo" && k.Status == "disabled"
+		})).Return(nil).Once()
+
+		body := `{"status": "disabled"}`
+		req, _ := http.NewRequest(http.MethodPut, "/admin/gemini-keys/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("UpdateGeminiKeyHandler rejects negative daily quota", func(t *testing.T) {
+		existingKey := &model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "old-key", Status: "active"}
+		mockDB.On("GetGeminiKey", uint(1)).Return(existingKey, nil).Once()
+
+		body := `{"daily_quota": -1}`
+		req, _ := http.NewRequest(http.MethodPut, "/admin/gemini-keys/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertExpectations(t)
 	})
 
 	t.Run("UpdateGeminiKeyHandler not found", func(t *testing.T) {
@@ -311,45 +675,463 @@ func TestUpdateGeminiKeyHandler(t *testing.T) {
 		req.SetBasicAuth("admin", "test-password")
 		resp := httptest.NewRecorder()
 		router.ServeHTTP(resp, req)
-		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
+
+func TestGeminiKeysRuntimeHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+	mockKM := &MockKeyManager{}
+	router := setupTestRouter(&mockDBService{}, mockKM, cfg)
+
+	disabledAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockKM.On("Snapshot").Return([]keymanager.KeyRuntimeInfo{
+		{ID: 1, KeySuffix: "aaaa", Status: "active", UsageCount: 10, Available: true},
+		{ID: 2, KeySuffix: "bbbb", Status: "disabled", FailureCount: 3, Disabled: true, DisabledAt: &disabledAt},
+	}).Once()
+	mockKM.On("LowAvailableKeyChecks").Return(int64(4)).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/runtime", nil)
+	req.SetBasicAuth("admin", "test-password")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var body struct {
+		Keys                  []keymanager.KeyRuntimeInfo `json:"keys"`
+		LowAvailableKeyChecks int64                       `json:"low_available_key_checks"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.Len(t, body.Keys, 2)
+	assert.Equal(t, int64(4), body.LowAvailableKeyChecks)
+	assert.False(t, body.Keys[0].Disabled)
+	assert.True(t, body.Keys[1].Disabled)
+	assert.Equal(t, "bbbb", body.Keys[1].KeySuffix)
+	assert.True(t, disabledAt.Equal(*body.Keys[1].DisabledAt))
+	mockKM.AssertExpectations(t)
+}
+
+func TestSearchKeysHandlers(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	t.Run("gemini keys are masked", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		mockDB.On("FindGeminiKeysBySuffix", "Xy_9").Return([]model.GeminiKey{{Key: "AIzaSecretValueXy_9", Status: "active"}}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/search?suffix=Xy_9", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"Key":"...Xy_9"`)
+		assert.NotContains(t, resp.Body.String(), "Secret")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("client keys are masked", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		mockDB.On("FindAPIKeysBySuffix", "abcd1234").Return([]model.APIKey{{Key: "sk-secretabcd1234"}}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/client-keys/search?suffix=abcd1234", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"Key":"...1234"`)
+		assert.NotContains(t, resp.Body.String(), "secret")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("suffix too short", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/search?suffix=ab", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertNotCalled(t, "FindGeminiKeysBySuffix", mock.Anything)
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		mockDB.On("FindAPIKeysBySuffix", "abcd").Return([]model.APIKey(nil), errors.New("db error")).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/client-keys/search?suffix=abcd", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestPeekNextGeminiKeyHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	t.Run("returns the next key suffix", func(t *testing.T) {
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(&mockDBService{}, mockKM, cfg)
+		mockKM.On("PeekNextKey").Return("abcd", nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/next", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"key_suffix":"abcd"}`, resp.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("no key available", func(t *testing.T) {
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(&mockDBService{}, mockKM, cfg)
+		mockKM.On("PeekNextKey").Return("", errors.New("no active Gemini keys available")).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/next", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		mockKM.AssertExpectations(t)
+	})
+}
+
+func TestPauseResumeProxyHandlers(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	t.Run("pause", func(t *testing.T) {
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(&mockDBService{}, mockKM, cfg)
+		mockKM.On("SetPaused", true).Once()
+		mockKM.On("IsPaused").Return(true).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/proxy/pause", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"paused":true}`, resp.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(&mockDBService{}, mockKM, cfg)
+		mockKM.On("SetPaused", false).Once()
+		mockKM.On("IsPaused").Return(false).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/proxy/resume", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"paused":false}`, resp.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("requires admin auth", func(t *testing.T) {
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(&mockDBService{}, mockKM, cfg)
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/proxy/pause", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockKM.AssertNotCalled(t, "SetPaused", mock.Anything)
+	})
+}
+
+func TestRotateGeminiKeyHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	newKey := func() *model.GeminiKey {
+		return &model.GeminiKey{
+			Model:        gorm.Model{ID: 1},
+			Key:          "old-secret",
+			Status:       "disabled",
+			FailureCount: 5,
+			UsageCount:   1234,
+			LastFailedAt: time.Now(),
+		}
+	}
+
+	t.Run("rotates the secret and keeps the stats", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+
+		mockDB.On("GetGeminiKey", uint(1)).Return(newKey(), nil).Once()
+		mockKM.On("ValidateRawKey", "new-secret").Return(nil).Once()
+		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.ID == 1 && k.Key == "new-secret" && k.Status == "active" &&
+				k.FailureCount == 0 && k.LastFailedAt.IsZero() && k.UsageCount == 1234
+		})).Return(nil).Once()
+		mockKM.On("ReloadKeys").Return(1, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/1/rotate", strings.NewReader(`{"key": " new-secret "}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("rejects an invalid secret without changing the key", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+
+		mockDB.On("GetGeminiKey", uint(1)).Return(newKey(), nil).Once()
+		mockKM.On("ValidateRawKey", "bad-secret").Return(&keymanager.KeyTestError{StatusCode: http.StatusForbidden, Body: "denied"}).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/1/rotate", strings.NewReader(`{"key": "bad-secret"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, float64(http.StatusForbidden), body["upstream_status"])
+		mockDB.AssertNotCalled(t, "UpdateGeminiKey", mock.Anything)
+		mockKM.AssertNotCalled(t, "ReloadKeys")
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		router := setupTestRouter(&mockDBService{}, &MockKeyManager{}, cfg)
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/1/rotate", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		mockDB.On("GetGeminiKey", uint(9)).Return(nil, db.ErrGeminiKeyNotFound).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/9/rotate", strings.NewReader(`{"key": "new-secret"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestDeleteGeminiKeyHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+	mockDB := &mockDBService{}
+	mockKM := &MockKeyManager{}
+	router := setupTestRouter(mockDB, mockKM, cfg)
+
+	t.Run("DeleteGeminiKeyHandler success", func(t *testing.T) {
+		mockDB.On("DeleteGeminiKey", uint(1)).Return(nil).Once()
+		mockKM.On("ReloadKeys").Return(1, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/1", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("DeleteGeminiKeyHandler db error", func(t *testing.T) {
+		mockDB.On("DeleteGeminiKey", uint(1)).Return(errors.New("db error")).Once()
+
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/1", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("DeleteGeminiKeyHandler invalid id", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/abc", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
+
+func TestGeminiKeyTrashHandlers(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+	mockDB := &mockDBService{}
+	mockKM := &MockKeyManager{}
+	router := setupTestRouter(mockDB, mockKM, cfg)
+
+	t.Run("ListDeletedGeminiKeysHandler success", func(t *testing.T) {
+		deleted := []model.GeminiKey{{Model: gorm.Model{ID: 1}, Key: "deleted-key"}}
+		mockDB.On("ListDeletedGeminiKeys").Return(deleted, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/trash", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var jsonResp struct {
+			Keys []model.GeminiKey `json:"keys"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &jsonResp))
+		assert.Len(t, jsonResp.Keys, 1)
+		assert.Equal(t, "deleted-key", jsonResp.Keys[0].Key)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("RestoreGeminiKeyHandler success", func(t *testing.T) {
+		mockDB.On("RestoreGeminiKey", uint(1)).Return(nil).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/1/restore", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("RestoreGeminiKeyHandler not found", func(t *testing.T) {
+		mockDB.On("RestoreGeminiKey", uint(2)).Return(db.ErrGeminiKeyNotFound).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/2/restore", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("PurgeGeminiKeyHandler success", func(t *testing.T) {
+		mockDB.On("PurgeGeminiKey", uint(1)).Return(nil).Once()
+		mockKM.On("ReloadKeys").Return(1, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/1/purge", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("PurgeGeminiKeyHandler invalid id", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/abc/purge", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("PurgeDeletedGeminiKeysHandler success", func(t *testing.T) {
+		mockDB.On("PurgeDeletedGeminiKeys").Return(int64(3), nil).Once()
+
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/trash", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"purged": 3}`, resp.Body.String())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("PurgeDeletedGeminiKeysHandler db error", func(t *testing.T) {
+		mockDB.On("PurgeDeletedGeminiKeys").Return(int64(0), errors.New("db error")).Once()
+
+		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/trash", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockDB.AssertExpectations(t)
 	})
 }
 
-func TestDeleteGeminiKeyHandler(t *testing.T) {
-	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+func TestNewKeyDefaultStatus(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{Password: "test-password"},
+		Proxy: config.ProxyConfig{NewKeyDefaultStatus: config.KeyStatusPending},
+	}
 	mockDB := &mockDBService{}
 	router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
 
-	t.Run("DeleteGeminiKeyHandler success", func(t *testing.T) {
-		mockDB.On("DeleteGeminiKey", uint(1)).Return(nil).Once()
+	t.Run("created key is pending", func(t *testing.T) {
+		mockDB.On("CreateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == "new-key" && k.Status == "pending"
+		})).Return(nil).Once()
 
-		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/1", nil)
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys", strings.NewReader(`{"key": "new-key"}`))
+		req.Header.Set("Content-Type", "application/json")
 		req.SetBasicAuth("admin", "test-password")
 		resp := httptest.NewRecorder()
 		router.ServeHTTP(resp, req)
 
-		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, http.StatusCreated, resp.Code)
 		mockDB.AssertExpectations(t)
 	})
 
-	t.Run("DeleteGeminiKeyHandler db error", func(t *testing.T) {
-		mockDB.On("DeleteGeminiKey", uint(1)).Return(errors.New("db error")).Once()
+	t.Run("batch created keys are pending", func(t *testing.T) {
+		mockDB.On("BatchAddGeminiKeys", []string{"key1", "key2"}, "pending").Return(int64(2), nil).Once()
 
-		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/1", nil)
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/batch", strings.NewReader(`{"keys": ["key1", "key2"]}`))
+		req.Header.Set("Content-Type", "application/json")
 		req.SetBasicAuth("admin", "test-password")
 		resp := httptest.NewRecorder()
 		router.ServeHTTP(resp, req)
 
-		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		assert.Equal(t, http.StatusCreated, resp.Code)
 		mockDB.AssertExpectations(t)
 	})
 
-	t.Run("DeleteGeminiKeyHandler invalid id", func(t *testing.T) {
-		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/abc", nil)
+	t.Run("created key is active by default", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, &config.Config{Admin: config.AdminConfig{Password: "test-password"}})
+		mockDB.On("CreateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Status == "active"
+		})).Return(nil).Once()
+
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys", strings.NewReader(`{"key": "new-key"}`))
+		req.Header.Set("Content-Type", "application/json")
 		req.SetBasicAuth("admin", "test-password")
 		resp := httptest.NewRecorder()
 		router.ServeHTTP(resp, req)
-		assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		mockDB.AssertExpectations(t)
 	})
 }
 
@@ -360,7 +1142,7 @@ func TestBatchCreateGeminiKeysHandler(t *testing.T) {
 
 	t.Run("BatchCreateGeminiKeysHandler success", func(t *testing.T) {
 		keys := []string{"key1", "key2"}
-		mockDB.On("BatchAddGeminiKeys", keys).Return(nil).Once()
+		mockDB.On("BatchAddGeminiKeys", keys, "active").Return(int64(2), nil).Once()
 
 		body := `{"keys": ["key1", "key2"]}`
 		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/batch", strings.NewReader(body))
@@ -385,14 +1167,117 @@ func TestBatchCreateGeminiKeysHandler(t *testing.T) {
 	})
 }
 
-func TestBatchDeleteGeminiKeysHandler(t *testing.T) {
+func TestImportGeminiKeysHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+	mockDB := &mockDBService{}
+	router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+
+	doImport := func(contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("plain text ignores blank lines and whitespace", func(t *testing.T) {
+		mockDB.On("BatchAddGeminiKeys", []string{"key1", "key2", "key3"}, "active").Return(int64(3), nil).Once()
+
+		resp := doImport("text/plain; charset=utf-8", "key1\n\n  key2  \r\n\t\nkey1\nkey3\n")
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		var summary map[string]int
+		json.Unmarshal(resp.Body.Bytes(), &summary)
+		assert.Equal(t, 3, summary["added"])
+		assert.Equal(t, 1, summary["skipped"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("csv with header and optional priority column", func(t *testing.T) {
+		mockDB.On("BatchAddGeminiKeys", []string{"key1", "key2"}, "active").Return(int64(2), nil).Once()
+
+		resp := doImport("text/csv", "key,priority\nkey1,1\n\n key2\nkey2,5\n")
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		var summary map[string]int
+		json.Unmarshal(resp.Body.Bytes(), &summary)
+		assert.Equal(t, 2, summary["added"])
+		assert.Equal(t, 1, summary["skipped"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("keys already stored count as skipped", func(t *testing.T) {
+		mockDB.On("BatchAddGeminiKeys", []string{"key1", "key2", "key3"}, "active").Return(int64(1), nil).Once()
+
+		resp := doImport("text/plain", "key1\nkey2\nkey3\nkey3\n")
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		var summary map[string]int
+		json.Unmarshal(resp.Body.Bytes(), &summary)
+		assert.Equal(t, 1, summary["added"])
+		assert.Equal(t, 3, summary["skipped"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("csv with invalid priority", func(t *testing.T) {
+		resp := doImport("text/csv", "key1,high\n")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		resp := doImport("application/json", `{"keys": ["key1"]}`)
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		mockDB.On("BatchAddGeminiKeys", []string{"key1"}, "active").Return(int64(0), errors.New("db error")).Once()
+
+		resp := doImport("text/plain", "key1\n")
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestExportGeminiKeysHandler(t *testing.T) {
 	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
 	mockDB := &mockDBService{}
 	router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
 
+	keys := []model.GeminiKey{
+		{Model: gorm.Model{ID: 1}, Key: "key1", Status: "active", FailureCount: 0, UsageCount: 42},
+		{Model: gorm.Model{ID: 2}, Key: "key2", Status: "disabled", FailureCount: 3, UsageCount: 7},
+	}
+	mockDB.On("StreamGeminiKeys", mock.Anything).Return(keys, nil).Once()
+
+	req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys/export", nil)
+	req.SetBasicAuth("admin", "test-password")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/csv", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "attachment")
+	lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+	assert.Equal(t, []string{
+		"id,key,status,failure_count,usage_count",
+		"1,key1,active,0,42",
+		"2,key2,disabled,3,7",
+	}, lines)
+	mockDB.AssertExpectations(t)
+}
+
+func TestBatchDeleteGeminiKeysHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+	mockDB := &mockDBService{}
+	mockKM := &MockKeyManager{}
+	router := setupTestRouter(mockDB, mockKM, cfg)
+
 	t.Run("BatchDeleteGeminiKeysHandler success", func(t *testing.T) {
 		ids := []uint{1, 2}
 		mockDB.On("BatchDeleteGeminiKeys", ids).Return(nil).Once()
+		mockKM.On("ReloadKeys").Return(0, nil).Once()
 
 		body := `{"ids": [1, 2]}`
 		req, _ := http.NewRequest(http.MethodDelete, "/admin/gemini-keys/batch", strings.NewReader(body))
@@ -417,6 +1302,126 @@ func TestBatchDeleteGeminiKeysHandler(t *testing.T) {
 	})
 }
 
+func TestBatchUpdateGeminiKeyStatusHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	send := func(router *gin.Engine, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/batch-status", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("success reloads keys", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+		mockDB.On("BatchUpdateGeminiKeyStatus", []uint{1, 2, 3}, "active").Return(int64(2), nil).Once()
+		mockKM.On("ReloadKeys").Return(3, nil).Once()
+
+		resp := send(router, `{"ids": [1, 2, 3], "status": "active"}`)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"updated":2`, "only the keys actually updated are reported")
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("accepts pending", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+		mockDB.On("BatchUpdateGeminiKeyStatus", []uint{4}, "pending").Return(int64(1), nil).Once()
+		mockKM.On("ReloadKeys").Return(0, nil).Once()
+
+		resp := send(router, `{"ids": [4], "status": "pending"}`)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("invalid status lists the valid ones", func(t *testing.T) {
+		router := setupTestRouter(&mockDBService{}, &MockKeyManager{}, cfg)
+
+		resp := send(router, `{"ids": [1], "status": "enabled"}`)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Contains(t, resp.Body.String(), "status must be one of active, disabled, dead, pending")
+	})
+
+	t.Run("rejects invalid input", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+
+		for name, body := range map[string]string{
+			"invalid status": `{"ids": [1], "status": "enabled"}`,
+			"missing status": `{"ids": [1]}`,
+			"empty ids":      `{"ids": [], "status": "active"}`,
+			"malformed body": `{"ids": "1", "status": "active"}`,
+		} {
+			resp := send(router, body)
+			assert.Equal(t, http.StatusBadRequest, resp.Code, name)
+		}
+		mockDB.AssertNotCalled(t, "BatchUpdateGeminiKeyStatus", mock.Anything, mock.Anything)
+		mockKM.AssertNotCalled(t, "ReloadKeys")
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+		mockDB.On("BatchUpdateGeminiKeyStatus", []uint{1}, "disabled").Return(int64(0), errors.New("db error")).Once()
+
+		resp := send(router, `{"ids": [1], "status": "disabled"}`)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockKM.AssertNotCalled(t, "ReloadKeys")
+	})
+}
+
+func TestResetGeminiKeyFailuresHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	send := func(router *gin.Engine) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/reset-failures", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("success reloads keys", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+		mockDB.On("ResetAllGeminiFailureCounts").Return(nil).Once()
+		mockKM.On("ReloadKeys").Return(4, nil).Once()
+
+		resp := send(router)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"active_keys":4`)
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		mockKM := &MockKeyManager{}
+		router := setupTestRouter(mockDB, mockKM, cfg)
+		mockDB.On("ResetAllGeminiFailureCounts").Return(errors.New("db error")).Once()
+
+		resp := send(router)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		mockKM.AssertNotCalled(t, "ReloadKeys")
+	})
+}
+
 func TestListClientKeysHandler(t *testing.T) {
 	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
 	mockDB := &mockDBService{}
@@ -424,7 +1429,7 @@ func TestListClientKeysHandler(t *testing.T) {
 
 	t.Run("ListClientKeysHandler success", func(t *testing.T) {
 		expectedKeys := []model.APIKey{{Model: gorm.Model{ID: 1}, Key: "client-key-1"}}
-		mockDB.On("ListAPIKeys").Return(expectedKeys, nil).Once()
+		mockDB.On("ListAPIKeysPaged", 1, 10, "all").Return(expectedKeys, int64(1), nil).Once()
 
 		req, _ := http.NewRequest(http.MethodGet, "/admin/client-keys", nil)
 		req.SetBasicAuth("admin", "test-password")
@@ -432,10 +1437,26 @@ func TestListClientKeysHandler(t *testing.T) {
 		router.ServeHTTP(resp, req)
 
 		assert.Equal(t, http.StatusOK, resp.Code)
-		var keys []model.APIKey
-		json.Unmarshal(resp.Body.Bytes(), &keys)
-		assert.Len(t, keys, 1)
-		assert.Equal(t, "client-key-1", keys[0].Key)
+		var body struct {
+			Keys  []model.APIKey `json:"keys"`
+			Total int64          `json:"total"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		assert.Len(t, body.Keys, 1)
+		assert.Equal(t, "client-key-1", body.Keys[0].Key)
+		assert.Equal(t, int64(1), body.Total)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListClientKeysHandler passes pagination and filter", func(t *testing.T) {
+		mockDB.On("ListAPIKeysPaged", 2, 5, "disabled").Return([]model.APIKey{}, int64(6), nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/client-keys?page=2&limit=5&status=disabled", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
 		mockDB.AssertExpectations(t)
 	})
 }
@@ -460,6 +1481,75 @@ func TestCreateClientKeyHandler(t *testing.T) {
 	})
 }
 
+func TestGenerateClientKeyHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	send := func(router http.Handler, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/client-keys/generate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("defaults without a body", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		var created model.APIKey
+		mockDB.On("CreateAPIKey", mock.AnythingOfType("*model.APIKey")).Run(func(args mock.Arguments) {
+			created = *args.Get(0).(*model.APIKey)
+		}).Return(nil).Once()
+
+		resp := send(router, "")
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		assert.True(t, strings.HasPrefix(created.Key, "gg-"))
+		assert.Len(t, created.Key, len("gg-")+40)
+		assert.Equal(t, "active", created.Status)
+		assert.Contains(t, resp.Body.String(), created.Key)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("applies options from the body", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		var created model.APIKey
+		mockDB.On("CreateAPIKey", mock.AnythingOfType("*model.APIKey")).Run(func(args mock.Arguments) {
+			created = *args.Get(0).(*model.APIKey)
+		}).Return(nil).Once()
+
+		resp := send(router, `{"prefix": "team-", "length": 20, "permissions": "gemini", "rate_limit": 60, "expires_at": "2030-01-01T00:00:00Z"}`)
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		assert.True(t, strings.HasPrefix(created.Key, "team-"))
+		assert.Len(t, created.Key, len("team-")+20)
+		assert.Equal(t, "gemini", created.Permissions)
+		assert.Equal(t, 60, created.RateLimit)
+		assert.Equal(t, 2030, created.ExpiresAt.Year())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+
+		assert.Equal(t, http.StatusBadRequest, send(router, `{"length": 4}`).Code)
+		assert.Equal(t, http.StatusBadRequest, send(router, `{"rate_limit": -1}`).Code)
+		assert.Equal(t, http.StatusBadRequest, send(router, `{not json`).Code)
+		mockDB.AssertNotCalled(t, "CreateAPIKey", mock.Anything)
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		mockDB := &mockDBService{}
+		router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
+		mockDB.On("CreateAPIKey", mock.AnythingOfType("*model.APIKey")).Return(errors.New("db error")).Once()
+
+		assert.Equal(t, http.StatusInternalServerError, send(router, "").Code)
+		mockDB.AssertExpectations(t)
+	})
+}
+
 func TestGetClientKeyHandler(t *testing.T) {
 	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
 	mockDB := &mockDBService{}
@@ -540,8 +1630,9 @@ func TestListGeminiKeysHandler(t *testing.T) {
 	router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
 
 	t.Run("ListGeminiKeysHandler success", func(t *testing.T) {
-		expectedKeys := []model.GeminiKey{{Model: gorm.Model{ID: 1}, Key: "key1"}, {Model: gorm.Model{ID: 2}, Key: "key2"}}
-		mockDB.On("ListGeminiKeys", 1, 10, "all", 0).Return(expectedKeys, 2, nil).Once()
+		expectedKeys := []model.GeminiKey{{Model: gorm.Model{ID: 1}, Key: "key1", ProjectLabel: "project-foo"}, {Model: gorm.Model{ID: 2}, Key: "key2"}}
+		mockDB.On("ListGeminiKeys", 1, 10, "all", 0, "", "").Return(expectedKeys, 2, nil).Once()
+		mockDB.On("GeminiKeyStatusCounts").Return(db.GeminiKeyCounts{Total: 11, Active: 5, Disabled: 3, Pending: 1, Dead: 2, Failing: 2}, nil).Once()
 
 		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys", nil)
 		req.SetBasicAuth("admin", "test-password")
@@ -555,11 +1646,49 @@ func TestListGeminiKeysHandler(t *testing.T) {
 		keys, ok := result["keys"].([]interface{})
 		assert.True(t, ok)
 		assert.Len(t, keys, 2)
+		assert.Equal(t, "project-foo", keys[0].(map[string]interface{})["ProjectLabel"])
+		assert.Equal(t, map[string]interface{}{
+			"total": float64(11), "active": float64(5), "disabled": float64(3), "pending": float64(1), "dead": float64(2), "failing": float64(2),
+		}, result["summary"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListGeminiKeysHandler summary ignores the status filter", func(t *testing.T) {
+		mockDB.On("ListGeminiKeys", 2, 5, "disabled", 0, "", "").Return([]model.GeminiKey{}, 3, nil).Once()
+		mockDB.On("GeminiKeyStatusCounts").Return(db.GeminiKeyCounts{Total: 9, Active: 5, Disabled: 3, Pending: 1}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys?page=2&limit=5&status=disabled", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var result struct {
+			Total   int64              `json:"total"`
+			Summary db.GeminiKeyCounts `json:"summary"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+		assert.Equal(t, int64(3), result.Total)
+		assert.Equal(t, int64(9), result.Summary.Total)
+		assert.Equal(t, int64(5), result.Summary.Active)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListGeminiKeysHandler summary error", func(t *testing.T) {
+		mockDB.On("ListGeminiKeys", 1, 10, "all", 0, "", "").Return([]model.GeminiKey{}, 0, nil).Once()
+		mockDB.On("GeminiKeyStatusCounts").Return(db.GeminiKeyCounts{}, errors.New("db error")).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
 		mockDB.AssertExpectations(t)
 	})
 
 	t.Run("ListGeminiKeysHandler db error", func(t *testing.T) {
-		mockDB.On("ListGeminiKeys", 1, 10, "all", 0).Return([]model.GeminiKey{}, 0, errors.New("db error")).Once()
+		mockDB.On("ListGeminiKeys", 1, 10, "all", 0, "", "").Return([]model.GeminiKey{}, 0, errors.New("db error")).Once()
 
 		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys", nil)
 		req.SetBasicAuth("admin", "test-password")
@@ -569,6 +1698,31 @@ func TestListGeminiKeysHandler(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, resp.Code)
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("ListGeminiKeysHandler passes sort parameters", func(t *testing.T) {
+		mockDB.On("ListGeminiKeys", 1, 10, "all", 0, "usage_count", "asc").Return([]model.GeminiKey{}, 0, nil).Once()
+		mockDB.On("GeminiKeyStatusCounts").Return(db.GeminiKeyCounts{}, nil).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys?sortBy=usage_count&sortOrder=asc", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListGeminiKeysHandler rejects invalid sort", func(t *testing.T) {
+		mockDB.On("ListGeminiKeys", 1, 10, "all", 0, "key", "").Return([]model.GeminiKey{}, 0, fmt.Errorf("%w: cannot sort by %q", db.ErrInvalidSort, "key")).Once()
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys?sortBy=key", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		mockDB.AssertExpectations(t)
+	})
 }
 
 func TestHandlerDBErrors(t *testing.T) {
@@ -578,7 +1732,7 @@ func TestHandlerDBErrors(t *testing.T) {
 	router := setupTestRouter(mockDB, &MockKeyManager{}, cfg)
 
 	t.Run("ListGeminiKeysHandler db error", func(t *testing.T) {
-		mockDB.On("ListGeminiKeys", 1, 10, "all", 0).Return([]model.GeminiKey{}, 0, errors.New("db error")).Once()
+		mockDB.On("ListGeminiKeys", 1, 10, "all", 0, "", "").Return([]model.GeminiKey{}, 0, errors.New("db error")).Once()
 
 		req, _ := http.NewRequest(http.MethodGet, "/admin/gemini-keys", nil)
 		req.SetBasicAuth("admin", "test-password")
@@ -633,7 +1787,7 @@ func TestHandlerDBErrors(t *testing.T) {
 
 	t.Run("BatchCreateGeminiKeysHandler db error", func(t *testing.T) {
 		keys := []string{"key1"}
-		mockDB.On("BatchAddGeminiKeys", keys).Return(errors.New("db error")).Once()
+		mockDB.On("BatchAddGeminiKeys", keys, "active").Return(int64(0), errors.New("db error")).Once()
 
 		body := `{"keys": ["key1"]}`
 		req, _ := http.NewRequest(http.MethodPost, "/admin/gemini-keys/batch", strings.NewReader(body))
@@ -662,7 +1816,7 @@ func TestHandlerDBErrors(t *testing.T) {
 	})
 
 	t.Run("ListClientKeysHandler db error", func(t *testing.T) {
-		mockDB.On("ListAPIKeys").Return([]model.APIKey{}, errors.New("db error")).Once()
+		mockDB.On("ListAPIKeysPaged", 1, 10, "all").Return([]model.APIKey{}, int64(0), errors.New("db error")).Once()
 
 		req, _ := http.NewRequest(http.MethodGet, "/admin/client-keys", nil)
 		req.SetBasicAuth("admin", "test-password")
@@ -792,3 +1946,102 @@ func TestResetClientKeyHandler(t *testing.T) {
 		mockDB.AssertExpectations(t)
 	})
 }
+
+// mockJobRunner records which scheduler jobs were started.
+type mockJobRunner struct {
+	ran chan string
+}
+
+func (m *mockJobRunner) Job(name string) (func(), bool) {
+	switch name {
+	case "revival", "health-check", "reset-usage":
+		return func() { m.ran <- name }, true
+	default:
+		return nil, false
+	}
+}
+
+func TestRunSchedulerJobHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+	jobs := &mockJobRunner{ran: make(chan string, 1)}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SetupRoutes(router, &mockDBService{}, &MockKeyManager{}, jobs, nil, cfg)
+
+	for _, name := range []string{"revival", "health-check", "reset-usage"} {
+		t.Run("runs "+name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/admin/scheduler/run/"+name, nil)
+			req.SetBasicAuth("admin", "test-password")
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusAccepted, resp.Code)
+			select {
+			case ran := <-jobs.ran:
+				assert.Equal(t, name, ran)
+			case <-time.After(time.Second):
+				t.Fatalf("job %s was not started", name)
+			}
+		})
+	}
+
+	t.Run("unknown job", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/admin/scheduler/run/nope", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("scheduler unavailable", func(t *testing.T) {
+		router := setupTestRouter(&mockDBService{}, &MockKeyManager{}, cfg)
+		req, _ := http.NewRequest(http.MethodPost, "/admin/scheduler/run/revival", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	})
+}
+
+func TestRecentRequestsHandler(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{Password: "test-password"}}
+
+	t.Run("returns the buffer newest first", func(t *testing.T) {
+		requestLog := requestlog.NewBuffer(2)
+		requestLog.Add(requestlog.Entry{Method: "POST", Path: "/first", Status: http.StatusOK})
+		requestLog.Add(requestlog.Entry{Method: "POST", Path: "/second", Status: http.StatusOK})
+		requestLog.Add(requestlog.Entry{Method: "GET", Path: "/third", Status: http.StatusBadGateway, KeySuffix: "abcd", Retries: 2})
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		SetupRoutes(router, &mockDBService{}, &MockKeyManager{}, nil, requestLog, cfg)
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/requests/recent", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var entries []requestlog.Entry
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &entries))
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "/third", entries[0].Path)
+		assert.Equal(t, "abcd", entries[0].KeySuffix)
+		assert.Equal(t, 2, entries[0].Retries)
+		assert.Equal(t, "/second", entries[1].Path)
+	})
+
+	t.Run("returns an empty list without a request log", func(t *testing.T) {
+		router := setupTestRouter(&mockDBService{}, &MockKeyManager{}, cfg)
+
+		req, _ := http.NewRequest(http.MethodGet, "/admin/requests/recent", nil)
+		req.SetBasicAuth("admin", "test-password")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `[]`, resp.Body.String())
+	})
+}
@@ -5,15 +5,21 @@ import (
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
 	"github.com/ubuygold/gogemini/internal/keymanager"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine, dbService db.Service, km keymanager.Manager, cfg *config.Config) {
+// SetupRoutes registers the admin API. jobs may be nil, in which case manual job runs are
+// unavailable; requestLog may be nil, in which case no recent requests are reported.
+func SetupRoutes(router *gin.Engine, dbService db.Service, km keymanager.Manager, jobs JobRunner, requestLog *requestlog.Buffer, cfg *config.Config) {
 	handler := NewHandler(dbService, km)
+	handler.Jobs = jobs
+	handler.RequestLog = requestLog
+	handler.NewKeyStatus = cfg.Proxy.NewKeyDefaultStatus
 
 	adminGroup := router.Group("/admin")
-	adminGroup.Use(auth.AdminAuthMiddleware(cfg.Admin.Password))
+	adminGroup.Use(auth.IPAllowMiddleware(cfg.Access.AdminAllowCIDRs), auth.AdminAuthMiddleware(cfg.Admin.Credentials()))
 	{
 		geminiKeysGroup := adminGroup.Group("/gemini-keys")
 		{
@@ -21,21 +27,43 @@ func SetupRoutes(router *gin.Engine, dbService db.Service, km keymanager.Manager
 			geminiKeysGroup.POST("", handler.CreateGeminiKeyHandler)
 			geminiKeysGroup.POST("/batch", handler.BatchCreateGeminiKeysHandler)
 			geminiKeysGroup.DELETE("/batch", handler.BatchDeleteGeminiKeysHandler)
+			geminiKeysGroup.POST("/batch-status", handler.BatchUpdateGeminiKeyStatusHandler)
+			geminiKeysGroup.POST("/reset-failures", handler.ResetGeminiKeyFailuresHandler)
+			geminiKeysGroup.POST("/import", handler.ImportGeminiKeysHandler)
+			geminiKeysGroup.GET("/export", handler.ExportGeminiKeysHandler)
 			geminiKeysGroup.POST("/test", handler.TestAllGeminiKeysHandler) // Bulk test
+			geminiKeysGroup.POST("/test-batch", handler.TestGeminiKeysBatchHandler)
+			geminiKeysGroup.POST("/validate", handler.ValidateGeminiKeyHandler)
+			geminiKeysGroup.POST("/reload", handler.ReloadGeminiKeysHandler)
+			geminiKeysGroup.GET("/trash", handler.ListDeletedGeminiKeysHandler)
+			geminiKeysGroup.DELETE("/trash", handler.PurgeDeletedGeminiKeysHandler)
+			geminiKeysGroup.GET("/runtime", handler.GeminiKeysRuntimeHandler)
+			geminiKeysGroup.GET("/next", handler.PeekNextGeminiKeyHandler)
+			geminiKeysGroup.GET("/search", handler.SearchGeminiKeysHandler)
 			geminiKeysGroup.GET("/:id", handler.GetGeminiKeyHandler)
 			geminiKeysGroup.PUT("/:id", handler.UpdateGeminiKeyHandler)
 			geminiKeysGroup.DELETE("/:id", handler.DeleteGeminiKeyHandler)
 			geminiKeysGroup.POST("/:id/test", handler.TestGeminiKeyHandler) // Single test
+			geminiKeysGroup.POST("/:id/rotate", handler.RotateGeminiKeyHandler)
+			geminiKeysGroup.POST("/:id/restore", handler.RestoreGeminiKeyHandler)
+			geminiKeysGroup.DELETE("/:id/purge", handler.PurgeGeminiKeyHandler)
 		}
 
 		clientKeysGroup := adminGroup.Group("/client-keys")
 		{
 			clientKeysGroup.GET("", handler.ListClientKeysHandler)
 			clientKeysGroup.POST("", handler.CreateClientKeyHandler)
+			clientKeysGroup.POST("/generate", handler.GenerateClientKeyHandler)
+			clientKeysGroup.GET("/search", handler.SearchClientKeysHandler)
 			clientKeysGroup.GET("/:id", handler.GetClientKeyHandler)
 			clientKeysGroup.PUT("/:id", handler.UpdateClientKeyHandler)
 			clientKeysGroup.DELETE("/:id", handler.DeleteClientKeyHandler)
 			clientKeysGroup.POST("/:id/reset", handler.ResetClientKeyHandler)
 		}
+
+		adminGroup.POST("/proxy/pause", handler.PauseProxyHandler)
+		adminGroup.POST("/proxy/resume", handler.ResumeProxyHandler)
+		adminGroup.POST("/scheduler/run/:job", handler.RunSchedulerJobHandler)
+		adminGroup.GET("/requests/recent", handler.RecentRequestsHandler)
 	}
 }
@@ -0,0 +1,53 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error types used in the OpenAI error envelope.
+const (
+	TypeServerError    = "server_error"
+	TypeInvalidRequest = "invalid_request_error"
+)
+
+// Error codes returned by the proxies.
+const (
+	CodeNoAvailableKeys     = "no_available_keys"
+	CodeNoKeysConfigured    = "no_keys_configured"
+	CodeKeysDisabled        = "keys_temporarily_disabled"
+	CodeKeySelectionPaused  = "key_selection_paused"
+	CodeShuttingDown        = "shutting_down"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeBadGateway          = "bad_gateway"
+	CodeUpstreamTimeout     = "upstream_timeout"
+	CodeServerBusy          = "server_busy"
+	CodeRequestTooLarge     = "request_too_large"
+	CodeInvalidRequestBody  = "invalid_request_body"
+	CodeInvalidAPIVersion   = "invalid_api_version"
+	CodeInvalidAPIKey       = "invalid_api_key"
+	CodePermissionDenied    = "permission_denied"
+	CodeInternalError       = "internal_error"
+)
+
+// Response is the OpenAI error envelope: {"error": {"message", "type", "param", "code"}}.
+type Response struct {
+	Error Detail `json:"error"`
+}
+
+// Detail describes a single error inside Response.
+type Detail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    string  `json:"code"`
+}
+
+// Write sends an error response in the OpenAI error envelope with the given status.
+// Headers already set on w, such as Retry-After, are kept.
+func Write(w http.ResponseWriter, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Response{Error: Detail{Message: message, Type: errType, Code: code}})
+}
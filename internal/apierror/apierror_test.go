@@ -0,0 +1,39 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rr.Header().Set("Retry-After", "30")
+
+	Write(rr, http.StatusServiceUnavailable, TypeServerError, CodeNoAvailableKeys, "No active API keys")
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"), "existing headers must be kept")
+	assert.JSONEq(t, `{"error": {"message": "No active API keys", "type": "server_error", "param": null, "code": "no_available_keys"}}`, rr.Body.String())
+
+	// OpenAI SDKs read the envelope's error object and its message, type and code fields.
+	var sdkShape struct {
+		Error *struct {
+			Message string          `json:"message"`
+			Type    string          `json:"type"`
+			Param   json.RawMessage `json:"param"`
+			Code    *string         `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sdkShape))
+	require.NotNil(t, sdkShape.Error)
+	assert.Equal(t, "No active API keys", sdkShape.Error.Message)
+	assert.Equal(t, "server_error", sdkShape.Error.Type)
+	require.NotNil(t, sdkShape.Error.Code)
+	assert.Equal(t, "no_available_keys", *sdkShape.Error.Code)
+}
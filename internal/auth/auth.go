@@ -1,76 +1,255 @@
 package auth
 
 import (
+	"context"
+	"crypto/subtle"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/ubuygold/gogemini/internal/apierror"
+	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
+	"github.com/ubuygold/gogemini/internal/model"
 
 	"errors"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(dbService db.Service) gin.HandlerFunc {
+// Scopes that can be granted to a client API key through its comma-separated Permissions.
+const (
+	ScopeGemini     = "gemini"
+	ScopeOpenAI     = "openai"
+	ScopeEmbeddings = "embeddings"
+	ScopeAnthropic  = "anthropic"
+)
+
+// APIKeyContextKey is the gin context key holding the authenticated *model.APIKey.
+const APIKeyContextKey = "api_key"
+
+// openAIErrorsKey is the gin context key set by OpenAIErrors.
+const openAIErrorsKey = "openai_errors"
+
+// OpenAIErrors makes the client middlewares after it report failures in the OpenAI error
+// envelope, which OpenAI SDKs expect, instead of the flat {"error": "..."} shape.
+func OpenAIErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(openAIErrorsKey, true)
+		c.Next()
+	}
+}
+
+// abort ends the request with status and message, in the OpenAI error envelope when
+// OpenAIErrors is in effect.
+func abort(c *gin.Context, status int, message string) {
+	if !c.GetBool(openAIErrorsKey) {
+		c.AbortWithStatusJSON(status, gin.H{"error": message})
+		return
+	}
+	errType, code := apierror.TypeInvalidRequest, apierror.CodeInvalidAPIKey
+	switch status {
+	case http.StatusForbidden:
+		code = apierror.CodePermissionDenied
+	case http.StatusInternalServerError:
+		errType, code = apierror.TypeServerError, apierror.CodeInternalError
+	}
+	apierror.Write(c.Writer, status, errType, code, message)
+	c.Abort()
+}
+
+type keyGroupContextKey struct{}
+
+// ContextWithKeyGroup returns a copy of ctx that restricts Gemini key selection to group.
+func ContextWithKeyGroup(ctx context.Context, group string) context.Context {
+	return context.WithValue(ctx, keyGroupContextKey{}, group)
+}
+
+// KeyGroupFromContext returns the Gemini key group set by AuthMiddleware for the client key,
+// or an empty string, which allows keys from every group.
+func KeyGroupFromContext(ctx context.Context) string {
+	group, _ := ctx.Value(keyGroupContextKey{}).(string)
+	return group
+}
+
+// ParsePermissions splits a comma-separated permissions string into a set of scopes.
+// An empty string, "*" or "all" grants every scope and is returned as a set containing "*".
+func ParsePermissions(permissions string) map[string]struct{} {
+	scopes := make(map[string]struct{})
+	for _, p := range strings.Split(permissions, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "all" {
+			p = "*"
+		}
+		if p != "" {
+			scopes[p] = struct{}{}
+		}
+	}
+	if len(scopes) == 0 {
+		scopes["*"] = struct{}{}
+	}
+	return scopes
+}
+
+// HasScope reports whether the permissions string grants scope.
+func HasScope(permissions, scope string) bool {
+	scopes := ParsePermissions(permissions)
+	if _, ok := scopes["*"]; ok {
+		return true
+	}
+	_, ok := scopes[scope]
+	return ok
+}
+
+// RequireScope rejects requests whose API key, set by AuthMiddleware, lacks the given scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, ok := c.Get(APIKeyContextKey)
+		if !ok {
+			abort(c, http.StatusUnauthorized, "API key is required")
+			return
+		}
+		if !HasScope(apiKey.(*model.APIKey).Permissions, scope) {
+			abort(c, http.StatusForbidden, "API key lacks the "+scope+" scope")
+			return
+		}
+		c.Next()
+	}
+}
+
+// IPAllowMiddleware rejects requests whose client IP is outside the given CIDR ranges with 403.
+// An empty list allows every client. The client IP comes from c.ClientIP, which trusts forwarding
+// headers from whatever peers the router trusts; gin's default trusts every peer, so the router
+// must be restricted with SetTrustedProxies (the server uses access.trusted_proxies, trusting no
+// proxy when unset) or any client can claim an allowed IP. Invalid entries, which
+// config.LoadConfig rejects, never match.
+func IPAllowMiddleware(cidrs []string) gin.HandlerFunc {
+	if len(cidrs) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, entry := range cidrs {
+		if network, err := config.ParseNetwork(entry); err == nil {
+			networks = append(networks, network)
+		}
+	}
 	return func(c *gin.Context) {
-		var token string
-		// Check for OpenAI-style Bearer token
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			parts := strings.Split(authHeader, " ")
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				token = parts[1]
+		ip := net.ParseIP(c.ClientIP())
+		if ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
 			}
 		}
+		abort(c, http.StatusForbidden, "Client IP is not allowed")
+	}
+}
 
-		// If no Bearer token, check for Gemini-style API key
-		if token == "" {
-			token = c.GetHeader("x-goog-api-key")
+// AuthMiddleware authenticates client keys, looked up in the given sources (see
+// config.ParseKeySource) in order; an empty list uses config.DefaultClientKeySources. Invalid
+// sources, which config.LoadConfig rejects, are skipped. A key found in a query parameter is
+// removed from the URL so it is not forwarded upstream, where Gemini would take it as its API key.
+// Usage is counted once the request has passed the handlers that follow, such as RequireScope,
+// so requests they reject do not count toward the key's quota.
+func AuthMiddleware(dbService db.Service, sources []string) gin.HandlerFunc {
+	if len(sources) == 0 {
+		sources = config.DefaultClientKeySources
+	}
+	keySources := make([]config.KeySource, 0, len(sources))
+	for _, entry := range sources {
+		if source, err := config.ParseKeySource(entry); err == nil {
+			keySources = append(keySources, source)
 		}
+	}
 
+	return func(c *gin.Context) {
+		token := clientKey(c.Request, keySources)
 		if token == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key is required"})
+			abort(c, http.StatusUnauthorized, "API key is required")
 			return
 		}
 
 		apiKey, err := dbService.FindAPIKeyByKey(token)
 		if err != nil {
 			if errors.Is(err, db.ErrAPIKeyNotFound) {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				abort(c, http.StatusUnauthorized, "Invalid API key")
 				return
 			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			abort(c, http.StatusInternalServerError, "Database error")
 			return
 		}
 
 		if apiKey.Status != "active" {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key is not active"})
+			abort(c, http.StatusForbidden, "API key is not active")
 			return
 		}
 
 		if !apiKey.ExpiresAt.IsZero() && apiKey.ExpiresAt.Before(time.Now()) {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key has expired"})
+			abort(c, http.StatusForbidden, "API key has expired")
 			return
 		}
 
-		// Increment usage count in a goroutine to not slow down the request
-		go func() {
-			_ = dbService.IncrementAPIKeyUsageCount(token)
-		}()
+		if apiKey.MonthlyQuota > 0 && apiKey.UsageThisMonth >= apiKey.MonthlyQuota {
+			abort(c, http.StatusForbidden, "Monthly quota exceeded for this API key")
+			return
+		}
 
+		c.Set(APIKeyContextKey, apiKey)
+		if apiKey.KeyGroup != "" {
+			c.Request = c.Request.WithContext(ContextWithKeyGroup(c.Request.Context(), apiKey.KeyGroup))
+		}
 		c.Next()
+
+		// The response has been written by now, so counting does not slow the request down.
+		if !c.IsAborted() {
+			_ = dbService.IncrementAPIKeyUsageCount(token)
+		}
 	}
 }
 
-func AdminAuthMiddleware(adminPassword string) gin.HandlerFunc {
+// clientKey returns the first client key found in sources, removing it from the URL when it
+// was a query parameter.
+func clientKey(r *http.Request, sources []config.KeySource) string {
+	for _, source := range sources {
+		switch source.Kind {
+		case config.KeySourceBearer:
+			parts := strings.Split(r.Header.Get("Authorization"), " ")
+			if len(parts) == 2 && parts[0] == "Bearer" && parts[1] != "" {
+				return parts[1]
+			}
+		case config.KeySourceHeader:
+			if token := r.Header.Get(source.Name); token != "" {
+				return token
+			}
+		case config.KeySourceQuery:
+			query := r.URL.Query()
+			if token := query.Get(source.Name); token != "" {
+				query.Del(source.Name)
+				r.URL.RawQuery = query.Encode()
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// AdminUserKey is the gin context key holding the authenticated admin username.
+const AdminUserKey = "admin_user"
+
+// AdminAuthMiddleware checks basic auth credentials against the given username to password map.
+func AdminAuthMiddleware(credentials map[string]string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, password, hasAuth := c.Request.BasicAuth()
-		if !hasAuth || user != "admin" || password != adminPassword {
+		expected, known := credentials[user]
+		if !hasAuth || !known || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
 			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
+		c.Set(AdminUserKey, user)
 		c.Next()
 	}
 }
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -32,30 +33,68 @@ func (m *mockAuthDBService) FindAPIKeyByKey(key string) (*model.APIKey, error) {
 	return &apiKey, nil
 }
 
+// IncrementAPIKeyUsageCount counts usage in the test database so tests can tell which requests counted.
+func (m *mockAuthDBService) IncrementAPIKeyUsageCount(key string) error {
+	return m.db.Model(&model.APIKey{}).Where("key = ?", key).UpdateColumn("usage_count", gorm.Expr("usage_count + 1")).Error
+}
+
 // --- Dummy implementations for the rest of the db.Service interface ---
 func (m *mockAuthDBService) CreateGeminiKey(key *model.GeminiKey) error { return nil }
-func (m *mockAuthDBService) BatchAddGeminiKeys(keys []string) error     { return nil }
-func (m *mockAuthDBService) BatchDeleteGeminiKeys(ids []uint) error     { return nil }
-func (m *mockAuthDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error) {
+func (m *mockAuthDBService) BatchAddGeminiKeys(keys []string, status string) (int64, error) {
+	return 0, nil
+}
+func (m *mockAuthDBService) BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error) {
+	return 0, nil
+}
+func (m *mockAuthDBService) BatchDeleteGeminiKeys(ids []uint) error { return nil }
+func (m *mockAuthDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error) {
 	return nil, 0, nil
 }
+func (m *mockAuthDBService) GeminiKeyStatusCounts() (db.GeminiKeyCounts, error) {
+	return db.GeminiKeyCounts{}, nil
+}
 func (m *mockAuthDBService) GetGeminiKey(id uint) (*model.GeminiKey, error)   { return nil, nil }
 func (m *mockAuthDBService) UpdateGeminiKey(key *model.GeminiKey) error       { return nil }
+func (m *mockAuthDBService) UpdateGeminiKeyState(key *model.GeminiKey) error  { return nil }
 func (m *mockAuthDBService) DeleteGeminiKey(id uint) error                    { return nil }
 func (m *mockAuthDBService) LoadActiveGeminiKeys() ([]model.GeminiKey, error) { return nil, nil }
 func (m *mockAuthDBService) HandleGeminiKeyFailure(key string, disableThreshold int) (bool, error) {
 	return false, nil
 }
-func (m *mockAuthDBService) ResetGeminiKeyFailureCount(key string) error    { return nil }
-func (m *mockAuthDBService) IncrementGeminiKeyUsageCount(key string) error  { return nil }
-func (m *mockAuthDBService) UpdateGeminiKeyStatus(key, status string) error { return nil }
-func (m *mockAuthDBService) CreateAPIKey(key *model.APIKey) error           { return nil }
-func (m *mockAuthDBService) ListAPIKeys() ([]model.APIKey, error)           { return nil, nil }
-func (m_ *mockAuthDBService) GetAPIKey(id uint) (*model.APIKey, error)      { return nil, nil }
-func (m *mockAuthDBService) UpdateAPIKey(key *model.APIKey) error           { return nil }
-func (m *mockAuthDBService) DeleteAPIKey(id uint) error                     { return nil }
-func (m *mockAuthDBService) IncrementAPIKeyUsageCount(key string) error     { return nil }
-func (m *mockAuthDBService) ResetAllAPIKeyUsage() error                     { return nil }
+func (m *mockAuthDBService) ResetGeminiKeyFailureCount(key string) error       { return nil }
+func (m *mockAuthDBService) IncrementGeminiKeyUsageCount(key string) error     { return nil }
+func (m *mockAuthDBService) UpdateGeminiKeyStatus(key, status string) error    { return nil }
+func (m *mockAuthDBService) CreateAPIKey(key *model.APIKey) error              { return nil }
+func (m *mockAuthDBService) ListAPIKeys() ([]model.APIKey, error)              { return nil, nil }
+func (m_ *mockAuthDBService) GetAPIKey(id uint) (*model.APIKey, error)         { return nil, nil }
+func (m *mockAuthDBService) UpdateAPIKey(key *model.APIKey) error              { return nil }
+func (m *mockAuthDBService) DeleteAPIKey(id uint) error                        { return nil }
+func (m *mockAuthDBService) ResetAllAPIKeyUsage() error                        { return nil }
+func (m *mockAuthDBService) ListDeletedGeminiKeys() ([]model.GeminiKey, error) { return nil, nil }
+func (m *mockAuthDBService) RestoreGeminiKey(id uint) error                    { return nil }
+func (m *mockAuthDBService) PurgeGeminiKey(id uint) error                      { return nil }
+func (m *mockAuthDBService) ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error) {
+	return nil, 0, nil
+}
+func (m *mockAuthDBService) StreamGeminiKeys(fn func(model.GeminiKey) error) error        { return nil }
+func (m *mockAuthDBService) ResetDailyGeminiUsage() error                                 { return nil }
+func (m *mockAuthDBService) DecayGeminiFailureCounts(olderThan time.Duration) error       { return nil }
+func (m *mockAuthDBService) BatchIncrementGeminiUsage(counts map[string]int64) error      { return nil }
+func (m *mockAuthDBService) BatchIncrementGeminiTokenUsage(tokens map[string]int64) error { return nil }
+func (m *mockAuthDBService) ExpireStaleAPIKeys() (int64, error)                           { return 0, nil }
+func (m *mockAuthDBService) ResetAllGeminiFailureCounts() error                           { return nil }
+func (m *mockAuthDBService) Ping() error                                                  { return nil }
+func (m *mockAuthDBService) PurgeDeletedGeminiKeys() (int64, error)                       { return 0, nil }
+func (m *mockAuthDBService) ResetMonthlyAPIUsage() error                                  { return nil }
+func (m *mockAuthDBService) FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error) {
+	return nil, nil
+}
+func (m *mockAuthDBService) FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error) {
+	return nil, nil
+}
+func (m *mockAuthDBService) PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error) {
+	return 0, nil
+}
 
 // Ensure mockAuthDBService implements the interface
 var _ db.Service = (*mockAuthDBService)(nil)
@@ -81,9 +120,11 @@ func TestAuthMiddleware(t *testing.T) {
 	db.Create(&model.APIKey{Key: "valid-key", Status: "active"})
 	db.Create(&model.APIKey{Key: "revoked-key", Status: "revoked"})
 	db.Create(&model.APIKey{Key: "expired-key", Status: "active", ExpiresAt: time.Now().Add(-time.Hour)})
+	db.Create(&model.APIKey{Key: "over-quota-key", Status: "active", MonthlyQuota: 10, UsageThisMonth: 10})
+	db.Create(&model.APIKey{Key: "under-quota-key", Status: "active", MonthlyQuota: 10, UsageThisMonth: 9})
 
 	router := gin.New()
-	router.Use(AuthMiddleware(mockService))
+	router.Use(AuthMiddleware(mockService, nil))
 	router.GET("/", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
@@ -99,8 +140,12 @@ func TestAuthMiddleware(t *testing.T) {
 		{"valid bearer key", "Bearer valid-key", "Authorization", http.StatusOK},
 		{"revoked bearer key", "Bearer revoked-key", "Authorization", http.StatusForbidden},
 		{"expired bearer key", "Bearer expired-key", "Authorization", http.StatusForbidden},
+		{"over monthly quota", "Bearer over-quota-key", "Authorization", http.StatusForbidden},
+		{"under monthly quota", "Bearer under-quota-key", "Authorization", http.StatusOK},
 		{"invalid gemini key", "invalid-key", "x-goog-api-key", http.StatusUnauthorized},
 		{"valid gemini key", "valid-key", "x-goog-api-key", http.StatusOK},
+		{"valid anthropic key", "valid-key", "x-api-key", http.StatusOK},
+		{"invalid anthropic key", "invalid-key", "x-api-key", http.StatusUnauthorized},
 	}
 
 	for _, tc := range testCases {
@@ -118,14 +163,244 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestAuthMiddleware_KeySources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService, db := setupTestAuthDB(t)
+	db.Create(&model.APIKey{Key: "valid-key", Status: "active"})
+
+	router := gin.New()
+	router.Use(AuthMiddleware(mockService, []string{"query:key", "header:api-key", "bearer"}))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.URL.RawQuery)
+	})
+
+	testCases := []struct {
+		name           string
+		url            string
+		header         string
+		value          string
+		expectedStatus int
+		expectedQuery  string
+	}{
+		{"query param", "/?alt=sse&key=valid-key", "", "", http.StatusOK, "alt=sse"},
+		{"invalid query param", "/?key=invalid-key", "", "", http.StatusUnauthorized, ""},
+		{"custom header", "/", "api-key", "valid-key", http.StatusOK, ""},
+		{"bearer token", "/", "Authorization", "Bearer valid-key", http.StatusOK, ""},
+		{"query param takes priority", "/?key=invalid-key", "api-key", "valid-key", http.StatusUnauthorized, ""},
+		{"unconfigured default header", "/", "x-goog-api-key", "valid-key", http.StatusUnauthorized, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if rr.Code == http.StatusOK && rr.Body.String() != tc.expectedQuery {
+				t.Errorf("Expected the forwarded query %q, got %q", tc.expectedQuery, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_KeyGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService, db := setupTestAuthDB(t)
+	db.Create(&model.APIKey{Key: "grouped-key", Status: "active", KeyGroup: "project-a"})
+	db.Create(&model.APIKey{Key: "ungrouped-key", Status: "active"})
+
+	router := gin.New()
+	router.Use(AuthMiddleware(mockService, nil))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, KeyGroupFromContext(c.Request.Context()))
+	})
+
+	for key, expectedGroup := range map[string]string{"grouped-key": "project-a", "ungrouped-key": ""} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("x-goog-api-key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d for %s, got %d", http.StatusOK, key, rr.Code)
+		}
+		if rr.Body.String() != expectedGroup {
+			t.Errorf("Expected key group %q for %s, got %q", expectedGroup, key, rr.Body.String())
+		}
+	}
+}
+
+func TestAuthMiddleware_ErrorEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService, db := setupTestAuthDB(t)
+	db.Create(&model.APIKey{Key: "gemini-only-key", Status: "active", Permissions: ScopeGemini})
+
+	router := gin.New()
+	router.GET("/admin", AuthMiddleware(mockService, nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/openai", OpenAIErrors(), AuthMiddleware(mockService, nil), RequireScope(ScopeOpenAI), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	t.Run("flat shape by default", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/admin", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var body map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected a flat JSON error, got %q: %v", rr.Body.String(), err)
+		}
+		if rr.Code != http.StatusUnauthorized || body["error"] != "API key is required" {
+			t.Errorf("Expected 401 with a flat error, got %d %q", rr.Code, rr.Body.String())
+		}
+	})
+
+	testCases := []struct {
+		name           string
+		key            string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"missing key", "", http.StatusUnauthorized, "invalid_api_key"},
+		{"invalid key", "Bearer invalid-key", http.StatusUnauthorized, "invalid_api_key"},
+		{"missing scope", "Bearer gemini-only-key", http.StatusForbidden, "permission_denied"},
+	}
+	for _, tc := range testCases {
+		t.Run("openai shape for "+tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/openai", nil)
+			if tc.key != "" {
+				req.Header.Set("Authorization", tc.key)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			var body struct {
+				Error struct {
+					Message string `json:"message"`
+					Type    string `json:"type"`
+					Code    string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected an OpenAI error envelope, got %q: %v", rr.Body.String(), err)
+			}
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if body.Error.Message == "" || body.Error.Type != "invalid_request_error" || body.Error.Code != tc.expectedCode {
+				t.Errorf("Unexpected error envelope %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	testCases := []struct {
+		permissions string
+		scope       string
+		expected    bool
+	}{
+		{"", ScopeGemini, true},
+		{"*", ScopeOpenAI, true},
+		{"all", ScopeEmbeddings, true},
+		{"gemini", ScopeGemini, true},
+		{"gemini", ScopeOpenAI, false},
+		{" openai , Embeddings ", ScopeEmbeddings, true},
+		{"openai,embeddings", ScopeGemini, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.permissions+"/"+tc.scope, func(t *testing.T) {
+			if got := HasScope(tc.permissions, tc.scope); got != tc.expected {
+				t.Errorf("HasScope(%q, %q) = %v, want %v", tc.permissions, tc.scope, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService, db := setupTestAuthDB(t)
+
+	db.Create(&model.APIKey{Key: "scope-all-key", Status: "active", Permissions: ""})
+	db.Create(&model.APIKey{Key: "scope-gemini-key", Status: "active", Permissions: "gemini"})
+	db.Create(&model.APIKey{Key: "scope-openai-key", Status: "active", Permissions: "openai,embeddings"})
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/gemini/*path", AuthMiddleware(mockService, nil), RequireScope(ScopeGemini), ok)
+	router.GET("/openai/*path", AuthMiddleware(mockService, nil), RequireScope(ScopeOpenAI), ok)
+	router.POST("/v1/embeddings", AuthMiddleware(mockService, nil), RequireScope(ScopeEmbeddings), ok)
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		key            string
+		expectedStatus int
+	}{
+		{"unrestricted key on gemini", http.MethodGet, "/gemini/v1beta/models", "scope-all-key", http.StatusOK},
+		{"unrestricted key on openai", http.MethodGet, "/openai/v1/models", "scope-all-key", http.StatusOK},
+		{"gemini key on gemini", http.MethodGet, "/gemini/v1beta/models", "scope-gemini-key", http.StatusOK},
+		{"gemini key on openai", http.MethodGet, "/openai/v1/models", "scope-gemini-key", http.StatusForbidden},
+		{"gemini key on embeddings", http.MethodPost, "/v1/embeddings", "scope-gemini-key", http.StatusForbidden},
+		{"openai key on openai", http.MethodGet, "/openai/v1/models", "scope-openai-key", http.StatusOK},
+		{"openai key on embeddings", http.MethodPost, "/v1/embeddings", "scope-openai-key", http.StatusOK},
+		{"openai key on gemini", http.MethodGet, "/gemini/v1beta/models", "scope-openai-key", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.key)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+
+	t.Run("only requests that pass the scope check count", func(t *testing.T) {
+		var before model.APIKey
+		db.Where("key = ?", "scope-gemini-key").First(&before)
+		for _, path := range []string{"/gemini/v1beta/models", "/openai/v1/models", "/openai/v1/models"} {
+			req, _ := http.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Authorization", "Bearer scope-gemini-key")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		var after model.APIKey
+		db.Where("key = ?", "scope-gemini-key").First(&after)
+		if after.UsageCount != before.UsageCount+1 {
+			t.Errorf("Expected usage count %d, got %d", before.UsageCount+1, after.UsageCount)
+		}
+	})
+
+	t.Run("without AuthMiddleware", func(t *testing.T) {
+		r := gin.New()
+		r.GET("/", RequireScope(ScopeGemini), ok)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+}
+
 func TestAdminAuthMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	const adminPassword = "test-password"
 
 	router := gin.New()
-	router.Use(AdminAuthMiddleware(adminPassword))
+	router.Use(AdminAuthMiddleware(map[string]string{
+		"admin": adminPassword,
+		"alice": "alice-password",
+		"bob":   "bob-password",
+	}))
 	router.GET("/", func(c *gin.Context) {
-		c.Status(http.StatusOK)
+		c.String(http.StatusOK, c.GetString(AdminUserKey))
 	})
 
 	testCases := []struct {
@@ -138,6 +413,10 @@ func TestAdminAuthMiddleware(t *testing.T) {
 		{"wrong username", "user", adminPassword, http.StatusUnauthorized},
 		{"wrong password", "admin", "wrong-password", http.StatusUnauthorized},
 		{"correct auth", "admin", adminPassword, http.StatusOK},
+		{"second user", "alice", "alice-password", http.StatusOK},
+		{"third user", "bob", "bob-password", http.StatusOK},
+		{"another user's password", "alice", "bob-password", http.StatusUnauthorized},
+		{"unknown user", "mallory", "mallory-password", http.StatusUnauthorized},
 	}
 
 	for _, tc := range testCases {
@@ -151,6 +430,58 @@ func TestAdminAuthMiddleware(t *testing.T) {
 			if rr.Code != tc.expectedStatus {
 				t.Errorf("Expected status code %d, got %d", tc.expectedStatus, rr.Code)
 			}
+			if tc.expectedStatus == http.StatusOK && rr.Body.String() != tc.username {
+				t.Errorf("Expected authenticated user %q in context, got %q", tc.username, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestIPAllowMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+
+	newRouter := func(t *testing.T, cidrs, trustedProxies []string) *gin.Engine {
+		router := gin.New()
+		if err := router.SetTrustedProxies(trustedProxies); err != nil {
+			t.Fatalf("SetTrustedProxies failed: %v", err)
+		}
+		router.GET("/", IPAllowMiddleware(cidrs), ok)
+		return router
+	}
+
+	testCases := []struct {
+		name           string
+		cidrs          []string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		expectedStatus int
+	}{
+		{"empty list allows all", nil, nil, "203.0.113.9:1234", "", http.StatusOK},
+		{"ip inside range", []string{"10.0.0.0/8"}, nil, "10.1.2.3:1234", "", http.StatusOK},
+		{"ip outside range", []string{"10.0.0.0/8"}, nil, "192.168.1.1:1234", "", http.StatusForbidden},
+		{"single ip entry", []string{"192.168.1.1"}, nil, "192.168.1.1:1234", "", http.StatusOK},
+		{"ipv6 range", []string{"2001:db8::/32"}, nil, "[2001:db8::1]:1234", "", http.StatusOK},
+		{"forwarded for ignored without trusted proxy", []string{"10.0.0.0/8"}, nil, "192.168.1.1:1234", "10.1.2.3", http.StatusForbidden},
+		{"forwarded for cannot bypass from untrusted peer", []string{"10.0.0.0/8"}, []string{"172.16.0.0/12"}, "192.168.1.1:1234", "10.1.2.3", http.StatusForbidden},
+		{"forwarded for from trusted proxy allowed", []string{"10.0.0.0/8"}, []string{"172.16.0.0/12"}, "172.16.0.5:1234", "10.1.2.3", http.StatusOK},
+		{"forwarded for from trusted proxy blocked", []string{"10.0.0.0/8"}, []string{"172.16.0.0/12"}, "172.16.0.5:1234", "192.168.1.1", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := newRouter(t, tc.cidrs, tc.trustedProxies)
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tc.expectedStatus, rr.Code)
+			}
 		})
 	}
 }
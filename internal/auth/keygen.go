@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+// Defaults for GenerateAPIKey.
+const (
+	DefaultKeyPrefix = "gg-"
+	DefaultKeyLength = 40
+	MinKeyLength     = 16
+	MaxKeyLength     = 128
+)
+
+// ErrInvalidKeyLength is returned when a requested key length is outside [MinKeyLength, MaxKeyLength].
+var ErrInvalidKeyLength = errors.New("key length out of range")
+
+const keyAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// GenerateAPIKey returns prefix followed by length characters drawn uniformly from
+// an alphanumeric alphabet using crypto/rand.
+func GenerateAPIKey(prefix string, length int) (string, error) {
+	if length < MinKeyLength || length > MaxKeyLength {
+		return "", ErrInvalidKeyLength
+	}
+
+	max := big.NewInt(int64(len(keyAlphabet)))
+	buf := make([]byte, length)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = keyAlphabet[n.Int64()]
+	}
+	return prefix + string(buf), nil
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	t.Run("length and prefix", func(t *testing.T) {
+		key, err := GenerateAPIKey("test-", 32)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !strings.HasPrefix(key, "test-") {
+			t.Errorf("Expected key to start with %q, got %q", "test-", key)
+		}
+		if len(key) != len("test-")+32 {
+			t.Errorf("Expected key length %d, got %d", len("test-")+32, len(key))
+		}
+		for _, r := range strings.TrimPrefix(key, "test-") {
+			if !strings.ContainsRune(keyAlphabet, r) {
+				t.Errorf("Unexpected character %q in key", r)
+			}
+		}
+	})
+
+	t.Run("keys are unique", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			key, err := GenerateAPIKey(DefaultKeyPrefix, MinKeyLength)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if seen[key] {
+				t.Fatalf("Duplicate key generated: %s", key)
+			}
+			seen[key] = true
+		}
+	})
+
+	t.Run("rejects out of range lengths", func(t *testing.T) {
+		for _, length := range []int{0, MinKeyLength - 1, MaxKeyLength + 1} {
+			if _, err := GenerateAPIKey("", length); !errors.Is(err, ErrInvalidKeyLength) {
+				t.Errorf("length %d: expected ErrInvalidKeyLength, got %v", length, err)
+			}
+		}
+	})
+}
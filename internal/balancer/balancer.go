@@ -7,14 +7,50 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/apierror"
+	"github.com/ubuygold/gogemini/internal/auth"
+	"github.com/ubuygold/gogemini/internal/bodylimit"
+	"github.com/ubuygold/gogemini/internal/circuitbreaker"
+	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/headerfilter"
+	"github.com/ubuygold/gogemini/internal/logger"
+	"github.com/ubuygold/gogemini/internal/requestlog"
+	"github.com/ubuygold/gogemini/internal/timeout"
+	"github.com/ubuygold/gogemini/internal/unavailable"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer returns a tracer from the global provider, which is a no-op until tracing is set up.
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/ubuygold/gogemini/internal/balancer")
+}
+
 // Manager defines the interface for a key manager that the balancer can use.
 type Manager interface {
-	GetNextKey() (string, error)
+	GetNextKeyWeighted(group, model string, weight float64) (string, error)
+	GetKeyForSessionWeighted(sessionID, group, model string, weight float64) (string, error)
+	AvailabilityError() error
+	SuspendedError() error
 }
 
+// sessionIDHeader lets clients pin a multi-turn conversation to a single Gemini key.
+const sessionIDHeader = "X-Session-ID"
+
+// apiVersionHeader lets a client pick the Gemini API version, e.g. v1, for a single request.
+const apiVersionHeader = "X-Gemini-API-Version"
+
+// useClientKeyHeader asks the balancer to forward the client's own x-goog-api-key instead of a pool key.
+const useClientKeyHeader = "X-Use-Client-Key"
+
 type contextKey string
 
 const geminiKey contextKey = "geminiKey"
@@ -23,62 +59,125 @@ const geminiKey contextKey = "geminiKey"
 type Balancer struct {
 	keyManager Manager
 	proxy      *httputil.ReverseProxy
+	breaker    *circuitbreaker.Breaker
 	logger     *slog.Logger
+	// requestTimeout bounds the time until the upstream response starts; zero disables it.
+	requestTimeout    time.Duration
+	heartbeatInterval time.Duration
+	allowBYOKey       bool
+	// apiVersion replaces the API version of every request path; empty keeps the client's.
+	apiVersion string
+	// maxRequestBodyBytes caps request bodies; zero disables the limit.
+	maxRequestBodyBytes int64
+	// usageWeightFor returns how much a request to a path counts toward its key's usage.
+	usageWeightFor func(path string) float64
+	// tokenRecorder, when set, is credited with the tokens of each response; see recordTokenUsage.
+	tokenRecorder TokenRecorder
+	// RequestLog, when set, records every request served by the balancer.
+	RequestLog *requestlog.Buffer
 }
 
 // NewBalancer creates a new Balancer that acts as a reverse proxy.
-func NewBalancer(km Manager, logger *slog.Logger) (*Balancer, error) {
+// Upstream requests are sent through the given transport.
+func NewBalancer(km Manager, cfg *config.Config, transport http.RoundTripper, logger *slog.Logger) (*Balancer, error) {
 	targetURL, err := url.Parse("https://generativelanguage.googleapis.com")
 	if err != nil {
 		return nil, err
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.Transport = transport
 
+	balancerLogger := logger.With("component", "balancer")
 	balancer := &Balancer{
-		keyManager: km,
-		proxy:      proxy,
-		logger:     logger.With("component", "balancer"),
+		keyManager:  km,
+		proxy:       proxy,
+		breaker:     circuitbreaker.New(km.AvailabilityError, circuitbreaker.DefaultCooldown, balancerLogger),
+		logger:      balancerLogger,
+		allowBYOKey: cfg.Balancer.AllowBYOKey,
+		// Negative intervals disable heartbeats just like zero.
+		heartbeatInterval:   max(cfg.Proxy.SSEHeartbeatInterval, 0),
+		maxRequestBodyBytes: cfg.Proxy.RequestBodyLimit(),
+		usageWeightFor:      cfg.Balancer.UsageWeightFor,
+		apiVersion:          cfg.Balancer.APIVersion,
+	}
+	if d := cfg.Proxy.RequestTimeout; d > 0 {
+		balancer.requestTimeout = d
 	}
 
 	proxy.Director = func(req *http.Request) {
-		// Retrieve the key from the context.
-		key, ok := req.Context().Value(geminiKey).(string)
-		if !ok {
-			// This should not happen if ServeHTTP is used, but as a safeguard:
-			balancer.logger.Error("Gemini key not found in request context")
-			return
-		}
+		if balancer.useClientKey(req) {
+			// The client brought its own key, so forward its x-goog-api-key untouched.
+			req.Header.Del("Authorization")
+		} else {
+			// Retrieve the key from the context.
+			key, ok := req.Context().Value(geminiKey).(string)
+			if !ok {
+				// This should not happen if ServeHTTP is used, but as a safeguard:
+				balancer.requestLogger(req).Error("Gemini key not found in request context")
+				return
+			}
 
-		// This is the key part: we are REPLACING the client's key with one from our pool.
-		req.Header.Set("x-goog-api-key", key)
-		req.Header.Del("Authorization") // Not needed by Gemini
+			// This is the key part: we are REPLACING the client's key with one from our pool.
+			req.Header.Set("x-goog-api-key", key)
+			req.Header.Del("Authorization") // Not needed by Gemini
+		}
+		version := req.Header.Get(apiVersionHeader)
+		if version == "" {
+			version = balancer.apiVersion
+		}
+		req.Header.Del(sessionIDHeader)
+		req.Header.Del(useClientKeyHeader)
+		req.Header.Del(apiVersionHeader)
 
 		// Set the host and scheme to the target's
 		req.URL.Scheme = targetURL.Scheme
 		req.URL.Host = targetURL.Host
 		req.Host = targetURL.Host
 
-		// The original path from the client request is already in req.URL.Path
-		// The original path from the client request is already in req.URL.Path.
-		// We need to ensure the "models/" prefix exists for the target API.
-		// e.g., /v1beta/gemini-pro:generateContent -> /v1beta/models/gemini-pro:generateContent
-		if !strings.Contains(req.URL.Path, "/models/") {
-			req.URL.Path = strings.Replace(req.URL.Path, "/v1beta/", "/v1beta/models/", 1)
+		req.URL.Path = upstreamPath(req.URL.Path, version)
+	}
+
+	if recorder, ok := km.(TokenRecorder); ok && cfg.Proxy.SelectionStrategy == config.SelectionTokenWeighted {
+		balancer.tokenRecorder = recorder
+	}
+
+	// Upstream headers such as Server or Alt-Svc are passed on unless configured otherwise.
+	filter := headerfilter.New(cfg.Proxy.StripResponseHeaders, cfg.Proxy.AllowResponseHeaders)
+	if filter != nil || balancer.tokenRecorder != nil {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			filter.Apply(resp.Header)
+			if balancer.tokenRecorder != nil {
+				balancer.recordTokenUsage(resp)
+			}
+			return nil
 		}
 	}
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if timeout.Exceeded(r) {
+			balancer.requestLogger(r).Warn("Upstream did not respond before the request timeout", "timeout", balancer.requestTimeout)
+			apierror.Write(w, http.StatusGatewayTimeout, apierror.TypeServerError, apierror.CodeUpstreamTimeout, "Upstream did not respond in time")
+			return
+		}
+
+		// The body is streamed upstream, so a chunked body over the limit only fails mid-request.
+		if bodylimit.Exceeded(err) {
+			balancer.requestLogger(r).Warn("Request body exceeded the size limit", "limit", balancer.maxRequestBodyBytes)
+			apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.TypeInvalidRequest, apierror.CodeRequestTooLarge, "Request body is too large")
+			return
+		}
+
 		// Check if the error is a context cancellation from the client.
 		if errors.Is(err, context.Canceled) || errors.Is(err, http.ErrAbortHandler) {
 			// This happens when the client closes the connection, which is normal for streaming.
-			balancer.logger.Warn("Client disconnected", "error", err)
+			balancer.requestLogger(r).Warn("Client disconnected", "error", err)
 			return // Stop further processing.
 		}
 
 		// For all other errors, log them and return a bad gateway status.
-		balancer.logger.Error("Proxy error", "error", err)
-		http.Error(w, "Proxy Error", http.StatusBadGateway)
+		balancer.requestLogger(r).Error("Proxy error", "error", err)
+		apierror.Write(w, http.StatusBadGateway, apierror.TypeServerError, apierror.CodeBadGateway, "Proxy Error")
 	}
 
 	return balancer, nil
@@ -86,19 +185,119 @@ func NewBalancer(km Manager, logger *slog.Logger) (*Balancer, error) {
 
 // ServeHTTP is the handler for all incoming requests.
 func (b *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	key, err := b.keyManager.GetNextKey()
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "Balancer.ServeHTTP", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.method", r.Method),
+		attribute.String("http.path", r.URL.Path),
+	))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	start := time.Now()
+	entry := requestlog.Begin(ctx, start, r.Method, r.URL.Path)
+	sw := requestlog.NewStatusWriter(w)
+	w = sw
+	defer func() {
+		entry.Status = sw.Status()
+		entry.LatencyMS = time.Since(start).Milliseconds()
+		b.RequestLog.Add(*entry)
+	}()
+
+	if version := r.Header.Get(apiVersionHeader); version != "" && !config.IsGeminiAPIVersion(version) {
+		span.SetStatus(codes.Error, "invalid api version")
+		apierror.Write(w, http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeInvalidAPIVersion, "Invalid "+apiVersionHeader+" header: "+version)
+		return
+	}
+
+	if !bodylimit.Limit(w, r, b.maxRequestBodyBytes) {
+		span.SetStatus(codes.Error, "request body too large")
+		apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.TypeInvalidRequest, apierror.CodeRequestTooLarge, "Request body is too large")
+		return
+	}
+
+	if b.useClientKey(r) {
+		span.SetAttributes(attribute.Bool("client_key", true))
+		b.forward(w, r)
+		return
+	}
+
+	// Paused and draining managers turn requests away without tripping the breaker, so
+	// requests are served again as soon as selection resumes.
+	if err := b.keyManager.SuspendedError(); err != nil {
+		span.SetStatus(codes.Error, "key selection suspended")
+		unavailable.Write(w, err)
+		return
+	}
+
+	// Fail fast without per-request logging while no keys are available.
+	if err := b.breaker.Allow(); err != nil {
+		span.SetStatus(codes.Error, "circuit open")
+		unavailable.Write(w, err)
+		return
+	}
+
+	var key string
+	var err error
+	group := auth.KeyGroupFromContext(r.Context())
+	model := modelFromPath(r.URL.Path)
+	weight := b.usageWeightFor(r.URL.Path)
+	if sessionID := r.Header.Get(sessionIDHeader); sessionID != "" {
+		key, err = b.keyManager.GetKeyForSessionWeighted(sessionID, group, model, weight)
+	} else {
+		key, err = b.keyManager.GetNextKeyWeighted(group, model, weight)
+	}
 	if err != nil {
-		b.logger.Error("Aborting request, no available Gemini key", "error", err)
-		http.Error(w, "Service Unavailable: No active API keys", http.StatusServiceUnavailable)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "no available key")
+		b.requestLogger(r).Error("Aborting request, no available Gemini key", "error", err)
+		unavailable.Write(w, err)
 		return
 	}
+	span.SetAttributes(attribute.String("key_suffix", safeKeySuffix(key)))
+	entry.KeySuffix = safeKeySuffix(key)
 
 	// Store the key in the request context to pass it to the director.
-	ctx := context.WithValue(r.Context(), geminiKey, key)
+	ctx = context.WithValue(ctx, geminiKey, key)
 	reqWithContext := r.WithContext(ctx)
 
+	b.forward(w, reqWithContext)
+}
+
+// forward sends r upstream, applying the request timeout and SSE heartbeats.
+func (b *Balancer) forward(w http.ResponseWriter, r *http.Request) {
+	if b.heartbeatInterval > 0 {
+		hw := newHeartbeatWriter(w, b.heartbeatInterval)
+		defer hw.stop()
+		w = hw
+	}
+	w, r, cancel := timeout.FirstByte(w, r, b.requestTimeout)
+	defer cancel()
+
 	// The ReverseProxy handles everything else, including streaming.
-	b.proxy.ServeHTTP(w, reqWithContext)
+	b.proxy.ServeHTTP(w, r)
+}
+
+// useClientKey reports whether r should be forwarded with the client's own x-goog-api-key.
+// The client must authenticate to gogemini with a Bearer token so its Gemini key stays separate.
+func (b *Balancer) useClientKey(r *http.Request) bool {
+	if !b.allowBYOKey || r.Header.Get("x-goog-api-key") == "" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return false
+	}
+	ok, _ := strconv.ParseBool(r.Header.Get(useClientKeyHeader))
+	return ok
+}
+
+// requestLogger returns the balancer's logger annotated with the request ID of r, if any.
+func (b *Balancer) requestLogger(r *http.Request) *slog.Logger {
+	return logger.FromContext(r.Context(), b.logger)
+}
+
+// safeKeySuffix returns the last 4 characters of a key for logging and tracing.
+func safeKeySuffix(key string) string {
+	if len(key) > 4 {
+		return key[len(key)-4:]
+	}
+	return key
 }
 
 // Close gracefully shuts down the balancer's background tasks.
@@ -106,3 +305,38 @@ func (b *Balancer) Close() {
 	// No-op since the keyManager is now responsible for its own lifecycle.
 	b.logger.Info("Balancer shutdown.")
 }
+
+// upstreamPath returns the Gemini API path for the client path p. The API version at the start of
+// p is replaced with version, or kept when version is empty; paths without one get
+// config.DefaultGeminiAPIVersion. The "models/" prefix the API expects is added to model method
+// calls that lack it, e.g. /v1beta/gemini-pro:generateContent -> /v1beta/models/gemini-pro:generateContent.
+func upstreamPath(p, version string) string {
+	rest := strings.TrimPrefix(p, "/")
+	if first, tail, found := strings.Cut(rest, "/"); config.IsGeminiAPIVersion(first) {
+		if version == "" {
+			version = first
+		}
+		if !found {
+			return "/" + version
+		}
+		rest = tail
+	} else if version == "" {
+		version = config.DefaultGeminiAPIVersion
+	}
+	if strings.Contains(rest, ":") && !strings.Contains(rest, "/") {
+		rest = "models/" + rest
+	}
+	return "/" + version + "/" + rest
+}
+
+// modelFromPath returns the model a native Gemini method call targets, e.g. "gemini-pro" for
+// /v1beta/models/gemini-pro:generateContent or /v1beta/gemini-pro:generateContent. Paths
+// without a ":method" suffix, such as the model listing, return "".
+func modelFromPath(path string) string {
+	segment := path[strings.LastIndex(path, "/")+1:]
+	model, _, found := strings.Cut(segment, ":")
+	if !found {
+		return ""
+	}
+	return model
+}
@@ -1,13 +1,29 @@
 package balancer
 
 import (
+	"bytes"
+	"compress/zlib"
 	"context"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/apierror"
+	"github.com/ubuygold/gogemini/internal/auth"
+	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/db"
+	"github.com/ubuygold/gogemini/internal/keymanager"
+	"github.com/ubuygold/gogemini/internal/logger"
+	"github.com/ubuygold/gogemini/internal/model"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -17,13 +33,36 @@ import (
 // MockKeyManager is a mock implementation of the Manager interface.
 type MockKeyManager struct {
 	mock.Mock
+	// suspended is returned by SuspendedError, standing in for a paused or draining manager.
+	suspended error
 }
 
-func (m *MockKeyManager) GetNextKey() (string, error) {
-	args := m.Called()
+func (m *MockKeyManager) GetNextKeyWeighted(group, model string, weight float64) (string, error) {
+	args := m.Called(group, model, weight)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockKeyManager) GetKeyForSessionWeighted(sessionID, group, model string, weight float64) (string, error) {
+	args := m.Called(sessionID, group, model, weight)
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockKeyManager) GetAvailableKeyCount() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
+// AvailabilityError follows the GetAvailableKeyCount expectation, like the real manager, which
+// only fails when no key is available.
+func (m *MockKeyManager) AvailabilityError() error {
+	if m.GetAvailableKeyCount() == 0 {
+		return keymanager.ErrNoKeys
+	}
+	return nil
+}
+
+func (m *MockKeyManager) SuspendedError() error { return m.suspended }
+
 func TestBalancer_ServeHTTP(t *testing.T) {
 	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
@@ -39,10 +78,11 @@ func TestBalancer_ServeHTTP(t *testing.T) {
 
 		// 2. Setup Mock KeyManager
 		mockKM := new(MockKeyManager)
-		mockKM.On("GetNextKey").Return("test-key-123", nil).Once()
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("test-key-123", nil).Once()
 
 		// 3. Create Balancer with Mocks
-		balancer, err := NewBalancer(mockKM, testLogger)
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		// Manually set the proxy target to our test server
@@ -66,13 +106,81 @@ func TestBalancer_ServeHTTP(t *testing.T) {
 		mockKM.AssertExpectations(t)
 	})
 
+	t.Run("uses the session key when X-Session-ID is set", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "session-key", r.Header.Get("x-goog-api-key"))
+			assert.Empty(t, r.Header.Get("X-Session-ID"), "session header should not be forwarded upstream")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer upstreamServer.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetKeyForSessionWeighted", "conversation-1", "", "", 1.0).Return("session-key", nil).Once()
+
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		targetURL, _ := url.Parse(upstreamServer.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+			req.Host = targetURL.Host
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Session-ID", "conversation-1")
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+		mockKM.AssertNotCalled(t, "GetNextKeyWeighted")
+	})
+
+	t.Run("selects from the client key's group", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "group-key", r.Header.Get("x-goog-api-key"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer upstreamServer.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "project-a", "", 1.0).Return("group-key", nil).Once()
+		mockKM.On("HandleKeySuccess", "group-key").Return().Maybe()
+
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		targetURL, _ := url.Parse(upstreamServer.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+			req.Host = targetURL.Host
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(auth.ContextWithKeyGroup(req.Context(), "project-a"))
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+	})
+
 	t.Run("handles error from keymanager", func(t *testing.T) {
 		// 1. Setup Mock KeyManager to return an error
 		mockKM := new(MockKeyManager)
-		mockKM.On("GetNextKey").Return("", assert.AnError).Once()
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("", assert.AnError).Once()
 
 		// 2. Create Balancer
-		balancer, err := NewBalancer(mockKM, testLogger)
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		// 3. Perform Request
@@ -83,13 +191,55 @@ func TestBalancer_ServeHTTP(t *testing.T) {
 		// 4. Assertions
 		// When the key manager fails, we expect a 503 Service Unavailable error.
 		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
-		assert.Contains(t, rr.Body.String(), "Service Unavailable: No active API keys")
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"error": {"message": "Service temporarily unavailable", "type": "server_error", "param": null, "code": "no_available_keys"}}`, rr.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("distinguishes missing keys from temporarily disabled ones", func(t *testing.T) {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("", keymanager.ErrNoKeys).Once()
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("", &keymanager.KeysDisabledError{RetryAfter: 30 * time.Second}).Once()
+
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Empty(t, rr.Header().Get("Retry-After"))
+		assert.Contains(t, rr.Body.String(), `"code":"no_keys_configured"`)
+
+		rr = httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+		assert.Contains(t, rr.Body.String(), `"code":"keys_temporarily_disabled"`)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("logs include the request ID", func(t *testing.T) {
+		var logBuf bytes.Buffer
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("", assert.AnError).Once()
+
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, slog.New(slog.NewJSONHandler(&logBuf, nil)))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(logger.ContextWithRequestID(req.Context(), "req-abc"))
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, req)
+
+		assert.Contains(t, logBuf.String(), `"request_id":"req-abc"`)
 		mockKM.AssertExpectations(t)
 	})
 
 	t.Run("director safeguard", func(t *testing.T) {
 		mockKM := new(MockKeyManager)
-		balancer, err := NewBalancer(mockKM, testLogger)
+		balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		// Create a request without the geminiKey in the context
@@ -103,11 +253,82 @@ func TestBalancer_ServeHTTP(t *testing.T) {
 	})
 }
 
+func TestBalancer_CircuitOpenReportsDisabledKeys(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dbService, err := db.NewService(config.DatabaseConfig{Type: "sqlite", DSN: filepath.Join(t.TempDir(), "keys.db")})
+	require.NoError(t, err)
+	for _, key := range []string{"key-one", "key-two"} {
+		require.NoError(t, dbService.CreateGeminiKey(&model.GeminiKey{Key: key, Status: "active"}))
+	}
+
+	cfg := &config.Config{Proxy: config.ProxyConfig{DisableKeyThreshold: 1, TemporaryDisableDuration: time.Minute}}
+	km, err := keymanager.NewKeyManager(dbService, cfg, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+	defer km.Close()
+	km.HandleKeyFailure("key-one", http.StatusInternalServerError, "")
+	km.HandleKeyFailure("key-two", http.StatusInternalServerError, "")
+
+	balancer, err := NewBalancer(km, cfg, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+
+	// The first request opens the breaker, the second is turned away by it; both say why.
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest("POST", "/v1beta/models/gemini-pro:generateContent", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"code":"keys_temporarily_disabled"`)
+		assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestBalancer_ServesRequestsRightAfterResume(t *testing.T) {
+	var logBuf bytes.Buffer
+	testLogger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	dbService, err := db.NewService(config.DatabaseConfig{Type: "sqlite", DSN: filepath.Join(t.TempDir(), "keys.db")})
+	require.NoError(t, err)
+	require.NoError(t, dbService.CreateGeminiKey(&model.GeminiKey{Key: "key-one", Status: "active"}))
+
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstreamServer.Close()
+
+	cfg := &config.Config{}
+	km, err := keymanager.NewKeyManager(dbService, cfg, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+	defer km.Close()
+
+	balancer, err := NewBalancer(km, cfg, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+	targetURL, _ := url.Parse(upstreamServer.URL)
+	originalDirector := balancer.proxy.Director
+	balancer.proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.URL.Scheme = targetURL.Scheme
+		req.URL.Host = targetURL.Host
+		req.Host = targetURL.Host
+	}
+
+	km.SetPaused(true)
+	rr := httptest.NewRecorder()
+	balancer.ServeHTTP(rr, httptest.NewRequest("POST", "/v1beta/models/gemini-pro:generateContent", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"key_selection_paused"`)
+
+	// Pausing must not open the breaker, or requests would be refused until its cooldown ends.
+	km.SetPaused(false)
+	rr = httptest.NewRecorder()
+	balancer.ServeHTTP(rr, httptest.NewRequest("POST", "/v1beta/models/gemini-pro:generateContent", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, logBuf.String(), "Circuit breaker opened")
+}
+
 func TestNewBalancer(t *testing.T) {
 	mockKM := new(MockKeyManager)
 	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	balancer, err := NewBalancer(mockKM, testLogger)
+	balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
 	require.NoError(t, err)
 	assert.NotNil(t, balancer)
 	assert.NotNil(t, balancer.proxy)
@@ -117,7 +338,7 @@ func TestNewBalancer(t *testing.T) {
 func TestBalancer_ErrorHandler(t *testing.T) {
 	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	mockKM := new(MockKeyManager)
-	balancer, err := NewBalancer(mockKM, testLogger)
+	balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
 	require.NoError(t, err)
 
 	t.Run("handles context canceled error", func(t *testing.T) {
@@ -134,14 +355,14 @@ func TestBalancer_ErrorHandler(t *testing.T) {
 		rr := httptest.NewRecorder()
 		balancer.proxy.ErrorHandler(rr, req, assert.AnError)
 		assert.Equal(t, http.StatusBadGateway, rr.Code)
-		assert.Contains(t, rr.Body.String(), "Proxy Error")
+		assert.JSONEq(t, `{"error": {"message": "Proxy Error", "type": "server_error", "param": null, "code": "bad_gateway"}}`, rr.Body.String())
 	})
 }
 
 func TestDirector_PathModification(t *testing.T) {
 	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	mockKM := new(MockKeyManager)
-	balancer, err := NewBalancer(mockKM, testLogger)
+	balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
 	require.NoError(t, err)
 
 	testCases := []struct {
@@ -152,12 +373,12 @@ func TestDirector_PathModification(t *testing.T) {
 		{
 			name:         "with models prefix",
 			inputPath:    "/v1beta/models/gemini-pro:generateContent",
-			expectedPath: "/v1beta/gemini-pro:generateContent",
+			expectedPath: "/v1beta/models/gemini-pro:generateContent",
 		},
 		{
 			name:         "without models prefix",
 			inputPath:    "/v1beta/gemini-pro:generateContent",
-			expectedPath: "/v1beta/gemini-pro:generateContent",
+			expectedPath: "/v1beta/models/gemini-pro:generateContent",
 		},
 		{
 			name:         "irrelevant path",
@@ -178,3 +399,554 @@ func TestDirector_PathModification(t *testing.T) {
 		})
 	}
 }
+
+func TestDirector_APIVersion(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	testCases := []struct {
+		name         string
+		configured   string
+		header       string
+		inputPath    string
+		expectedPath string
+	}{
+		{"keeps the client's v1beta", "", "", "/v1beta/models/gemini-pro:generateContent", "/v1beta/models/gemini-pro:generateContent"},
+		{"keeps the client's v1", "", "", "/v1/models/gemini-pro:generateContent", "/v1/models/gemini-pro:generateContent"},
+		{"keeps the client's v1alpha", "", "", "/v1alpha/models/gemini-pro:generateContent", "/v1alpha/models/gemini-pro:generateContent"},
+		{"adds the models prefix", "", "", "/v1/gemini-pro:generateContent", "/v1/models/gemini-pro:generateContent"},
+		{"defaults a path without version", "", "", "/models/gemini-pro:generateContent", "/v1beta/models/gemini-pro:generateContent"},
+		{"leaves the model listing alone", "", "", "/v1beta/models", "/v1beta/models"},
+		{"configured version replaces the client's", "v1", "", "/v1beta/models/gemini-pro:generateContent", "/v1/models/gemini-pro:generateContent"},
+		{"configured version applies to a path without version", "v1", "", "/models/gemini-pro:countTokens", "/v1/models/gemini-pro:countTokens"},
+		{"header overrides the client's version", "", "v1", "/v1beta/models/gemini-pro:generateContent", "/v1/models/gemini-pro:generateContent"},
+		{"header overrides the configured version", "v1", "v1beta", "/v1/models/gemini-pro:generateContent", "/v1beta/models/gemini-pro:generateContent"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{Balancer: config.BalancerConfig{APIVersion: tc.configured}}
+			balancer, err := NewBalancer(new(MockKeyManager), cfg, http.DefaultTransport, testLogger)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("POST", tc.inputPath, nil)
+			if tc.header != "" {
+				req.Header.Set(apiVersionHeader, tc.header)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), geminiKey, "test-key"))
+
+			balancer.proxy.Director(req)
+
+			assert.Equal(t, tc.expectedPath, req.URL.Path)
+			assert.Empty(t, req.Header.Get(apiVersionHeader), "the version header should not be forwarded")
+		})
+	}
+
+	t.Run("rejects an invalid version header", func(t *testing.T) {
+		balancer, err := NewBalancer(new(MockKeyManager), &config.Config{}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1beta/models/gemini-pro:generateContent", nil)
+		req.Header.Set(apiVersionHeader, "../v1")
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), apierror.CodeInvalidAPIVersion)
+	})
+}
+
+func TestBalancer_RequestTimeout(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	newBalancer := func(t *testing.T, upstream *httptest.Server) *Balancer {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("test-key-123", nil)
+
+		balancer, err := NewBalancer(mockKM, &config.Config{Proxy: config.ProxyConfig{RequestTimeout: 50 * time.Millisecond}}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		targetURL, _ := url.Parse(upstream.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+			req.Host = targetURL.Host
+		}
+		return balancer
+	}
+
+	t.Run("returns 504 when the upstream does not respond in time", func(t *testing.T) {
+		release := make(chan struct{})
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer upstreamServer.Close()
+		defer close(release)
+
+		rr := httptest.NewRecorder()
+		newBalancer(t, upstreamServer).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+		assert.Contains(t, rr.Body.String(), "upstream_timeout")
+	})
+
+	t.Run("does not cut a stream that started in time", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for i := 0; i < 3; i++ {
+				time.Sleep(40 * time.Millisecond)
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+			}
+		}))
+		defer upstreamServer.Close()
+
+		rr := httptest.NewRecorder()
+		newBalancer(t, upstreamServer).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "chunkchunkchunk", rr.Body.String())
+	})
+}
+
+func TestBalancer_RequestBodyLimit(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newBalancer := func(t *testing.T) (*Balancer, *int32) {
+		var received int32
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			atomic.AddInt32(&received, int32(len(body)))
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(upstreamServer.Close)
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("test-key-123", nil)
+
+		balancer, err := NewBalancer(mockKM, &config.Config{Proxy: config.ProxyConfig{MaxRequestBodyBytes: 10}}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		targetURL, _ := url.Parse(upstreamServer.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+			req.Host = targetURL.Host
+		}
+		return balancer, &received
+	}
+
+	t.Run("forwards a body just under the limit", func(t *testing.T) {
+		balancer, received := newBalancer(t)
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-pro:generateContent", strings.NewReader(strings.Repeat("a", 9))))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, int32(9), atomic.LoadInt32(received))
+	})
+
+	t.Run("rejects a body just over the limit", func(t *testing.T) {
+		balancer, received := newBalancer(t)
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-pro:generateContent", strings.NewReader(strings.Repeat("a", 11))))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.Contains(t, rr.Body.String(), "request_too_large")
+		assert.Zero(t, atomic.LoadInt32(received))
+	})
+
+	t.Run("rejects a chunked body over the limit", func(t *testing.T) {
+		balancer, _ := newBalancer(t)
+		req := httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-pro:generateContent", io.NopCloser(strings.NewReader(strings.Repeat("a", 11))))
+		req.ContentLength = -1
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+}
+
+func TestBalancer_BYOKey(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	newBalancer := func(t *testing.T, mockKM *MockKeyManager, allow bool, upstream *httptest.Server) *Balancer {
+		balancer, err := NewBalancer(mockKM, &config.Config{Balancer: config.BalancerConfig{AllowBYOKey: allow}}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		targetURL, _ := url.Parse(upstream.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+			req.Host = targetURL.Host
+		}
+		return balancer
+	}
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/v1beta/models/gemini-pro:generateContent", nil)
+		req.Header.Set("Authorization", "Bearer gogemini-client-key")
+		req.Header.Set("x-goog-api-key", "client-own-key")
+		req.Header.Set("X-Use-Client-Key", "true")
+		return req
+	}
+
+	t.Run("forwards the client's own key when allowed", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "client-own-key", r.Header.Get("x-goog-api-key"))
+			assert.Empty(t, r.Header.Get("Authorization"))
+			assert.Empty(t, r.Header.Get("X-Use-Client-Key"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer upstreamServer.Close()
+
+		mockKM := new(MockKeyManager)
+		rr := httptest.NewRecorder()
+		newBalancer(t, mockKM, true, upstreamServer).ServeHTTP(rr, newRequest())
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertNotCalled(t, "GetNextKeyWeighted")
+	})
+
+	t.Run("injects a pool key when BYO is not enabled", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "pool-key", r.Header.Get("x-goog-api-key"))
+			assert.Empty(t, r.Header.Get("X-Use-Client-Key"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer upstreamServer.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("pool-key", nil).Once()
+		rr := httptest.NewRecorder()
+		newBalancer(t, mockKM, false, upstreamServer).ServeHTTP(rr, newRequest())
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("injects a pool key without the opt-in header", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "pool-key", r.Header.Get("x-goog-api-key"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer upstreamServer.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("pool-key", nil).Once()
+		req := newRequest()
+		req.Header.Del("X-Use-Client-Key")
+		rr := httptest.NewRecorder()
+		newBalancer(t, mockKM, true, upstreamServer).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+	})
+}
+
+func TestBalancer_SSEHeartbeat(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	serve := func(t *testing.T, contentType string) string {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(150 * time.Millisecond)
+			w.Write([]byte("data: 2\n\n"))
+		}))
+		defer upstreamServer.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("test-key-123", nil).Once()
+
+		cfg := &config.Config{Proxy: config.ProxyConfig{SSEHeartbeatInterval: 20 * time.Millisecond}}
+		balancer, err := NewBalancer(mockKM, cfg, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		targetURL, _ := url.Parse(upstreamServer.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+			req.Host = targetURL.Host
+		}
+
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest("POST", "/v1beta/models/gemini-pro:streamGenerateContent", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+
+	t.Run("writes heartbeats while an event stream is idle", func(t *testing.T) {
+		body := serve(t, "text/event-stream")
+
+		assert.True(t, strings.HasPrefix(body, "data: 1\n\n: keep-alive\n\n"), "body: %q", body)
+		assert.True(t, strings.HasSuffix(body, ": keep-alive\n\ndata: 2\n\n"), "body: %q", body)
+	})
+
+	t.Run("leaves non-streaming responses untouched", func(t *testing.T) {
+		body := serve(t, "application/json")
+
+		assert.Equal(t, "data: 1\n\ndata: 2\n\n", body)
+	})
+}
+
+func TestHeartbeatWriter_OnlyBetweenEvents(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rr.Header().Set("Content-Type", "text/event-stream")
+	hw := newHeartbeatWriter(rr, time.Hour)
+	defer hw.stop()
+
+	hw.WriteHeader(http.StatusOK)
+	hw.Write([]byte("data: partial"))
+	hw.wrote = false
+	hw.beat()
+	assert.Equal(t, "data: partial", rr.Body.String())
+
+	hw.Write([]byte(" event\n\n"))
+	hw.wrote = false
+	hw.beat()
+	assert.Equal(t, "data: partial event\n\n: keep-alive\n\n", rr.Body.String())
+}
+
+func TestBalancer_RequestLog(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer upstreamServer.Close()
+
+	mockKM := new(MockKeyManager)
+	mockKM.On("GetAvailableKeyCount").Return(1)
+	mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("test-key-123", nil).Once()
+	mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("", assert.AnError).Once()
+
+	balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+	balancer.RequestLog = requestlog.NewBuffer(1)
+
+	targetURL, _ := url.Parse(upstreamServer.URL)
+	originalDirector := balancer.proxy.Director
+	balancer.proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.URL.Scheme = targetURL.Scheme
+		req.URL.Host = targetURL.Host
+		req.Host = targetURL.Host
+	}
+
+	balancer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1beta/models/gemini-pro:generateContent", nil))
+	recent := balancer.RequestLog.Recent()
+	require.Len(t, recent, 1)
+	assert.Equal(t, http.StatusTeapot, recent[0].Status)
+	assert.Equal(t, "-123", recent[0].KeySuffix)
+
+	balancer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1beta/models", nil))
+	recent = balancer.RequestLog.Recent()
+	require.Len(t, recent, 1, "buffer should be capped at its size")
+	assert.Equal(t, "/v1beta/models", recent[0].Path)
+	assert.Equal(t, http.StatusServiceUnavailable, recent[0].Status)
+	assert.Empty(t, recent[0].KeySuffix)
+}
+
+func TestBalancer_UsageWeights(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	mockKM := new(MockKeyManager)
+	mockKM.On("GetAvailableKeyCount").Return(1)
+	mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("test-key-123", nil).Once()
+	mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 0.1).Return("test-key-123", nil).Once()
+
+	balancer, err := NewBalancer(mockKM, &config.Config{}, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+	targetURL, _ := url.Parse(upstreamServer.URL)
+	originalDirector := balancer.proxy.Director
+	balancer.proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.URL.Scheme = targetURL.Scheme
+		req.URL.Host = targetURL.Host
+		req.Host = targetURL.Host
+	}
+
+	for _, path := range []string{"/v1beta/models/gemini-pro:generateContent", "/v1beta/models/gemini-pro:countTokens"} {
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}")))
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+	mockKM.AssertExpectations(t)
+}
+
+func TestBalancer_ResponseHeaders(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Server", "scaffolding on HTTPServer2")
+		w.Header().Set("Alt-Svc", `h3=":443"`)
+		w.Header().Set("X-Goog-Request-Id", "abc")
+		w.Write([]byte(`{}`))
+	}))
+	defer upstreamServer.Close()
+
+	serve := func(t *testing.T, cfg *config.Config) *httptest.ResponseRecorder {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "", 1.0).Return("test-key", nil)
+
+		balancer, err := NewBalancer(mockKM, cfg, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		targetURL, _ := url.Parse(upstreamServer.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+		}
+
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest("GET", "/v1beta/models", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr
+	}
+
+	t.Run("passes headers through by default", func(t *testing.T) {
+		rr := serve(t, &config.Config{})
+		assert.Equal(t, "scaffolding on HTTPServer2", rr.Header().Get("Server"))
+		assert.Equal(t, "abc", rr.Header().Get("X-Goog-Request-Id"))
+	})
+
+	t.Run("strips configured headers", func(t *testing.T) {
+		rr := serve(t, &config.Config{Proxy: config.ProxyConfig{StripResponseHeaders: []string{"Server", "Alt-Svc", "X-Goog-*"}}})
+		assert.Empty(t, rr.Header().Get("Server"))
+		assert.Empty(t, rr.Header().Get("Alt-Svc"))
+		assert.Empty(t, rr.Header().Get("X-Goog-Request-Id"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	})
+
+	t.Run("keeps only allowed headers", func(t *testing.T) {
+		rr := serve(t, &config.Config{Proxy: config.ProxyConfig{AllowResponseHeaders: []string{"X-Goog-Request-Id"}}})
+		assert.Equal(t, "abc", rr.Header().Get("X-Goog-Request-Id"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.Empty(t, rr.Header().Get("Server"))
+		assert.Empty(t, rr.Header().Get("Alt-Svc"))
+	})
+}
+
+// tokenRecordingKeyManager adds token usage recording to MockKeyManager.
+type tokenRecordingKeyManager struct {
+	*MockKeyManager
+	tokens map[string]int64
+}
+
+func (m *tokenRecordingKeyManager) RecordTokenUsage(key string, tokens int64) {
+	m.tokens[key] += tokens
+}
+
+func TestBalancer_TokenUsage(t *testing.T) {
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			w.Write([]byte(`[{"usageMetadata":{"totalTokenCount":7}}]`))
+			return
+		}
+		if r.URL.Query().Has("deflate") {
+			w.Header().Set("Content-Encoding", "deflate")
+			zw := zlib.NewWriter(w)
+			zw.Write([]byte(`{"candidates":[],"usageMetadata":{"totalTokenCount":20}}`))
+			zw.Close()
+			return
+		}
+		w.Write([]byte(`{"candidates":[],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":9,"totalTokenCount":12}}`))
+	}))
+	t.Cleanup(upstreamServer.Close)
+
+	serve := func(t *testing.T, strategy, path string) *tokenRecordingKeyManager {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKeyWeighted", "", "gemini-pro", 1.0).Return("test-key", nil)
+		km := &tokenRecordingKeyManager{MockKeyManager: mockKM, tokens: map[string]int64{}}
+
+		balancer, err := NewBalancer(km, &config.Config{Proxy: config.ProxyConfig{SelectionStrategy: strategy}}, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		targetURL, _ := url.Parse(upstreamServer.URL)
+		originalDirector := balancer.proxy.Director
+		balancer.proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			req.URL.Scheme = targetURL.Scheme
+			req.URL.Host = targetURL.Host
+		}
+
+		rr := httptest.NewRecorder()
+		balancer.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}")))
+		require.Equal(t, http.StatusOK, rr.Code)
+		if rr.Header().Get("Content-Encoding") == "" {
+			assert.Contains(t, rr.Body.String(), "usageMetadata", "the body is still forwarded")
+		}
+		return km
+	}
+
+	t.Run("records total tokens with token_weighted", func(t *testing.T) {
+		km := serve(t, config.SelectionTokenWeighted, "/v1beta/models/gemini-pro:generateContent")
+		assert.Equal(t, map[string]int64{"test-key": 12}, km.tokens)
+	})
+
+	t.Run("records tokens of deflate-encoded responses", func(t *testing.T) {
+		km := serve(t, config.SelectionTokenWeighted, "/v1beta/models/gemini-pro:generateContent?deflate")
+		assert.Equal(t, map[string]int64{"test-key": 20}, km.tokens)
+	})
+
+	t.Run("does not record with other strategies", func(t *testing.T) {
+		km := serve(t, config.SelectionLowestUsage, "/v1beta/models/gemini-pro:generateContent")
+		assert.Empty(t, km.tokens)
+	})
+
+	t.Run("does not buffer streamed responses", func(t *testing.T) {
+		km := serve(t, config.SelectionTokenWeighted, "/v1beta/models/gemini-pro:streamGenerateContent")
+		assert.Empty(t, km.tokens)
+	})
+}
+
+func TestTotalTokenCount(t *testing.T) {
+	assert.Equal(t, int64(12), totalTokenCount([]byte(`{"usageMetadata":{"totalTokenCount":12}}`)))
+	assert.Equal(t, int64(5), totalTokenCount([]byte(`{"usageMetadata":{"promptTokenCount":2,"candidatesTokenCount":3}}`)))
+	assert.Zero(t, totalTokenCount([]byte(`{"candidates":[]}`)))
+	assert.Zero(t, totalTokenCount([]byte(`not json`)))
+}
+
+func TestModelFromPath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/v1beta/models/gemini-pro:generateContent", "gemini-pro"},
+		{"/v1beta/gemini-2.5-flash:streamGenerateContent", "gemini-2.5-flash"},
+		{"/v1beta/models", ""},
+		{"/v1beta/models/gemini-pro", ""},
+		{"/", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			assert.Equal(t, tc.expected, modelFromPath(tc.path))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sseHeartbeat is an SSE comment line, which clients ignore but which keeps idle connections alive.
+var sseHeartbeat = []byte(": keep-alive\n\n")
+
+// heartbeatWriter injects SSE heartbeats into an event-stream response whenever the upstream
+// has been silent for a whole interval. Heartbeats are only written between events.
+type heartbeatWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+
+	mu         sync.Mutex
+	sse        bool
+	wrote      bool
+	atBoundary bool
+	stopped    bool
+	done       chan struct{}
+}
+
+// newHeartbeatWriter wraps w and starts sending heartbeats every interval. stop must be called
+// before the handler returns.
+func newHeartbeatWriter(w http.ResponseWriter, interval time.Duration) *heartbeatWriter {
+	hw := &heartbeatWriter{
+		ResponseWriter: w,
+		rc:             http.NewResponseController(w),
+		atBoundary:     true,
+		done:           make(chan struct{}),
+	}
+	go hw.run(interval)
+	return hw
+}
+
+func (w *heartbeatWriter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.beat()
+		}
+	}
+}
+
+func (w *heartbeatWriter) beat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped || !w.sse || !w.atBoundary || w.wrote {
+		w.wrote = false
+		return
+	}
+	if _, err := w.ResponseWriter.Write(sseHeartbeat); err == nil {
+		w.rc.Flush()
+	}
+}
+
+// stop ends the heartbeats; no heartbeat is written after it returns.
+func (w *heartbeatWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.done)
+	}
+}
+
+func (w *heartbeatWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sse = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *heartbeatWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(b) == 0 {
+		return w.ResponseWriter.Write(b)
+	}
+	w.wrote = true
+	w.atBoundary = bytes.HasSuffix(b, []byte("\n\n")) || bytes.HasSuffix(b, []byte("\r\n\r\n"))
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *heartbeatWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rc.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *heartbeatWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
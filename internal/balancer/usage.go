@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ubuygold/gogemini/internal/contentencoding"
+)
+
+// TokenRecorder is implemented by key managers that balance keys on token usage, see
+// config.SelectionTokenWeighted.
+type TokenRecorder interface {
+	RecordTokenUsage(key string, tokens int64)
+}
+
+// recordTokenUsage reports the usageMetadata.totalTokenCount of a successful, non-streaming
+// JSON response to the token recorder, crediting the pool key that served it. Streamed
+// responses, responses served with the client's own key and bodies that cannot be decoded or
+// carry no usage are not counted.
+func (b *Balancer) recordTokenUsage(resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || resp.Body == nil || resp.Request == nil ||
+		strings.Contains(resp.Request.URL.Path, ":streamGenerateContent") {
+		return
+	}
+	key, _ := resp.Request.Context().Value(geminiKey).(string)
+	if key == "" {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return
+	}
+
+	body, err := contentencoding.DecodeBody(resp)
+	if err != nil {
+		b.logger.Debug("Not recording token usage of undecodable response", "error", err)
+		return
+	}
+
+	if tokens := totalTokenCount(body); tokens > 0 {
+		b.tokenRecorder.RecordTokenUsage(key, tokens)
+	}
+}
+
+// totalTokenCount returns the usageMetadata.totalTokenCount of a Gemini response body, falling
+// back to the sum of promptTokenCount and candidatesTokenCount. Bodies without usage count as zero.
+func totalTokenCount(body []byte) int64 {
+	var response struct {
+		UsageMetadata *struct {
+			PromptTokenCount     int64 `json:"promptTokenCount"`
+			CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+			TotalTokenCount      int64 `json:"totalTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.UsageMetadata == nil {
+		return 0
+	}
+	if total := response.UsageMetadata.TotalTokenCount; total > 0 {
+		return total
+	}
+	return response.UsageMetadata.PromptTokenCount + response.UsageMetadata.CandidatesTokenCount
+}
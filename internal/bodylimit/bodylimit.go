@@ -0,0 +1,28 @@
+// Package bodylimit caps the size of request bodies accepted by the proxies.
+package bodylimit
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Limit caps r's body at n bytes. It returns false when the declared Content-Length already
+// exceeds n, in which case the caller should answer 413 without reading the body. Otherwise
+// reading past n fails with an error for which Exceeded reports true. A non-positive n
+// disables the limit.
+func Limit(w http.ResponseWriter, r *http.Request, n int64) bool {
+	if n <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > n {
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, n)
+	return true
+}
+
+// Exceeded reports whether err was caused by reading past the limit set by Limit.
+func Exceeded(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
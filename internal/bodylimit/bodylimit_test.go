@@ -0,0 +1,49 @@
+package bodylimit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimit(t *testing.T) {
+	t.Run("rejects a declared length over the limit", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345"))
+		assert.False(t, Limit(httptest.NewRecorder(), r, 4))
+	})
+
+	t.Run("allows a body at the limit", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("1234"))
+		assert.True(t, Limit(httptest.NewRecorder(), r, 4))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "1234", string(body))
+	})
+
+	t.Run("fails reads past the limit without a declared length", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("12345")))
+		r.ContentLength = -1
+		assert.True(t, Limit(httptest.NewRecorder(), r, 4))
+		_, err := io.ReadAll(r.Body)
+		assert.True(t, Exceeded(err))
+		assert.True(t, Exceeded(fmt.Errorf("wrapped: %w", err)))
+	})
+
+	t.Run("non-positive limit disables it", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345"))
+		assert.True(t, Limit(httptest.NewRecorder(), r, 0))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Len(t, body, 5)
+	})
+
+	t.Run("other errors are not reported as exceeded", func(t *testing.T) {
+		assert.False(t, Exceeded(io.ErrUnexpectedEOF))
+		assert.False(t, Exceeded(nil))
+	})
+}
@@ -0,0 +1,121 @@
+package circuitbreaker
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultCooldown is how long the breaker stays open before probing for keys again.
+const DefaultCooldown = 10 * time.Second
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets requests through.
+	Closed State = iota
+	// Open rejects requests until the cooldown has elapsed.
+	Open
+	// HalfOpen lets the next request probe whether keys are available again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker short-circuits requests while no Gemini keys are available, so that an
+// outage costs one log line per cooldown window instead of one per request.
+type Breaker struct {
+	mu       sync.Mutex
+	state    State
+	openedAt time.Time
+	// cause is the check error that last opened the breaker.
+	cause    error
+	cooldown time.Duration
+	check    func() error
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+// New creates a closed Breaker that uses check to find out whether keys are available:
+// check returns nil when they are, and otherwise the reason they are not.
+func New(check func() error, cooldown time.Duration, logger *slog.Logger) *Breaker {
+	if cooldown <= 0 {
+		cooldown = DefaultCooldown
+	}
+	return &Breaker{
+		check:    check,
+		cooldown: cooldown,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// Allow returns nil when a request may proceed. While closed or half-open it runs the
+// check, opening the breaker when it fails; while open it returns the error that opened
+// it without checking again until the cooldown has elapsed.
+//
+// The check runs without holding the breaker's lock, as it may take locks of its own, so
+// concurrent requests check in parallel rather than one at a time.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	b.advanceLocked()
+	if b.state == Open {
+		cause := b.cause
+		b.mu.Unlock()
+		return cause
+	}
+	b.mu.Unlock()
+
+	err := b.check()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		// A concurrent request may have opened the breaker meanwhile; keep its cooldown.
+		if b.state == Open {
+			return err
+		}
+		if b.state == Closed {
+			b.logger.Error("Circuit breaker opened: no Gemini keys available", "error", err, "cooldown", b.cooldown)
+		}
+		b.state = Open
+		b.openedAt = b.now()
+		b.cause = err
+		return err
+	}
+
+	if b.state != Closed {
+		b.logger.Info("Circuit breaker closed: Gemini keys available again")
+		b.state = Closed
+		b.cause = nil
+	}
+	return nil
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advanceLocked()
+	return b.state
+}
+
+// advanceLocked moves an open breaker to half-open once its cooldown has elapsed.
+func (b *Breaker) advanceLocked() {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = HalfOpen
+	}
+}
@@ -0,0 +1,89 @@
+package circuitbreaker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+
+	errNoKeys := errors.New("no keys")
+	var unavailable error
+	probes := 0
+	b := New(func() error { probes++; return unavailable }, time.Minute, logger)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	// Closed while keys are available.
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, Closed, b.State())
+
+	// Opens as soon as no keys are available, reporting why.
+	unavailable = errNoKeys
+	assert.ErrorIs(t, b.Allow(), errNoKeys)
+	assert.Equal(t, Open, b.State())
+
+	// While open, requests are rejected with the same reason, without probing and without
+	// logging again.
+	probes = 0
+	for i := 0; i < 5; i++ {
+		assert.ErrorIs(t, b.Allow(), errNoKeys)
+	}
+	assert.Equal(t, 0, probes)
+	assert.Equal(t, 1, strings.Count(logBuf.String(), "Circuit breaker opened"))
+
+	// After the cooldown the breaker is half-open and the next request probes.
+	now = now.Add(time.Minute)
+	assert.Equal(t, HalfOpen, b.State())
+	assert.Error(t, b.Allow())
+	assert.Equal(t, 1, probes)
+	assert.Equal(t, Open, b.State(), "a failed probe re-opens the breaker")
+
+	// A successful probe closes it again.
+	now = now.Add(time.Minute)
+	unavailable = nil
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, Closed, b.State())
+	assert.Contains(t, logBuf.String(), "Circuit breaker closed")
+	assert.Equal(t, 1, strings.Count(logBuf.String(), "Circuit breaker opened"))
+}
+
+func TestBreaker_CheckRunsOutsideLock(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var calls atomic.Int32
+	b := New(func() error {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		return nil
+	}, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	done := make(chan error)
+	go func() { done <- b.Allow() }()
+	<-entered
+
+	// A slow check does not hold up other requests or state queries.
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, Closed, b.State())
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestNew_DefaultCooldown(t *testing.T) {
+	b := New(func() error { return nil }, 0, slog.Default())
+	assert.Equal(t, DefaultCooldown, b.cooldown)
+}
@@ -0,0 +1,82 @@
+// Package concurrency caps how many proxy requests are served at once so bursts queue up
+// instead of racing through key selection and overwhelming a few keys.
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrQueueTimeout is returned by Acquire when no slot frees up within the queue timeout.
+var ErrQueueTimeout = errors.New("timed out waiting for a free request slot")
+
+// Limiter hands out a fixed number of slots; callers beyond that wait for one to be released.
+type Limiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewLimiter creates a Limiter allowing max concurrent holders, where waiting callers give up
+// after queueTimeout; a non-positive queueTimeout waits until the caller's context ends. It
+// returns nil, which never limits, when max is not positive.
+func NewLimiter(max int, queueTimeout time.Duration) *Limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, max), queueTimeout: queueTimeout}
+}
+
+// Acquire waits for a free slot and returns the func releasing it. It fails with
+// ErrQueueTimeout after the queue timeout, or with ctx's error if ctx ends first.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	var expired <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-expired:
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Middleware holds a slot of l for the rest of the request. Requests that time out in the
+// queue get 503; requests whose client went away while queued are dropped without a response.
+func Middleware(l *Limiter) gin.HandlerFunc {
+	if l == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		release, err := l.Acquire(c.Request.Context())
+		if err != nil {
+			if errors.Is(err, ErrQueueTimeout) {
+				apierror.Write(c.Writer, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeServerBusy, "Server is busy, try again later")
+			}
+			c.Abort()
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -0,0 +1,153 @@
+package concurrency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Acquire(t *testing.T) {
+	t.Run("nil limiter never blocks", func(t *testing.T) {
+		l := NewLimiter(0, time.Second)
+		assert.Nil(t, l)
+		release, err := l.Acquire(context.Background())
+		require.NoError(t, err)
+		release()
+	})
+
+	t.Run("waits for a released slot", func(t *testing.T) {
+		l := NewLimiter(1, time.Second)
+		release, err := l.Acquire(context.Background())
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			release()
+		}()
+		start := time.Now()
+		second, err := l.Acquire(context.Background())
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+		second()
+	})
+
+	t.Run("times out in the queue", func(t *testing.T) {
+		l := NewLimiter(1, 20*time.Millisecond)
+		release, err := l.Acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+
+		_, err = l.Acquire(context.Background())
+		assert.ErrorIs(t, err, ErrQueueTimeout)
+	})
+
+	t.Run("stops waiting when the context ends", func(t *testing.T) {
+		l := NewLimiter(1, -1)
+		release, err := l.Acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = l.Acquire(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(l *Limiter, handler gin.HandlerFunc) *gin.Engine {
+		router := gin.New()
+		router.GET("/", Middleware(l), handler)
+		return router
+	}
+
+	t.Run("requests beyond the limit queue until a slot frees up", func(t *testing.T) {
+		unblock := make(chan struct{})
+		started := make(chan struct{}, 2)
+		router := newRouter(NewLimiter(1, time.Second), func(c *gin.Context) {
+			started <- struct{}{}
+			<-unblock
+			c.Status(http.StatusOK)
+		})
+
+		results := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+				results <- rr.Code
+			}()
+		}
+
+		<-started
+		select {
+		case <-started:
+			t.Fatal("the second request should wait for the first to finish")
+		case <-time.After(50 * time.Millisecond):
+		}
+		unblock <- struct{}{}
+		<-started
+		close(unblock)
+
+		assert.Equal(t, http.StatusOK, <-results)
+		assert.Equal(t, http.StatusOK, <-results)
+	})
+
+	t.Run("queued request times out with 503", func(t *testing.T) {
+		unblock := make(chan struct{})
+		defer close(unblock)
+		started := make(chan struct{})
+		handled := 0
+		router := newRouter(NewLimiter(1, 20*time.Millisecond), func(c *gin.Context) {
+			handled++
+			close(started)
+			<-unblock
+		})
+
+		go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-started
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), apierror.CodeServerBusy)
+		assert.Equal(t, 1, handled)
+	})
+
+	t.Run("client leaving the queue gets no response", func(t *testing.T) {
+		unblock := make(chan struct{})
+		defer close(unblock)
+		started := make(chan struct{})
+		router := newRouter(NewLimiter(1, time.Second), func(c *gin.Context) {
+			close(started)
+			<-unblock
+		})
+
+		go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		assert.Empty(t, rr.Body.String())
+		assert.False(t, rr.Flushed)
+	})
+
+	t.Run("no limit passes requests through", func(t *testing.T) {
+		router := newRouter(nil, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+	})
+}
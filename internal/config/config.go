@@ -1,9 +1,19 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v2"
 )
 
@@ -11,31 +21,692 @@ import (
 type DatabaseConfig struct {
 	Type string `yaml:"type"`
 	DSN  string `yaml:"dsn"`
+	// ReplicaDSN optionally points listing queries at a read replica of the same database type.
+	ReplicaDSN string `yaml:"replica_dsn"`
+	// Connection pool limits; zero values fall back to the defaults below.
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// ConnectRetries is how often opening the database is retried with backoff at startup;
+	// negative disables retries.
+	ConnectRetries int `yaml:"connect_retries"`
+	// HealthCheckInterval is how often the connection is pinged for /readyz while it is healthy.
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
 }
 
+// Default database connection pool settings.
+const (
+	DefaultDBMaxOpenConns    = 25
+	DefaultDBMaxIdleConns    = 10
+	DefaultDBConnMaxLifetime = 30 * time.Minute
+)
+
+// Default database connection health settings.
+const (
+	DefaultDBConnectRetries      = 5
+	DefaultDBHealthCheckInterval = 15 * time.Second
+)
+
+// DefaultMaxRetryAttempts is the number of upstream attempts made per request when not configured.
+const DefaultMaxRetryAttempts = 5
+
+// DefaultRetryBackoffMultiplier and DefaultRetryBackoffMax shape the delay between upstream
+// attempts when proxy.retry_backoff is set but they are not configured.
+const (
+	DefaultRetryBackoffMultiplier = 2.0
+	DefaultRetryBackoffMax        = 10 * time.Second
+)
+
+// DefaultModelsCacheTTL is how long the upstream model listing is cached when not configured.
+const DefaultModelsCacheTTL = 5 * time.Minute
+
+// DefaultQueueTimeout is how long a request waits for a free slot under
+// proxy.max_concurrent_requests when proxy.queue_timeout is not configured.
+const DefaultQueueTimeout = 10 * time.Second
+
+// DefaultKeyTestTimeout bounds a single key validation request when not configured.
+const DefaultKeyTestTimeout = 60 * time.Second
+
+// Key selection strategies for proxy.selection_strategy.
+const (
+	// SelectionLowestUsage hands out the available key with the lowest usage count.
+	SelectionLowestUsage = "lowest_usage"
+	// SelectionWeightedQuota picks keys at random, weighted by their remaining daily quota.
+	SelectionWeightedQuota = "weighted_quota"
+	// SelectionTokenWeighted hands out the available key that has spent the fewest tokens, as
+	// reported in the usage of upstream responses.
+	SelectionTokenWeighted = "token_weighted"
+)
+
+// Statuses proxy.new_key_default_status may give newly added Gemini keys.
+const (
+	// KeyStatusActive puts new keys into rotation right away.
+	KeyStatusActive = "active"
+	// KeyStatusPending keeps new keys out of rotation until they pass a test or are activated.
+	KeyStatusPending = "pending"
+)
+
+// DefaultMaxRequestBodyBytes caps proxied request bodies when not configured, matching
+// Gemini's 20 MB inline request limit.
+const DefaultMaxRequestBodyBytes = 20 << 20
+
+// DefaultVerbatimBodyBytes is the request body size above which OpenAI requests are forwarded
+// verbatim when not configured.
+const DefaultVerbatimBodyBytes = 4 << 20
+
+// DefaultMinSamples is how many recent requests a key's success ratio is measured over when not configured.
+const DefaultMinSamples = 20
+
+// DefaultRequestLogSize is how many recent requests are kept for the admin API when not configured.
+const DefaultRequestLogSize = 100
+
+// DefaultStripFields are the OpenAI-specific fields removed from chat completion requests when
+// proxy.strip_fields is not configured. Sourced from OpenAI API documentation and common client libraries.
+var DefaultStripFields = []string{
+	"frequency_penalty",
+	"presence_penalty",
+	"logit_bias",
+	"logprobs",
+	"top_logprobs",
+	"reasoning_effort",
+	"max_completion_tokens",
+	"n",
+	"tools",
+	"function_call",
+	"functions",
+}
+
+// DefaultToolModels are the models that keep the tool and function calling fields of OpenAI
+// requests when proxy.tool_models is not configured.
+var DefaultToolModels = []string{"gemini-1.5-*", "gemini-2*"}
+
+// DefaultRetryableStatusCodes are the upstream statuses retried with another key when
+// proxy.retryable_status_codes is not configured.
+var DefaultRetryableStatusCodes = []int{401, 403, 429, 500, 502, 503}
+
 // ProxyConfig holds configuration specific to the proxy.
 type ProxyConfig struct {
-	DisableKeyThreshold int `yaml:"disable_key_threshold"`
+	DisableKeyThreshold int               `yaml:"disable_key_threshold"`
+	MaxRetryAttempts    int               `yaml:"max_retry_attempts"`
+	ModelAliases        map[string]string `yaml:"model_aliases"`
+	// DefaultModel is set as the model of chat completion requests that name none; empty leaves
+	// such requests unchanged.
+	DefaultModel string `yaml:"default_model"`
+	// ModelsCacheTTL is how long GET /v1/models responses are cached; negative disables caching.
+	ModelsCacheTTL time.Duration `yaml:"models_cache_ttl"`
+	// RequestTimeout bounds the time until the upstream response starts. Unset or non-positive
+	// disables it, since long non-streaming generations can take minutes to answer.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MaxConcurrentRequests caps the proxy requests served at once; excess requests queue for a
+	// free slot. Zero disables the limit.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+	// QueueTimeout is how long a queued request waits before getting 503; negative waits as long
+	// as the client does.
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	// MaxRequestBodyBytes caps proxied request bodies; larger requests get 413. Negative disables it.
+	MaxRequestBodyBytes int64 `yaml:"max_request_body_bytes"`
+	// VerbatimBodyBytes forwards OpenAI request bodies larger than this without stripping fields,
+	// as multimodal bodies always are; only the model name is rewritten. Negative disables the
+	// size check.
+	VerbatimBodyBytes int64 `yaml:"verbatim_body_bytes"`
+	// SSEHeartbeatInterval is how often the balancer keeps idle event streams alive; zero disables it.
+	SSEHeartbeatInterval time.Duration `yaml:"sse_heartbeat_interval"`
+	// RequestLogSize is how many recent requests are kept in memory; negative disables the log.
+	RequestLogSize int `yaml:"request_log_size"`
+	// SelectionStrategy decides how the key manager picks the next key, see SelectionLowestUsage.
+	SelectionStrategy string `yaml:"selection_strategy"`
+	// NewKeyDefaultStatus is the status of Gemini keys added through the admin API or seeded
+	// from gemini_keys, see KeyStatusActive.
+	NewKeyDefaultStatus string `yaml:"new_key_default_status"`
+	// TemporaryDisableDuration re-enables keys that hit the failure threshold after this long,
+	// without waiting for a revival check. Zero keeps them disabled until revived.
+	TemporaryDisableDuration time.Duration `yaml:"temporary_disable_duration"`
+	// MinSuccessRatio disables keys whose success ratio over the last MinSamples requests drops
+	// below it, catching keys that fail intermittently; zero disables the check.
+	MinSuccessRatio float64 `yaml:"min_success_ratio"`
+	// MinSamples is how many recent requests a key's success ratio is measured over.
+	MinSamples int `yaml:"min_samples"`
+	// FatalErrorPatterns disable a key permanently when an upstream error body contains one
+	// of them, ignoring case, instead of counting toward DisableKeyThreshold.
+	FatalErrorPatterns []string `yaml:"fatal_error_patterns"`
+	// MinAvailableKeysWarn logs a warning when fewer keys than this are available; zero disables it.
+	MinAvailableKeysWarn int `yaml:"min_available_keys_warn"`
+	// RateLimitCooldown is how long a key that returned 429 is moved behind the other keys.
+	RateLimitCooldown time.Duration `yaml:"rate_limit_cooldown"`
+	// KeyTestTimeout bounds each request made to validate a key, e.g. by health checks and revivals.
+	KeyTestTimeout time.Duration `yaml:"key_test_timeout"`
+	// TrackUsage persists per-key usage counts to the database; nil means enabled.
+	TrackUsage *bool `yaml:"track_usage"`
+	// StripFields are removed from OpenAI chat completion requests; nil means DefaultStripFields.
+	StripFields []string `yaml:"strip_fields"`
+	// ToolModels are path.Match patterns of the models for which the tool and function calling
+	// fields are kept even when StripFields lists them; nil means DefaultToolModels.
+	ToolModels []string `yaml:"tool_models"`
+	// StripTopK removes top_k from OpenAI requests; nil means enabled.
+	StripTopK *bool `yaml:"strip_top_k"`
+	// StripNullFields removes top-level fields set to null from OpenAI requests; nil means enabled.
+	StripNullFields *bool `yaml:"strip_null_fields"`
+	// RetryableStatusCodes are the upstream statuses retried with another key; nil means
+	// DefaultRetryableStatusCodes and an empty list only retries transport errors.
+	RetryableStatusCodes []int `yaml:"retryable_status_codes"`
+	// RetryBackoff is the delay before the first retry of a failed upstream attempt; zero
+	// retries immediately.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// RetryBackoffMultiplier grows the delay after every retry; zero means DefaultRetryBackoffMultiplier.
+	RetryBackoffMultiplier float64 `yaml:"retry_backoff_multiplier"`
+	// RetryBackoffMax caps the delay between attempts, including one asked for by Retry-After;
+	// zero means DefaultRetryBackoffMax.
+	RetryBackoffMax time.Duration `yaml:"retry_backoff_max"`
+	// MaxRetryBodyBytes caps the request body kept in memory to resend on retries; requests with
+	// larger bodies are sent once without retries. Zero means the request body limit, see
+	// RequestBodyLimit, and negative buffers bodies of any size.
+	MaxRetryBodyBytes int64 `yaml:"max_retry_body_bytes"`
+	// RetryJitter waits a random time between zero and the computed delay instead of the full delay.
+	RetryJitter bool `yaml:"retry_jitter"`
+	// NormalizeResponses fills in the id, object, created and usage fields of non-streaming
+	// chat completion responses for strict OpenAI clients.
+	NormalizeResponses bool `yaml:"normalize_responses"`
+	// StripResponseHeaders are path.Match patterns of upstream response headers removed before
+	// responses reach clients, e.g. Alt-Svc or X-Goog-*.
+	StripResponseHeaders []string `yaml:"strip_response_headers"`
+	// AllowResponseHeaders, when not empty, are the path.Match patterns of the only upstream
+	// response headers passed on to clients, apart from the headers framing the body.
+	AllowResponseHeaders []string `yaml:"allow_response_headers"`
+}
+
+// UsageTrackingEnabled reports whether key usage should be written to the database.
+func (p ProxyConfig) UsageTrackingEnabled() bool {
+	return p.TrackUsage == nil || *p.TrackUsage
+}
+
+// TopKStrippingEnabled reports whether top_k should be removed from OpenAI requests.
+func (p ProxyConfig) TopKStrippingEnabled() bool {
+	return p.StripTopK == nil || *p.StripTopK
+}
+
+// NullStrippingEnabled reports whether null-valued fields should be removed from OpenAI requests.
+func (p ProxyConfig) NullStrippingEnabled() bool {
+	return p.StripNullFields == nil || *p.StripNullFields
+}
+
+// RequestBodyLimit returns the maximum proxied request body size in bytes, or zero for no limit.
+func (p ProxyConfig) RequestBodyLimit() int64 {
+	switch {
+	case p.MaxRequestBodyBytes > 0:
+		return p.MaxRequestBodyBytes
+	case p.MaxRequestBodyBytes == 0:
+		return DefaultMaxRequestBodyBytes
+	default:
+		return 0
+	}
+}
+
+// VerbatimBodyThreshold returns the request body size above which OpenAI requests are forwarded
+// verbatim, or zero when only multimodal requests are.
+func (p ProxyConfig) VerbatimBodyThreshold() int64 {
+	switch {
+	case p.VerbatimBodyBytes > 0:
+		return p.VerbatimBodyBytes
+	case p.VerbatimBodyBytes == 0:
+		return DefaultVerbatimBodyBytes
+	default:
+		return 0
+	}
+}
+
+// FieldsToStrip returns the fields removed from OpenAI chat completion requests.
+func (p ProxyConfig) FieldsToStrip() []string {
+	if p.StripFields == nil {
+		return DefaultStripFields
+	}
+	return p.StripFields
+}
+
+// ToolCallingModels returns the model patterns that keep the tool and function calling fields.
+func (p ProxyConfig) ToolCallingModels() []string {
+	if p.ToolModels == nil {
+		return DefaultToolModels
+	}
+	return p.ToolModels
+}
+
+// RetryBodyLimit returns the largest request body buffered to resend on retries, or zero for
+// no limit.
+func (p ProxyConfig) RetryBodyLimit() int64 {
+	switch {
+	case p.MaxRetryBodyBytes > 0:
+		return p.MaxRetryBodyBytes
+	case p.MaxRetryBodyBytes == 0:
+		return p.RequestBodyLimit()
+	default:
+		return 0
+	}
+}
+
+// RetryableStatuses returns the upstream statuses that are retried with another key.
+func (p ProxyConfig) RetryableStatuses() []int {
+	if p.RetryableStatusCodes == nil {
+		return DefaultRetryableStatusCodes
+	}
+	return p.RetryableStatusCodes
+}
+
+// AdminUser is a set of credentials allowed to access the admin panel.
+type AdminUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 // AdminConfig holds configuration for the admin panel.
+// Password is the legacy single password for the "admin" user; Users adds named accounts.
 type AdminConfig struct {
-	Password string `yaml:"password"`
+	Password string      `yaml:"password"`
+	Users    []AdminUser `yaml:"users"`
+}
+
+// Credentials returns the configured admin passwords keyed by username.
+func (a AdminConfig) Credentials() map[string]string {
+	creds := make(map[string]string, len(a.Users)+1)
+	if a.Password != "" {
+		creds["admin"] = a.Password
+	}
+	for _, u := range a.Users {
+		creds[u.Username] = u.Password
+	}
+	return creds
 }
 
+// DefaultKeyReloadInterval is how often keys are reloaded from the database when not configured.
+const DefaultKeyReloadInterval = 1 * time.Minute
+
+// DefaultFailureDecayWindow is how long a key must go without failing before its failure count decays.
+const DefaultFailureDecayWindow = 24 * time.Hour
+
+// Defaults for the auto-prune job, which only runs when scheduler.auto_prune_failures is set.
+const (
+	DefaultAutoPruneInterval = "@daily"
+	DefaultAutoPruneDays     = 7
+)
+
+// Actions the auto-prune job can take on a key that keeps failing revival.
+const (
+	// AutoPruneActionDead sets the key's status to "dead", which no check or revival touches.
+	AutoPruneActionDead = "dead"
+	// AutoPruneActionDelete soft-deletes the key so it can still be restored from the trash.
+	AutoPruneActionDelete = "delete"
+)
+
 // SchedulerConfig holds configuration for the scheduler.
 type SchedulerConfig struct {
 	KeyRevivalInterval string `yaml:"key_revival_interval"`
+	KeyReloadInterval  string `yaml:"key_reload_interval"`
+	// FailureDecayInterval is the cron spec for decrementing failure counts of keys that stopped failing.
+	FailureDecayInterval string `yaml:"failure_decay_interval"`
+	// FailureDecayWindow is a Go duration; only keys without a failure in this window decay.
+	FailureDecayWindow string `yaml:"failure_decay_window"`
+	// ClientKeyExpiryInterval is the cron spec for marking client keys past their expiry as expired.
+	ClientKeyExpiryInterval string `yaml:"client_key_expiry_interval"`
+	// UsageResetInterval is the cron spec for resetting the daily usage of Gemini keys. Client key
+	// usage is always reset monthly, matching APIKey.MonthlyQuota.
+	UsageResetInterval string `yaml:"usage_reset_interval"`
+	// AutoPruneFailures is the number of failed revivals after which a disabled key is pruned;
+	// 0 disables the auto-prune job.
+	AutoPruneFailures int `yaml:"auto_prune_failures"`
+	// AutoPruneDays is how many days a key must have been disabled before it is pruned.
+	AutoPruneDays int `yaml:"auto_prune_days"`
+	// AutoPruneAction is AutoPruneActionDead or AutoPruneActionDelete.
+	AutoPruneAction string `yaml:"auto_prune_action"`
+	// AutoPruneInterval is the cron spec for the auto-prune job.
+	AutoPruneInterval string `yaml:"auto_prune_interval"`
+}
+
+// FailureDecayWindowDuration returns the parsed failure decay window, or DefaultFailureDecayWindow when unset.
+func (s SchedulerConfig) FailureDecayWindowDuration() time.Duration {
+	if s.FailureDecayWindow == "" {
+		return DefaultFailureDecayWindow
+	}
+	d, err := time.ParseDuration(s.FailureDecayWindow)
+	if err != nil || d <= 0 {
+		return DefaultFailureDecayWindow
+	}
+	return d
+}
+
+// KeyReloadDuration returns the parsed key reload interval, or DefaultKeyReloadInterval when unset.
+// LoadConfig rejects invalid values, so a parse failure here also falls back to the default.
+func (s SchedulerConfig) KeyReloadDuration() time.Duration {
+	if s.KeyReloadInterval == "" {
+		return DefaultKeyReloadInterval
+	}
+	d, err := time.ParseDuration(s.KeyReloadInterval)
+	if err != nil || d <= 0 {
+		return DefaultKeyReloadInterval
+	}
+	return d
+}
+
+// Defaults for the upstream the OpenAI proxy and key health checks talk to.
+const (
+	DefaultUpstreamBaseURL = "https://generativelanguage.googleapis.com"
+	DefaultHealthCheckPath = "/v1beta/openai/models"
+)
+
+// UpstreamConfig holds the connection settings for requests to the Gemini API.
+type UpstreamConfig struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	// BaseURL is the scheme and host of the OpenAI-compatible upstream.
+	BaseURL string `yaml:"base_url"`
+	// HealthCheckPath is requested on BaseURL to test whether a key works.
+	HealthCheckPath string `yaml:"health_check_path"`
+}
+
+// BaseURLOrDefault returns BaseURL, or DefaultUpstreamBaseURL when unset.
+func (u UpstreamConfig) BaseURLOrDefault() string {
+	if u.BaseURL == "" {
+		return DefaultUpstreamBaseURL
+	}
+	return u.BaseURL
+}
+
+// HealthCheckURL returns the full URL used to test keys against the upstream.
+func (u UpstreamConfig) HealthCheckURL() string {
+	path := u.HealthCheckPath
+	if path == "" {
+		path = DefaultHealthCheckPath
+	}
+	return strings.TrimRight(u.BaseURLOrDefault(), "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// TracingConfig holds the OpenTelemetry trace export settings.
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Endpoint    string `yaml:"endpoint"`
+	Insecure    bool   `yaml:"insecure"`
+	ServiceName string `yaml:"service_name"`
+}
+
+// DefaultRedisChannel is the pub/sub channel key state changes are shared on when not configured.
+const DefaultRedisChannel = "gogemini:key-events"
+
+// RedisConfig holds the Redis server used to share key state between instances.
+type RedisConfig struct {
+	// Addr is the host:port of the Redis server; empty disables sharing.
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// Channel is the pub/sub channel; empty uses DefaultRedisChannel.
+	Channel string `yaml:"channel"`
+}
+
+// Enabled reports whether key state should be shared through Redis.
+func (r RedisConfig) Enabled() bool {
+	return r.Addr != ""
+}
+
+// NotificationsConfig holds where key lifecycle events are reported.
+type NotificationsConfig struct {
+	// WebhookURL receives a JSON POST when a key is disabled or revived and when no keys are
+	// left; empty disables notifications. Slack incoming webhooks are supported.
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// TLSConfig holds the certificate used to serve HTTPS directly.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// Enabled reports whether both a certificate and a key are configured.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// CORSConfig controls cross-origin access to the admin API and proxy endpoints.
+type CORSConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+}
+
+// AccessConfig restricts the admin API and proxy endpoints by client IP.
+// Entries are CIDR ranges or single IP addresses; an empty list allows every client.
+type AccessConfig struct {
+	AdminAllowCIDRs []string `yaml:"admin_allow_cidrs"`
+	ProxyAllowCIDRs []string `yaml:"proxy_allow_cidrs"`
+	// TrustedProxies are the reverse proxies (CIDR ranges or IPs) whose X-Forwarded-For header is
+	// used to find the client IP. When empty, X-Forwarded-For is ignored and the connection's
+	// remote address is used, so clients cannot spoof their way past the allowlists.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// ClientKeySources lists where the proxy endpoints look for the client key, in priority
+	// order, see ParseKeySource. Nil uses DefaultClientKeySources.
+	ClientKeySources []string `yaml:"client_key_sources"`
+}
+
+// DefaultClientKeySources are the client key sources used when access.client_key_sources is not set:
+// an OpenAI-style bearer token, then Gemini's and Anthropic's API key headers.
+var DefaultClientKeySources = []string{KeySourceBearer, "header:x-goog-api-key", "header:x-api-key"}
+
+// KeySources returns the configured client key sources, or DefaultClientKeySources.
+func (a AccessConfig) KeySources() []string {
+	if a.ClientKeySources == nil {
+		return DefaultClientKeySources
+	}
+	return a.ClientKeySources
+}
+
+// Kinds of client key sources.
+const (
+	// KeySourceBearer reads the key from an "Authorization: Bearer" header.
+	KeySourceBearer = "bearer"
+	// KeySourceHeader reads the key from a named header, written "header:<name>".
+	KeySourceHeader = "header"
+	// KeySourceQuery reads the key from a named query parameter, written "query:<name>".
+	KeySourceQuery = "query"
+)
+
+// KeySource is a parsed access.client_key_sources entry.
+type KeySource struct {
+	// Kind is KeySourceBearer, KeySourceHeader or KeySourceQuery.
+	Kind string
+	// Name is the header or query parameter name; it is empty for KeySourceBearer.
+	Name string
+}
+
+// ParseKeySource parses a client key source: "bearer", "header:<name>" or "query:<name>".
+func ParseKeySource(entry string) (KeySource, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == KeySourceBearer {
+		return KeySource{Kind: KeySourceBearer}, nil
+	}
+	kind, name, _ := strings.Cut(entry, ":")
+	name = strings.TrimSpace(name)
+	if (kind != KeySourceHeader && kind != KeySourceQuery) || name == "" {
+		return KeySource{}, fmt.Errorf("invalid key source %q, expected %q, \"header:<name>\" or \"query:<name>\"", entry, KeySourceBearer)
+	}
+	return KeySource{Kind: kind, Name: name}, nil
+}
+
+// ServerConfig holds settings for the HTTP server itself.
+type ServerConfig struct {
+	// RequestTimeout bounds how long the server spends on a request before answering 503.
+	// Negative disables it.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// RequestTimeoutOverrides replaces RequestTimeout for paths under the given prefixes; a
+	// non-positive value disables the timeout there. Nil uses DefaultRequestTimeoutOverrides,
+	// an empty map applies RequestTimeout everywhere.
+	RequestTimeoutOverrides map[string]time.Duration `yaml:"request_timeout_overrides"`
+	// UnixSocket, when set, is the path of a Unix domain socket the server listens on instead of
+	// the TCP port.
+	UnixSocket string `yaml:"unix_socket"`
+}
+
+// DefaultServerRequestTimeout is server.request_timeout when not configured.
+const DefaultServerRequestTimeout = time.Minute
+
+// DefaultRequestTimeoutOverrides exempts the streaming endpoints from server.request_timeout,
+// which buffers responses: the proxy endpoints, where proxy.request_timeout bounds the wait for
+// the upstream when set, and the key export, which streams the whole table.
+var DefaultRequestTimeoutOverrides = map[string]time.Duration{
+	"/gemini":                   -1,
+	"/openai":                   -1,
+	"/anthropic":                -1,
+	"/v1/embeddings":            -1,
+	"/admin/gemini-keys/export": -1,
+}
+
+// RequestTimeoutFor returns the request timeout for path: the override with the longest
+// matching prefix, or RequestTimeout. A non-positive result means no timeout.
+func (s ServerConfig) RequestTimeoutFor(path string) time.Duration {
+	timeout := s.RequestTimeout
+	if timeout == 0 {
+		timeout = DefaultServerRequestTimeout
+	}
+	overrides := s.RequestTimeoutOverrides
+	if overrides == nil {
+		overrides = DefaultRequestTimeoutOverrides
+	}
+	longest := -1
+	for prefix, d := range overrides {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if len(prefix) > longest && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+			timeout, longest = d, len(prefix)
+		}
+	}
+	return timeout
+}
+
+// ParseNetwork parses a CIDR range, or a single IP address as a one-address range.
+func ParseNetwork(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+	}
+	return network, nil
+}
+
+// cleanKeyList trims whitespace from keys and drops empty and repeated entries, keeping their order.
+func cleanKeyList(keys []string) []string {
+	var cleaned []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, key)
+	}
+	return cleaned
+}
+
+// LoggingConfig controls the application log output.
+type LoggingConfig struct {
+	// Format is "json" or "text".
+	Format string `yaml:"format"`
+	// Level is the minimum level logged (debug, info, warn or error). When empty, Debug
+	// selects debug and otherwise info is used.
+	Level string `yaml:"level"`
+	// AccessLog logs every request served, with its status, latency and keys; nil means enabled.
+	AccessLog *bool `yaml:"access_log"`
+}
+
+// AccessLogEnabled reports whether requests are written to the access log.
+func (l LoggingConfig) AccessLogEnabled() bool {
+	return l.AccessLog == nil || *l.AccessLog
+}
+
+// BalancerConfig holds configuration specific to the native Gemini balancer.
+type BalancerConfig struct {
+	// AllowBYOKey lets clients forward their own x-goog-api-key by sending X-Use-Client-Key: true.
+	AllowBYOKey bool `yaml:"allow_byo_key"`
+	// UsageWeights sets how much a request whose path ends with the given suffix counts toward
+	// its key's usage, e.g. 0.1 for ":countTokens"; other requests count 1. Nil uses
+	// DefaultUsageWeights and an empty map counts every request equally.
+	UsageWeights map[string]float64 `yaml:"usage_weights"`
+	// APIVersion, e.g. "v1", replaces the API version in every request path; empty keeps the
+	// version the client asked for. Clients can override it with the X-Gemini-API-Version header.
+	APIVersion string `yaml:"api_version"`
+}
+
+// DefaultGeminiAPIVersion is used for native Gemini paths that name no API version.
+const DefaultGeminiAPIVersion = "v1beta"
+
+// geminiAPIVersionPattern matches Gemini API versions such as v1, v1beta and v1alpha.
+var geminiAPIVersionPattern = regexp.MustCompile(`^v[0-9]+(alpha|beta)?[0-9]*$`)
+
+// IsGeminiAPIVersion reports whether s is a Gemini API version such as "v1" or "v1beta".
+func IsGeminiAPIVersion(s string) bool {
+	return geminiAPIVersionPattern.MatchString(s)
+}
+
+// DefaultUsageWeights makes token counting, which is cheap compared to generation, count as a
+// tenth of a request so it doesn't skew the balancing.
+var DefaultUsageWeights = map[string]float64{
+	":countTokens": 0.1,
+}
+
+// UsageWeightFor returns the usage weight of a request to path: the weight of the longest
+// matching suffix in UsageWeights, or 1.
+func (b BalancerConfig) UsageWeightFor(path string) float64 {
+	weights := b.UsageWeights
+	if weights == nil {
+		weights = DefaultUsageWeights
+	}
+	weight, longest := 1.0, -1
+	for suffix, w := range weights {
+		if len(suffix) > longest && strings.HasSuffix(path, suffix) {
+			weight, longest = w, len(suffix)
+		}
+	}
+	return weight
 }
 
 // Config holds the configuration for the load balancer.
 type Config struct {
-	Database  DatabaseConfig  `yaml:"database"`
-	Proxy     ProxyConfig     `yaml:"proxy"`
-	Admin     AdminConfig     `yaml:"admin"`
-	Scheduler SchedulerConfig `yaml:"scheduler"`
-	Port      int             `yaml:"port"`
-	Debug     bool            `yaml:"debug"`
+	Database      DatabaseConfig      `yaml:"database"`
+	Proxy         ProxyConfig         `yaml:"proxy"`
+	Balancer      BalancerConfig      `yaml:"balancer"`
+	Admin         AdminConfig         `yaml:"admin"`
+	Scheduler     SchedulerConfig     `yaml:"scheduler"`
+	Upstream      UpstreamConfig      `yaml:"upstream"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	TLS           TLSConfig           `yaml:"tls"`
+	CORS          CORSConfig          `yaml:"cors"`
+	Access        AccessConfig        `yaml:"access"`
+	Server        ServerConfig        `yaml:"server"`
+	Logging       LoggingConfig       `yaml:"logging"`
+	Redis         RedisConfig         `yaml:"redis"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Port          int                 `yaml:"port"`
+	Debug         bool                `yaml:"debug"`
+	// GeminiKeys are seeded into the database at startup; keys already stored are skipped.
+	GeminiKeys []string `yaml:"gemini_keys"`
+}
+
+// validatePatterns reports the first pattern that path.Match cannot parse.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
 }
 
 // LoadConfig reads and parses the configuration file. It returns the config and a potential warning message.
@@ -61,11 +732,92 @@ var LoadConfig = func(path string) (*Config, string, error) {
 		config.Proxy.DisableKeyThreshold = 3
 		warning = "proxy.disable_key_threshold not set, using default value of 3"
 	}
+	if config.Proxy.MaxRetryAttempts == 0 {
+		config.Proxy.MaxRetryAttempts = DefaultMaxRetryAttempts
+	}
+	if config.Proxy.KeyTestTimeout == 0 {
+		config.Proxy.KeyTestTimeout = DefaultKeyTestTimeout
+	}
+	if config.Proxy.QueueTimeout == 0 {
+		config.Proxy.QueueTimeout = DefaultQueueTimeout
+	}
+	if config.Scheduler.AutoPruneDays == 0 {
+		config.Scheduler.AutoPruneDays = DefaultAutoPruneDays
+	}
+	if config.Scheduler.AutoPruneAction == "" {
+		config.Scheduler.AutoPruneAction = AutoPruneActionDead
+	}
+	if config.Scheduler.AutoPruneInterval == "" {
+		config.Scheduler.AutoPruneInterval = DefaultAutoPruneInterval
+	}
+	if config.Proxy.ModelsCacheTTL == 0 {
+		config.Proxy.ModelsCacheTTL = DefaultModelsCacheTTL
+	}
+	if config.Server.RequestTimeout == 0 {
+		config.Server.RequestTimeout = DefaultServerRequestTimeout
+	}
+	if config.Proxy.MaxRequestBodyBytes == 0 {
+		config.Proxy.MaxRequestBodyBytes = DefaultMaxRequestBodyBytes
+	}
+	if config.Proxy.VerbatimBodyBytes == 0 {
+		config.Proxy.VerbatimBodyBytes = DefaultVerbatimBodyBytes
+	}
+	if config.Proxy.RequestLogSize == 0 {
+		config.Proxy.RequestLogSize = DefaultRequestLogSize
+	}
+	if config.Proxy.SelectionStrategy == "" {
+		config.Proxy.SelectionStrategy = SelectionLowestUsage
+	}
+	if config.Proxy.NewKeyDefaultStatus == "" {
+		config.Proxy.NewKeyDefaultStatus = KeyStatusActive
+	}
+	if config.Proxy.StripFields == nil {
+		config.Proxy.StripFields = append([]string(nil), DefaultStripFields...)
+	}
+	if config.Proxy.ToolModels == nil {
+		config.Proxy.ToolModels = append([]string(nil), DefaultToolModels...)
+	}
+	if config.Proxy.MinSamples == 0 {
+		config.Proxy.MinSamples = DefaultMinSamples
+	}
+	if config.Proxy.RetryableStatusCodes == nil {
+		config.Proxy.RetryableStatusCodes = append([]int(nil), DefaultRetryableStatusCodes...)
+	}
+	if config.Upstream.MaxIdleConns == 0 {
+		config.Upstream.MaxIdleConns = 100
+	}
+	if config.Upstream.MaxIdleConnsPerHost == 0 {
+		config.Upstream.MaxIdleConnsPerHost = 100
+	}
+	if config.Upstream.IdleConnTimeout == 0 {
+		config.Upstream.IdleConnTimeout = 90 * time.Second
+	}
+	if config.Upstream.BaseURL == "" {
+		config.Upstream.BaseURL = DefaultUpstreamBaseURL
+	}
+	if config.Upstream.HealthCheckPath == "" {
+		config.Upstream.HealthCheckPath = DefaultHealthCheckPath
+	}
+	if config.Logging.Format == "" {
+		config.Logging.Format = "json"
+	}
+	if config.Tracing.ServiceName == "" {
+		config.Tracing.ServiceName = "gogemini"
+	}
+	if len(config.CORS.AllowedMethods) == 0 {
+		config.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	}
+	if len(config.CORS.AllowedHeaders) == 0 {
+		config.CORS.AllowedHeaders = []string{"Authorization", "Content-Type", "X-Request-ID", "X-Session-ID", "x-goog-api-key"}
+	}
 
 	// Override with environment variables if they exist
 	if dsn := os.Getenv("GOGEMINI_DATABASE_DSN"); dsn != "" {
 		config.Database.DSN = dsn
 	}
+	if replicaDSN := os.Getenv("GOGEMINI_DATABASE_REPLICA_DSN"); replicaDSN != "" {
+		config.Database.ReplicaDSN = replicaDSN
+	}
 	if dbType := os.Getenv("GOGEMINI_DATABASE_TYPE"); dbType != "" {
 		config.Database.Type = dbType
 	}
@@ -81,11 +833,222 @@ var LoadConfig = func(path string) (*Config, string, error) {
 	if debug := os.Getenv("GOGEMINI_DEBUG"); debug != "" {
 		config.Debug = (debug == "true")
 	}
+	if keys := os.Getenv("GOGEMINI_GEMINI_KEYS"); keys != "" {
+		config.GeminiKeys = strings.Split(keys, ",")
+	}
+	config.GeminiKeys = cleanKeyList(config.GeminiKeys)
 
-	// Final validation after overrides
-	if config.Database.Type == "" || config.Database.DSN == "" {
-		return nil, "", fmt.Errorf("database type and dsn must be configured in config.yaml or via environment variables")
+	if err := config.Validate(); err != nil {
+		return nil, "", err
 	}
 
 	return &config, warning, nil
 }
+
+// Validate checks c, with the defaults LoadConfig applies, and returns an error listing every
+// problem found, one per line, or nil if there are none.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Database.Type == "" || c.Database.DSN == "" {
+		errs = append(errs, fmt.Errorf("database type and dsn must be configured in config.yaml or via environment variables"))
+	}
+	if u, err := url.Parse(c.Upstream.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("upstream.base_url must be an absolute URL, got %q", c.Upstream.BaseURL))
+	}
+	if webhookURL := c.Notifications.WebhookURL; webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("notifications.webhook_url must be an http or https URL"))
+		}
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		errs = append(errs, fmt.Errorf("logging.format must be json or text, got %q", c.Logging.Format))
+	}
+	if c.Logging.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+			errs = append(errs, fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level))
+		}
+	}
+	if c.Proxy.MinSuccessRatio < 0 || c.Proxy.MinSuccessRatio > 1 {
+		errs = append(errs, fmt.Errorf("proxy.min_success_ratio must be between 0 and 1, got %v", c.Proxy.MinSuccessRatio))
+	}
+	if c.Proxy.MinSamples < 0 {
+		errs = append(errs, fmt.Errorf("proxy.min_samples must not be negative, got %d", c.Proxy.MinSamples))
+	}
+	for _, pattern := range c.Proxy.FatalErrorPatterns {
+		if strings.TrimSpace(pattern) == "" {
+			errs = append(errs, fmt.Errorf("proxy.fatal_error_patterns must not contain empty patterns"))
+			break
+		}
+	}
+	if c.Balancer.APIVersion != "" && !IsGeminiAPIVersion(c.Balancer.APIVersion) {
+		errs = append(errs, fmt.Errorf("balancer.api_version must be a Gemini API version such as v1 or v1beta, got %q", c.Balancer.APIVersion))
+	}
+	if err := validatePatterns(c.Proxy.ToolModels); err != nil {
+		errs = append(errs, fmt.Errorf("proxy.tool_models: %w", err))
+	}
+	if err := validatePatterns(c.Proxy.StripResponseHeaders); err != nil {
+		errs = append(errs, fmt.Errorf("proxy.strip_response_headers: %w", err))
+	}
+	if err := validatePatterns(c.Proxy.AllowResponseHeaders); err != nil {
+		errs = append(errs, fmt.Errorf("proxy.allow_response_headers: %w", err))
+	}
+	for suffix, weight := range c.Balancer.UsageWeights {
+		if suffix == "" {
+			errs = append(errs, fmt.Errorf("balancer.usage_weights must not contain an empty path suffix"))
+		}
+		if weight < 0 {
+			errs = append(errs, fmt.Errorf("balancer.usage_weights: weight for %q must not be negative, got %v", suffix, weight))
+		}
+	}
+	if c.Proxy.MaxConcurrentRequests < 0 {
+		errs = append(errs, fmt.Errorf("proxy.max_concurrent_requests must not be negative, got %d", c.Proxy.MaxConcurrentRequests))
+	}
+	if c.Proxy.KeyTestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("proxy.key_test_timeout must not be negative, got %s", c.Proxy.KeyTestTimeout))
+	}
+	if c.Proxy.MinAvailableKeysWarn < 0 {
+		errs = append(errs, fmt.Errorf("proxy.min_available_keys_warn must not be negative, got %d", c.Proxy.MinAvailableKeysWarn))
+	}
+	for _, code := range c.Proxy.RetryableStatusCodes {
+		// Statuses below 400 are treated as success and never retried.
+		if code < 400 || code > 599 {
+			errs = append(errs, fmt.Errorf("proxy.retryable_status_codes: %d is not an HTTP error status (400-599)", code))
+		}
+	}
+	if c.CORS.Enabled && len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, fmt.Errorf("cors.allowed_origins must not be empty when cors is enabled"))
+	}
+	if c.CORS.Enabled && c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		// The wildcard is answered by echoing the origin, which with credentials would let any
+		// site make authenticated requests on a visitor's behalf.
+		errs = append(errs, fmt.Errorf("cors.allow_credentials cannot be combined with the \"*\" origin; list the origins explicitly"))
+	}
+	for _, list := range []struct {
+		name    string
+		entries []string
+	}{
+		{"access.admin_allow_cidrs", c.Access.AdminAllowCIDRs},
+		{"access.proxy_allow_cidrs", c.Access.ProxyAllowCIDRs},
+		{"access.trusted_proxies", c.Access.TrustedProxies},
+	} {
+		for _, entry := range list.entries {
+			if _, err := ParseNetwork(entry); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", list.name, err))
+			}
+		}
+	}
+	if c.Server.UnixSocket != "" && (len(c.Access.AdminAllowCIDRs) > 0 || len(c.Access.ProxyAllowCIDRs) > 0) {
+		// Socket peers have no IP address, so an allowlist would reject every request.
+		errs = append(errs, fmt.Errorf("access.admin_allow_cidrs and access.proxy_allow_cidrs cannot be used with server.unix_socket; restrict access with the socket's file permissions instead"))
+	}
+	if c.Access.ClientKeySources != nil && len(c.Access.ClientKeySources) == 0 {
+		errs = append(errs, fmt.Errorf("access.client_key_sources must not be empty"))
+	}
+	for _, entry := range c.Access.ClientKeySources {
+		if _, err := ParseKeySource(entry); err != nil {
+			errs = append(errs, fmt.Errorf("access.client_key_sources: %w", err))
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.TLS.Enabled() {
+		if _, err := os.Stat(c.TLS.CertFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls.cert_file %q is not readable: %w", c.TLS.CertFile, err))
+		}
+		if _, err := os.Stat(c.TLS.KeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls.key_file %q is not readable: %w", c.TLS.KeyFile, err))
+		}
+	}
+	if c.Scheduler.KeyReloadInterval != "" {
+		d, err := time.ParseDuration(c.Scheduler.KeyReloadInterval)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid scheduler.key_reload_interval %q: %w", c.Scheduler.KeyReloadInterval, err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("scheduler.key_reload_interval must be positive, got %s", d))
+		}
+	}
+	if c.Scheduler.FailureDecayWindow != "" {
+		d, err := time.ParseDuration(c.Scheduler.FailureDecayWindow)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid scheduler.failure_decay_window %q: %w", c.Scheduler.FailureDecayWindow, err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("scheduler.failure_decay_window must be positive, got %s", d))
+		}
+	}
+	if c.Scheduler.AutoPruneFailures < 0 {
+		errs = append(errs, fmt.Errorf("scheduler.auto_prune_failures must not be negative, got %d", c.Scheduler.AutoPruneFailures))
+	}
+	if c.Scheduler.AutoPruneDays < 0 {
+		errs = append(errs, fmt.Errorf("scheduler.auto_prune_days must not be negative, got %d", c.Scheduler.AutoPruneDays))
+	}
+	switch c.Scheduler.AutoPruneAction {
+	case AutoPruneActionDead, AutoPruneActionDelete:
+	default:
+		errs = append(errs, fmt.Errorf("scheduler.auto_prune_action must be %q or %q, got %q", AutoPruneActionDead, AutoPruneActionDelete, c.Scheduler.AutoPruneAction))
+	}
+	seenUsers := make(map[string]bool, len(c.Admin.Users))
+	for i, u := range c.Admin.Users {
+		if u.Username == "" || u.Password == "" {
+			errs = append(errs, fmt.Errorf("admin.users[%d] must have both a username and a password", i))
+		}
+		if seenUsers[u.Username] {
+			errs = append(errs, fmt.Errorf("admin.users contains duplicate username %q", u.Username))
+		}
+		seenUsers[u.Username] = true
+	}
+	for _, spec := range []struct {
+		name  string
+		value string
+	}{
+		{"scheduler.key_revival_interval", c.Scheduler.KeyRevivalInterval},
+		{"scheduler.failure_decay_interval", c.Scheduler.FailureDecayInterval},
+		{"scheduler.client_key_expiry_interval", c.Scheduler.ClientKeyExpiryInterval},
+		{"scheduler.usage_reset_interval", c.Scheduler.UsageResetInterval},
+		{"scheduler.auto_prune_interval", c.Scheduler.AutoPruneInterval},
+	} {
+		if spec.value == "" {
+			continue
+		}
+		if _, err := cron.ParseStandard(spec.value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", spec.name, spec.value, err))
+		}
+	}
+	if c.Proxy.DisableKeyThreshold < 1 {
+		errs = append(errs, fmt.Errorf("proxy.disable_key_threshold must be at least 1, got %d", c.Proxy.DisableKeyThreshold))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 0 and 65535, got %d", c.Port))
+	}
+	if c.Proxy.MaxRetryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("proxy.max_retry_attempts must be at least 1, got %d", c.Proxy.MaxRetryAttempts))
+	}
+	if c.Proxy.TemporaryDisableDuration < 0 {
+		errs = append(errs, fmt.Errorf("proxy.temporary_disable_duration must not be negative, got %s", c.Proxy.TemporaryDisableDuration))
+	}
+	if c.Proxy.RetryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("proxy.retry_backoff must not be negative, got %s", c.Proxy.RetryBackoff))
+	}
+	if c.Proxy.RetryBackoffMultiplier != 0 && c.Proxy.RetryBackoffMultiplier < 1 {
+		errs = append(errs, fmt.Errorf("proxy.retry_backoff_multiplier must be at least 1, got %v", c.Proxy.RetryBackoffMultiplier))
+	}
+	if c.Proxy.RetryBackoffMax < 0 {
+		errs = append(errs, fmt.Errorf("proxy.retry_backoff_max must not be negative, got %s", c.Proxy.RetryBackoffMax))
+	}
+	if c.Proxy.RateLimitCooldown < 0 {
+		errs = append(errs, fmt.Errorf("proxy.rate_limit_cooldown must not be negative, got %s", c.Proxy.RateLimitCooldown))
+	}
+	switch c.Proxy.SelectionStrategy {
+	case SelectionLowestUsage, SelectionWeightedQuota, SelectionTokenWeighted:
+	default:
+		errs = append(errs, fmt.Errorf("proxy.selection_strategy must be %q, %q or %q, got %q", SelectionLowestUsage, SelectionWeightedQuota, SelectionTokenWeighted, c.Proxy.SelectionStrategy))
+	}
+	switch c.Proxy.NewKeyDefaultStatus {
+	case KeyStatusActive, KeyStatusPending:
+	default:
+		errs = append(errs, fmt.Errorf("proxy.new_key_default_status must be %q or %q, got %q", KeyStatusActive, KeyStatusPending, c.Proxy.NewKeyDefaultStatus))
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -44,6 +48,250 @@ func TestLoadConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("upstream connection pool settings", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"upstream:\n" +
+				"  max_idle_conns_per_host: 20\n" +
+				"  idle_conn_timeout: 30s\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Upstream.MaxIdleConns != 100 {
+			t.Errorf("Expected max_idle_conns to default to 100, got %d", config.Upstream.MaxIdleConns)
+		}
+		if config.Upstream.MaxIdleConnsPerHost != 20 {
+			t.Errorf("Expected max_idle_conns_per_host to be 20, got %d", config.Upstream.MaxIdleConnsPerHost)
+		}
+		if config.Upstream.IdleConnTimeout != 30*time.Second {
+			t.Errorf("Expected idle_conn_timeout to be 30s, got %s", config.Upstream.IdleConnTimeout)
+		}
+	})
+
+	t.Run("multiple admin users", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"admin:\n" +
+				"  password: \"legacy-password\"\n" +
+				"  users:\n" +
+				"    - username: \"alice\"\n" +
+				"      password: \"alice-password\"\n" +
+				"    - username: \"bob\"\n" +
+				"      password: \"bob-password\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		creds := config.Admin.Credentials()
+		if len(creds) != 3 {
+			t.Fatalf("Expected 3 admin credentials, got %d", len(creds))
+		}
+		if creds["admin"] != "legacy-password" {
+			t.Errorf("Expected legacy password for admin, got %s", creds["admin"])
+		}
+		if creds["alice"] != "alice-password" || creds["bob"] != "bob-password" {
+			t.Errorf("Unexpected credentials for configured users: %v", creds)
+		}
+	})
+
+	t.Run("invalid admin users", func(t *testing.T) {
+		cases := map[string]string{
+			"missing password": "  users:\n    - username: \"alice\"\n",
+			"duplicate user": "  users:\n    - username: \"alice\"\n      password: \"a\"\n" +
+				"    - username: \"alice\"\n      password: \"b\"\n",
+		}
+		for name, users := range cases {
+			content := []byte(
+				"database:\n" +
+					"  type: \"sqlite\"\n" +
+					"  dsn: \"gogemini.db\"\n" +
+					"admin:\n" + users)
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write(content)
+			tmpfile.Close()
+
+			if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+				t.Errorf("%s: expected an error, but got nil", name)
+			}
+		}
+	})
+
+	t.Run("key reload interval", func(t *testing.T) {
+		cases := []struct {
+			interval string
+			want     time.Duration
+			wantErr  bool
+		}{
+			{"", time.Minute, false},
+			{"15s", 15 * time.Second, false},
+			{"soon", 0, true},
+			{"-1m", 0, true},
+		}
+		for _, tc := range cases {
+			content := []byte(
+				"database:\n" +
+					"  type: \"sqlite\"\n" +
+					"  dsn: \"gogemini.db\"\n" +
+					"scheduler:\n" +
+					"  key_reload_interval: \"" + tc.interval + "\"\n")
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write(content)
+			tmpfile.Close()
+
+			config, _, err := LoadConfig(tmpfile.Name())
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("interval %q: expected an error, but got nil", tc.interval)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("interval %q: expected no error, but got %v", tc.interval, err)
+			}
+			if got := config.Scheduler.KeyReloadDuration(); got != tc.want {
+				t.Errorf("interval %q: expected %s, got %s", tc.interval, tc.want, got)
+			}
+		}
+	})
+
+	t.Run("failure decay window", func(t *testing.T) {
+		cases := []struct {
+			window  string
+			want    time.Duration
+			wantErr bool
+		}{
+			{"", DefaultFailureDecayWindow, false},
+			{"6h", 6 * time.Hour, false},
+			{"a while", 0, true},
+			{"0s", 0, true},
+		}
+		for _, tc := range cases {
+			content := []byte(
+				"database:\n" +
+					"  type: \"sqlite\"\n" +
+					"  dsn: \"gogemini.db\"\n" +
+					"scheduler:\n" +
+					"  failure_decay_window: \"" + tc.window + "\"\n")
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write(content)
+			tmpfile.Close()
+
+			config, _, err := LoadConfig(tmpfile.Name())
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("window %q: expected an error, but got nil", tc.window)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("window %q: expected no error, but got %v", tc.window, err)
+			}
+			if got := config.Scheduler.FailureDecayWindowDuration(); got != tc.want {
+				t.Errorf("window %q: expected %s, got %s", tc.window, tc.want, got)
+			}
+		}
+	})
+
+	t.Run("tls files", func(t *testing.T) {
+		certFile, _ := os.CreateTemp("", "server.crt")
+		defer os.Remove(certFile.Name())
+		certFile.Close()
+		keyFile, _ := os.CreateTemp("", "server.key")
+		defer os.Remove(keyFile.Name())
+		keyFile.Close()
+
+		cases := []struct {
+			name    string
+			tls     string
+			wantErr bool
+		}{
+			{"both files exist", "  cert_file: \"" + certFile.Name() + "\"\n  key_file: \"" + keyFile.Name() + "\"\n", false},
+			{"only cert file", "  cert_file: \"" + certFile.Name() + "\"\n", true},
+			{"missing key file", "  cert_file: \"" + certFile.Name() + "\"\n  key_file: \"/does/not/exist.key\"\n", true},
+		}
+		for _, tc := range cases {
+			content := []byte(
+				"database:\n" +
+					"  type: \"sqlite\"\n" +
+					"  dsn: \"gogemini.db\"\n" +
+					"tls:\n" + tc.tls)
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write(content)
+			tmpfile.Close()
+
+			config, _, err := LoadConfig(tmpfile.Name())
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("%s: expected an error, but got nil", tc.name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: expected no error, but got %v", tc.name, err)
+			}
+			if !config.TLS.Enabled() {
+				t.Errorf("%s: expected TLS to be enabled", tc.name)
+			}
+		}
+	})
+
+	t.Run("cors", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"cors:\n" +
+				"  enabled: true\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+			t.Error("Expected an error for cors enabled without allowed_origins, but got nil")
+		}
+
+		content = append(content, []byte("  allowed_origins: [\"https://dashboard.example.com\"]\n")...)
+		os.WriteFile(tmpfile.Name(), content, 0o644)
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(config.CORS.AllowedMethods) == 0 || len(config.CORS.AllowedHeaders) == 0 {
+			t.Error("Expected default CORS methods and headers to be set")
+		}
+
+		config.CORS.AllowedOrigins = []string{"*"}
+		config.CORS.AllowCredentials = true
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "cors.allow_credentials") {
+			t.Errorf("Expected an error for credentials with the wildcard origin, got %v", err)
+		}
+		config.CORS.AllowCredentials = false
+		if err := config.Validate(); err != nil {
+			t.Errorf("Expected the wildcard origin without credentials to be valid, got %v", err)
+		}
+	})
+
 	t.Run("non-existent file without env vars", func(t *testing.T) {
 		_, _, err := LoadConfig("non-existent-file.yaml")
 		if err == nil {
@@ -69,6 +317,23 @@ func TestLoadConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("gemini keys from env", func(t *testing.T) {
+		os.Setenv("GOGEMINI_DATABASE_TYPE", "sqlite")
+		os.Setenv("GOGEMINI_DATABASE_DSN", "some-dsn")
+		os.Setenv("GOGEMINI_GEMINI_KEYS", " key-1, key-2,,key-1 ")
+		defer os.Unsetenv("GOGEMINI_DATABASE_TYPE")
+		defer os.Unsetenv("GOGEMINI_DATABASE_DSN")
+		defer os.Unsetenv("GOGEMINI_GEMINI_KEYS")
+
+		config, _, err := LoadConfig("non-existent-file.yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if strings.Join(config.GeminiKeys, ",") != "key-1,key-2" {
+			t.Errorf("Expected gemini keys [key-1 key-2], got %v", config.GeminiKeys)
+		}
+	})
+
 	t.Run("invalid yaml", func(t *testing.T) {
 		tmpfile, _ := os.CreateTemp("", "config.yaml")
 		defer os.Remove(tmpfile.Name())
@@ -80,6 +345,1008 @@ func TestLoadConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("max retry attempts defaults and validation", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.MaxRetryAttempts != DefaultMaxRetryAttempts {
+			t.Errorf("Expected max_retry_attempts to default to %d, got %d", DefaultMaxRetryAttempts, config.Proxy.MaxRetryAttempts)
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write(append(content, []byte("proxy:\n  max_retry_attempts: -1\n")...))
+		invalid.Close()
+
+		_, _, err = LoadConfig(invalid.Name())
+		if err == nil {
+			t.Error("Expected an error for negative max_retry_attempts, but got nil")
+		}
+	})
+
+	t.Run("logging defaults and validation", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(append(content, []byte("logging:\n  level: warn\n")...))
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Logging.Format != "json" || config.Logging.Level != "warn" {
+			t.Errorf("Expected json format and warn level, got %+v", config.Logging)
+		}
+
+		for _, logging := range []string{"format: xml", "level: verbose"} {
+			invalid, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(invalid.Name())
+			invalid.Write(append(content, []byte("logging:\n  "+logging+"\n")...))
+			invalid.Close()
+
+			if _, _, err := LoadConfig(invalid.Name()); err == nil {
+				t.Errorf("Expected an error for logging %s, but got nil", logging)
+			}
+		}
+	})
+
+	t.Run("max request body bytes defaults", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.RequestBodyLimit() != DefaultMaxRequestBodyBytes {
+			t.Errorf("Expected request body limit %d, got %d", DefaultMaxRequestBodyBytes, config.Proxy.RequestBodyLimit())
+		}
+
+		disabled := ProxyConfig{MaxRequestBodyBytes: -1}
+		if disabled.RequestBodyLimit() != 0 {
+			t.Errorf("Expected a negative max_request_body_bytes to disable the limit, got %d", disabled.RequestBodyLimit())
+		}
+		if config.Proxy.VerbatimBodyThreshold() != DefaultVerbatimBodyBytes {
+			t.Errorf("Expected verbatim body threshold %d, got %d", DefaultVerbatimBodyBytes, config.Proxy.VerbatimBodyThreshold())
+		}
+		if threshold := (ProxyConfig{VerbatimBodyBytes: -1}).VerbatimBodyThreshold(); threshold != 0 {
+			t.Errorf("Expected a negative verbatim_body_bytes to disable the size check, got %d", threshold)
+		}
+		if config.Proxy.RetryBodyLimit() != DefaultMaxRequestBodyBytes {
+			t.Errorf("Expected the retry body limit to follow the request body limit, got %d", config.Proxy.RetryBodyLimit())
+		}
+		if limit := (ProxyConfig{MaxRetryBodyBytes: -1}).RetryBodyLimit(); limit != 0 {
+			t.Errorf("Expected a negative max_retry_body_bytes to disable the limit, got %d", limit)
+		}
+	})
+
+	t.Run("success ratio defaults and validation", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.MinSuccessRatio != 0 || config.Proxy.MinSamples != DefaultMinSamples {
+			t.Errorf("Expected min_success_ratio 0 and min_samples %d, got %v and %d", DefaultMinSamples, config.Proxy.MinSuccessRatio, config.Proxy.MinSamples)
+		}
+
+		for _, proxy := range []string{"min_success_ratio: 1.5", "min_success_ratio: -0.1", "min_samples: -1"} {
+			invalid, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(invalid.Name())
+			invalid.Write(append(content, []byte("proxy:\n  "+proxy+"\n")...))
+			invalid.Close()
+
+			if _, _, err := LoadConfig(invalid.Name()); err == nil {
+				t.Errorf("Expected an error for %s, but got nil", proxy)
+			}
+		}
+	})
+
+	t.Run("retryable status codes defaults and validation", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if fmt.Sprint(config.Proxy.RetryableStatusCodes) != fmt.Sprint(DefaultRetryableStatusCodes) {
+			t.Errorf("Expected default retryable status codes, got %v", config.Proxy.RetryableStatusCodes)
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte("proxy:\n  retryable_status_codes: [409, 429]\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if fmt.Sprint(config.Proxy.RetryableStatuses()) != "[409 429]" {
+			t.Errorf("Expected retryable status codes [409 429], got %v", config.Proxy.RetryableStatuses())
+		}
+
+		for _, codes := range []string{"[200]", "[600]", "[0]"} {
+			invalid, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(invalid.Name())
+			invalid.Write(append(content, []byte("proxy:\n  retryable_status_codes: "+codes+"\n")...))
+			invalid.Close()
+
+			if _, _, err := LoadConfig(invalid.Name()); err == nil {
+				t.Errorf("Expected an error for retryable_status_codes %s, but got nil", codes)
+			}
+		}
+	})
+
+	t.Run("models cache ttl defaults and parsing", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.ModelsCacheTTL != DefaultModelsCacheTTL {
+			t.Errorf("Expected models_cache_ttl to default to %v, got %v", DefaultModelsCacheTTL, config.Proxy.ModelsCacheTTL)
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte("proxy:\n  models_cache_ttl: 30s\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.ModelsCacheTTL != 30*time.Second {
+			t.Errorf("Expected models_cache_ttl to be 30s, got %v", config.Proxy.ModelsCacheTTL)
+		}
+	})
+
+	t.Run("proxy selection strategy", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.SelectionStrategy != SelectionLowestUsage {
+			t.Errorf("Expected selection_strategy to default to %q, got %q", SelectionLowestUsage, config.Proxy.SelectionStrategy)
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write(append(content, []byte("proxy:\n  selection_strategy: round_robin\n")...))
+		invalid.Close()
+
+		if _, _, err := LoadConfig(invalid.Name()); err == nil {
+			t.Error("Expected an error for an unknown selection_strategy, but got nil")
+		}
+
+		tokenWeighted, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tokenWeighted.Name())
+		tokenWeighted.Write(append(content, []byte("proxy:\n  selection_strategy: token_weighted\n")...))
+		tokenWeighted.Close()
+
+		config, _, err = LoadConfig(tokenWeighted.Name())
+		if err != nil {
+			t.Fatalf("Expected no error for token_weighted, but got %v", err)
+		}
+		if config.Proxy.SelectionStrategy != SelectionTokenWeighted {
+			t.Errorf("Expected selection_strategy %q, got %q", SelectionTokenWeighted, config.Proxy.SelectionStrategy)
+		}
+	})
+
+	t.Run("proxy new key default status", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.NewKeyDefaultStatus != KeyStatusActive {
+			t.Errorf("Expected new_key_default_status to default to %q, got %q", KeyStatusActive, config.Proxy.NewKeyDefaultStatus)
+		}
+
+		pending, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(pending.Name())
+		pending.Write(append(content, []byte("proxy:\n  new_key_default_status: pending\n")...))
+		pending.Close()
+
+		config, _, err = LoadConfig(pending.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.NewKeyDefaultStatus != KeyStatusPending {
+			t.Errorf("Expected new_key_default_status to be %q, got %q", KeyStatusPending, config.Proxy.NewKeyDefaultStatus)
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write(append(content, []byte("proxy:\n  new_key_default_status: disabled\n")...))
+		invalid.Close()
+
+		if _, _, err := LoadConfig(invalid.Name()); err == nil {
+			t.Error("Expected an error for an unknown new_key_default_status, but got nil")
+		}
+	})
+
+	t.Run("negative temporary disable duration", func(t *testing.T) {
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"proxy:\n" +
+				"  temporary_disable_duration: -1m\n"))
+		tmpfile.Close()
+
+		if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+			t.Error("Expected an error for a negative temporary_disable_duration, but got nil")
+		}
+	})
+
+	t.Run("proxy request timeout", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.RequestTimeout != 0 {
+			t.Errorf("Expected request_timeout to be disabled by default, got %v", config.Proxy.RequestTimeout)
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte("proxy:\n  request_timeout: 45s\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.RequestTimeout != 45*time.Second {
+			t.Errorf("Expected request_timeout to be 45s, got %v", config.Proxy.RequestTimeout)
+		}
+	})
+
+	t.Run("upstream base url and health check path", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if got := config.Upstream.HealthCheckURL(); got != "https://generativelanguage.googleapis.com/v1beta/openai/models" {
+			t.Errorf("Expected default health check URL, got %s", got)
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte("upstream:\n  base_url: \"http://localhost:9000/\"\n  health_check_path: \"v1/models\"\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if got := config.Upstream.HealthCheckURL(); got != "http://localhost:9000/v1/models" {
+			t.Errorf("Expected health check URL on the configured host, got %s", got)
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write(append(content, []byte("upstream:\n  base_url: \"localhost:9000\"\n")...))
+		invalid.Close()
+
+		_, _, err = LoadConfig(invalid.Name())
+		if err == nil {
+			t.Error("Expected an error for a base_url without a scheme, but got nil")
+		}
+	})
+
+	t.Run("usage tracking defaults to enabled", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !config.Proxy.UsageTrackingEnabled() {
+			t.Error("Expected usage tracking to be enabled by default")
+		}
+
+		disabled, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(disabled.Name())
+		disabled.Write(append(content, []byte("proxy:\n  track_usage: false\n")...))
+		disabled.Close()
+
+		config, _, err = LoadConfig(disabled.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Proxy.UsageTrackingEnabled() {
+			t.Error("Expected usage tracking to be disabled")
+		}
+	})
+
+	t.Run("access log defaults to enabled", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !config.Logging.AccessLogEnabled() {
+			t.Error("Expected the access log to be enabled by default")
+		}
+
+		disabled, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(disabled.Name())
+		disabled.Write(append(content, []byte("logging:\n  access_log: false\n")...))
+		disabled.Close()
+
+		config, _, err = LoadConfig(disabled.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if config.Logging.AccessLogEnabled() {
+			t.Error("Expected the access log to be disabled")
+		}
+	})
+
+	t.Run("strip fields", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(config.Proxy.StripFields) != len(DefaultStripFields) {
+			t.Errorf("Expected default strip fields %v, got %v", DefaultStripFields, config.Proxy.StripFields)
+		}
+		if !config.Proxy.TopKStrippingEnabled() || !config.Proxy.NullStrippingEnabled() {
+			t.Error("Expected top_k and null stripping to be enabled by default")
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte("proxy:\n  strip_fields: [\"seed\"]\n  strip_top_k: false\n  strip_null_fields: false\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(config.Proxy.StripFields) != 1 || config.Proxy.StripFields[0] != "seed" {
+			t.Errorf("Expected strip fields [seed], got %v", config.Proxy.StripFields)
+		}
+		if config.Proxy.TopKStrippingEnabled() || config.Proxy.NullStrippingEnabled() {
+			t.Error("Expected top_k and null stripping to be disabled")
+		}
+	})
+
+	t.Run("proxy tool models", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(config.Proxy.ToolCallingModels()) != len(DefaultToolModels) {
+			t.Errorf("Expected default tool models %v, got %v", DefaultToolModels, config.Proxy.ToolCallingModels())
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write(append(content, []byte("proxy:\n  tool_models: [\"gemini-[\"]\n")...))
+		invalid.Close()
+
+		if _, _, err := LoadConfig(invalid.Name()); err == nil {
+			t.Error("Expected an error for an invalid tool model pattern, but got nil")
+		}
+	})
+
+	t.Run("proxy response headers", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"proxy:\n" +
+				"  strip_response_headers: [\"Server\", \"X-Goog-*\"]\n" +
+				"  allow_response_headers: [\"Retry-After\"]\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(config.Proxy.StripResponseHeaders) != 2 || config.Proxy.StripResponseHeaders[1] != "X-Goog-*" {
+			t.Errorf("Expected strip_response_headers [Server X-Goog-*], got %v", config.Proxy.StripResponseHeaders)
+		}
+		if len(config.Proxy.AllowResponseHeaders) != 1 || config.Proxy.AllowResponseHeaders[0] != "Retry-After" {
+			t.Errorf("Expected allow_response_headers [Retry-After], got %v", config.Proxy.AllowResponseHeaders)
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write([]byte("database:\n  type: \"sqlite\"\n  dsn: \"gogemini.db\"\nproxy:\n  strip_response_headers: [\"X-[\"]\n"))
+		invalid.Close()
+
+		if _, _, err := LoadConfig(invalid.Name()); err == nil {
+			t.Error("Expected an error for an invalid response header pattern, but got nil")
+		}
+	})
+
+	t.Run("server request timeout", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if got := config.Server.RequestTimeoutFor("/admin/gemini-keys"); got != DefaultServerRequestTimeout {
+			t.Errorf("Expected admin requests to use the default timeout %v, got %v", DefaultServerRequestTimeout, got)
+		}
+		for _, path := range []string{"/gemini/v1beta/models", "/openai/v1/chat/completions", "/anthropic/v1/messages", "/v1/embeddings", "/admin/gemini-keys/export"} {
+			if got := config.Server.RequestTimeoutFor(path); got > 0 {
+				t.Errorf("Expected %s to be exempt from the request timeout, got %v", path, got)
+			}
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte(
+			"server:\n"+
+				"  request_timeout: 10s\n"+
+				"  request_timeout_overrides:\n"+
+				"    /admin: 5m\n"+
+				"    /admin/gemini-keys/export: -1s\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		cases := map[string]time.Duration{
+			"/version":                  10 * time.Second,
+			"/openai/v1/models":         10 * time.Second,
+			"/admin":                    5 * time.Minute,
+			"/admin/gemini-keys":        5 * time.Minute,
+			"/administrator":            10 * time.Second,
+			"/admin/gemini-keys/export": -time.Second,
+		}
+		for path, want := range cases {
+			if got := config.Server.RequestTimeoutFor(path); got != want {
+				t.Errorf("Expected request timeout %v for %s, got %v", want, path, got)
+			}
+		}
+	})
+
+	t.Run("client key sources", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !reflect.DeepEqual(config.Access.KeySources(), DefaultClientKeySources) {
+			t.Errorf("Expected the default client key sources, got %v", config.Access.KeySources())
+		}
+
+		custom, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(custom.Name())
+		custom.Write(append(content, []byte("access:\n  client_key_sources: [\"query:key\", \"header:api-key\", \"bearer\"]\n")...))
+		custom.Close()
+
+		config, _, err = LoadConfig(custom.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if want := []string{"query:key", "header:api-key", "bearer"}; !reflect.DeepEqual(config.Access.KeySources(), want) {
+			t.Errorf("Expected client key sources %v, got %v", want, config.Access.KeySources())
+		}
+
+		for _, sources := range []string{"[]", "[\"cookie:key\"]", "[\"header:\"]"} {
+			invalid, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(invalid.Name())
+			invalid.Write(append(content, []byte("access:\n  client_key_sources: "+sources+"\n")...))
+			invalid.Close()
+
+			_, _, err = LoadConfig(invalid.Name())
+			if err == nil || !strings.Contains(err.Error(), "access.client_key_sources") {
+				t.Errorf("Expected an access.client_key_sources error for %s, got %v", sources, err)
+			}
+		}
+	})
+
+	t.Run("access lists", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"access:\n" +
+				"  admin_allow_cidrs: [\"10.0.0.0/8\", \"192.168.1.10\"]\n" +
+				"  trusted_proxies: [\"127.0.0.1\"]\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(config.Access.AdminAllowCIDRs) != 2 || len(config.Access.ProxyAllowCIDRs) != 0 {
+			t.Errorf("Unexpected access config %+v", config.Access)
+		}
+
+		invalid, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(invalid.Name())
+		invalid.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"access:\n" +
+				"  proxy_allow_cidrs: [\"10.0.0.0/33\"]\n"))
+		invalid.Close()
+
+		_, _, err = LoadConfig(invalid.Name())
+		if err == nil || !strings.Contains(err.Error(), "access.proxy_allow_cidrs") {
+			t.Errorf("Expected an access.proxy_allow_cidrs error, got %v", err)
+		}
+
+		config.Server.UnixSocket = "/run/gogemini.sock"
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "server.unix_socket") {
+			t.Errorf("Expected an error for admin_allow_cidrs with a Unix socket, got %v", err)
+		}
+		config.Access.AdminAllowCIDRs = nil
+		config.Access.ProxyAllowCIDRs = []string{"127.0.0.1"}
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "server.unix_socket") {
+			t.Errorf("Expected an error for proxy_allow_cidrs with a Unix socket, got %v", err)
+		}
+		config.Access.ProxyAllowCIDRs = nil
+		if err := config.Validate(); err != nil {
+			t.Errorf("Expected a Unix socket without allowlists to be valid, got %v", err)
+		}
+	})
+
+	t.Run("fatal error patterns", func(t *testing.T) {
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"proxy:\n" +
+				"  fatal_error_patterns: [\"API key not valid\", \"\"]\n"))
+		tmpfile.Close()
+
+		if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+			t.Error("Expected an error for an empty fatal_error_patterns entry, but got nil")
+		}
+	})
+
+	t.Run("balancer usage weights", func(t *testing.T) {
+		var defaults BalancerConfig
+		if w := defaults.UsageWeightFor("/v1beta/models/gemini-pro:countTokens"); w != 0.1 {
+			t.Errorf("Expected the default countTokens weight 0.1, got %v", w)
+		}
+		if w := defaults.UsageWeightFor("/v1beta/models/gemini-pro:generateContent"); w != 1 {
+			t.Errorf("Expected generateContent to weigh 1, got %v", w)
+		}
+		if w := (BalancerConfig{UsageWeights: map[string]float64{}}).UsageWeightFor("/v1beta/models/gemini-pro:countTokens"); w != 1 {
+			t.Errorf("Expected an empty usage_weights map to weigh every request 1, got %v", w)
+		}
+
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"balancer:\n" +
+				"  usage_weights:\n" +
+				"    \":countTokens\": -1\n"))
+		tmpfile.Close()
+
+		if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+			t.Error("Expected an error for a negative usage weight, but got nil")
+		}
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"redis:\n" +
+				"  addr: \"localhost:6379\"\n" +
+				"  db: 2\n"))
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if !config.Redis.Enabled() || config.Redis.DB != 2 {
+			t.Errorf("Expected redis at localhost:6379 db 2, got %+v", config.Redis)
+		}
+		if (RedisConfig{}).Enabled() {
+			t.Error("Expected redis to be disabled without an address")
+		}
+	})
+
+	t.Run("proxy key test timeout", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if config.Proxy.KeyTestTimeout != DefaultKeyTestTimeout {
+			t.Errorf("Expected default key test timeout %s, got %s", DefaultKeyTestTimeout, config.Proxy.KeyTestTimeout)
+		}
+
+		negative, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(negative.Name())
+		negative.Write(append(content, []byte("proxy:\n  key_test_timeout: -1s\n")...))
+		negative.Close()
+
+		if _, _, err := LoadConfig(negative.Name()); err == nil {
+			t.Error("Expected an error for a negative key test timeout, but got nil")
+		}
+	})
+
+	t.Run("balancer api version", func(t *testing.T) {
+		for version, wantErr := range map[string]bool{"": false, "v1": false, "v1beta": false, "v2alpha1": false, "beta": true, "v1/../x": true} {
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write([]byte(
+				"database:\n" +
+					"  type: \"sqlite\"\n" +
+					"  dsn: \"gogemini.db\"\n" +
+					"balancer:\n" +
+					"  api_version: \"" + version + "\"\n"))
+			tmpfile.Close()
+
+			config, _, err := LoadConfig(tmpfile.Name())
+			if wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for api version %q, but got nil", version)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() failed for api version %q: %v", version, err)
+			}
+			if config.Balancer.APIVersion != version {
+				t.Errorf("Expected api version %q, got %q", version, config.Balancer.APIVersion)
+			}
+		}
+	})
+
+	t.Run("proxy concurrency limit", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if config.Proxy.MaxConcurrentRequests != 0 || config.Proxy.QueueTimeout != DefaultQueueTimeout {
+			t.Errorf("Expected no concurrency limit and the default queue timeout, got %d and %s", config.Proxy.MaxConcurrentRequests, config.Proxy.QueueTimeout)
+		}
+
+		negative, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(negative.Name())
+		negative.Write(append(content, []byte("proxy:\n  max_concurrent_requests: -1\n")...))
+		negative.Close()
+
+		if _, _, err := LoadConfig(negative.Name()); err == nil {
+			t.Error("Expected an error for a negative max concurrent requests, but got nil")
+		}
+	})
+
+	t.Run("reports every invalid field at once", func(t *testing.T) {
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"proxy:\n" +
+				"  disable_key_threshold: -2\n" +
+				"  min_success_ratio: 1.5\n" +
+				"upstream:\n" +
+				"  base_url: \"not a url\"\n" +
+				"scheduler:\n" +
+				"  key_revival_interval: \"every now and then\"\n" +
+				"  usage_reset_interval: \"61 * * * *\"\n" +
+				"  key_reload_interval: \"soon\"\n"))
+		tmpfile.Close()
+
+		_, _, err := LoadConfig(tmpfile.Name())
+		if err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+		for _, want := range []string{
+			"proxy.disable_key_threshold",
+			"proxy.min_success_ratio",
+			"upstream.base_url",
+			"scheduler.key_revival_interval",
+			"scheduler.usage_reset_interval",
+			"scheduler.key_reload_interval",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected the error to mention %s, got:\n%v", want, err)
+			}
+		}
+		if got := strings.Count(err.Error(), "\n") + 1; got != 6 {
+			t.Errorf("Expected 6 problems, one per line, got %d:\n%v", got, err)
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n"))
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if err := config.Validate(); err != nil {
+			t.Errorf("Expected a loaded config to be valid, got %v", err)
+		}
+
+		config.Scheduler.FailureDecayInterval = "@every"
+		config.Port = 70000
+		err = config.Validate()
+		if err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+		if !strings.Contains(err.Error(), "scheduler.failure_decay_interval") || !strings.Contains(err.Error(), "port") {
+			t.Errorf("Expected both problems to be reported, got:\n%v", err)
+		}
+	})
+
+	t.Run("scheduler auto prune", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		config, _, err := LoadConfig(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		s := config.Scheduler
+		if s.AutoPruneFailures != 0 || s.AutoPruneDays != DefaultAutoPruneDays || s.AutoPruneAction != AutoPruneActionDead || s.AutoPruneInterval != DefaultAutoPruneInterval {
+			t.Errorf("Expected auto-prune to be off with default settings, got %+v", s)
+		}
+
+		for _, invalid := range []string{
+			"scheduler:\n  auto_prune_failures: -1\n",
+			"scheduler:\n  auto_prune_days: -1\n",
+			"scheduler:\n  auto_prune_action: \"archive\"\n",
+		} {
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write(append(content, []byte(invalid)...))
+			tmpfile.Close()
+			if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+				t.Errorf("Expected an error for %q, but got nil", invalid)
+			}
+		}
+	})
+
+	t.Run("notifications webhook url", func(t *testing.T) {
+		for webhookURL, wantErr := range map[string]bool{
+			"https://hooks.slack.com/services/T0/B0/x": false,
+			"ftp://example.com/hook":                   true,
+			"not a url":                                true,
+		} {
+			tmpfile, _ := os.CreateTemp("", "config.yaml")
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write([]byte(
+				"database:\n" +
+					"  type: \"sqlite\"\n" +
+					"  dsn: \"gogemini.db\"\n" +
+					"notifications:\n" +
+					"  webhook_url: \"" + webhookURL + "\"\n"))
+			tmpfile.Close()
+
+			config, _, err := LoadConfig(tmpfile.Name())
+			if wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for webhook url %q, but got nil", webhookURL)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() failed: %v", err)
+			}
+			if config.Notifications.WebhookURL != webhookURL {
+				t.Errorf("Expected webhook url %q, got %q", webhookURL, config.Notifications.WebhookURL)
+			}
+		}
+	})
+
+	t.Run("negative min available keys warn", func(t *testing.T) {
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write([]byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"proxy:\n" +
+				"  min_available_keys_warn: -1\n"))
+		tmpfile.Close()
+
+		if _, _, err := LoadConfig(tmpfile.Name()); err == nil {
+			t.Error("Expected an error for a negative min_available_keys_warn, but got nil")
+		}
+	})
+
+	t.Run("negative rate limit cooldown", func(t *testing.T) {
+		content := []byte(
+			"database:\n" +
+				"  type: \"sqlite\"\n" +
+				"  dsn: \"gogemini.db\"\n" +
+				"proxy:\n" +
+				"  rate_limit_cooldown: -1s\n")
+		tmpfile, _ := os.CreateTemp("", "config.yaml")
+		defer os.Remove(tmpfile.Name())
+		tmpfile.Write(content)
+		tmpfile.Close()
+
+		_, _, err := LoadConfig(tmpfile.Name())
+		if err == nil {
+			t.Error("Expected an error for a negative proxy.rate_limit_cooldown, but got nil")
+		}
+	})
+
 	t.Run("missing database config", func(t *testing.T) {
 		content := []byte(
 			"port: 8080\n" +
@@ -0,0 +1,107 @@
+// Package contentencoding decompresses and recompresses HTTP response bodies according to
+// their Content-Encoding, for the proxies that inspect or rewrite upstream responses.
+package contentencoding
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrUndecodable is returned by DecodeBody for bodies with an unsupported or corrupt
+// Content-Encoding; such responses should be passed on untouched.
+var ErrUndecodable = errors.New("response body cannot be decompressed")
+
+// Of returns resp's Content-Encoding in lower case; "identity" counts as none.
+func Of(resp *http.Response) string {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
+
+// DecodeBody reads resp's body and returns it decompressed according to its Content-Encoding.
+// resp.Body is replaced with the bytes as received, so the response is unchanged until
+// ReplaceBody is called. Bodies that cannot be decompressed are reported as errors after the
+// body has been put back, wrapping ErrUndecodable; only a failed read leaves resp.Body
+// unusable.
+func DecodeBody(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+
+	encoding := Of(resp)
+	if encoding == "" {
+		return raw, nil
+	}
+	reader, err := NewDecompressor(encoding, raw)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrUndecodable, encoding, err)
+	}
+	return decoded, nil
+}
+
+// ReplaceBody sets resp's body to body, compressed again with resp's Content-Encoding so the
+// client receives what it negotiated, and updates the content length to match.
+func ReplaceBody(resp *http.Response, body []byte) error {
+	switch encoding := Of(resp); encoding {
+	case "":
+	case "gzip", "x-gzip", "deflate":
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		if encoding == "deflate" {
+			w = zlib.NewWriter(&buf)
+		} else {
+			w = gzip.NewWriter(&buf)
+		}
+		if _, err := w.Write(body); err != nil {
+			return fmt.Errorf("failed to compress %s body: %w", encoding, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to compress %s body: %w", encoding, err)
+		}
+		body = buf.Bytes()
+	default:
+		return fmt.Errorf("cannot compress body with unsupported content encoding %s", encoding)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// NewDecompressor returns a reader of raw decompressed with encoding. "deflate" is the zlib
+// format per RFC 9110, but raw DEFLATE streams sent by some servers are accepted as well.
+func NewDecompressor(encoding string, raw []byte) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUndecodable, encoding, err)
+		}
+		return r, nil
+	case "deflate":
+		if r, err := zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			return r, nil
+		}
+		return flate.NewReader(bytes.NewReader(raw)), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported content encoding %s", ErrUndecodable, encoding)
+	}
+}
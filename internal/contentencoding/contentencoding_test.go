@@ -0,0 +1,86 @@
+package contentencoding
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeAndReplaceBody(t *testing.T) {
+	compress := func(newWriter func(io.Writer) io.WriteCloser, data string) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(data))
+		w.Close()
+		return buf.Bytes()
+	}
+	newResponse := func(encoding string, body []byte) *http.Response {
+		resp := &http.Response{Header: make(http.Header), Body: io.NopCloser(bytes.NewReader(body))}
+		if encoding != "" {
+			resp.Header.Set("Content-Encoding", encoding)
+		}
+		return resp
+	}
+	gzipWriter := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zlibWriter := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	flateWriter := func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		raw      []byte
+		decode   func(io.Reader) (io.Reader, error)
+	}{
+		{"identity", "", []byte("plain"), func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{"gzip", "gzip", compress(gzipWriter, "plain"), func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate", "deflate", compress(zlibWriter, "plain"), func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{"raw deflate", "deflate", compress(flateWriter, "plain"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := newResponse(tt.encoding, tt.raw)
+			decoded, err := DecodeBody(resp)
+			require.NoError(t, err)
+			assert.Equal(t, "plain", string(decoded))
+
+			untouched, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.raw, untouched, "the body is put back as received")
+
+			if tt.decode == nil {
+				return
+			}
+			require.NoError(t, ReplaceBody(resp, []byte("changed")))
+			encoded, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(encoded)), resp.ContentLength)
+			r, err := tt.decode(bytes.NewReader(encoded))
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "changed", string(got))
+		})
+	}
+
+	t.Run("unsupported or corrupt encodings", func(t *testing.T) {
+		for encoding, raw := range map[string]string{"br": "brotli", "gzip": "not gzip"} {
+			resp := newResponse(encoding, []byte(raw))
+			_, err := DecodeBody(resp)
+			assert.ErrorIs(t, err, ErrUndecodable, encoding)
+
+			untouched, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, raw, string(untouched))
+		}
+	})
+}
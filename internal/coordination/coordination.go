@@ -0,0 +1,95 @@
+// Package coordination shares Gemini key state changes between gogemini instances, so a key
+// disabled or cooling down on one instance is taken out of rotation on the others without
+// waiting for the next database reload.
+package coordination
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Kinds of key state changes carried by an Event.
+const (
+	// EventDisabled takes the key out of rotation.
+	EventDisabled = "disabled"
+	// EventCooldown moves the key behind the others until Event.Until.
+	EventCooldown = "cooldown"
+)
+
+// Event is a key state change published by one instance. Keys are identified by their
+// database ID so key values never leave the instance.
+type Event struct {
+	KeyID uint   `json:"key_id"`
+	Kind  string `json:"kind"`
+	// Status is the key's status after the change; "active" for temporary disables.
+	Status string    `json:"status,omitempty"`
+	At     time.Time `json:"at"`
+	Until  time.Time `json:"until,omitempty"`
+	// Origin identifies the publishing instance, which ignores its own events.
+	Origin string `json:"origin"`
+}
+
+// Backend delivers events between instances.
+type Backend interface {
+	// Publish sends e to every subscriber, including those of the publishing instance.
+	Publish(ctx context.Context, e Event) error
+	// Subscribe calls handle for every published event until ctx is done.
+	Subscribe(ctx context.Context, handle func(Event)) error
+	// Close releases the backend's resources.
+	Close() error
+}
+
+// Memory is a Backend that delivers events within the process. It is meant for tests and
+// single-instance deployments that want the same code path as Redis.
+type Memory struct {
+	mu       sync.Mutex
+	handlers map[int]func(Event)
+	nextID   int
+}
+
+// NewMemory creates an in-process Backend.
+func NewMemory() *Memory {
+	return &Memory{handlers: make(map[int]func(Event))}
+}
+
+// Publish calls every subscribed handler with e.
+func (m *Memory) Publish(_ context.Context, e Event) error {
+	m.mu.Lock()
+	handlers := make([]func(Event), 0, len(m.handlers))
+	for _, handle := range m.handlers {
+		handlers = append(handlers, handle)
+	}
+	m.mu.Unlock()
+
+	for _, handle := range handlers {
+		handle(e)
+	}
+	return nil
+}
+
+// Subscribe registers handle and blocks until ctx is done.
+func (m *Memory) Subscribe(ctx context.Context, handle func(Event)) error {
+	m.mu.Lock()
+	id := m.nextID
+	m.nextID++
+	m.handlers[id] = handle
+	m.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.mu.Lock()
+	delete(m.handlers, id)
+	m.mu.Unlock()
+	return nil
+}
+
+// Subscribers returns how many subscriptions are active.
+func (m *Memory) Subscribers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.handlers)
+}
+
+// Close is a no-op.
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,63 @@
+package coordination
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	t.Run("delivers events to every subscriber", func(t *testing.T) {
+		backend := NewMemory()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var mu sync.Mutex
+		received := make(map[string][]Event)
+		var wg sync.WaitGroup
+		for _, name := range []string{"a", "b"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				backend.Subscribe(ctx, func(e Event) {
+					mu.Lock()
+					defer mu.Unlock()
+					received[name] = append(received[name], e)
+				})
+			}()
+		}
+		assert.Eventually(t, func() bool { return backend.Subscribers() == 2 }, time.Second, time.Millisecond)
+
+		event := Event{KeyID: 7, Kind: EventDisabled, Status: "disabled", Origin: "a"}
+		assert.NoError(t, backend.Publish(ctx, event))
+
+		mu.Lock()
+		assert.Equal(t, []Event{event}, received["a"])
+		assert.Equal(t, []Event{event}, received["b"])
+		mu.Unlock()
+
+		cancel()
+		wg.Wait()
+	})
+
+	t.Run("stops delivering once the subscription ends", func(t *testing.T) {
+		backend := NewMemory()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var calls int
+		done := make(chan struct{})
+		go func() {
+			backend.Subscribe(ctx, func(Event) { calls++ })
+			close(done)
+		}()
+		assert.Eventually(t, func() bool { return backend.Subscribers() == 1 }, time.Second, time.Millisecond)
+
+		cancel()
+		<-done
+		assert.NoError(t, backend.Publish(context.Background(), Event{KeyID: 1, Kind: EventCooldown}))
+		assert.Equal(t, 0, calls)
+	})
+}
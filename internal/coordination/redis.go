@@ -0,0 +1,75 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ubuygold/gogemini/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Backend that exchanges events over a Redis pub/sub channel.
+type Redis struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedis creates a Backend for the Redis server in cfg. The connection is established
+// lazily, so an unreachable server only shows up as publish and subscribe errors.
+func NewRedis(cfg config.RedisConfig) *Redis {
+	channel := cfg.Channel
+	if channel == "" {
+		channel = config.DefaultRedisChannel
+	}
+	return &Redis{
+		client: redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		}),
+		channel: channel,
+	}
+}
+
+// Publish sends e to the channel.
+func (r *Redis) Publish(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode key event: %w", err)
+	}
+	if err := r.client.Publish(ctx, r.channel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish key event: %w", err)
+	}
+	return nil
+}
+
+// Subscribe calls handle for every event on the channel until ctx is done. The client
+// resubscribes by itself after connection errors; undecodable messages are skipped.
+func (r *Redis) Subscribe(ctx context.Context, handle func(Event)) error {
+	sub := r.client.Subscribe(ctx, r.channel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				continue
+			}
+			handle(e)
+		}
+	}
+}
+
+// Close closes the Redis client.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
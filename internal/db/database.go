@@ -3,6 +3,9 @@ package db
 import (
 	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/model"
@@ -16,56 +19,163 @@ import (
 
 var ErrAPIKeyNotFound = errors.New("api key not found")
 var ErrGeminiKeyNotFound = errors.New("gemini key not found")
+var ErrInvalidSort = errors.New("invalid sort column or order")
+
+// GeminiKeyCounts summarizes the Gemini keys by status. Dead counts the keys retired by
+// PruneGeminiKeys; Failing counts the active keys with at least one recent failure.
+type GeminiKeyCounts struct {
+	Total    int64 `json:"total"`
+	Active   int64 `json:"active"`
+	Disabled int64 `json:"disabled"`
+	Pending  int64 `json:"pending"`
+	Dead     int64 `json:"dead"`
+	Failing  int64 `json:"failing"`
+}
 
 // Service defines the interface for database operations.
 type Service interface {
 	// Gemini Key Management
 	CreateGeminiKey(key *model.GeminiKey) error
-	BatchAddGeminiKeys(keys []string) error
+	BatchAddGeminiKeys(keys []string, status string) (int64, error)
 	BatchDeleteGeminiKeys(ids []uint) error
-	ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error)
+	BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error)
+	ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error)
+	GeminiKeyStatusCounts() (GeminiKeyCounts, error)
 	GetGeminiKey(id uint) (*model.GeminiKey, error)
 	UpdateGeminiKey(key *model.GeminiKey) error
+	UpdateGeminiKeyState(key *model.GeminiKey) error
 	DeleteGeminiKey(id uint) error
+	ListDeletedGeminiKeys() ([]model.GeminiKey, error)
+	StreamGeminiKeys(fn func(model.GeminiKey) error) error
+	RestoreGeminiKey(id uint) error
+	PurgeGeminiKey(id uint) error
+	PurgeDeletedGeminiKeys() (int64, error)
 	LoadActiveGeminiKeys() ([]model.GeminiKey, error)
 	HandleGeminiKeyFailure(key string, disableThreshold int) (bool, error)
 	ResetGeminiKeyFailureCount(key string) error
+	ResetAllGeminiFailureCounts() error
 	IncrementGeminiKeyUsageCount(key string) error
+	BatchIncrementGeminiUsage(counts map[string]int64) error
+	BatchIncrementGeminiTokenUsage(tokens map[string]int64) error
+	ResetDailyGeminiUsage() error
+	DecayGeminiFailureCounts(olderThan time.Duration) error
 	UpdateGeminiKeyStatus(key, status string) error
+	PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error)
+	FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error)
 
 	// Client API Key Management
 	CreateAPIKey(key *model.APIKey) error
 	ListAPIKeys() ([]model.APIKey, error)
+	ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error)
 	GetAPIKey(id uint) (*model.APIKey, error)
 	UpdateAPIKey(key *model.APIKey) error
 	DeleteAPIKey(id uint) error
 	IncrementAPIKeyUsageCount(key string) error
 	FindAPIKeyByKey(key string) (*model.APIKey, error)
+	FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error)
+	ExpireStaleAPIKeys() (int64, error)
+	ResetMonthlyAPIUsage() error
+
+	// Ping checks that the database, and the read replica if any, can be reached.
+	Ping() error
 }
 
 // gormService is an implementation of the Service interface that uses GORM.
 type gormService struct {
 	db *gorm.DB
+	// replica serves listing queries; it is the same connection as db when no replica is configured.
+	// Single-record lookups stay on db so they see writes made moments before.
+	replica *gorm.DB
 }
 
-// NewService creates a new Service with a database connection.
-func NewService(cfg config.DatabaseConfig) (Service, error) {
-	var dialector gorm.Dialector
-	switch cfg.Type {
+// newGormService creates a gormService that sends listing queries to replica, or to primary if replica is nil.
+func newGormService(primary, replica *gorm.DB) *gormService {
+	if replica == nil {
+		replica = primary
+	}
+	return &gormService{db: primary, replica: replica}
+}
+
+// openDialector returns the GORM dialector for the given database type.
+func openDialector(dbType, dsn string) (gorm.Dialector, error) {
+	switch dbType {
 	case "sqlite":
-		dialector = sqlite.Open(cfg.DSN)
+		return sqlite.Open(dsn), nil
 	case "postgres":
-		dialector = postgres.Open(cfg.DSN)
+		return postgres.Open(dsn), nil
 	case "mysql":
-		dialector = mysql.Open(cfg.DSN)
+		return mysql.Open(dsn), nil
 	default:
-		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
+		return nil, fmt.Errorf("unsupported database type: %s", dbType)
 	}
+}
 
-	db, err := gorm.Open(dialector, &gorm.Config{})
+// configurePool applies the connection pool limits from cfg to db, using defaults for unset values.
+func configurePool(db *gorm.DB, cfg config.DatabaseConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+
+	maxOpen := cfg.MaxOpenConns
+	if maxOpen == 0 {
+		maxOpen = config.DefaultDBMaxOpenConns
+	}
+	maxIdle := cfg.MaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = config.DefaultDBMaxIdleConns
+	}
+	lifetime := cfg.ConnMaxLifetime
+	if lifetime == 0 {
+		lifetime = config.DefaultDBConnMaxLifetime
+	}
+
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(lifetime)
+	return nil
+}
+
+// Backoff bounds between connection attempts at startup; variables so tests can shorten them.
+var (
+	connectMinBackoff = 1 * time.Second
+	connectMaxBackoff = 30 * time.Second
+)
+
+// openWithRetry opens a connection, retrying with exponential backoff so the service can start
+// while the database is still coming up. Zero retries means config.DefaultDBConnectRetries and a
+// negative value disables retrying.
+func openWithRetry(dialector gorm.Dialector, retries int) (*gorm.DB, error) {
+	if retries == 0 {
+		retries = config.DefaultDBConnectRetries
+	}
+	backoff := connectMinBackoff
+	for attempt := 0; ; attempt++ {
+		db, err := gorm.Open(dialector, &gorm.Config{})
+		if err == nil || attempt >= retries {
+			return db, err
+		}
+		slog.Warn("Failed to connect to database, retrying", "attempt", attempt+1, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, connectMaxBackoff)
+	}
+}
+
+// NewService creates a new Service with a database connection, plus a read replica connection
+// when cfg.ReplicaDSN is set.
+func NewService(cfg config.DatabaseConfig) (Service, error) {
+	dialector, err := openDialector(cfg.Type, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openWithRetry(dialector, cfg.ConnectRetries)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	if err := configurePool(db, cfg); err != nil {
+		return nil, err
+	}
 
 	// Auto-migrate the schema
 	err = db.AutoMigrate(&model.APIKey{}, &model.GeminiKey{})
@@ -73,13 +183,51 @@ func NewService(cfg config.DatabaseConfig) (Service, error) {
 		return nil, fmt.Errorf("failed to auto-migrate database: %w", err)
 	}
 
-	return &gormService{db: db}, nil
+	var replica *gorm.DB
+	if cfg.ReplicaDSN != "" {
+		replicaDialector, err := openDialector(cfg.Type, cfg.ReplicaDSN)
+		if err != nil {
+			return nil, err
+		}
+		replica, err = openWithRetry(replicaDialector, cfg.ConnectRetries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to read replica: %w", err)
+		}
+		if err := configurePool(replica, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return newGormService(db, replica), nil
+}
+
+// Ping checks that the database, and the read replica if any, can be reached. database/sql
+// dials a new connection when pooled ones are broken, so a successful ping also reconnects.
+func (s *gormService) Ping() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	if s.replica == s.db {
+		return nil
+	}
+	replicaDB, err := s.replica.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get underlying sql.DB of read replica: %w", err)
+	}
+	if err := replicaDB.Ping(); err != nil {
+		return fmt.Errorf("failed to ping read replica: %w", err)
+	}
+	return nil
 }
 
 // LoadActiveGeminiKeys retrieves all active Gemini keys from the database.
 func (s *gormService) LoadActiveGeminiKeys() ([]model.GeminiKey, error) {
 	var keys []model.GeminiKey
-	result := s.db.Model(&model.GeminiKey{}).
+	result := s.replica.Model(&model.GeminiKey{}).
 		Where("status = ?", "active").
 		Order("usage_count asc").
 		Find(&keys)
@@ -90,10 +238,14 @@ func (s *gormService) LoadActiveGeminiKeys() ([]model.GeminiKey, error) {
 }
 
 // HandleGeminiKeyFailure increments the failure count for a key and disables it if the threshold is met.
+// A key's own DisableThreshold takes precedence over disableThreshold.
 func (s *gormService) HandleGeminiKeyFailure(key string, disableThreshold int) (bool, error) {
 	var disabled bool
 	err := s.db.Transaction(func(tx *gorm.DB) error {
-		result := tx.Model(&model.GeminiKey{}).Where("key = ?", key).UpdateColumn("failure_count", gorm.Expr("failure_count + 1"))
+		result := tx.Model(&model.GeminiKey{}).Where("key = ?", key).UpdateColumns(map[string]interface{}{
+			"failure_count":  gorm.Expr("failure_count + 1"),
+			"last_failed_at": time.Now(),
+		})
 		if result.Error != nil {
 			return result.Error
 		}
@@ -106,8 +258,14 @@ func (s *gormService) HandleGeminiKeyFailure(key string, disableThreshold int) (
 			return err
 		}
 
+		if geminiKey.DisableThreshold > 0 {
+			disableThreshold = geminiKey.DisableThreshold
+		}
 		if geminiKey.FailureCount >= disableThreshold && geminiKey.Status == "active" {
-			if err := tx.Model(&geminiKey).Update("status", "disabled").Error; err != nil {
+			if err := tx.Model(&geminiKey).Updates(map[string]interface{}{
+				"status":          "disabled",
+				"disabled_reason": model.DisabledReasonFailureThreshold,
+			}).Error; err != nil {
 				return err
 			}
 			disabled = true
@@ -126,18 +284,137 @@ func (s *gormService) ResetGeminiKeyFailureCount(key string) error {
 	return nil
 }
 
-// IncrementGeminiKeyUsageCount atomically increments the usage count for a given key.
+// ResetAllGeminiFailureCounts zeroes the failure count of every Gemini key and re-activates
+// keys disabled by the failure threshold. Keys disabled by an admin, a fatal upstream error or
+// a low success ratio stay disabled. It is meant for recovering after a provider-wide outage.
+func (s *gormService) ResetAllGeminiFailureCounts() error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.GeminiKey{}).
+			Where("status = ? AND disabled_reason = ?", "disabled", model.DisabledReasonFailureThreshold).
+			Updates(map[string]interface{}{"status": "active", "disabled_reason": ""}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.GeminiKey{}).
+			Where("failure_count > 0").
+			Update("failure_count", 0).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset gemini failure counts: %w", err)
+	}
+	return nil
+}
+
+// IncrementGeminiKeyUsageCount atomically increments the total and daily usage counts for a given key.
 func (s *gormService) IncrementGeminiKeyUsageCount(key string) error {
-	result := s.db.Model(&model.GeminiKey{}).Where("key = ?", key).UpdateColumn("usage_count", gorm.Expr("usage_count + 1"))
+	result := s.db.Model(&model.GeminiKey{}).Where("key = ?", key).UpdateColumns(map[string]interface{}{
+		"usage_count": gorm.Expr("usage_count + 1"),
+		"usage_today": gorm.Expr("usage_today + 1"),
+	})
 	if result.Error != nil {
 		return fmt.Errorf("failed to increment usage count for key %s: %w", key, result.Error)
 	}
 	return nil
 }
 
-// UpdateGeminiKeyStatus updates the status of a specific Gemini key.
+// BatchIncrementGeminiUsage adds each count to the total and daily usage of its key in a single transaction.
+func (s *gormService) BatchIncrementGeminiUsage(counts map[string]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for key, n := range counts {
+			result := tx.Model(&model.GeminiKey{}).Where("key = ?", key).UpdateColumns(map[string]interface{}{
+				"usage_count": gorm.Expr("usage_count + ?", n),
+				"usage_today": gorm.Expr("usage_today + ?", n),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to batch increment gemini usage: %w", err)
+	}
+	return nil
+}
+
+// BatchIncrementGeminiTokenUsage adds each count to the token usage of its key in a single transaction.
+func (s *gormService) BatchIncrementGeminiTokenUsage(tokens map[string]int64) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for key, n := range tokens {
+			result := tx.Model(&model.GeminiKey{}).Where("key = ?", key).UpdateColumn("token_usage", gorm.Expr("token_usage + ?", n))
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to batch increment gemini token usage: %w", err)
+	}
+	return nil
+}
+
+// ResetDailyGeminiUsage zeroes the daily usage counter of every Gemini key.
+func (s *gormService) ResetDailyGeminiUsage() error {
+	result := s.db.Model(&model.GeminiKey{}).Where("usage_today <> ?", 0).UpdateColumn("usage_today", 0)
+	if result.Error != nil {
+		return fmt.Errorf("failed to reset daily gemini usage: %w", result.Error)
+	}
+	return nil
+}
+
+// DecayGeminiFailureCounts decrements the failure count of every active key that
+// has not failed within olderThan, so occasional transient errors heal over time.
+func (s *gormService) DecayGeminiFailureCounts(olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan)
+	result := s.db.Model(&model.GeminiKey{}).
+		Where("status = ? AND failure_count > 0", "active").
+		Where("last_failed_at IS NULL OR last_failed_at < ?", cutoff).
+		UpdateColumn("failure_count", gorm.Expr("failure_count - 1"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to decay gemini failure counts: %w", result.Error)
+	}
+	return nil
+}
+
+// PruneGeminiKeys retires disabled keys that have failed at least minRevivalFailures revival
+// attempts and were disabled before disabledBefore. They are soft-deleted when softDelete is
+// set and marked "dead" otherwise. It returns the number of keys pruned.
+//
+// Keys disabled before disabled_since was recorded first get it backfilled from their last
+// failure, or their last update when they have none, so they can be pruned too.
+func (s *gormService) PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error) {
+	// UpdateColumn leaves updated_at alone, which the backfill may read.
+	backfill := s.db.Model(&model.GeminiKey{}).
+		Where("status = ? AND (disabled_since IS NULL OR disabled_since <= ?)", "disabled", time.Time{}).
+		UpdateColumn("disabled_since", gorm.Expr("CASE WHEN last_failed_at > ? THEN last_failed_at ELSE updated_at END", time.Time{}))
+	if backfill.Error != nil {
+		return 0, fmt.Errorf("failed to backfill disabled_since: %w", backfill.Error)
+	}
+
+	tx := s.db.Model(&model.GeminiKey{}).
+		Where("status = ? AND revival_failures >= ?", "disabled", minRevivalFailures).
+		Where("disabled_since IS NOT NULL AND disabled_since > ? AND disabled_since < ?", time.Time{}, disabledBefore)
+	var result *gorm.DB
+	if softDelete {
+		result = tx.Delete(&model.GeminiKey{})
+	} else {
+		result = tx.Update("status", "dead")
+	}
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune gemini keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// UpdateGeminiKeyStatus updates the status of a specific Gemini key and clears its disable reason.
 func (s *gormService) UpdateGeminiKeyStatus(key, status string) error {
-	result := s.db.Model(&model.GeminiKey{}).Where("key = ?", key).Update("status", status)
+	result := s.db.Model(&model.GeminiKey{}).Where("key = ?", key).Updates(map[string]interface{}{"status": status, "disabled_reason": ""})
 	if result.Error != nil {
 		return fmt.Errorf("failed to update status for key %s: %w", key, result.Error)
 	}
@@ -147,28 +424,45 @@ func (s *gormService) UpdateGeminiKeyStatus(key, status string) error {
 	return nil
 }
 
-// BatchAddGeminiKeys adds multiple Gemini keys to the database in a single transaction.
-func (s *gormService) BatchAddGeminiKeys(keys []string) error {
+// BatchAddGeminiKeys adds multiple Gemini keys with the given status to the database in a
+// single transaction and returns how many were inserted. Keys already stored are skipped;
+// deleted keys in the trash are replaced by the new ones, see purgeDeletedDuplicates.
+func (s *gormService) BatchAddGeminiKeys(keys []string, status string) (int64, error) {
 	if s.db.Error != nil {
-		return s.db.Error
+		return 0, s.db.Error
 	}
 	if len(keys) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	var keyModels []model.GeminiKey
 	for _, key := range keys {
-		keyModels = append(keyModels, model.GeminiKey{Key: key, Status: "active"})
+		keyModels = append(keyModels, model.GeminiKey{Key: key, Status: status})
 	}
 
-	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&keyModels)
-	if result.Error != nil {
-		return fmt.Errorf("failed to batch add gemini keys: %w", result.Error)
+	var added int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := purgeDeletedDuplicates(tx, keys); err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&keyModels)
+		added = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to batch add gemini keys: %w", err)
 	}
-	return nil
+	return added, nil
+}
+
+// purgeDeletedDuplicates permanently removes soft-deleted rows holding any of keys. Deleted
+// rows keep their place in the unique index on key, so without this a key moved to the trash
+// could never be added again; adding it back starts it afresh instead of restoring old state.
+func purgeDeletedDuplicates(tx *gorm.DB, keys []string) error {
+	return tx.Unscoped().Where("key IN ? AND deleted_at IS NOT NULL", keys).Delete(&model.GeminiKey{}).Error
 }
 
-// BatchDeleteGeminiKeys removes multiple Gemini keys from the database.
+// BatchDeleteGeminiKeys soft-deletes multiple Gemini keys so they can be restored from the trash.
 func (s *gormService) BatchDeleteGeminiKeys(ids []uint) error {
 	if s.db.Error != nil {
 		return s.db.Error
@@ -176,26 +470,104 @@ func (s *gormService) BatchDeleteGeminiKeys(ids []uint) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	result := s.db.Unscoped().Where("id IN ?", ids).Delete(&model.GeminiKey{})
+	result := s.db.Where("id IN ?", ids).Delete(&model.GeminiKey{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to batch delete gemini keys: %w", result.Error)
 	}
 	return nil
 }
 
-func (s *gormService) CreateGeminiKey(key *model.GeminiKey) error {
-	result := s.db.Create(key)
+// BatchUpdateGeminiKeyStatus sets the status of multiple Gemini keys in a single UPDATE, clearing
+// their disable reason, and returns how many keys it updated; unknown and deleted IDs are skipped.
+func (s *gormService) BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error) {
+	if s.db.Error != nil {
+		return 0, s.db.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := s.db.Model(&model.GeminiKey{}).Where("id IN ?", ids).Updates(map[string]interface{}{"status": status, "disabled_reason": ""})
 	if result.Error != nil {
-		return fmt.Errorf("failed to create gemini key: %w", result.Error)
+		return 0, fmt.Errorf("failed to batch update gemini key status: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// likeSuffixEscaper escapes the LIKE wildcards, which are common in key values, using '!'
+// as the escape character since it needs no quoting in any supported database.
+var likeSuffixEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// FindGeminiKeysBySuffix returns the Gemini keys whose value ends with suffix.
+func (s *gormService) FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error) {
+	var keys []model.GeminiKey
+	pattern := "%" + likeSuffixEscaper.Replace(suffix)
+	if err := s.replica.Where("key LIKE ? ESCAPE '!'", pattern).Order("id desc").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to search gemini keys: %w", err)
+	}
+	return keys, nil
+}
+
+// CreateGeminiKey stores a new Gemini key, replacing a deleted key with the same value that is
+// still in the trash.
+func (s *gormService) CreateGeminiKey(key *model.GeminiKey) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := purgeDeletedDuplicates(tx, []string{key.Key}); err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create gemini key: %w", err)
 	}
 	return nil
 }
 
-func (s *gormService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error) {
+// geminiKeySortColumns are the columns ListGeminiKeys can sort by. Only these names reach the
+// ORDER BY clause, so user input is never interpolated into SQL.
+var geminiKeySortColumns = map[string]bool{
+	"id":            true,
+	"usage_count":   true,
+	"failure_count": true,
+	"status":        true,
+}
+
+// geminiKeyOrder builds the ORDER BY for ListGeminiKeys. An empty sortBy means id and an empty
+// sortOrder means desc; ties are broken by id so pages stay stable.
+func geminiKeyOrder(sortBy, sortOrder string) (clause.OrderBy, error) {
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	if !geminiKeySortColumns[sortBy] {
+		return clause.OrderBy{}, fmt.Errorf("%w: cannot sort by %q", ErrInvalidSort, sortBy)
+	}
+	var desc bool
+	switch strings.ToLower(sortOrder) {
+	case "", "desc":
+		desc = true
+	case "asc":
+	default:
+		return clause.OrderBy{}, fmt.Errorf("%w: sort order must be asc or desc, got %q", ErrInvalidSort, sortOrder)
+	}
+
+	order := clause.OrderBy{Columns: []clause.OrderByColumn{{Column: clause.Column{Name: sortBy}, Desc: desc}}}
+	if sortBy != "id" {
+		order.Columns = append(order.Columns, clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: true})
+	}
+	return order, nil
+}
+
+// ListGeminiKeys returns a page of Gemini keys matching the filters, sorted by one of
+// geminiKeySortColumns. Other sort columns or orders return ErrInvalidSort.
+func (s *gormService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error) {
 	var keys []model.GeminiKey
 	var total int64
 
-	tx := s.db.Model(&model.GeminiKey{})
+	order, err := geminiKeyOrder(sortBy, sortOrder)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tx := s.replica.Model(&model.GeminiKey{})
 
 	if statusFilter != "all" && statusFilter != "" {
 		tx = tx.Where("status = ?", statusFilter)
@@ -211,7 +583,7 @@ func (s *gormService) ListGeminiKeys(page, limit int, statusFilter string, minFa
 
 	// Get paginated results
 	offset := (page - 1) * limit
-	result := tx.Offset(offset).Limit(limit).Order("id desc").Find(&keys)
+	result := tx.Offset(offset).Limit(limit).Clauses(order).Find(&keys)
 	if result.Error != nil {
 		return nil, 0, fmt.Errorf("failed to list gemini keys: %w", result.Error)
 	}
@@ -219,6 +591,23 @@ func (s *gormService) ListGeminiKeys(page, limit int, statusFilter string, minFa
 	return keys, total, nil
 }
 
+// GeminiKeyStatusCounts counts all Gemini keys by status in a single query.
+func (s *gormService) GeminiKeyStatusCounts() (GeminiKeyCounts, error) {
+	var counts GeminiKeyCounts
+	err := s.replica.Model(&model.GeminiKey{}).Select(
+		"COUNT(*) AS total, " +
+			"COALESCE(SUM(CASE WHEN status = 'active' THEN 1 ELSE 0 END), 0) AS active, " +
+			"COALESCE(SUM(CASE WHEN status = 'disabled' THEN 1 ELSE 0 END), 0) AS disabled, " +
+			"COALESCE(SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END), 0) AS pending, " +
+			"COALESCE(SUM(CASE WHEN status = 'dead' THEN 1 ELSE 0 END), 0) AS dead, " +
+			"COALESCE(SUM(CASE WHEN status = 'active' AND failure_count > 0 THEN 1 ELSE 0 END), 0) AS failing",
+	).Scan(&counts).Error
+	if err != nil {
+		return GeminiKeyCounts{}, fmt.Errorf("failed to count gemini keys by status: %w", err)
+	}
+	return counts, nil
+}
+
 func (s *gormService) GetGeminiKey(id uint) (*model.GeminiKey, error) {
 	var key model.GeminiKey
 	result := s.db.First(&key, id)
@@ -239,14 +628,105 @@ func (s *gormService) UpdateGeminiKey(key *model.GeminiKey) error {
 	return nil
 }
 
+// UpdateGeminiKeyState writes the health state the key manager tracks for key: its failure
+// count, status, disable reason, failure and disable times, revival failures and models. Unlike UpdateGeminiKey
+// it leaves the secret and admin-managed settings alone and never recreates deleted keys.
+func (s *gormService) UpdateGeminiKeyState(key *model.GeminiKey) error {
+	result := s.db.Model(&model.GeminiKey{}).Where("id = ?", key.ID).Select(
+		"failure_count", "status", "disabled_reason", "last_failed_at", "disabled_since", "revival_failures", "models",
+	).Updates(&model.GeminiKey{
+		FailureCount:    key.FailureCount,
+		Status:          key.Status,
+		DisabledReason:  key.DisabledReason,
+		LastFailedAt:    key.LastFailedAt,
+		DisabledSince:   key.DisabledSince,
+		RevivalFailures: key.RevivalFailures,
+		Models:          key.Models,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update state of gemini key %d: %w", key.ID, result.Error)
+	}
+	return nil
+}
+
+// DeleteGeminiKey soft-deletes a Gemini key so it can be restored from the trash.
 func (s *gormService) DeleteGeminiKey(id uint) error {
-	result := s.db.Unscoped().Delete(&model.GeminiKey{}, id)
+	result := s.db.Delete(&model.GeminiKey{}, id)
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete gemini key %d: %w", id, result.Error)
 	}
 	return nil
 }
 
+// StreamGeminiKeys calls fn for every Gemini key in id order without loading them all into memory.
+// Iteration stops at the first error returned by fn.
+func (s *gormService) StreamGeminiKeys(fn func(model.GeminiKey) error) error {
+	rows, err := s.replica.Model(&model.GeminiKey{}).Order("id asc").Rows()
+	if err != nil {
+		return fmt.Errorf("failed to stream gemini keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key model.GeminiKey
+		if err := s.replica.ScanRows(rows, &key); err != nil {
+			return fmt.Errorf("failed to scan gemini key: %w", err)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to stream gemini keys: %w", err)
+	}
+	return nil
+}
+
+// ListDeletedGeminiKeys retrieves all soft-deleted Gemini keys, most recently deleted first.
+func (s *gormService) ListDeletedGeminiKeys() ([]model.GeminiKey, error) {
+	var keys []model.GeminiKey
+	result := s.replica.Unscoped().
+		Where("deleted_at IS NOT NULL").
+		Order("deleted_at desc").
+		Find(&keys)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to list deleted gemini keys: %w", result.Error)
+	}
+	return keys, nil
+}
+
+// RestoreGeminiKey clears the deletion marker of a soft-deleted Gemini key.
+func (s *gormService) RestoreGeminiKey(id uint) error {
+	result := s.db.Unscoped().Model(&model.GeminiKey{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore gemini key %d: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrGeminiKeyNotFound
+	}
+	return nil
+}
+
+// PurgeGeminiKey permanently removes a Gemini key, whether or not it has been soft-deleted.
+func (s *gormService) PurgeGeminiKey(id uint) error {
+	result := s.db.Unscoped().Delete(&model.GeminiKey{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to purge gemini key %d: %w", id, result.Error)
+	}
+	return nil
+}
+
+// PurgeDeletedGeminiKeys permanently removes all soft-deleted Gemini keys and returns how many were removed.
+func (s *gormService) PurgeDeletedGeminiKeys() (int64, error) {
+	result := s.db.Unscoped().Where("deleted_at IS NOT NULL").Delete(&model.GeminiKey{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge deleted gemini keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
 func (s *gormService) CreateAPIKey(key *model.APIKey) error {
 	result := s.db.Create(key)
 	if result.Error != nil {
@@ -257,13 +737,59 @@ func (s *gormService) CreateAPIKey(key *model.APIKey) error {
 
 func (s *gormService) ListAPIKeys() ([]model.APIKey, error) {
 	var keys []model.APIKey
-	result := s.db.Find(&keys)
+	result := s.replica.Find(&keys)
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to list api keys: %w", result.Error)
 	}
 	return keys, nil
 }
 
+// ExpireStaleAPIKeys sets the status of active client keys whose expiry has passed to "expired"
+// and returns how many were changed.
+func (s *gormService) ExpireStaleAPIKeys() (int64, error) {
+	result := s.db.Model(&model.APIKey{}).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at > ? AND expires_at < ?", "active", time.Time{}, time.Now()).
+		Update("status", "expired")
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to expire stale api keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// ResetMonthlyAPIUsage zeroes the monthly usage counter of every client key.
+func (s *gormService) ResetMonthlyAPIUsage() error {
+	result := s.db.Model(&model.APIKey{}).Where("usage_this_month <> ?", 0).UpdateColumn("usage_this_month", 0)
+	if result.Error != nil {
+		return fmt.Errorf("failed to reset monthly api usage: %w", result.Error)
+	}
+	return nil
+}
+
+func (s *gormService) ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error) {
+	var keys []model.APIKey
+	var total int64
+
+	tx := s.replica.Model(&model.APIKey{})
+
+	if statusFilter != "all" && statusFilter != "" {
+		tx = tx.Where("status = ?", statusFilter)
+	}
+
+	// Get total count after applying filters
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count api keys: %w", err)
+	}
+
+	// Get paginated results
+	offset := (page - 1) * limit
+	result := tx.Offset(offset).Limit(limit).Order("id desc").Find(&keys)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("failed to list api keys: %w", result.Error)
+	}
+
+	return keys, total, nil
+}
+
 func (s *gormService) GetAPIKey(id uint) (*model.APIKey, error) {
 	var key model.APIKey
 	result := s.db.First(&key, id)
@@ -292,9 +818,12 @@ func (s *gormService) DeleteAPIKey(id uint) error {
 	return nil
 }
 
-// IncrementAPIKeyUsageCount atomically increments the usage count for a given API key.
+// IncrementAPIKeyUsageCount atomically increments the total and monthly usage counts for a given API key.
 func (s *gormService) IncrementAPIKeyUsageCount(key string) error {
-	result := s.db.Model(&model.APIKey{}).Where("key = ?", key).UpdateColumn("usage_count", gorm.Expr("usage_count + 1"))
+	result := s.db.Model(&model.APIKey{}).Where("key = ?", key).UpdateColumns(map[string]any{
+		"usage_count":      gorm.Expr("usage_count + 1"),
+		"usage_this_month": gorm.Expr("usage_this_month + 1"),
+	})
 	if result.Error != nil {
 		return fmt.Errorf("failed to increment usage count for api key %s: %w", key, result.Error)
 	}
@@ -313,3 +842,13 @@ func (s *gormService) FindAPIKeyByKey(key string) (*model.APIKey, error) {
 	}
 	return &apiKey, nil
 }
+
+// FindAPIKeysBySuffix returns the client keys whose value ends with suffix.
+func (s *gormService) FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	pattern := "%" + likeSuffixEscaper.Replace(suffix)
+	if err := s.replica.Where("key LIKE ? ESCAPE '!'", pattern).Order("id desc").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to search api keys: %w", err)
+	}
+	return keys, nil
+}
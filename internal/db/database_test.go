@@ -1,7 +1,10 @@
 package db
 
 import (
+	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/model"
@@ -36,20 +39,20 @@ func TestGeminiKeyCRUD(t *testing.T) {
 	t.Run("ListGeminiKeys", func(t *testing.T) {
 		db.CreateGeminiKey(&model.GeminiKey{Key: "disabled-key", Status: "disabled", FailureCount: 5})
 		// Test no filters
-		keys, total, err := db.ListGeminiKeys(1, 10, "all", 0)
+		keys, total, err := db.ListGeminiKeys(1, 10, "all", 0, "", "")
 		assert.NoError(t, err)
 		assert.Len(t, keys, 2)
 		assert.Equal(t, int64(2), total)
 
 		// Test status filter
-		keys, total, err = db.ListGeminiKeys(1, 10, "disabled", 0)
+		keys, total, err = db.ListGeminiKeys(1, 10, "disabled", 0, "", "")
 		assert.NoError(t, err)
 		assert.Len(t, keys, 1)
 		assert.Equal(t, int64(1), total)
 		assert.Equal(t, "disabled-key", keys[0].Key)
 
 		// Test failure count filter
-		keys, total, err = db.ListGeminiKeys(1, 10, "all", 3)
+		keys, total, err = db.ListGeminiKeys(1, 10, "all", 3, "", "")
 		assert.NoError(t, err)
 		assert.Len(t, keys, 1)
 		assert.Equal(t, int64(1), total)
@@ -105,6 +108,113 @@ func TestAPIKeyCRUD(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestExpireStaleAPIKeys(t *testing.T) {
+	db := setupTestDB(t)
+	expired := &model.APIKey{Key: "expired-client-key", Status: "active", ExpiresAt: time.Now().Add(-time.Hour)}
+	future := &model.APIKey{Key: "future-client-key", Status: "active", ExpiresAt: time.Now().Add(time.Hour)}
+	forever := &model.APIKey{Key: "forever-client-key", Status: "active"}
+	revoked := &model.APIKey{Key: "revoked-client-key", Status: "revoked", ExpiresAt: time.Now().Add(-time.Hour)}
+	for _, k := range []*model.APIKey{expired, future, forever, revoked} {
+		assert.NoError(t, db.CreateAPIKey(k))
+	}
+
+	count, err := db.ExpireStaleAPIKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	fetched, _ := db.GetAPIKey(expired.ID)
+	assert.Equal(t, "expired", fetched.Status)
+	fetched, _ = db.GetAPIKey(future.ID)
+	assert.Equal(t, "active", fetched.Status)
+	fetched, _ = db.GetAPIKey(forever.ID)
+	assert.Equal(t, "active", fetched.Status)
+	fetched, _ = db.GetAPIKey(revoked.ID)
+	assert.Equal(t, "revoked", fetched.Status)
+
+	keys, total, err := db.ListAPIKeysPaged(1, 10, "expired")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "expired-client-key", keys[0].Key)
+}
+
+func TestReplicaRouting(t *testing.T) {
+	dir := t.TempDir()
+	primaryDSN := filepath.Join(dir, "primary.db")
+	replicaDSN := filepath.Join(dir, "replica.db")
+
+	// Migrate the replica schema the way replication would.
+	replicaOnly, err := NewService(config.DatabaseConfig{Type: "sqlite", DSN: replicaDSN})
+	assert.NoError(t, err)
+	replicaKey := &model.GeminiKey{Key: "replica-only-key", Status: "active"}
+	assert.NoError(t, replicaOnly.CreateGeminiKey(replicaKey))
+	assert.NoError(t, replicaOnly.CreateAPIKey(&model.APIKey{Key: "replica-only-client", Status: "active"}))
+
+	service, err := NewService(config.DatabaseConfig{Type: "sqlite", DSN: primaryDSN, ReplicaDSN: replicaDSN})
+	assert.NoError(t, err)
+
+	t.Run("reads go to the replica", func(t *testing.T) {
+		active, err := service.LoadActiveGeminiKeys()
+		assert.NoError(t, err)
+		assert.Len(t, active, 1)
+
+		keys, total, err := service.ListGeminiKeys(1, 10, "all", 0, "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, "replica-only-key", keys[0].Key)
+
+		clients, err := service.ListAPIKeys()
+		assert.NoError(t, err)
+		assert.Len(t, clients, 1)
+	})
+
+	t.Run("writes and lookups go to the primary", func(t *testing.T) {
+		assert.NoError(t, service.CreateGeminiKey(&model.GeminiKey{Key: "primary-key", Status: "active"}))
+
+		fetched, err := service.GetGeminiKey(replicaKey.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "primary-key", fetched.Key, "the primary has its own rows")
+
+		keys, _, err := replicaOnly.ListGeminiKeys(1, 10, "all", 0, "", "")
+		assert.NoError(t, err)
+		assert.Len(t, keys, 1, "the write must not reach the replica connection")
+	})
+
+	t.Run("falls back to the primary without a replica", func(t *testing.T) {
+		primaryOnly, err := NewService(config.DatabaseConfig{Type: "sqlite", DSN: primaryDSN})
+		assert.NoError(t, err)
+		keys, _, err := primaryOnly.ListGeminiKeys(1, 10, "all", 0, "", "")
+		assert.NoError(t, err)
+		assert.Len(t, keys, 1)
+		assert.Equal(t, "primary-key", keys[0].Key)
+	})
+}
+
+func TestConnectionPoolSettings(t *testing.T) {
+	maxOpen := func(t *testing.T, service Service) int {
+		sqlDB, err := service.(*gormService).db.DB()
+		assert.NoError(t, err)
+		return sqlDB.Stats().MaxOpenConnections
+	}
+
+	t.Run("configured values are applied", func(t *testing.T) {
+		service, err := NewService(config.DatabaseConfig{
+			Type:            "sqlite",
+			DSN:             filepath.Join(t.TempDir(), "pool.db"),
+			MaxOpenConns:    7,
+			MaxIdleConns:    3,
+			ConnMaxLifetime: time.Minute,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 7, maxOpen(t, service))
+	})
+
+	t.Run("defaults are applied when unset", func(t *testing.T) {
+		service, err := NewService(config.DatabaseConfig{Type: "sqlite", DSN: filepath.Join(t.TempDir(), "pool.db")})
+		assert.NoError(t, err)
+		assert.Equal(t, config.DefaultDBMaxOpenConns, maxOpen(t, service))
+	})
+}
+
 func TestNewService_UnsupportedDB(t *testing.T) {
 	_, err := NewService(config.DatabaseConfig{
 		Type: "unsupported",
@@ -124,6 +234,50 @@ func TestLoadActiveGeminiKeys(t *testing.T) {
 	assert.Equal(t, "active-key", keys[0].Key)
 }
 
+func TestListGeminiKeys_Sorting(t *testing.T) {
+	db := setupTestDB(t)
+	for _, key := range []*model.GeminiKey{
+		{Key: "sort-a", Status: "active", UsageCount: 30, FailureCount: 0},
+		{Key: "sort-b", Status: "disabled", UsageCount: 10, FailureCount: 4},
+		{Key: "sort-c", Status: "active", UsageCount: 20, FailureCount: 2},
+	} {
+		assert.NoError(t, db.CreateGeminiKey(key))
+	}
+
+	tests := []struct {
+		sortBy, sortOrder string
+		want              []string
+	}{
+		{"", "", []string{"sort-c", "sort-b", "sort-a"}},
+		{"id", "asc", []string{"sort-a", "sort-b", "sort-c"}},
+		{"usage_count", "desc", []string{"sort-a", "sort-c", "sort-b"}},
+		{"usage_count", "asc", []string{"sort-b", "sort-c", "sort-a"}},
+		{"failure_count", "DESC", []string{"sort-b", "sort-c", "sort-a"}},
+		{"status", "asc", []string{"sort-c", "sort-a", "sort-b"}}, // ties by id desc
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy+" "+tt.sortOrder, func(t *testing.T) {
+			keys, _, err := db.ListGeminiKeys(1, 10, "all", 0, tt.sortBy, tt.sortOrder)
+			assert.NoError(t, err)
+			var got []string
+			for _, k := range keys {
+				got = append(got, k.Key)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("rejects disallowed columns and orders", func(t *testing.T) {
+		for _, sort := range [][2]string{{"key", ""}, {"id; DROP TABLE gemini_keys", ""}, {"usage_count", "sideways"}} {
+			_, _, err := db.ListGeminiKeys(1, 10, "all", 0, sort[0], sort[1])
+			assert.ErrorIs(t, err, ErrInvalidSort, sort[0])
+		}
+		_, total, err := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), total)
+	})
+}
+
 func TestHandleGeminiKeyFailure(t *testing.T) {
 	db := setupTestDB(t)
 	key := &model.GeminiKey{Key: "fail-key", Status: "active"}
@@ -152,6 +306,12 @@ func TestHandleGeminiKeyFailure(t *testing.T) {
 	disabled, err = db.HandleGeminiKeyFailure("non-existent-key", 3)
 	assert.Error(t, err)
 	assert.False(t, disabled)
+
+	// A key's own threshold takes precedence
+	db.CreateGeminiKey(&model.GeminiKey{Key: "fragile-key", Status: "active", DisableThreshold: 1})
+	disabled, err = db.HandleGeminiKeyFailure("fragile-key", 3)
+	assert.NoError(t, err)
+	assert.True(t, disabled)
 }
 
 func TestResetGeminiKeyFailureCount(t *testing.T) {
@@ -176,6 +336,173 @@ func TestIncrementGeminiKeyUsageCount(t *testing.T) {
 
 	fetchedKey, _ := db.GetGeminiKey(key.ID)
 	assert.Equal(t, int64(1), fetchedKey.UsageCount)
+	assert.Equal(t, int64(1), fetchedKey.UsageToday)
+}
+
+func TestBatchIncrementGeminiUsage(t *testing.T) {
+	db := setupTestDB(t)
+	first := &model.GeminiKey{Key: "batch-usage-1"}
+	second := &model.GeminiKey{Key: "batch-usage-2", UsageCount: 5}
+	db.CreateGeminiKey(first)
+	db.CreateGeminiKey(second)
+
+	err := db.BatchIncrementGeminiUsage(map[string]int64{"batch-usage-1": 3, "batch-usage-2": 7})
+	assert.NoError(t, err)
+
+	fetched, _ := db.GetGeminiKey(first.ID)
+	assert.Equal(t, int64(3), fetched.UsageCount)
+	assert.Equal(t, int64(3), fetched.UsageToday)
+	fetched, _ = db.GetGeminiKey(second.ID)
+	assert.Equal(t, int64(12), fetched.UsageCount)
+	assert.Equal(t, int64(7), fetched.UsageToday)
+
+	assert.NoError(t, db.BatchIncrementGeminiUsage(nil))
+}
+
+func TestGeminiKeyStatusCounts(t *testing.T) {
+	db := setupTestDB(t)
+
+	counts, err := db.GeminiKeyStatusCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, GeminiKeyCounts{}, counts)
+
+	db.CreateGeminiKey(&model.GeminiKey{Key: "counts-active-1", Status: "active"})
+	db.CreateGeminiKey(&model.GeminiKey{Key: "counts-active-2", Status: "active", FailureCount: 2})
+	db.CreateGeminiKey(&model.GeminiKey{Key: "counts-disabled", Status: "disabled", FailureCount: 5})
+	db.CreateGeminiKey(&model.GeminiKey{Key: "counts-pending", Status: "pending"})
+	db.CreateGeminiKey(&model.GeminiKey{Key: "counts-dead", Status: "dead"})
+	deleted := &model.GeminiKey{Key: "counts-deleted", Status: "active"}
+	db.CreateGeminiKey(deleted)
+	db.DeleteGeminiKey(deleted.ID)
+
+	counts, err = db.GeminiKeyStatusCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, GeminiKeyCounts{Total: 5, Active: 2, Disabled: 1, Pending: 1, Dead: 1, Failing: 1}, counts)
+}
+
+func TestBatchIncrementGeminiTokenUsage(t *testing.T) {
+	db := setupTestDB(t)
+	key := &model.GeminiKey{Key: "token-usage-1", TokenUsage: 100}
+	db.CreateGeminiKey(key)
+
+	err := db.BatchIncrementGeminiTokenUsage(map[string]int64{"token-usage-1": 250, "missing-key": 5})
+	assert.NoError(t, err)
+
+	fetched, _ := db.GetGeminiKey(key.ID)
+	assert.Equal(t, int64(350), fetched.TokenUsage)
+	assert.Equal(t, int64(0), fetched.UsageCount)
+
+	assert.NoError(t, db.BatchIncrementGeminiTokenUsage(nil))
+}
+
+func TestResetDailyGeminiUsage(t *testing.T) {
+	db := setupTestDB(t)
+	key := &model.GeminiKey{Key: "quota-key", DailyQuota: 10}
+	db.CreateGeminiKey(key)
+	db.IncrementGeminiKeyUsageCount("quota-key")
+	db.IncrementGeminiKeyUsageCount("quota-key")
+
+	err := db.ResetDailyGeminiUsage()
+	assert.NoError(t, err)
+
+	fetchedKey, _ := db.GetGeminiKey(key.ID)
+	assert.Equal(t, int64(0), fetchedKey.UsageToday)
+	assert.Equal(t, int64(2), fetchedKey.UsageCount, "total usage must survive the daily reset")
+	assert.Equal(t, int64(10), fetchedKey.DailyQuota)
+}
+
+func TestDecayGeminiFailureCounts(t *testing.T) {
+	db := setupTestDB(t)
+	stale := &model.GeminiKey{Key: "stale-fail-key", Status: "active", FailureCount: 2, LastFailedAt: time.Now().Add(-48 * time.Hour)}
+	recent := &model.GeminiKey{Key: "recent-fail-key", Status: "active"}
+	healthy := &model.GeminiKey{Key: "healthy-key", Status: "active"}
+	disabled := &model.GeminiKey{Key: "disabled-fail-key", Status: "disabled", FailureCount: 3, LastFailedAt: time.Now().Add(-48 * time.Hour)}
+	for _, k := range []*model.GeminiKey{stale, recent, healthy, disabled} {
+		assert.NoError(t, db.CreateGeminiKey(k))
+	}
+	_, err := db.HandleGeminiKeyFailure("recent-fail-key", 5)
+	assert.NoError(t, err)
+
+	err = db.DecayGeminiFailureCounts(24 * time.Hour)
+	assert.NoError(t, err)
+
+	fetched, _ := db.GetGeminiKey(stale.ID)
+	assert.Equal(t, 1, fetched.FailureCount, "key untouched for the window should decay")
+	fetched, _ = db.GetGeminiKey(recent.ID)
+	assert.Equal(t, 1, fetched.FailureCount, "recently failed key should not decay")
+	fetched, _ = db.GetGeminiKey(healthy.ID)
+	assert.Equal(t, 0, fetched.FailureCount, "failure count should not go below zero")
+	fetched, _ = db.GetGeminiKey(disabled.ID)
+	assert.Equal(t, 3, fetched.FailureCount, "disabled keys are left to the revival job")
+}
+
+func TestPruneGeminiKeys(t *testing.T) {
+	longAgo := time.Now().AddDate(0, 0, -10)
+	newKeys := func(t *testing.T, db Service) (prunable, recent, fewFailures, active *model.GeminiKey) {
+		prunable = &model.GeminiKey{Key: "prunable-key", Status: "disabled", DisabledSince: longAgo, RevivalFailures: 5}
+		recent = &model.GeminiKey{Key: "recently-disabled-key", Status: "disabled", DisabledSince: time.Now().Add(-time.Hour), RevivalFailures: 5}
+		fewFailures = &model.GeminiKey{Key: "few-failures-key", Status: "disabled", DisabledSince: longAgo, RevivalFailures: 2}
+		active = &model.GeminiKey{Key: "active-key", Status: "active", RevivalFailures: 5}
+		for _, k := range []*model.GeminiKey{prunable, recent, fewFailures, active} {
+			assert.NoError(t, db.CreateGeminiKey(k))
+		}
+		return prunable, recent, fewFailures, active
+	}
+	cutoff := time.Now().AddDate(0, 0, -7)
+
+	t.Run("marks matching keys dead", func(t *testing.T) {
+		db := setupTestDB(t)
+		prunable, recent, fewFailures, active := newKeys(t, db)
+
+		pruned, err := db.PruneGeminiKeys(3, cutoff, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), pruned)
+
+		fetched, _ := db.GetGeminiKey(prunable.ID)
+		assert.Equal(t, "dead", fetched.Status)
+		fetched, _ = db.GetGeminiKey(recent.ID)
+		assert.Equal(t, "disabled", fetched.Status, "recently disabled key should not be pruned")
+		fetched, _ = db.GetGeminiKey(fewFailures.ID)
+		assert.Equal(t, "disabled", fetched.Status, "key below the failure count should not be pruned")
+		fetched, _ = db.GetGeminiKey(active.ID)
+		assert.Equal(t, "active", fetched.Status)
+	})
+
+	t.Run("soft-deletes matching keys", func(t *testing.T) {
+		db := setupTestDB(t)
+		prunable, recent, _, _ := newKeys(t, db)
+
+		pruned, err := db.PruneGeminiKeys(3, cutoff, true)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), pruned)
+
+		deleted, err := db.ListDeletedGeminiKeys()
+		assert.NoError(t, err)
+		if assert.Len(t, deleted, 1) {
+			assert.Equal(t, prunable.ID, deleted[0].ID)
+		}
+		_, err = db.GetGeminiKey(recent.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("backfills keys disabled without disabled_since", func(t *testing.T) {
+		db := setupTestDB(t)
+		failedLongAgo := &model.GeminiKey{Key: "failed-long-ago-key", Status: "disabled", LastFailedAt: longAgo, RevivalFailures: 5}
+		failedRecently := &model.GeminiKey{Key: "failed-recently-key", Status: "disabled", LastFailedAt: time.Now().Add(-time.Hour), RevivalFailures: 5}
+		for _, k := range []*model.GeminiKey{failedLongAgo, failedRecently} {
+			assert.NoError(t, db.CreateGeminiKey(k))
+		}
+
+		pruned, err := db.PruneGeminiKeys(3, cutoff, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), pruned)
+
+		fetched, _ := db.GetGeminiKey(failedLongAgo.ID)
+		assert.Equal(t, "dead", fetched.Status)
+		fetched, _ = db.GetGeminiKey(failedRecently.ID)
+		assert.Equal(t, "disabled", fetched.Status)
+		assert.WithinDuration(t, failedRecently.LastFailedAt, fetched.DisabledSince, time.Second, "disabled_since is taken from the last failure")
+	})
 }
 
 func TestBatchAddDeleteGeminiKeys(t *testing.T) {
@@ -183,15 +510,17 @@ func TestBatchAddDeleteGeminiKeys(t *testing.T) {
 	keys := []string{"batch-key-1", "batch-key-2"}
 
 	// Batch Add
-	err := db.BatchAddGeminiKeys(keys)
+	added, err := db.BatchAddGeminiKeys(keys, "active")
 	assert.NoError(t, err)
-	allKeys, total, _ := db.ListGeminiKeys(1, 10, "all", 0)
+	assert.Equal(t, int64(2), added)
+	allKeys, total, _ := db.ListGeminiKeys(1, 10, "all", 0, "", "")
 	assert.Len(t, allKeys, 2)
 	assert.Equal(t, int64(2), total)
 
 	// Test adding empty slice
-	err = db.BatchAddGeminiKeys([]string{})
+	added, err = db.BatchAddGeminiKeys([]string{}, "active")
 	assert.NoError(t, err)
+	assert.Zero(t, added)
 
 	// Batch Delete
 	var idsToDelete []uint
@@ -200,7 +529,7 @@ func TestBatchAddDeleteGeminiKeys(t *testing.T) {
 	}
 	err = db.BatchDeleteGeminiKeys(idsToDelete)
 	assert.NoError(t, err)
-	allKeys, total, _ = db.ListGeminiKeys(1, 10, "all", 0)
+	allKeys, total, _ = db.ListGeminiKeys(1, 10, "all", 0, "", "")
 	assert.Len(t, allKeys, 0)
 	assert.Equal(t, int64(0), total)
 
@@ -209,6 +538,185 @@ func TestBatchAddDeleteGeminiKeys(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBatchUpdateGeminiKeyStatus(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.BatchAddGeminiKeys([]string{"status-key-1", "status-key-2", "status-key-3"}, "active")
+	assert.NoError(t, err)
+	allKeys, _, _ := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+	assert.Len(t, allKeys, 3)
+
+	ids := []uint{allKeys[0].ID, allKeys[1].ID}
+	updated, err := db.BatchUpdateGeminiKeyStatus(append(ids, 9999), "disabled")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updated, "unknown ids are not counted")
+
+	disabled, total, _ := db.ListGeminiKeys(1, 10, "disabled", 0, "", "")
+	assert.Equal(t, int64(2), total)
+	assert.ElementsMatch(t, ids, []uint{disabled[0].ID, disabled[1].ID})
+	active, _ := db.LoadActiveGeminiKeys()
+	assert.Len(t, active, 1)
+	assert.Equal(t, allKeys[2].ID, active[0].ID)
+
+	_, err = db.BatchUpdateGeminiKeyStatus(ids, "active")
+	assert.NoError(t, err)
+	active, _ = db.LoadActiveGeminiKeys()
+	assert.Len(t, active, 3)
+
+	// Updating an empty slice is a no-op
+	updated, err = db.BatchUpdateGeminiKeyStatus([]uint{}, "disabled")
+	assert.NoError(t, err)
+	assert.Zero(t, updated)
+}
+
+func TestResetAllGeminiFailureCounts(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.BatchAddGeminiKeys([]string{"reset-failing", "reset-auto-disabled", "reset-manually-disabled"}, "active")
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := db.HandleGeminiKeyFailure("reset-failing", 5)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := db.HandleGeminiKeyFailure("reset-auto-disabled", 3)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.UpdateGeminiKeyStatus("reset-manually-disabled", "disabled"))
+	fatal := model.GeminiKey{Key: "reset-fatally-disabled", Status: "active"}
+	assert.NoError(t, db.CreateGeminiKey(&fatal))
+	fatal.Status = "disabled"
+	fatal.DisabledReason = model.DisabledReasonFatalError
+	fatal.FailureCount = 1
+	assert.NoError(t, db.UpdateGeminiKeyState(&fatal))
+
+	assert.NoError(t, db.ResetAllGeminiFailureCounts())
+
+	keys, _, err := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+	assert.NoError(t, err)
+	byKey := make(map[string]model.GeminiKey, len(keys))
+	for _, k := range keys {
+		byKey[k.Key] = k
+		assert.Zero(t, k.FailureCount, k.Key)
+	}
+	assert.Equal(t, "active", byKey["reset-failing"].Status)
+	assert.Equal(t, "active", byKey["reset-auto-disabled"].Status, "keys disabled by failures are re-enabled")
+	assert.Equal(t, "disabled", byKey["reset-manually-disabled"].Status, "keys disabled by an admin stay disabled")
+	assert.Equal(t, "disabled", byKey["reset-fatally-disabled"].Status, "keys disabled by a fatal error stay disabled")
+	assert.Empty(t, byKey["reset-auto-disabled"].DisabledReason)
+}
+
+func TestKeyGroups(t *testing.T) {
+	db := setupTestDB(t)
+	assert.NoError(t, db.CreateGeminiKey(&model.GeminiKey{Key: "grouped-gemini-key", Group: "project-a"}))
+	apiKey := &model.APIKey{Key: "grouped-client-key", KeyGroup: "project-a"}
+	assert.NoError(t, db.CreateAPIKey(apiKey))
+
+	active, err := db.LoadActiveGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+	assert.Equal(t, "project-a", active[0].Group)
+
+	fetched, err := db.FindAPIKeyByKey("grouped-client-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "project-a", fetched.KeyGroup)
+}
+
+func TestGeminiKeyProjectLabel(t *testing.T) {
+	db := setupTestDB(t)
+	key := &model.GeminiKey{Key: "labelled-gemini-key", ProjectLabel: "project-foo"}
+	assert.NoError(t, db.CreateGeminiKey(key))
+
+	fetched, err := db.GetGeminiKey(key.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "project-foo", fetched.ProjectLabel)
+
+	fetched.ProjectLabel = "project-bar"
+	assert.NoError(t, db.UpdateGeminiKey(fetched))
+
+	listed, _, err := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+	assert.NoError(t, err)
+	assert.Len(t, listed, 1)
+	assert.Equal(t, "project-bar", listed[0].ProjectLabel)
+
+	active, err := db.LoadActiveGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+	assert.Equal(t, "project-bar", active[0].ProjectLabel)
+}
+
+func TestGeminiKeyModels(t *testing.T) {
+	db := setupTestDB(t)
+	untested := &model.GeminiKey{Key: "untested-gemini-key"}
+	assert.NoError(t, db.CreateGeminiKey(untested))
+	tested := &model.GeminiKey{Key: "tested-gemini-key"}
+	assert.NoError(t, db.CreateGeminiKey(tested))
+
+	tested.Models = []string{"gemini-2.0-flash", "gemini-2.5-pro"}
+	assert.NoError(t, db.UpdateGeminiKey(tested))
+
+	fetched, err := db.GetGeminiKey(tested.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gemini-2.0-flash", "gemini-2.5-pro"}, fetched.Models)
+
+	fetched, err = db.GetGeminiKey(untested.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, fetched.Models)
+}
+
+func TestUpdateGeminiKeyState(t *testing.T) {
+	db := setupTestDB(t)
+
+	t.Run("writes only the health state", func(t *testing.T) {
+		key := &model.GeminiKey{Key: "state-key", Status: "active", DailyQuota: 100, ProjectLabel: "project-foo"}
+		assert.NoError(t, db.CreateGeminiKey(key))
+		assert.NoError(t, db.BatchIncrementGeminiTokenUsage(map[string]int64{"state-key": 50}))
+
+		// A stale in-memory copy, e.g. held by the key manager while an admin edits the key.
+		stale := *key
+		stale.Key = "old-secret"
+		stale.DailyQuota = 0
+		stale.ProjectLabel = ""
+		stale.TokenUsage = 500
+		stale.FailureCount = 2
+		stale.Status = "disabled"
+		stale.RevivalFailures = 1
+		stale.Models = []string{"gemini-2.5-pro"}
+		assert.NoError(t, db.UpdateGeminiKeyState(&stale))
+
+		fetched, err := db.GetGeminiKey(key.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, fetched.FailureCount)
+		assert.Equal(t, "disabled", fetched.Status)
+		assert.Equal(t, 1, fetched.RevivalFailures)
+		assert.Equal(t, []string{"gemini-2.5-pro"}, fetched.Models)
+		assert.Equal(t, "state-key", fetched.Key)
+		assert.Equal(t, int64(100), fetched.DailyQuota)
+		assert.Equal(t, "project-foo", fetched.ProjectLabel)
+		assert.Equal(t, int64(50), fetched.TokenUsage)
+	})
+
+	t.Run("does not bring back deleted keys", func(t *testing.T) {
+		deleted := &model.GeminiKey{Key: "deleted-state-key", Status: "active"}
+		assert.NoError(t, db.CreateGeminiKey(deleted))
+		assert.NoError(t, db.DeleteGeminiKey(deleted.ID))
+		purged := &model.GeminiKey{Key: "purged-state-key", Status: "active"}
+		assert.NoError(t, db.CreateGeminiKey(purged))
+		assert.NoError(t, db.PurgeGeminiKey(purged.ID))
+
+		deleted.FailureCount = 1
+		assert.NoError(t, db.UpdateGeminiKeyState(deleted))
+		purged.FailureCount = 1
+		assert.NoError(t, db.UpdateGeminiKeyState(purged))
+
+		_, err := db.GetGeminiKey(deleted.ID)
+		assert.ErrorIs(t, err, ErrGeminiKeyNotFound)
+		_, err = db.GetGeminiKey(purged.ID)
+		assert.ErrorIs(t, err, ErrGeminiKeyNotFound)
+		trash, err := db.ListDeletedGeminiKeys()
+		assert.NoError(t, err)
+		assert.Len(t, trash, 1)
+	})
+}
+
 func TestIncrementAPIKeyUsageCount(t *testing.T) {
 	db := setupTestDB(t)
 	key := &model.APIKey{Key: "api-usage-key"}
@@ -219,6 +727,43 @@ func TestIncrementAPIKeyUsageCount(t *testing.T) {
 
 	fetchedKey, _ := db.GetAPIKey(key.ID)
 	assert.Equal(t, 1, fetchedKey.UsageCount)
+	assert.Equal(t, 1, fetchedKey.UsageThisMonth)
+}
+
+func TestResetMonthlyAPIUsage(t *testing.T) {
+	db := setupTestDB(t)
+	key := &model.APIKey{Key: "monthly-usage-key", MonthlyQuota: 5, UsageThisMonth: 5, UsageCount: 7}
+	assert.NoError(t, db.CreateAPIKey(key))
+
+	assert.NoError(t, db.ResetMonthlyAPIUsage())
+
+	fetched, _ := db.GetAPIKey(key.ID)
+	assert.Equal(t, 0, fetched.UsageThisMonth)
+	assert.Equal(t, 7, fetched.UsageCount, "the total usage count is kept")
+}
+
+func TestFindKeysBySuffix(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.BatchAddGeminiKeys([]string{"AIza-one-Xy_9", "AIza-two-XyZ9", "AIza-three-abcd"}, "active")
+	assert.NoError(t, err)
+	assert.NoError(t, db.CreateAPIKey(&model.APIKey{Key: "client-a-1234", Permissions: "all"}))
+	assert.NoError(t, db.CreateAPIKey(&model.APIKey{Key: "client-b-5678", Permissions: "all"}))
+
+	keys, err := db.FindGeminiKeysBySuffix("Xy_9")
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1, "'_' must match literally") {
+		assert.Equal(t, "AIza-one-Xy_9", keys[0].Key)
+	}
+
+	keys, err = db.FindGeminiKeysBySuffix("missing")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	apiKeys, err := db.FindAPIKeysBySuffix("1234")
+	assert.NoError(t, err)
+	if assert.Len(t, apiKeys, 1) {
+		assert.Equal(t, "client-a-1234", apiKeys[0].Key)
+	}
 }
 
 func TestUpdateGeminiKeyStatus(t *testing.T) {
@@ -249,10 +794,38 @@ func TestFindAPIKeyByKey(t *testing.T) {
 
 func TestNewService_InvalidDSN(t *testing.T) {
 	_, err := NewService(config.DatabaseConfig{
-		Type: "sqlite",
-		DSN:  "/invalid/path/to/db",
+		Type:           "sqlite",
+		DSN:            "/invalid/path/to/db",
+		ConnectRetries: -1,
+	})
+	assert.Error(t, err)
+}
+
+func TestNewService_ConnectRetries(t *testing.T) {
+	originalMin, originalMax := connectMinBackoff, connectMaxBackoff
+	connectMinBackoff, connectMaxBackoff = time.Millisecond, 2*time.Millisecond
+	t.Cleanup(func() { connectMinBackoff, connectMaxBackoff = originalMin, originalMax })
+
+	start := time.Now()
+	_, err := NewService(config.DatabaseConfig{
+		Type:           "sqlite",
+		DSN:            "/invalid/path/to/db",
+		ConnectRetries: 3,
 	})
 	assert.Error(t, err)
+	// 1ms + 2ms + 2ms of backoff between the four attempts.
+	assert.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond)
+}
+
+func TestPing(t *testing.T) {
+	service, err := NewService(config.DatabaseConfig{Type: "sqlite", DSN: filepath.Join(t.TempDir(), "ping.db")})
+	assert.NoError(t, err)
+	assert.NoError(t, service.Ping())
+
+	sqlDB, err := service.(*gormService).db.DB()
+	assert.NoError(t, err)
+	sqlDB.Close()
+	assert.Error(t, service.Ping())
 }
 
 func TestUpdateGeminiKeyStatus_NotFound(t *testing.T) {
@@ -276,17 +849,39 @@ func TestGetGeminiKey_NotFound(t *testing.T) {
 	assert.Equal(t, ErrGeminiKeyNotFound, err)
 }
 
+func TestBatchAddGeminiKeys_PendingStatus(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.BatchAddGeminiKeys([]string{"pending-key"}, "pending")
+	assert.NoError(t, err)
+	_, err = db.BatchAddGeminiKeys([]string{"active-key"}, "active")
+	assert.NoError(t, err)
+
+	keys, total, err := db.ListGeminiKeys(1, 10, "pending", 0, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "pending-key", keys[0].Key)
+
+	active, err := db.LoadActiveGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+	assert.Equal(t, "active-key", active[0].Key)
+}
+
 func TestBatchAddGeminiKeys_Conflict(t *testing.T) {
 	db := setupTestDB(t)
 	keys := []string{"conflict-key", "conflict-key"}
 
-	err := db.BatchAddGeminiKeys(keys)
+	added, err := db.BatchAddGeminiKeys(keys, "active")
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), added)
 
-	allKeys, total, _ := db.ListGeminiKeys(1, 10, "all", 0)
-	assert.Len(t, allKeys, 1)
-	assert.Equal(t, int64(1), total)
-	assert.Equal(t, "conflict-key", allKeys[0].Key)
+	added, err = db.BatchAddGeminiKeys([]string{"conflict-key", "new-key"}, "active")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), added, "keys already stored are not counted")
+
+	allKeys, total, _ := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+	assert.Len(t, allKeys, 2)
+	assert.Equal(t, int64(2), total)
 }
 
 func TestListGeminiKeys_EmptyFilter(t *testing.T) {
@@ -294,8 +889,203 @@ func TestListGeminiKeys_EmptyFilter(t *testing.T) {
 	db.CreateGeminiKey(&model.GeminiKey{Key: "key-1", Status: "active"})
 	db.CreateGeminiKey(&model.GeminiKey{Key: "key-2", Status: "disabled"})
 
-	keys, total, err := db.ListGeminiKeys(1, 10, "", 0)
+	keys, total, err := db.ListGeminiKeys(1, 10, "", 0, "", "")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, int64(2), total)
+}
+
+func TestListAPIKeysPaged(t *testing.T) {
+	db := setupTestDB(t)
+	for i := 1; i <= 5; i++ {
+		status := "active"
+		if i%2 == 0 {
+			status = "disabled"
+		}
+		assert.NoError(t, db.CreateAPIKey(&model.APIKey{Key: fmt.Sprintf("client-key-%d", i), Status: status}))
+	}
+
+	t.Run("paginates newest first", func(t *testing.T) {
+		keys, total, err := db.ListAPIKeysPaged(1, 2, "all")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+		assert.Len(t, keys, 2)
+		assert.Equal(t, "client-key-5", keys[0].Key)
+
+		keys, _, err = db.ListAPIKeysPaged(3, 2, "all")
+		assert.NoError(t, err)
+		assert.Len(t, keys, 1)
+		assert.Equal(t, "client-key-1", keys[0].Key)
+	})
+
+	t.Run("filters by status", func(t *testing.T) {
+		keys, total, err := db.ListAPIKeysPaged(1, 10, "disabled")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Len(t, keys, 2)
+		for _, k := range keys {
+			assert.Equal(t, "disabled", k.Status)
+		}
+	})
+
+	t.Run("empty filter returns all", func(t *testing.T) {
+		_, total, err := db.ListAPIKeysPaged(1, 10, "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+	})
+}
+
+func TestSoftDeleteAndRestoreGeminiKey(t *testing.T) {
+	db := setupTestDB(t)
+	key := &model.GeminiKey{Key: "trash-key", Status: "active"}
+	db.CreateGeminiKey(key)
+
+	// Soft delete hides the key from normal queries but keeps it in the trash.
+	err := db.DeleteGeminiKey(key.ID)
 	assert.NoError(t, err)
+	_, err = db.GetGeminiKey(key.ID)
+	assert.Equal(t, ErrGeminiKeyNotFound, err)
+
+	deleted, err := db.ListDeletedGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, "trash-key", deleted[0].Key)
+
+	// Restore brings it back.
+	err = db.RestoreGeminiKey(key.ID)
+	assert.NoError(t, err)
+	restored, err := db.GetGeminiKey(key.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "trash-key", restored.Key)
+	deleted, _ = db.ListDeletedGeminiKeys()
+	assert.Len(t, deleted, 0)
+
+	// Restoring a key that is not in the trash fails.
+	err = db.RestoreGeminiKey(key.ID)
+	assert.Equal(t, ErrGeminiKeyNotFound, err)
+}
+
+func TestDeleteAndReAddGeminiKey(t *testing.T) {
+	db := setupTestDB(t)
+	created := &model.GeminiKey{Key: "re-added-key", Status: "active", FailureCount: 3}
+	assert.NoError(t, db.CreateGeminiKey(created))
+	imported := &model.GeminiKey{Key: "re-imported-key", Status: "active"}
+	assert.NoError(t, db.CreateGeminiKey(imported))
+	assert.NoError(t, db.BatchDeleteGeminiKeys([]uint{created.ID, imported.ID}))
+
+	// Creating a trashed key again replaces the trash entry with a fresh key.
+	again := &model.GeminiKey{Key: "re-added-key", Status: "active"}
+	assert.NoError(t, db.CreateGeminiKey(again))
+	fetched, err := db.GetGeminiKey(again.ID)
+	assert.NoError(t, err)
+	assert.Zero(t, fetched.FailureCount)
+
+	// Importing one counts it as added, not skipped.
+	added, err := db.BatchAddGeminiKeys([]string{"re-imported-key"}, "active")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), added)
+
+	active, err := db.LoadActiveGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, active, 2)
+	deleted, err := db.ListDeletedGeminiKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+}
+
+func TestBatchDeleteAndRestoreGeminiKeys(t *testing.T) {
+	db := setupTestDB(t)
+	_, _ = db.BatchAddGeminiKeys([]string{"batch-trash-1", "batch-trash-2"}, "active")
+	keys, _, _ := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+
+	var ids []uint
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	err := db.BatchDeleteGeminiKeys(ids)
+	assert.NoError(t, err)
+
+	deleted, err := db.ListDeletedGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+
+	for _, id := range ids {
+		assert.NoError(t, db.RestoreGeminiKey(id))
+	}
+	keys, total, _ := db.ListGeminiKeys(1, 10, "all", 0, "", "")
 	assert.Len(t, keys, 2)
 	assert.Equal(t, int64(2), total)
 }
+
+func TestPurgeGeminiKey(t *testing.T) {
+	db := setupTestDB(t)
+	key := &model.GeminiKey{Key: "purge-key", Status: "active"}
+	db.CreateGeminiKey(key)
+	db.DeleteGeminiKey(key.ID)
+
+	err := db.PurgeGeminiKey(key.ID)
+	assert.NoError(t, err)
+
+	deleted, _ := db.ListDeletedGeminiKeys()
+	assert.Len(t, deleted, 0)
+	err = db.RestoreGeminiKey(key.ID)
+	assert.Equal(t, ErrGeminiKeyNotFound, err)
+}
+
+func TestPurgeDeletedGeminiKeys(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.BatchAddGeminiKeys([]string{"trash-1", "trash-2", "kept"}, "active")
+	assert.NoError(t, err)
+	keys, _, err := db.ListGeminiKeys(1, 10, "", 0, "", "")
+	assert.NoError(t, err)
+	for _, k := range keys {
+		if k.Key != "kept" {
+			assert.NoError(t, db.DeleteGeminiKey(k.ID))
+		}
+	}
+
+	purged, err := db.PurgeDeletedGeminiKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	deleted, err := db.ListDeletedGeminiKeys()
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+	active, err := db.LoadActiveGeminiKeys()
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+	assert.Equal(t, "kept", active[0].Key)
+
+	purged, err = db.PurgeDeletedGeminiKeys()
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+}
+
+func TestStreamGeminiKeys(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := db.BatchAddGeminiKeys([]string{"key-1", "key-2", "key-3"}, "active")
+	assert.NoError(t, err)
+	keys, _, _ := db.ListGeminiKeys(1, 10, "all", 0, "", "")
+	for _, k := range keys {
+		if k.Key == "key-2" {
+			assert.NoError(t, db.DeleteGeminiKey(k.ID))
+		}
+	}
+
+	var streamed []string
+	err = db.StreamGeminiKeys(func(k model.GeminiKey) error {
+		streamed = append(streamed, k.Key)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key-1", "key-3"}, streamed)
+
+	stopErr := fmt.Errorf("stop")
+	calls := 0
+	err = db.StreamGeminiKeys(func(k model.GeminiKey) error {
+		calls++
+		return stopErr
+	})
+	assert.Equal(t, stopErr, err)
+	assert.Equal(t, 1, calls)
+}
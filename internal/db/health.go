@@ -0,0 +1,103 @@
+package db
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Backoff bounds for reconnection attempts after a failed health check.
+const (
+	healthCheckMinBackoff = 1 * time.Second
+	healthCheckMaxBackoff = 30 * time.Second
+)
+
+// HealthChecker pings the database in the background and remembers the result for readiness
+// checks. While the database is unreachable it pings again with exponential backoff instead of
+// waiting a full interval; database/sql replaces broken connections on the next successful dial,
+// so a ping that succeeds is the reconnection.
+type HealthChecker struct {
+	db         Service
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	logger     *slog.Logger
+
+	mu      sync.RWMutex
+	lastErr error
+	checked bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewHealthChecker creates a HealthChecker that pings db every interval while it is healthy.
+func NewHealthChecker(db Service, interval time.Duration, logger *slog.Logger) *HealthChecker {
+	return &HealthChecker{
+		db:         db,
+		interval:   interval,
+		minBackoff: healthCheckMinBackoff,
+		maxBackoff: healthCheckMaxBackoff,
+		logger:     logger.With("component", "db-health"),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start runs the first check immediately and keeps checking in the background until Stop.
+func (h *HealthChecker) Start() {
+	go h.run()
+}
+
+// Stop ends the background checks and waits for them to finish.
+func (h *HealthChecker) Stop() {
+	close(h.stop)
+	<-h.done
+}
+
+func (h *HealthChecker) run() {
+	defer close(h.done)
+
+	backoff := h.minBackoff
+	for {
+		wait := h.interval
+		if err := h.Check(); err != nil {
+			wait = backoff
+			backoff = min(backoff*2, h.maxBackoff)
+		} else {
+			backoff = h.minBackoff
+		}
+
+		select {
+		case <-h.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Check pings the database once, records the result and returns the ping error, if any.
+func (h *HealthChecker) Check() error {
+	err := h.db.Ping()
+
+	h.mu.Lock()
+	wasHealthy := !h.checked || h.lastErr == nil
+	h.lastErr = err
+	h.checked = true
+	h.mu.Unlock()
+
+	switch {
+	case err != nil && wasHealthy:
+		h.logger.Error("Database connection lost, reconnecting with backoff", "error", err)
+	case err == nil && !wasHealthy:
+		h.logger.Info("Database connection restored")
+	}
+	return err
+}
+
+// Err returns the error of the most recent check, or nil if the database was reachable.
+func (h *HealthChecker) Err() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastErr
+}
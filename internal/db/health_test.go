@@ -0,0 +1,83 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDB is a Service whose Ping fails while down is set. Other methods are not used.
+type flakyDB struct {
+	Service
+	mu    sync.Mutex
+	down  bool
+	pings int
+}
+
+func (f *flakyDB) Ping() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings++
+	if f.down {
+		return errors.New("sql: database is closed")
+	}
+	return nil
+}
+
+func (f *flakyDB) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyDB) pingCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pings
+}
+
+func TestHealthChecker(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("check records the ping result", func(t *testing.T) {
+		db := &flakyDB{}
+		h := NewHealthChecker(db, time.Hour, logger)
+
+		assert.NoError(t, h.Check())
+		assert.NoError(t, h.Err())
+
+		db.setDown(true)
+		assert.Error(t, h.Check())
+		assert.Error(t, h.Err())
+
+		db.setDown(false)
+		assert.NoError(t, h.Check())
+		assert.NoError(t, h.Err())
+	})
+
+	t.Run("reconnects with backoff after the database comes back", func(t *testing.T) {
+		db := &flakyDB{down: true}
+		h := NewHealthChecker(db, time.Hour, logger)
+		h.minBackoff, h.maxBackoff = time.Millisecond, 5*time.Millisecond
+		h.Start()
+		defer h.Stop()
+
+		// While down, the checker keeps retrying instead of waiting the hour-long interval.
+		require.Eventually(t, func() bool { return db.pingCount() >= 3 }, time.Second, time.Millisecond)
+		assert.Error(t, h.Err())
+
+		db.setDown(false)
+		require.Eventually(t, func() bool { return h.Err() == nil }, time.Second, time.Millisecond)
+
+		// Once healthy it waits for the interval again.
+		pings := db.pingCount()
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, pings, db.pingCount())
+	})
+}
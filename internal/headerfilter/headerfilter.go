@@ -0,0 +1,78 @@
+// Package headerfilter removes upstream response headers that should not reach clients.
+package headerfilter
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// framingHeaders describe the body itself and are always passed on, since removing them would
+// corrupt the response.
+var framingHeaders = []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"}
+
+// Filter strips response headers by name. Patterns use path.Match syntax, e.g. X-Goog-*, and
+// match header names regardless of case.
+type Filter struct {
+	strip []string
+	allow []string
+}
+
+// New returns a Filter that removes headers matching a strip pattern and, when allow is not
+// empty, every header that matches no allow pattern. It returns nil when both lists are empty,
+// which passes all headers through.
+func New(strip, allow []string) *Filter {
+	if len(strip) == 0 && len(allow) == 0 {
+		return nil
+	}
+	return &Filter{strip: lowerAll(strip), allow: lowerAll(allow)}
+}
+
+// Apply removes the filtered headers from h. A nil Filter leaves h unchanged.
+func (f *Filter) Apply(h http.Header) {
+	if f == nil {
+		return
+	}
+	for name := range h {
+		lower := strings.ToLower(name)
+		if matchesAny(f.strip, lower) || (len(f.allow) > 0 && !matchesAny(f.allow, lower) && !isFraming(name)) {
+			delete(h, name)
+		}
+	}
+}
+
+// ModifyResponse applies f to resp's headers; it has the signature of
+// httputil.ReverseProxy.ModifyResponse.
+func (f *Filter) ModifyResponse(resp *http.Response) error {
+	f.Apply(resp.Header)
+	return nil
+}
+
+// matchesAny reports whether name matches one of patterns; both are lower case.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isFraming reports whether name is one of framingHeaders.
+func isFraming(name string) bool {
+	for _, h := range framingHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerAll returns patterns in lower case, since header names are matched regardless of case.
+func lowerAll(patterns []string) []string {
+	lowered := make([]string, len(patterns))
+	for i, p := range patterns {
+		lowered[i] = strings.ToLower(p)
+	}
+	return lowered
+}
@@ -0,0 +1,71 @@
+package headerfilter
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func upstreamHeader() http.Header {
+	h := make(http.Header)
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", "42")
+	h.Set("Server", "scaffolding on HTTPServer2")
+	h.Set("Alt-Svc", `h3=":443"`)
+	h.Set("X-Goog-Request-Id", "abc")
+	h.Set("X-Goog-Trace", "def")
+	h.Set("Retry-After", "30")
+	return h
+}
+
+func TestNew_EmptyPassesThrough(t *testing.T) {
+	f := New(nil, []string{})
+	assert.Nil(t, f)
+
+	h := upstreamHeader()
+	f.Apply(h)
+	assert.Equal(t, upstreamHeader(), h)
+}
+
+func TestFilter_Strip(t *testing.T) {
+	h := upstreamHeader()
+	New([]string{"server", "Alt-Svc", "x-goog-*"}, nil).Apply(h)
+
+	assert.Empty(t, h.Get("Server"))
+	assert.Empty(t, h.Get("Alt-Svc"))
+	assert.Empty(t, h.Get("X-Goog-Request-Id"))
+	assert.Empty(t, h.Get("X-Goog-Trace"))
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.Equal(t, "30", h.Get("Retry-After"))
+}
+
+func TestFilter_Allow(t *testing.T) {
+	h := upstreamHeader()
+	New(nil, []string{"Retry-After", "X-Goog-Request-*"}).Apply(h)
+
+	assert.Equal(t, "30", h.Get("Retry-After"))
+	assert.Equal(t, "abc", h.Get("X-Goog-Request-Id"))
+	// The headers framing the body are kept even though they are not allowed explicitly.
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.Equal(t, "42", h.Get("Content-Length"))
+	assert.Empty(t, h.Get("Server"))
+	assert.Empty(t, h.Get("Alt-Svc"))
+	assert.Empty(t, h.Get("X-Goog-Trace"))
+}
+
+func TestFilter_StripOverridesAllow(t *testing.T) {
+	h := upstreamHeader()
+	New([]string{"X-Goog-Trace"}, []string{"X-Goog-*"}).Apply(h)
+
+	assert.Equal(t, "abc", h.Get("X-Goog-Request-Id"))
+	assert.Empty(t, h.Get("X-Goog-Trace"))
+	assert.Empty(t, h.Get("Server"))
+}
+
+func TestFilter_ModifyResponse(t *testing.T) {
+	resp := &http.Response{Header: upstreamHeader()}
+	assert.NoError(t, New([]string{"Server"}, nil).ModifyResponse(resp))
+	assert.Empty(t, resp.Header.Get("Server"))
+	assert.Equal(t, `h3=":443"`, resp.Header.Get("Alt-Svc"))
+}
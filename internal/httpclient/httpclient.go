@@ -0,0 +1,28 @@
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+)
+
+// NewTransport creates the http.Transport shared by all upstream requests to the Gemini API.
+// Its connection pool is tuned by the upstream config section; the remaining settings
+// mirror http.DefaultTransport.
+func NewTransport(cfg config.UpstreamConfig) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -0,0 +1,26 @@
+package httpclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransport(t *testing.T) {
+	cfg := config.UpstreamConfig{
+		MaxIdleConns:        200,
+		MaxIdleConnsPerHost: 50,
+		IdleConnTimeout:     45 * time.Second,
+	}
+
+	transport := NewTransport(cfg)
+
+	assert.Equal(t, 200, transport.MaxIdleConns)
+	assert.Equal(t, 50, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 45*time.Second, transport.IdleConnTimeout)
+	assert.True(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.Proxy)
+}
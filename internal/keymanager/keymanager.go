@@ -2,20 +2,69 @@ package keymanager
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/coordination"
 	"github.com/ubuygold/gogemini/internal/db"
 	"github.com/ubuygold/gogemini/internal/model"
+	"github.com/ubuygold/gogemini/internal/notify"
 )
 
+// defaultRateLimitCooldown is how long a key is held out of rotation after a 429 when
+// proxy.rate_limit_cooldown is not configured.
+const defaultRateLimitCooldown = 1 * time.Minute
+
+// defaultSessionTTL is how long an idle session stays pinned to its key.
+const defaultSessionTTL = 30 * time.Minute
+
+// usageFlushInterval and usageFlushSize bound how long and how many usage
+// increments are buffered before being written to the database.
+const (
+	usageFlushInterval = 1 * time.Second
+	usageFlushSize     = 500
+)
+
+// overflowWarnInterval limits how often a full usage queue is logged.
+const overflowWarnInterval = 1 * time.Minute
+
+// lowKeysWarnInterval limits how often a low available key count is logged.
+const lowKeysWarnInterval = 5 * time.Minute
+
+// ErrShuttingDown is returned by GetNextKey once the manager has started draining.
+var ErrShuttingDown = errors.New("key manager is shutting down")
+
+// ErrPaused is returned by GetNextKey while key selection is paused, see SetPaused.
+var ErrPaused = errors.New("key selection is paused")
+
+// ErrNoKeys is returned by GetNextKey when there are no active keys to select from, or none in
+// the requested group.
+var ErrNoKeys = errors.New("no active Gemini keys available")
+
+// KeysDisabledError is returned by GetNextKey when every key it could select from is temporarily
+// disabled after repeated failures.
+type KeysDisabledError struct {
+	// RetryAfter is how long until the first of the keys is re-enabled, or zero when only a
+	// revival check or an operator can bring them back.
+	RetryAfter time.Duration
+}
+
+func (e *KeysDisabledError) Error() string {
+	return "all available Gemini keys are temporarily disabled"
+}
+
 // HTTPClient defines the interface for making HTTP requests.
 // This allows for mocking in tests.
 type HTTPClient interface {
@@ -25,14 +74,26 @@ type HTTPClient interface {
 // Manager defines the interface for managing Gemini API keys.
 // This allows for mocking in tests and decouples the admin handler from the concrete implementation.
 type Manager interface {
-	GetNextKey() (string, error)
-	HandleKeyFailure(key string)
+	GetNextKey(group, model string) (string, error)
+	GetKeyForSession(sessionID, group, model string) (string, error)
+	HandleKeyFailure(key string, statusCode int, errorBody string)
 	HandleKeySuccess(key string)
 	ReviveDisabledKeys()
 	CheckAllKeysHealth()
 	GetAvailableKeyCount() int
-	TestKeyByID(id uint) error
+	AvailabilityError() error
+	SuspendedError() error
+	TestKeyByID(ctx context.Context, id uint) error
 	TestAllKeysAsync()
+	TestKeysAsync(ids []uint)
+	ValidateRawKey(key string) error
+	ReloadKeys() (int, error)
+	Snapshot() []KeyRuntimeInfo
+	LowAvailableKeyChecks() int64
+	PeekNextKey() (string, error)
+	SetPaused(paused bool)
+	IsPaused() bool
+	Drain()
 	Close()
 }
 
@@ -44,6 +105,107 @@ type managedKey struct {
 	Disabled bool
 	// DisabledAt records when the key was disabled.
 	DisabledAt time.Time
+	// CooldownUntil holds the key out of rotation until this time after a rate limit,
+	// unless every usable key is cooling down.
+	CooldownUntil time.Time
+	// recentOutcomes is a ring of the latest request results (true for success) used for the
+	// success ratio; recentNext is the oldest entry once the ring is full.
+	recentOutcomes  []bool
+	recentNext      int
+	recentSuccesses int
+	// usageCredit accumulates the fractional usage of requests weighted below 1, see markUsedLocked.
+	usageCredit float64
+}
+
+// logAttrs identifies the key in log lines by its suffix and, when set, its project label.
+func (mk *managedKey) logAttrs() []any {
+	if mk.ProjectLabel == "" {
+		return []any{"key_suffix", safeKeySuffix(mk.Key)}
+	}
+	return []any{"key_suffix", safeKeySuffix(mk.Key), "project_label", mk.ProjectLabel}
+}
+
+// recordOutcome adds a request result to the key's rolling window of the last window requests.
+func (mk *managedKey) recordOutcome(success bool, window int) {
+	if window <= 0 {
+		return
+	}
+	if len(mk.recentOutcomes) < window {
+		mk.recentOutcomes = append(mk.recentOutcomes, success)
+	} else {
+		if mk.recentOutcomes[mk.recentNext] {
+			mk.recentSuccesses--
+		}
+		mk.recentOutcomes[mk.recentNext] = success
+		mk.recentNext = (mk.recentNext + 1) % len(mk.recentOutcomes)
+	}
+	if success {
+		mk.recentSuccesses++
+	}
+}
+
+// successRatio returns the share of successful requests in the rolling window and how many
+// requests it holds.
+func (mk *managedKey) successRatio() (float64, int) {
+	samples := len(mk.recentOutcomes)
+	if samples == 0 {
+		return 0, 0
+	}
+	return float64(mk.recentSuccesses) / float64(samples), samples
+}
+
+// resetOutcomes empties the rolling window so a re-enabled key is judged on fresh requests.
+func (mk *managedKey) resetOutcomes() {
+	mk.recentOutcomes = nil
+	mk.recentNext = 0
+	mk.recentSuccesses = 0
+}
+
+// available reports whether the key can currently be handed out.
+func (mk *managedKey) available(now time.Time) bool {
+	return !mk.Disabled && !now.Before(mk.CooldownUntil) && !mk.overQuota()
+}
+
+// inGroup reports whether the key may serve requests for group; an empty group matches every key.
+func (mk *managedKey) inGroup(group string) bool {
+	return group == "" || mk.Group == group
+}
+
+// servesModel reports whether the key's last test listed model. Keys that have not been tested
+// yet report false.
+func (mk *managedKey) servesModel(model string) bool {
+	model = strings.TrimPrefix(model, "models/")
+	for _, m := range mk.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// lacksModel reports whether the key is known not to serve model, i.e. it has been tested and
+// model was missing from its model list.
+func (mk *managedKey) lacksModel(model string) bool {
+	return model != "" && mk.Models != nil && !mk.servesModel(model)
+}
+
+// coolingDown reports whether the key is usable apart from a rate-limit cooldown.
+func (mk *managedKey) coolingDown(now time.Time) bool {
+	return !mk.Disabled && now.Before(mk.CooldownUntil) && !mk.overQuota()
+}
+
+// overQuota reports whether the key has used up its daily quota.
+func (mk *managedKey) overQuota() bool {
+	return mk.DailyQuota > 0 && mk.UsageToday >= mk.DailyQuota
+}
+
+// disableThreshold returns the failure count at which the key is disabled: its own
+// DisableThreshold, or global when it has none.
+func (mk *managedKey) disableThreshold(global int) int {
+	if mk.DisableThreshold > 0 {
+		return mk.DisableThreshold
+	}
+	return global
 }
 
 // GetKey returns the key string.
@@ -61,6 +223,37 @@ func (mk *managedKey) GetFailureCount() int {
 	return mk.FailureCount
 }
 
+// KeyRuntimeInfo is the in-memory view of a managed key. It never contains the full secret.
+type KeyRuntimeInfo struct {
+	ID           uint   `json:"id"`
+	KeySuffix    string `json:"key_suffix"`
+	ProjectLabel string `json:"project_label"`
+	Group        string `json:"group"`
+	Status       string `json:"status"`
+	UsageCount   int64  `json:"usage_count"`
+	UsageToday   int64  `json:"usage_today"`
+	TokenUsage   int64  `json:"token_usage"`
+	DailyQuota   int64  `json:"daily_quota"`
+	FailureCount int    `json:"failure_count"`
+	// DisableThreshold is the failure count at which the key is disabled.
+	DisableThreshold int        `json:"disable_threshold"`
+	Disabled         bool       `json:"disabled"`
+	DisabledAt       *time.Time `json:"disabled_at"`
+	CooldownUntil    *time.Time `json:"cooldown_until"`
+	Available        bool       `json:"available"`
+	// SuccessRatio is the share of successful recent requests, nil until the key has served one.
+	SuccessRatio *float64 `json:"success_ratio"`
+	Samples      int      `json:"samples"`
+	// Models lists the models the key could access when last tested, nil if it has not been tested.
+	Models []string `json:"models"`
+}
+
+// sessionPin records the key a session is pinned to and when the pin lapses.
+type sessionPin struct {
+	key       string
+	expiresAt time.Time
+}
+
 type KeyManager struct {
 	mutex            sync.Mutex
 	keys             []*managedKey
@@ -71,12 +264,50 @@ type KeyManager struct {
 	wg               sync.WaitGroup
 	disableThreshold int
 	httpClient       HTTPClient
+	// keyTestTimeout bounds each key validation request; zero means config.DefaultKeyTestTimeout.
+	keyTestTimeout time.Duration
+	// closing is cancelled by Close to abort key validations still in flight; nil in tests.
+	closing          context.Context
+	cancelClosing    context.CancelFunc
 	revivalInterval  time.Duration
+	reloadInterval   time.Duration
+	cooldownDuration time.Duration
+	// minSuccessRatio disables keys whose success ratio over minSamples requests drops below it.
+	minSuccessRatio float64
+	minSamples      int
+	// temporaryDisableDuration re-enables disabled keys after this long; zero disables keys until revived.
+	temporaryDisableDuration time.Duration
+	sessions                 map[string]sessionPin
+	sessionTTL               time.Duration
+	draining                 atomic.Bool
+	paused                   atomic.Bool
+	closed                   bool // Set by Close, under the mutex, before updateQueue is closed
+	skipUsageWrites          bool // Keep usage counts in memory only, see config.ProxyConfig.TrackUsage
+	healthCheckURL           string
+	selector                 keySelector
+	// fatalErrorPatterns disable a key immediately when found in an upstream error body.
+	fatalErrorPatterns []string
+	// coordinator shares disables and cooldowns with other instances; nil keeps them local.
+	coordinator coordination.Backend
+	instanceID  string
+	// notifier reports disables, revivals and running out of keys; nil sends nothing.
+	notifier *notify.Webhook
+	// usageOverflow holds usage increments that didn't fit in updateQueue until the next flush.
+	usageOverflow     map[string]int64
+	overflowedUpdates atomic.Int64
+	lastOverflowWarn  time.Time
+	// pendingTokens holds token usage recorded since the last flush, see RecordTokenUsage.
+	pendingTokens map[string]int64
+	// minAvailableKeys is the available key count below which checkAvailableKeys warns; zero disables it.
+	minAvailableKeys int
+	lowKeyChecks     atomic.Int64
+	lastLowKeysWarn  time.Time
 	syncDBUpdates    bool // For testing purposes
 }
 
 // NewKeyManager creates a new KeyManager.
-func NewKeyManager(dbService db.Service, cfg *config.Config, logger *slog.Logger) (*KeyManager, error) {
+// Health-check requests are sent through the given transport.
+func NewKeyManager(dbService db.Service, cfg *config.Config, transport http.RoundTripper, logger *slog.Logger) (*KeyManager, error) {
 	initialKeys, err := dbService.LoadActiveGeminiKeys()
 	if err != nil {
 		return nil, fmt.Errorf("failed to perform initial load of Gemini keys: %w", err)
@@ -90,17 +321,44 @@ func NewKeyManager(dbService db.Service, cfg *config.Config, logger *slog.Logger
 		managedKeys[i] = &managedKey{GeminiKey: key}
 	}
 
+	cooldownDuration := cfg.Proxy.RateLimitCooldown
+	if cooldownDuration <= 0 {
+		cooldownDuration = defaultRateLimitCooldown
+	}
+	minSamples := cfg.Proxy.MinSamples
+	if minSamples <= 0 {
+		minSamples = config.DefaultMinSamples
+	}
+
 	km := &KeyManager{
-		keys:             managedKeys,
-		logger:           logger.With("component", "keymanager"),
-		db:               dbService,
-		stopChan:         make(chan struct{}),
-		updateQueue:      make(chan string, 100), // Buffered channel
-		disableThreshold: cfg.Proxy.DisableKeyThreshold,
-		httpClient: &http.Client{
-			Timeout: 60 * time.Second, // Generous timeout for the check
-		},
-		revivalInterval: 5 * time.Minute, // Cooldown before a key can be revived
+		keys:                     managedKeys,
+		logger:                   logger.With("component", "keymanager"),
+		db:                       dbService,
+		stopChan:                 make(chan struct{}),
+		updateQueue:              make(chan string, 100), // Buffered channel
+		disableThreshold:         cfg.Proxy.DisableKeyThreshold,
+		httpClient:               &http.Client{Transport: transport},
+		keyTestTimeout:           cfg.Proxy.KeyTestTimeout,
+		revivalInterval:          5 * time.Minute, // Cooldown before a key can be revived
+		reloadInterval:           cfg.Scheduler.KeyReloadDuration(),
+		cooldownDuration:         cooldownDuration,
+		minSuccessRatio:          cfg.Proxy.MinSuccessRatio,
+		minSamples:               minSamples,
+		temporaryDisableDuration: cfg.Proxy.TemporaryDisableDuration,
+		sessions:                 make(map[string]sessionPin),
+		sessionTTL:               defaultSessionTTL,
+		skipUsageWrites:          !cfg.Proxy.UsageTrackingEnabled(),
+		healthCheckURL:           cfg.Upstream.HealthCheckURL(),
+		selector:                 newKeySelector(cfg.Proxy.SelectionStrategy),
+		minAvailableKeys:         cfg.Proxy.MinAvailableKeysWarn,
+		fatalErrorPatterns:       cfg.Proxy.FatalErrorPatterns,
+		instanceID:               rand.Text(),
+		notifier:                 notify.NewWebhook(cfg.Notifications.WebhookURL, logger),
+	}
+	km.closing, km.cancelClosing = context.WithCancel(context.Background())
+	if cfg.Redis.Enabled() {
+		km.coordinator = coordination.NewRedis(cfg.Redis)
+		go km.subscribeEvents()
 	}
 
 	// Start a background goroutine to periodically update the keys from DB
@@ -113,48 +371,378 @@ func NewKeyManager(dbService db.Service, cfg *config.Config, logger *slog.Logger
 	return km, nil
 }
 
-// GetNextKey selects the key with the lowest usage count.
-func (km *KeyManager) GetNextKey() (string, error) {
+// GetNextKey selects the next key using the configured selection strategy. A non-empty group
+// restricts the selection to keys in that group; an empty group selects among all keys.
+// A non-empty model prefers keys known to serve that model, see nextKeyLocked.
+func (km *KeyManager) GetNextKey(group, model string) (string, error) {
+	return km.GetNextKeyWeighted(group, model, 1)
+}
+
+// GetNextKeyWeighted is GetNextKey for a request that counts as weight requests toward the
+// selected key's usage, so cheap calls such as countTokens skew the balancing less.
+func (km *KeyManager) GetNextKeyWeighted(group, model string, weight float64) (string, error) {
+	if km.draining.Load() {
+		return "", ErrShuttingDown
+	}
+	if km.paused.Load() {
+		return "", ErrPaused
+	}
+
 	km.mutex.Lock()
 	defer km.mutex.Unlock()
 
+	return km.nextKeyLocked(group, model, weight)
+}
+
+// GetRetryKey is GetNextKey for another attempt at a request that already held a key. Unlike
+// GetNextKey it keeps handing out keys while the manager drains, so requests that were in
+// flight when Drain was called can still be retried. When sessionID is set, the session is
+// re-pinned to the returned key so the rest of the conversation follows the retry.
+func (km *KeyManager) GetRetryKey(sessionID, group, model string) (string, error) {
+	if km.paused.Load() {
+		return "", ErrPaused
+	}
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	key, err := km.nextKeyLocked(group, model, 1)
+	if err != nil {
+		return "", err
+	}
+	if sessionID != "" {
+		km.sessions[sessionID] = sessionPin{key: key, expiresAt: time.Now().Add(km.sessionTTL)}
+	}
+	return key, nil
+}
+
+// GetKeyForSession returns the key pinned to sessionID, so multi-turn conversations keep
+// hitting the same key. Unknown or expired sessions, and sessions whose key is no longer
+// available, not in group or known not to serve model, are (re-)pinned to the next selected
+// key. An empty sessionID behaves like GetNextKey.
+func (km *KeyManager) GetKeyForSession(sessionID, group, model string) (string, error) {
+	return km.GetKeyForSessionWeighted(sessionID, group, model, 1)
+}
+
+// GetKeyForSessionWeighted is GetKeyForSession with the usage weight of GetNextKeyWeighted.
+func (km *KeyManager) GetKeyForSessionWeighted(sessionID, group, model string, weight float64) (string, error) {
+	if sessionID == "" {
+		return km.GetNextKeyWeighted(group, model, weight)
+	}
+	if km.draining.Load() {
+		return "", ErrShuttingDown
+	}
+	if km.paused.Load() {
+		return "", ErrPaused
+	}
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	now := time.Now()
+	if pin, ok := km.sessions[sessionID]; ok && now.Before(pin.expiresAt) {
+		for _, k := range km.keys {
+			if k.Key == pin.key && k.inGroup(group) && k.available(now) && !k.lacksModel(model) {
+				km.markUsedLocked(k, weight)
+				km.sessions[sessionID] = sessionPin{key: k.Key, expiresAt: now.Add(km.sessionTTL)}
+				return k.Key, nil
+			}
+		}
+		km.logger.Debug("Session key unavailable, re-pinning", "key_suffix", safeKeySuffix(pin.key))
+	}
+
+	key, err := km.nextKeyLocked(group, model, weight)
+	if err != nil {
+		return "", err
+	}
+	km.sessions[sessionID] = sessionPin{key: key, expiresAt: now.Add(km.sessionTTL)}
+	return key, nil
+}
+
+// nextKeyLocked picks an available key in group using the configured selection strategy.
+// When model is set, keys whose last test listed the model are tried first, then keys that
+// have not been tested yet, and only then keys known not to serve it, so a stale or partial
+// model list never makes a request fail outright. The selected key's usage grows by weight.
+// The caller must hold the lock.
+func (km *KeyManager) nextKeyLocked(group, model string, weight float64) (string, error) {
+	chosen, err := km.pickKeyLocked(group, model)
+	if err != nil {
+		return "", err
+	}
+	keyStr := chosen.Key
+	km.markUsedLocked(chosen, weight)
+	return keyStr, nil
+}
+
+// pickKeyLocked selects the key nextKeyLocked would hand out without recording its use.
+func (km *KeyManager) pickKeyLocked(group, model string) (*managedKey, error) {
 	if len(km.keys) == 0 {
-		return "", fmt.Errorf("no active Gemini keys available")
+		return nil, ErrNoKeys
 	}
 
-	// Find the first key that is not disabled
-	var keyToUse *managedKey
-	var keyIndex int = -1
-	for i, k := range km.keys {
-		if !k.Disabled {
-			keyToUse = k
-			keyIndex = i
-			break
+	candidates := km.keys
+	if group != "" {
+		candidates = make([]*managedKey, 0, len(km.keys))
+		for _, k := range km.keys {
+			if k.inGroup(group) {
+				candidates = append(candidates, k)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("%w in group %q", ErrNoKeys, group)
+		}
+	}
+
+	now := time.Now()
+	km.reenableExpiredLocked(now)
+
+	selector := km.selector
+	if selector == nil {
+		selector = lowestUsageSelector{}
+	}
+	chosen := selectForModel(selector, candidates, model, now)
+	if chosen == nil {
+		chosen = soonestCooledKey(candidates, now)
+	}
+	if chosen == nil {
+		return nil, &KeysDisabledError{RetryAfter: km.soonestReenableLocked(candidates, now)}
+	}
+	return chosen, nil
+}
+
+// soonestReenableLocked returns how long until the first temporarily disabled key in keys is
+// re-enabled by reenableExpiredLocked, or zero when none will be. The caller must hold the lock.
+func (km *KeyManager) soonestReenableLocked(keys []*managedKey, now time.Time) time.Duration {
+	if km.temporaryDisableDuration <= 0 {
+		return 0
+	}
+	var soonest time.Duration
+	for _, k := range keys {
+		if !k.Disabled || k.Status == "disabled" {
+			continue
+		}
+		if wait := k.DisabledAt.Add(km.temporaryDisableDuration).Sub(now); soonest == 0 || wait < soonest {
+			soonest = max(wait, time.Nanosecond)
+		}
+	}
+	return soonest
+}
+
+// SuspendedError returns ErrShuttingDown while the manager drains and ErrPaused while key
+// selection is paused, or nil otherwise. Both are switched on and off by hand, so the proxies
+// check them before their circuit breakers rather than letting them open the breaker.
+func (km *KeyManager) SuspendedError() error {
+	if km.draining.Load() {
+		return ErrShuttingDown
+	}
+	if km.paused.Load() {
+		return ErrPaused
+	}
+	return nil
+}
+
+// AvailabilityError returns the error GetNextKey would return for an unscoped request, or nil
+// when it would hand out a key. Unlike GetNextKey it records no usage, so callers that turn
+// requests away up front, such as the proxies' circuit breakers, can still tell clients why.
+// Pausing and draining are not reported here, see SuspendedError.
+func (km *KeyManager) AvailabilityError() error {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	_, err := km.pickKeyLocked("", "")
+	return err
+}
+
+// PeekNextKey returns the suffix of the key GetNextKey would select for an unscoped
+// request, without recording usage. Strategies that break ties at random may still
+// hand out a different key of equal standing.
+func (km *KeyManager) PeekNextKey() (string, error) {
+	if km.paused.Load() {
+		return "", ErrPaused
+	}
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	chosen, err := km.pickKeyLocked("", "")
+	if err != nil {
+		return "", err
+	}
+	return safeKeySuffix(chosen.Key), nil
+}
+
+// selectForModel runs selector over the keys known to serve model, then over the untested
+// keys, and finally over all keys. An empty model selects among all keys directly.
+func selectForModel(selector keySelector, keys []*managedKey, model string, now time.Time) *managedKey {
+	if model != "" {
+		var serving, untested []*managedKey
+		for _, k := range keys {
+			switch {
+			case k.servesModel(model):
+				serving = append(serving, k)
+			case k.Models == nil:
+				untested = append(untested, k)
+			}
+		}
+		for _, tier := range [][]*managedKey{serving, untested} {
+			if chosen := selector.selectKey(tier, now); chosen != nil {
+				return chosen
+			}
 		}
 	}
+	return selector.selectKey(keys, now)
+}
 
-	if keyIndex == -1 {
-		return "", fmt.Errorf("all available Gemini keys are temporarily disabled")
+// soonestCooledKey returns the rate-limited key whose cooldown ends first, or nil if no key is
+// cooling down. It is the fallback when every usable key is cooling down, since a key that may
+// still be rate limited is better than failing the request outright.
+func soonestCooledKey(keys []*managedKey, now time.Time) *managedKey {
+	var chosen *managedKey
+	for _, k := range keys {
+		if k.coolingDown(now) && (chosen == nil || k.CooldownUntil.Before(chosen.CooldownUntil)) {
+			chosen = k
+		}
 	}
+	return chosen
+}
 
-	keyStr := keyToUse.Key
+// markUsedLocked records weight uses of k in memory and queues the database updates.
+// Usage is counted in whole requests, so fractional weights accumulate in k.usageCredit
+// until they add up to one. The caller must hold the lock.
+func (km *KeyManager) markUsedLocked(k *managedKey, weight float64) {
+	// The epsilon keeps e.g. ten uses weighted 0.1 from summing to just below one.
+	k.usageCredit += weight
+	uses := int64(k.usageCredit + 1e-9)
+	if uses <= 0 {
+		return
+	}
+	k.usageCredit -= float64(uses)
 
-	// Increment the usage count for the selected key in memory immediately.
-	km.keys[keyIndex].UsageCount++
+	// Increment the usage counts for the selected key in memory immediately.
+	k.UsageCount += uses
+	k.UsageToday += uses
 
 	// Re-sort the slice to maintain the order for the next call.
 	km.sortKeys()
 
-	// Asynchronously update the usage count in the database by sending it to the queue.
+	if km.skipUsageWrites {
+		return
+	}
+	for range uses {
+		km.queueUsageLocked(k)
+	}
+}
+
+// queueUsageLocked asynchronously updates the usage count of k in the database by sending
+// it to the queue. The caller must hold the lock.
+func (km *KeyManager) queueUsageLocked(k *managedKey) {
+	if km.closed {
+		// The usage worker has flushed for the last time; late requests are not recorded.
+		return
+	}
 	select {
-	case km.updateQueue <- keyStr:
+	case km.updateQueue <- k.Key:
 		// Successfully queued
 	default:
-		// This case should be rare if the buffer is large enough and the worker is healthy.
-		km.logger.Error("Failed to queue usage count update: queue is full")
+		// The worker is falling behind. Keep the increment in memory so the next flush
+		// writes it instead of letting the database count drift.
+		if km.usageOverflow == nil {
+			km.usageOverflow = make(map[string]int64)
+		}
+		km.usageOverflow[k.Key]++
+		total := km.overflowedUpdates.Add(1)
+		if now := time.Now(); now.Sub(km.lastOverflowWarn) >= overflowWarnInterval {
+			km.lastOverflowWarn = now
+			km.logger.Warn("Usage update queue is full, deferring updates to the next flush", "overflowed_total", total)
+		}
 	}
+}
 
-	return keyStr, nil
+// RecordTokenUsage adds tokens reported by upstream for a response served with key to the
+// key's token usage, which proxy.selection_strategy token_weighted balances on. The database
+// is updated with the next usage flush. Unknown keys and non-positive counts are ignored.
+func (km *KeyManager) RecordTokenUsage(key string, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	for _, k := range km.keys {
+		if k.Key != key {
+			continue
+		}
+		k.TokenUsage += tokens
+		if !km.skipUsageWrites {
+			if km.pendingTokens == nil {
+				km.pendingTokens = make(map[string]int64)
+			}
+			km.pendingTokens[key] += tokens
+		}
+		return
+	}
+}
+
+// takePendingTokensLocked returns and clears the token usage recorded since the last flush.
+// The caller must hold the lock.
+func (km *KeyManager) takePendingTokensLocked() map[string]int64 {
+	tokens := km.pendingTokens
+	km.pendingTokens = nil
+	return tokens
+}
+
+// checkAvailableKeys warns when fewer keys than minAvailableKeys are available.
+// Every check below the threshold is counted, but the warning is logged at most
+// once per lowKeysWarnInterval until the count recovers.
+func (km *KeyManager) checkAvailableKeys() {
+	if km.minAvailableKeys <= 0 {
+		return
+	}
+	count := km.GetAvailableKeyCount()
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	if count >= km.minAvailableKeys {
+		km.lastLowKeysWarn = time.Time{}
+		return
+	}
+	total := km.lowKeyChecks.Add(1)
+	if now := time.Now(); now.Sub(km.lastLowKeysWarn) >= lowKeysWarnInterval {
+		km.lastLowKeysWarn = now
+		km.logger.Warn("Available Gemini keys below threshold", "available", count, "threshold", km.minAvailableKeys, "low_checks_total", total)
+	}
+}
+
+// LowAvailableKeyChecks returns how many availability checks found fewer keys
+// than the configured proxy.min_available_keys_warn.
+func (km *KeyManager) LowAvailableKeyChecks() int64 {
+	return km.lowKeyChecks.Load()
+}
+
+// OverflowedUsageUpdates returns how many usage updates did not fit in the update queue
+// and were deferred to a later flush.
+func (km *KeyManager) OverflowedUsageUpdates() int64 {
+	return km.overflowedUpdates.Load()
+}
+
+// takeUsageOverflowLocked returns and clears the deferred usage increments.
+// The caller must hold the lock.
+func (km *KeyManager) takeUsageOverflowLocked() map[string]int64 {
+	overflow := km.usageOverflow
+	km.usageOverflow = nil
+	return overflow
+}
+
+// pruneSessions drops session pins that have expired.
+func (km *KeyManager) pruneSessions() {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	now := time.Now()
+	for id, pin := range km.sessions {
+		if !now.Before(pin.expiresAt) {
+			delete(km.sessions, id)
+		}
+	}
 }
 
 // sortKeys sorts the keys slice by UsageCount in ascending order.
@@ -165,18 +753,17 @@ func (km *KeyManager) sortKeys() {
 	})
 }
 
-// keyReloader periodically reloads the keys from the database.
+// keyReloader periodically reloads the keys from the database every reloadInterval.
 func (km *KeyManager) keyReloader() {
-	// Wait for a minute before the first update
-	time.Sleep(1 * time.Minute)
-
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(km.reloadInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			km.updateKeys()
+			km.pruneSessions()
+			km.checkAvailableKeys()
 		case <-km.stopChan:
 			km.logger.Info("Stopping key reloader.")
 			return
@@ -185,32 +772,74 @@ func (km *KeyManager) keyReloader() {
 }
 
 // usageUpdater is a worker that processes key usage updates from a channel.
+// Increments are accumulated per key and written in batches, either every
+// usageFlushInterval or once usageFlushSize updates are pending. Whatever is
+// left is flushed when the queue is closed.
 func (km *KeyManager) usageUpdater() {
 	defer km.wg.Done()
 	km.logger.Info("Starting usage updater worker.")
 
-	for keyStr := range km.updateQueue {
-		err := km.db.IncrementGeminiKeyUsageCount(keyStr)
-		if err != nil {
-			keySuffix := ""
-			if len(keyStr) > 4 {
-				keySuffix = keyStr[len(keyStr)-4:]
-			} else {
-				keySuffix = keyStr
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]int64)
+	var pendingCount int
+	flush := func() {
+		km.mutex.Lock()
+		for key, n := range km.takeUsageOverflowLocked() {
+			pending[key] += n
+			pendingCount += int(n)
+		}
+		tokens := km.takePendingTokensLocked()
+		km.mutex.Unlock()
+		if len(tokens) > 0 {
+			if err := km.db.BatchIncrementGeminiTokenUsage(tokens); err != nil {
+				km.logger.Warn("Failed to increment token usage in DB", "keys", len(tokens), "error", err)
+			}
+		}
+		if pendingCount == 0 {
+			return
+		}
+		if err := km.db.BatchIncrementGeminiUsage(pending); err != nil {
+			km.logger.Warn("Failed to increment usage counts in DB", "keys", len(pending), "updates", pendingCount, "error", err)
+		}
+		pending = make(map[string]int64)
+		pendingCount = 0
+	}
+
+	for {
+		select {
+		case keyStr, ok := <-km.updateQueue:
+			if !ok {
+				flush()
+				km.logger.Info("Usage updater worker stopped.")
+				return
+			}
+			pending[keyStr]++
+			pendingCount++
+			if pendingCount >= usageFlushSize {
+				flush()
 			}
-			km.logger.Warn("Failed to increment usage count in DB", "key_suffix", keySuffix, "error", err)
+		case <-ticker.C:
+			flush()
 		}
 	}
-	km.logger.Info("Usage updater worker stopped.")
 }
 
 // updateKeys fetches the latest set of active keys from the database.
 func (km *KeyManager) updateKeys() {
+	if _, err := km.ReloadKeys(); err != nil {
+		km.logger.Error("Failed to update Gemini keys from database", "error", err)
+	}
+}
+
+// ReloadKeys replaces the in-memory key list with the active keys in the database
+// and returns how many were loaded.
+func (km *KeyManager) ReloadKeys() (int, error) {
 	km.logger.Info("Updating Gemini API keys from database...")
 	keys, err := km.db.LoadActiveGeminiKeys()
 	if err != nil {
-		km.logger.Error("Failed to update Gemini keys from database", "error", err)
-		return
+		return 0, fmt.Errorf("failed to load active gemini keys: %w", err)
 	}
 
 	km.mutex.Lock()
@@ -220,59 +849,194 @@ func (km *KeyManager) updateKeys() {
 		km.logger.Warn("No active Gemini keys found in database during update.")
 	}
 
+	// Carry the in-memory state (temporary disables, rate-limit cooldowns) over to the
+	// reloaded keys so a reload doesn't put a failing key straight back into rotation.
+	previous := make(map[string]*managedKey, len(km.keys))
+	for _, k := range km.keys {
+		previous[k.Key] = k
+	}
 	managedKeys := make([]*managedKey, len(keys))
 	for i, key := range keys {
 		managedKeys[i] = &managedKey{GeminiKey: key}
+		if old, ok := previous[key.Key]; ok {
+			managedKeys[i].CooldownUntil = old.CooldownUntil
+			managedKeys[i].recentOutcomes = old.recentOutcomes
+			managedKeys[i].recentNext = old.recentNext
+			managedKeys[i].recentSuccesses = old.recentSuccesses
+			// Permanently disabled keys are only reloaded once re-activated in the database.
+			if km.temporaryDisableDuration > 0 {
+				managedKeys[i].Disabled = old.Disabled
+				managedKeys[i].DisabledAt = old.DisabledAt
+			}
+		}
 	}
 
 	km.keys = managedKeys
 	if len(keys) > 0 {
 		km.logger.Info("Successfully updated Gemini API keys", "count", len(keys))
 	}
+	return len(keys), nil
+}
+
+// Snapshot returns the manager's current view of its keys, in selection order.
+func (km *KeyManager) Snapshot() []KeyRuntimeInfo {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	now := time.Now()
+	infos := make([]KeyRuntimeInfo, len(km.keys))
+	for i, k := range km.keys {
+		infos[i] = KeyRuntimeInfo{
+			ID:               k.ID,
+			KeySuffix:        safeKeySuffix(k.Key),
+			ProjectLabel:     k.ProjectLabel,
+			Group:            k.Group,
+			Status:           k.Status,
+			UsageCount:       k.UsageCount,
+			UsageToday:       k.UsageToday,
+			TokenUsage:       k.TokenUsage,
+			DailyQuota:       k.DailyQuota,
+			FailureCount:     k.FailureCount,
+			DisableThreshold: k.disableThreshold(km.disableThreshold),
+			Disabled:         k.Disabled,
+			Available:        k.available(now),
+		}
+		if !k.DisabledAt.IsZero() {
+			disabledAt := k.DisabledAt
+			infos[i].DisabledAt = &disabledAt
+		}
+		if now.Before(k.CooldownUntil) {
+			cooldownUntil := k.CooldownUntil
+			infos[i].CooldownUntil = &cooldownUntil
+		}
+		if ratio, samples := k.successRatio(); samples > 0 {
+			infos[i].SuccessRatio = &ratio
+			infos[i].Samples = samples
+		}
+		if k.Models != nil {
+			infos[i].Models = append([]string(nil), k.Models...)
+		}
+	}
+	return infos
+}
+
+// ResetDailyUsage zeroes the in-memory daily usage counters so keys that hit their
+// quota become available again without waiting for the next reload.
+func (km *KeyManager) ResetDailyUsage() {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	for _, k := range km.keys {
+		k.UsageToday = 0
+	}
+}
+
+// SetPaused stops or resumes handing out keys. While paused, GetNextKey and GetKeyForSession
+// return ErrPaused immediately, so traffic can be held back during a provider incident without
+// disabling any key.
+func (km *KeyManager) SetPaused(paused bool) {
+	if km.paused.Swap(paused) == paused {
+		return
+	}
+	if paused {
+		km.logger.Warn("Key selection paused, new requests will be rejected.")
+	} else {
+		km.logger.Info("Key selection resumed.")
+	}
+}
+
+// IsPaused reports whether key selection is paused, see SetPaused.
+func (km *KeyManager) IsPaused() bool {
+	return km.paused.Load()
+}
+
+// Drain stops handing out keys to new requests while letting in-flight ones finish, retries
+// included, see GetRetryKey. It should be called before the HTTP server is shut down, and
+// Close after.
+func (km *KeyManager) Drain() {
+	if km.draining.CompareAndSwap(false, true) {
+		km.logger.Info("Draining KeyManager, new requests will be rejected.")
+	}
 }
 
 // Close gracefully shuts down the KeyManager's background tasks.
 func (km *KeyManager) Close() {
+	if km.cancelClosing != nil {
+		km.cancelClosing()
+	}
 	close(km.stopChan)
+	km.mutex.Lock()
+	km.closed = true
 	close(km.updateQueue)
+	km.mutex.Unlock()
 	km.wg.Wait()
+	km.notifier.Close()
+	if km.coordinator != nil {
+		_ = km.coordinator.Close()
+	}
 	km.logger.Info("KeyManager shutdown complete.")
 }
 
-// HandleKeyFailure is called when a key fails a request.
-func (km *KeyManager) HandleKeyFailure(key string) {
+// HandleKeyFailure is called when a key fails a request with the given upstream status code
+// (0 for transport errors) and error body. A body containing one of the fatal error patterns
+// disables the key permanently. A 429 only puts the key on a short cooldown, since rate limits
+// are transient; any other failure counts toward the permanent disable threshold.
+func (km *KeyManager) HandleKeyFailure(key string, statusCode int, errorBody string) {
 	km.mutex.Lock()
 	defer km.mutex.Unlock()
 
-	for _, k := range km.keys {
-		if k.Key == key {
-			k.FailureCount++
-			if k.FailureCount >= km.disableThreshold {
-				if !k.Disabled { // Only log and update status on the transition
-					k.Disabled = true
-					k.DisabledAt = time.Now()
-					k.Status = "disabled"
-					km.logger.Warn("Disabling key due to reaching failure threshold", "key_suffix", safeKeySuffix(key), "failures", k.FailureCount)
-				}
-			}
+	if k := km.findKeyLocked(key); k != nil {
+		if pattern := km.fatalPattern(errorBody); pattern != "" {
+			km.disableFatalLocked(k, pattern)
+			return
+		}
+		km.recordFailureLocked(k, statusCode)
+	}
+}
 
-			// Persist the updated failure count and status to the database in the background.
-			// We make a copy to avoid data races in the goroutine.
-			keyToUpdate := k.GeminiKey
-			if km.syncDBUpdates {
-				if err := km.db.UpdateGeminiKey(&keyToUpdate); err != nil {
-					km.logger.Error("Failed to update key failure count in DB", "key_id", keyToUpdate.ID, "error", err)
-				}
-			} else {
-				go func() {
-					if err := km.db.UpdateGeminiKey(&keyToUpdate); err != nil {
-						km.logger.Error("Failed to update key failure count in DB", "key_id", keyToUpdate.ID, "error", err)
-					}
-				}()
-			}
-			break
+// fatalPattern returns the first fatal error pattern found in errorBody, ignoring case,
+// or "" if there is none.
+func (km *KeyManager) fatalPattern(errorBody string) string {
+	return MatchFatalPattern(km.fatalErrorPatterns, errorBody)
+}
+
+// MatchFatalPattern returns the first of patterns found in errorBody, ignoring case, or "" if
+// there is none. See config.ProxyConfig.FatalErrorPatterns.
+func MatchFatalPattern(patterns []string, errorBody string) string {
+	if errorBody == "" {
+		return ""
+	}
+	body := strings.ToLower(errorBody)
+	for _, pattern := range patterns {
+		if strings.Contains(body, strings.ToLower(pattern)) {
+			return pattern
 		}
 	}
+	return ""
+}
+
+// disableFatalLocked disables k in the database regardless of the temporary disable duration,
+// since an error matching a fatal pattern means the key will not recover. The caller must
+// hold the lock.
+func (km *KeyManager) disableFatalLocked(k *managedKey, pattern string) {
+	wasDisabled := k.Disabled
+	if !k.Disabled {
+		k.DisabledAt = time.Now()
+	}
+	k.Disabled = true
+	if k.Status != "disabled" {
+		k.startDisabledPeriod()
+	}
+	k.Status = "disabled"
+	k.DisabledReason = model.DisabledReasonFatalError
+	k.LastFailedAt = time.Now()
+	k.resetOutcomes()
+	km.logger.Warn("Disabling key due to fatal upstream error", append(k.logAttrs(), "pattern", pattern)...)
+	km.persistKeyStateLocked(k, "Failed to update key status in DB")
+	km.publishLocked(k, coordination.EventDisabled)
+	if !wasDisabled {
+		km.notifyDisabledLocked(k, "fatal upstream error")
+	}
 }
 
 // HandleKeySuccess is called when a key succeeds in a request.
@@ -280,32 +1044,228 @@ func (km *KeyManager) HandleKeySuccess(key string) {
 	km.mutex.Lock()
 	defer km.mutex.Unlock()
 
+	if k := km.findKeyLocked(key); k != nil {
+		km.recordSuccessLocked(k)
+	}
+}
+
+// findKeyLocked returns the managed key with the given value, or nil.
+// The caller must hold the lock.
+func (km *KeyManager) findKeyLocked(key string) *managedKey {
 	for _, k := range km.keys {
 		if k.Key == key {
-			if k.FailureCount > 0 || k.Disabled {
-				km.logger.Info("Re-activating key after successful request", "key_suffix", safeKeySuffix(key), "old_failures", k.FailureCount)
-				k.FailureCount = 0
-				k.Disabled = false
-				k.Status = "active"
-
-				// Persist the updated failure count and status to the database in the background.
-				// We make a copy to avoid data races in the goroutine.
-				keyToUpdate := k.GeminiKey
-				if km.syncDBUpdates {
-					if err := km.db.UpdateGeminiKey(&keyToUpdate); err != nil {
-						km.logger.Error("Failed to update key success status in DB", "key_id", keyToUpdate.ID, "error", err)
-					}
-				} else {
-					go func() {
-						if err := km.db.UpdateGeminiKey(&keyToUpdate); err != nil {
-							km.logger.Error("Failed to update key success status in DB", "key_id", keyToUpdate.ID, "error", err)
-						}
-					}()
-				}
+			return k
+		}
+	}
+	return nil
+}
+
+// recordFailureLocked applies a failed request with the given status code to k.
+// The caller must hold the lock.
+func (km *KeyManager) recordFailureLocked(k *managedKey, statusCode int) {
+	if statusCode == http.StatusTooManyRequests {
+		k.CooldownUntil = time.Now().Add(km.cooldownDuration)
+		km.logger.Info("Cooling down rate-limited key", append(k.logAttrs(), "until", k.CooldownUntil)...)
+		km.publishLocked(k, coordination.EventCooldown)
+		return
+	}
+
+	k.FailureCount++
+	k.LastFailedAt = time.Now()
+	k.recordOutcome(false, km.minSamples)
+	if k.FailureCount >= k.disableThreshold(km.disableThreshold) {
+		km.disableKeyLocked(k, model.DisabledReasonFailureThreshold, "reaching failure threshold", "failures", k.FailureCount)
+	} else if ratio, samples := k.successRatio(); km.minSuccessRatio > 0 && samples >= km.minSamples && ratio < km.minSuccessRatio {
+		km.disableKeyLocked(k, model.DisabledReasonLowSuccessRatio, "low success ratio", "success_ratio", ratio, "samples", samples)
+	}
+	km.persistKeyStateLocked(k, "Failed to update key failure count in DB")
+}
+
+// disableKeyLocked takes k out of rotation for the given reason, temporarily when a
+// temporary disable duration is configured. Keys disabled in the database record cause, one
+// of the model.DisabledReason constants. The caller must hold the lock.
+func (km *KeyManager) disableKeyLocked(k *managedKey, cause, reason string, attrs ...any) {
+	if k.Disabled { // Only log and update status on the transition
+		return
+	}
+	k.Disabled = true
+	k.DisabledAt = time.Now()
+	k.resetOutcomes()
+	attrs = append(k.logAttrs(), attrs...)
+	if km.temporaryDisableDuration > 0 {
+		// Temporary disables live in memory only, so the key stays active in the database.
+		km.logger.Warn("Temporarily disabling key due to "+reason, append(attrs, "until", k.DisabledAt.Add(km.temporaryDisableDuration))...)
+	} else {
+		k.Status = "disabled"
+		k.DisabledReason = cause
+		k.startDisabledPeriod()
+		km.logger.Warn("Disabling key due to "+reason, attrs...)
+	}
+	km.notifyDisabledLocked(k, reason)
+	km.publishLocked(k, coordination.EventDisabled)
+}
+
+// publishLocked shares the disable or cooldown of k with the other instances in the
+// background. The caller must hold the lock.
+func (km *KeyManager) publishLocked(k *managedKey, kind string) {
+	if km.coordinator == nil {
+		return
+	}
+	event := coordination.Event{
+		KeyID:  k.ID,
+		Kind:   kind,
+		Status: k.Status,
+		At:     time.Now(),
+		Until:  k.CooldownUntil,
+		Origin: km.instanceID,
+	}
+	go func() {
+		if err := km.coordinator.Publish(context.Background(), event); err != nil {
+			km.logger.Warn("Failed to share key state with other instances", "key_id", event.KeyID, "error", err)
+		}
+	}()
+}
+
+// subscribeEvents applies the key state changes published by other instances until the
+// manager is closed.
+func (km *KeyManager) subscribeEvents() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-km.stopChan
+		cancel()
+	}()
+	if err := km.coordinator.Subscribe(ctx, km.applyEvent); err != nil {
+		km.logger.Error("Stopped receiving key state from other instances", "error", err)
+	}
+}
+
+// applyEvent takes a key disabled or cooling down on another instance out of rotation here.
+// The publishing instance has already written any database change.
+func (km *KeyManager) applyEvent(e coordination.Event) {
+	if e.Origin == km.instanceID {
+		return
+	}
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	for _, k := range km.keys {
+		if k.ID != e.KeyID {
+			continue
+		}
+		switch e.Kind {
+		case coordination.EventDisabled:
+			if k.Disabled {
+				return
 			}
-			break
+			k.Disabled = true
+			k.DisabledAt = e.At
+			if e.Status != "" {
+				k.Status = e.Status
+			}
+			k.resetOutcomes()
+			km.logger.Info("Key disabled by another instance", k.logAttrs()...)
+		case coordination.EventCooldown:
+			if e.Until.After(k.CooldownUntil) {
+				k.CooldownUntil = e.Until
+			}
+		}
+		return
+	}
+}
+
+// reenableExpiredLocked puts temporarily disabled keys back into rotation once their disable
+// duration has passed. They stay one failure away from the threshold, so the next real request
+// acts as the revival test. The caller must hold the lock.
+func (km *KeyManager) reenableExpiredLocked(now time.Time) {
+	if km.temporaryDisableDuration <= 0 {
+		return
+	}
+	for _, k := range km.keys {
+		// Keys disabled in the database, e.g. by a fatal error, are not temporary.
+		if k.Disabled && k.Status != "disabled" && now.Sub(k.DisabledAt) >= km.temporaryDisableDuration {
+			k.Disabled = false
+			k.Status = "active"
+			k.FailureCount = max(k.disableThreshold(km.disableThreshold)-1, 0)
+			km.logger.Info("Re-enabling temporarily disabled key", k.logAttrs()...)
+			km.notifyRevivedLocked(k)
+		}
+	}
+}
+
+// recordSuccessLocked counts a successful request for k and re-activates k if it was failing.
+// The caller must hold the lock.
+func (km *KeyManager) recordSuccessLocked(k *managedKey) {
+	k.recordOutcome(true, km.minSamples)
+	if k.FailureCount == 0 && !k.Disabled {
+		return
+	}
+	km.logger.Info("Re-activating key after successful request", append(k.logAttrs(), "old_failures", k.FailureCount)...)
+	if k.Disabled {
+		km.notifyRevivedLocked(k)
+	}
+	k.FailureCount = 0
+	k.Disabled = false
+	k.Status = "active"
+	k.DisabledReason = ""
+	k.DisabledSince = time.Time{}
+	k.RevivalFailures = 0
+	km.persistKeyStateLocked(k, "Failed to update key success status in DB")
+}
+
+// startDisabledPeriod records that k was just disabled in the database, restarting the count
+// of failed revivals the auto-prune job looks at.
+func (mk *managedKey) startDisabledPeriod() {
+	mk.DisabledSince = time.Now()
+	mk.RevivalFailures = 0
+}
+
+// recordRevivalFailureLocked counts a failed attempt to revive the disabled key k. Only keys
+// disabled in the database are counted, since temporary disables lapse on their own. The
+// caller must hold the lock.
+func (km *KeyManager) recordRevivalFailureLocked(k *managedKey) {
+	if k.Status != "disabled" {
+		return
+	}
+	k.RevivalFailures++
+	km.persistKeyStateLocked(k, "Failed to update key revival failures in DB")
+}
+
+// notifyDisabledLocked reports that k was disabled, and that no keys are left if k was the
+// last enabled one. The caller must hold the lock.
+func (km *KeyManager) notifyDisabledLocked(k *managedKey, reason string) {
+	if km.notifier == nil {
+		return
+	}
+	km.notifier.Notify(notify.Event{Type: notify.EventKeyDisabled, KeyID: k.ID, KeySuffix: safeKeySuffix(k.Key), Reason: reason})
+	for _, other := range km.keys {
+		if !other.Disabled {
+			return
 		}
 	}
+	km.notifier.Notify(notify.Event{Type: notify.EventNoKeysAvailable})
+}
+
+// notifyRevivedLocked reports that k is back in rotation. The caller must hold the lock.
+func (km *KeyManager) notifyRevivedLocked(k *managedKey) {
+	km.notifier.Notify(notify.Event{Type: notify.EventKeyRevived, KeyID: k.ID, KeySuffix: safeKeySuffix(k.Key)})
+}
+
+// persistKeyStateLocked writes k's failure count and status to the database, in the
+// background unless syncDBUpdates is set. The caller must hold the lock.
+func (km *KeyManager) persistKeyStateLocked(k *managedKey, errMsg string) {
+	// We make a copy to avoid data races in the goroutine.
+	keyToUpdate := k.GeminiKey
+	if km.syncDBUpdates {
+		if err := km.db.UpdateGeminiKeyState(&keyToUpdate); err != nil {
+			km.logger.Error(errMsg, "key_id", keyToUpdate.ID, "error", err)
+		}
+		return
+	}
+	go func() {
+		if err := km.db.UpdateGeminiKeyState(&keyToUpdate); err != nil {
+			km.logger.Error(errMsg, "key_id", keyToUpdate.ID, "error", err)
+		}
+	}()
 }
 
 // safeKeySuffix returns the last 4 characters of a key, or the full key if it's shorter.
@@ -316,14 +1276,16 @@ func safeKeySuffix(key string) string {
 	return key
 }
 
-// ReviveDisabledKeys attempts to reactivate keys that were previously disabled.
+// ReviveDisabledKeys attempts to reactivate keys that were previously disabled. The checks are
+// aborted when the manager is closed.
 func (km *KeyManager) ReviveDisabledKeys() {
+	// Only the key values leave the lock; state is re-read under the lock after each test.
 	km.mutex.Lock()
-	disabledKeys := make([]*managedKey, 0)
+	disabledKeys := make([]string, 0)
 	for _, k := range km.keys {
 		// Check if the key is disabled and if enough time has passed since it was disabled.
 		if k.Disabled && time.Since(k.DisabledAt) > km.revivalInterval {
-			disabledKeys = append(disabledKeys, k)
+			disabledKeys = append(disabledKeys, k.Key)
 		}
 	}
 	km.mutex.Unlock()
@@ -334,21 +1296,29 @@ func (km *KeyManager) ReviveDisabledKeys() {
 
 	km.logger.Info("Starting check to revive disabled keys", "count", len(disabledKeys))
 
+	ctx := km.lifetimeContext()
 	var wg sync.WaitGroup
 	for _, k := range disabledKeys {
 		wg.Add(1)
-		go func(key *managedKey) {
+		go func(key string) {
 			defer wg.Done()
-			err := km.testAPIKey(key.Key)
+			models, err := km.testAPIKey(ctx, key)
+			if ctx.Err() != nil {
+				return // Shutting down; the key's state is left as it was.
+			}
 			if err == nil {
-				km.logger.Info("Successfully revived key", "key_suffix", safeKeySuffix(key.Key))
-				km.HandleKeySuccess(key.Key)
+				km.logger.Info("Successfully revived key", "key_suffix", safeKeySuffix(key))
+				km.recordModels(key, models)
+				km.HandleKeySuccess(key)
 			} else {
-				km.logger.Debug("Key still failing check", "key_suffix", safeKeySuffix(key.Key), "error", err)
+				km.logger.Debug("Key still failing check", "key_suffix", safeKeySuffix(key), "error", err)
 				// We need to update the DisabledAt time to reset the revival timer,
 				// otherwise we'll keep checking it on every scheduler run.
 				km.mutex.Lock()
-				key.DisabledAt = time.Now()
+				if k := km.findKeyLocked(key); k != nil && k.Disabled {
+					k.DisabledAt = time.Now()
+					km.recordRevivalFailureLocked(k)
+				}
 				km.mutex.Unlock()
 			}
 		}(k)
@@ -357,38 +1327,117 @@ func (km *KeyManager) ReviveDisabledKeys() {
 	km.logger.Info("Finished checking disabled keys.")
 }
 
+// KeyTestError is returned when the upstream rejects a key during a test request.
+type KeyTestError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *KeyTestError) Error() string {
+	return fmt.Sprintf("test request returned non-200 status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// ValidateRawKey tests a key against the upstream without adding it to the manager or the database.
+// A rejection by the upstream is reported as a *KeyTestError.
+func (km *KeyManager) ValidateRawKey(key string) error {
+	_, err := km.testAPIKey(km.lifetimeContext(), key)
+	return err
+}
+
+// lifetimeContext returns the context for work that should stop when the manager is closed.
+func (km *KeyManager) lifetimeContext() context.Context {
+	if km.closing == nil {
+		return context.Background()
+	}
+	return km.closing
+}
+
+// modelList is the response body of the OpenAI-compatible model listing endpoint.
+type modelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
 // testAPIKey performs a simple, low-cost request to the Gemini API to validate a key.
-func (km *KeyManager) testAPIKey(key string) error {
-	// To validate a key, we send a request to the OpenAI-compatible model listing endpoint.
+// When the health check endpoint is the model listing, it also returns the IDs of the models
+// the key can access, without the "models/" prefix; otherwise the returned slice is nil.
+// The request is aborted when ctx is done, the manager is closed or the key test timeout passes.
+func (km *KeyManager) testAPIKey(ctx context.Context, key string) ([]string, error) {
+	timeout := km.keyTestTimeout
+	if timeout <= 0 {
+		timeout = config.DefaultKeyTestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if km.closing != nil {
+		defer context.AfterFunc(km.closing, cancel)()
+	}
+
+	// To validate a key, we send a request to the OpenAI-compatible model listing endpoint by default.
 	// This is the most accurate and lightweight way to check if a key is valid for the proxy's use case.
-	const testURL = "https://generativelanguage.googleapis.com/v1beta/openai/models"
-	req, err := http.NewRequestWithContext(context.Background(), "GET", testURL, nil)
+	testURL := km.healthCheckURL
+	if testURL == "" {
+		testURL = config.UpstreamConfig{}.HealthCheckURL()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", testURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create test request: %w", err)
+		return nil, fmt.Errorf("failed to create test request: %w", err)
 	}
 	// The key for the OpenAI-compatible endpoint is still a Google Cloud API key, used as a Bearer token.
 	req.Header.Set("Authorization", "Bearer "+key)
 
 	resp, err := km.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("test request failed: %w", err)
+		return nil, fmt.Errorf("test request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		// We read the body to get more context on the error.
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return fmt.Errorf("test request returned non-200 status: %d, body: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return nil, &KeyTestError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
 	}
 
-	return nil
+	// The key is valid either way; a body that isn't a model list just leaves its models unknown.
+	var list modelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil || list.Data == nil {
+		return nil, nil
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		if id := strings.TrimPrefix(m.ID, "models/"); id != "" {
+			models = append(models, id)
+		}
+	}
+	return models, nil
 }
 
-// CheckAllKeysHealth performs a health check on all managed keys.
+// recordModels stores the models a successful test found for key, persisting them when they
+// changed. A nil models leaves the key's known models untouched.
+func (km *KeyManager) recordModels(key string, models []string) {
+	if models == nil {
+		return
+	}
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	k := km.findKeyLocked(key)
+	if k == nil || (k.Models != nil && slices.Equal(k.Models, models)) {
+		return
+	}
+	k.Models = models
+	km.persistKeyStateLocked(k, "Failed to update key models in DB")
+}
+
+// CheckAllKeysHealth performs a health check on all managed keys. The checks are aborted when
+// the manager is closed.
 func (km *KeyManager) CheckAllKeysHealth() {
+	// Only the key values leave the lock; state is re-read under the lock after each test.
 	km.mutex.Lock()
-	allKeys := make([]*managedKey, len(km.keys))
-	copy(allKeys, km.keys)
+	allKeys := make([]string, len(km.keys))
+	for i, k := range km.keys {
+		allKeys[i] = k.Key
+	}
 	km.mutex.Unlock()
 
 	if len(allKeys) == 0 {
@@ -397,27 +1446,41 @@ func (km *KeyManager) CheckAllKeysHealth() {
 
 	km.logger.Info("Starting daily health check for all keys", "count", len(allKeys))
 
+	ctx := km.lifetimeContext()
 	var wg sync.WaitGroup
 	for _, k := range allKeys {
 		wg.Add(1)
-		go func(key *managedKey) {
+		go func(key string) {
 			defer wg.Done()
-			err := km.testAPIKey(key.Key)
+			models, err := km.testAPIKey(ctx, key)
+			if ctx.Err() != nil {
+				return // Shutting down; a cancelled check says nothing about the key.
+			}
+			if err == nil {
+				km.recordModels(key, models)
+			}
+
+			km.mutex.Lock()
+			defer km.mutex.Unlock()
+			k := km.findKeyLocked(key)
+			if k == nil {
+				return // Removed by a reload while the check was running.
+			}
 			if err != nil {
 				// Key is failing, if it's currently active, disable it.
-				if !key.Disabled {
-					km.logger.Warn("Key failed daily health check, disabling it.", "key_suffix", safeKeySuffix(key.Key), "error", err)
+				if !k.Disabled {
+					km.logger.Warn("Key failed daily health check, disabling it.", append(k.logAttrs(), "error", err)...)
 					// We manually set it to be at the threshold to ensure it gets disabled.
-					km.mutex.Lock()
-					key.FailureCount = km.disableThreshold - 1
-					km.mutex.Unlock()
-					km.HandleKeyFailure(key.Key)
+					k.FailureCount = k.disableThreshold(km.disableThreshold) - 1
+					km.recordFailureLocked(k, 0)
+				} else {
+					km.recordRevivalFailureLocked(k)
 				}
 			} else {
 				// Key is working, if it's currently disabled, enable it.
-				if key.Disabled {
-					km.logger.Info("Key passed daily health check, re-activating it.", "key_suffix", safeKeySuffix(key.Key))
-					km.HandleKeySuccess(key.Key)
+				if k.Disabled {
+					km.logger.Info("Key passed daily health check, re-activating it.", k.logAttrs()...)
+					km.recordSuccessLocked(k)
 				}
 			}
 		}(k)
@@ -426,17 +1489,27 @@ func (km *KeyManager) CheckAllKeysHealth() {
 	km.logger.Info("Finished daily health check for all keys.")
 }
 
-// GetAvailableKeyCount returns the number of keys that are not currently disabled.
+// GetAvailableKeyCount returns the number of keys that are not currently disabled or cooling down.
+// When every usable key is cooling down it returns the number of cooling keys instead, since
+// GetNextKey falls back to them.
 func (km *KeyManager) GetAvailableKeyCount() int {
 	km.mutex.Lock()
 	defer km.mutex.Unlock()
 
-	count := 0
+	count, cooling := 0, 0
+	now := time.Now()
+	km.reenableExpiredLocked(now)
 	for _, k := range km.keys {
-		if !k.Disabled {
+		switch {
+		case k.available(now):
 			count++
+		case k.coolingDown(now):
+			cooling++
 		}
 	}
+	if count == 0 {
+		return cooling
+	}
 	return count
 }
 
@@ -454,8 +1527,8 @@ func (km *KeyManager) findKeyByID(id uint) (*managedKey, error) {
 }
 
 // TestKeyByID fetches a key by its ID and performs a health check.
-// This is a synchronous operation.
-func (km *KeyManager) TestKeyByID(id uint) error {
+// This is a synchronous operation; it is aborted when ctx is done.
+func (km *KeyManager) TestKeyByID(ctx context.Context, id uint) error {
 	// First, try to find the key in the in-memory list for efficiency.
 	mKey, err := km.findKeyByID(id)
 	if err != nil {
@@ -464,30 +1537,97 @@ func (km *KeyManager) TestKeyByID(id uint) error {
 		if dbErr != nil {
 			return fmt.Errorf("failed to find key with ID %d in DB: %w", id, dbErr)
 		}
-		// Create a managedKey and add it to the list so it can be handled.
-		mKey = &managedKey{GeminiKey: *dbKey}
+		if dbKey.Status == config.KeyStatusPending {
+			return km.testPendingKey(ctx, dbKey)
+		}
+		// Create a managedKey and add it to the list so it can be handled. Disabled keys stay
+		// out of rotation unless the test succeeds.
+		mKey = &managedKey{GeminiKey: *dbKey, Disabled: dbKey.Status != "active"}
 		km.mutex.Lock()
 		km.keys = append(km.keys, mKey)
 		km.mutex.Unlock()
 	}
 
 	km.logger.Info("Performing manual health check for key", "key_id", id)
-	err = km.testAPIKey(mKey.Key)
+	models, err := km.testAPIKey(ctx, mKey.Key)
+	if err != nil && (ctx.Err() != nil || km.lifetimeContext().Err() != nil) {
+		// A cancelled check says nothing about the key, so its state is left as it was.
+		return fmt.Errorf("health check for key %d aborted: %w", id, err)
+	}
 	if err != nil {
 		km.logger.Warn("Manual health check failed for key", "key_id", id, "error", err)
 		// We can also trigger the failure handler to ensure its state is updated.
-		km.HandleKeyFailure(mKey.Key)
+		km.HandleKeyFailure(mKey.Key, 0, "")
 		return err
 	}
 
-	km.logger.Info("Manual health check succeeded for key", "key_id", id)
+	km.logger.Info("Manual health check succeeded for key", "key_id", id, "models", len(models))
+	km.recordModels(mKey.Key, models)
 	// On success, ensure the key is marked as active.
 	km.HandleKeySuccess(mKey.Key)
 	return nil
 }
 
+// testPendingKey tests a key that has not been activated yet. A successful test activates it and
+// adds it to the rotation; a failed test leaves it pending, out of rotation and otherwise unchanged.
+func (km *KeyManager) testPendingKey(ctx context.Context, key *model.GeminiKey) error {
+	km.logger.Info("Performing manual health check for pending key", "key_id", key.ID)
+	models, err := km.testAPIKey(ctx, key.Key)
+	if err != nil {
+		km.logger.Warn("Manual health check failed for pending key, keeping it pending", "key_id", key.ID, "error", err)
+		return err
+	}
+
+	key.Status = "active"
+	key.FailureCount = 0
+	if models != nil {
+		key.Models = models
+	}
+	if err := km.db.UpdateGeminiKeyState(key); err != nil {
+		return fmt.Errorf("failed to activate key %d: %w", key.ID, err)
+	}
+	km.logger.Info("Activated pending key after successful health check", "key_id", key.ID)
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	if km.findKeyLocked(key.Key) == nil {
+		km.keys = append(km.keys, &managedKey{GeminiKey: *key})
+		km.sortKeys()
+	}
+	return nil
+}
+
 // TestAllKeysAsync triggers a health check for all keys in the background.
 func (km *KeyManager) TestAllKeysAsync() {
 	km.logger.Info("Triggering asynchronous health check for all keys...")
 	go km.CheckAllKeysHealth()
 }
+
+// keyTestConcurrency caps how many keys TestKeysAsync tests at the same time.
+const keyTestConcurrency = 8
+
+// TestKeysAsync tests the keys with the given IDs in the background, like TestKeyByID, e.g. to
+// validate a freshly imported batch without testing every key. The tests are aborted when the
+// manager is closed.
+func (km *KeyManager) TestKeysAsync(ids []uint) {
+	km.logger.Info("Triggering asynchronous health check for keys", "count", len(ids))
+	go func() {
+		ctx := km.lifetimeContext()
+		var failed atomic.Int64
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, keyTestConcurrency)
+		for _, id := range ids {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := km.TestKeyByID(ctx, id); err != nil {
+					failed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		km.logger.Info("Finished health check for keys", "count", len(ids), "failed", failed.Load())
+	}()
+}
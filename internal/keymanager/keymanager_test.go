@@ -1,20 +1,30 @@
 package keymanager
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/coordination"
+	"github.com/ubuygold/gogemini/internal/db"
 	"github.com/ubuygold/gogemini/internal/model"
+	"github.com/ubuygold/gogemini/internal/notify"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
@@ -36,6 +46,11 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return args.Get(0).(*http.Response), args.Error(1)
 }
 
+// httpClientFunc adapts a function to the HTTPClient interface.
+type httpClientFunc func(req *http.Request) (*http.Response, error)
+
+func (f httpClientFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
 func (m *MockDBService) LoadActiveGeminiKeys() ([]model.GeminiKey, error) {
 	args := m.Called()
 	return args.Get(0).([]model.GeminiKey), args.Error(1)
@@ -46,6 +61,16 @@ func (m *MockDBService) IncrementGeminiKeyUsageCount(key string) error {
 	return args.Error(0)
 }
 
+func (m *MockDBService) BatchIncrementGeminiUsage(counts map[string]int64) error {
+	args := m.Called(counts)
+	return args.Error(0)
+}
+
+func (m *MockDBService) BatchIncrementGeminiTokenUsage(tokens map[string]int64) error {
+	args := m.Called(tokens)
+	return args.Error(0)
+}
+
 func (m *MockDBService) HandleGeminiKeyFailure(key string, threshold int) (bool, error) {
 	args := m.Called(key, threshold)
 	return args.Bool(0), args.Error(1)
@@ -58,11 +83,19 @@ func (m *MockDBService) ResetGeminiKeyFailureCount(key string) error {
 
 // Implement other db.Service methods if needed for tests, returning nil or zero values.
 func (m *MockDBService) CreateGeminiKey(key *model.GeminiKey) error { return nil }
-func (m *MockDBService) BatchAddGeminiKeys(keys []string) error     { return nil }
-func (m *MockDBService) BatchDeleteGeminiKeys(ids []uint) error     { return nil }
-func (m *MockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error) {
+func (m *MockDBService) BatchAddGeminiKeys(keys []string, status string) (int64, error) {
+	return 0, nil
+}
+func (m *MockDBService) BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error) {
+	return 0, nil
+}
+func (m *MockDBService) BatchDeleteGeminiKeys(ids []uint) error { return nil }
+func (m *MockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error) {
 	return nil, 0, nil
 }
+func (m *MockDBService) GeminiKeyStatusCounts() (db.GeminiKeyCounts, error) {
+	return db.GeminiKeyCounts{}, nil
+}
 func (m *MockDBService) GetGeminiKey(id uint) (*model.GeminiKey, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -74,6 +107,10 @@ func (m *MockDBService) UpdateGeminiKey(key *model.GeminiKey) error {
 	args := m.Called(key)
 	return args.Error(0)
 }
+func (m *MockDBService) UpdateGeminiKeyState(key *model.GeminiKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
 func (m *MockDBService) DeleteGeminiKey(id uint) error                     { return nil }
 func (m *MockDBService) UpdateGeminiKeyStatus(key, status string) error    { return nil }
 func (m *MockDBService) CreateAPIKey(key *model.APIKey) error              { return nil }
@@ -84,6 +121,27 @@ func (m *MockDBService) DeleteAPIKey(id uint) error                        { ret
 func (m *MockDBService) IncrementAPIKeyUsageCount(key string) error        { return nil }
 func (m *MockDBService) ResetAllAPIKeyUsage() error                        { return nil }
 func (m *MockDBService) FindAPIKeyByKey(key string) (*model.APIKey, error) { return nil, nil }
+func (m *MockDBService) ListDeletedGeminiKeys() ([]model.GeminiKey, error) { return nil, nil }
+func (m *MockDBService) RestoreGeminiKey(id uint) error                    { return nil }
+func (m *MockDBService) PurgeGeminiKey(id uint) error                      { return nil }
+func (m *MockDBService) ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error) {
+	return nil, 0, nil
+}
+func (m *MockDBService) StreamGeminiKeys(fn func(model.GeminiKey) error) error  { return nil }
+func (m *MockDBService) ResetDailyGeminiUsage() error                           { return nil }
+func (m *MockDBService) DecayGeminiFailureCounts(olderThan time.Duration) error { return nil }
+func (m *MockDBService) ExpireStaleAPIKeys() (int64, error)                     { return 0, nil }
+func (m *MockDBService) ResetAllGeminiFailureCounts() error                     { return nil }
+func (m *MockDBService) Ping() error                                            { return nil }
+func (m *MockDBService) PurgeDeletedGeminiKeys() (int64, error)                 { return 0, nil }
+func (m *MockDBService) ResetMonthlyAPIUsage() error                            { return nil }
+func (m *MockDBService) FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error) {
+	return nil, nil
+}
+func (m *MockDBService) FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error) { return nil, nil }
+func (m *MockDBService) PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error) {
+	return 0, nil
+}
 
 func TestNewKeyManager(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
@@ -94,7 +152,7 @@ func TestNewKeyManager(t *testing.T) {
 		keys := []model.GeminiKey{{Key: "key1"}, {Key: "key2"}}
 		mockDB.On("LoadActiveGeminiKeys").Return(keys, nil).Once()
 
-		km, err := NewKeyManager(mockDB, cfg, logger)
+		km, err := NewKeyManager(mockDB, cfg, http.DefaultTransport, logger)
 		assert.NoError(t, err)
 		assert.NotNil(t, km)
 		assert.Equal(t, 2, len(km.keys))
@@ -102,11 +160,34 @@ func TestNewKeyManager(t *testing.T) {
 		km.Close() // Shutdown background goroutines
 	})
 
+	t.Run("usage tracking disabled skips usage writes", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		keys := []model.GeminiKey{{Key: "key1"}, {Key: "key2"}}
+		mockDB.On("LoadActiveGeminiKeys").Return(keys, nil).Once()
+
+		trackUsage := false
+		noTrackCfg := &config.Config{Proxy: config.ProxyConfig{DisableKeyThreshold: 3, TrackUsage: &trackUsage}}
+		km, err := NewKeyManager(mockDB, noTrackCfg, http.DefaultTransport, logger)
+		assert.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			_, err := km.GetNextKey("", "")
+			assert.NoError(t, err)
+		}
+		km.Close() // Waits for the usage updater to drain its queue
+
+		assert.Equal(t, int64(2), km.keys[0].GetUsageCount(), "usage is still counted in memory for balancing")
+		assert.Equal(t, int64(2), km.keys[1].GetUsageCount())
+		mockDB.AssertNotCalled(t, "IncrementGeminiKeyUsageCount", mock.Anything)
+		mockDB.AssertNotCalled(t, "BatchIncrementGeminiUsage", mock.Anything)
+		mockDB.AssertExpectations(t)
+	})
+
 	t.Run("db error on initial load", func(t *testing.T) {
 		mockDB := new(MockDBService)
 		mockDB.On("LoadActiveGeminiKeys").Return(([]model.GeminiKey)(nil), errors.New("db error")).Once()
 
-		km, err := NewKeyManager(mockDB, cfg, logger)
+		km, err := NewKeyManager(mockDB, cfg, http.DefaultTransport, logger)
 		assert.Error(t, err)
 		assert.Nil(t, km)
 		mockDB.AssertExpectations(t)
@@ -116,13 +197,42 @@ func TestNewKeyManager(t *testing.T) {
 		mockDB := new(MockDBService)
 		mockDB.On("LoadActiveGeminiKeys").Return(([]model.GeminiKey)(nil), nil).Once()
 
-		km, err := NewKeyManager(mockDB, cfg, logger)
+		km, err := NewKeyManager(mockDB, cfg, http.DefaultTransport, logger)
 		assert.NoError(t, err)
 		assert.NotNil(t, km)
 		assert.Empty(t, km.keys)
 		mockDB.AssertExpectations(t)
 		km.Close()
 	})
+
+	t.Run("short reload interval refreshes keys quickly", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{{Key: "key1"}}, nil).Once()
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{{Key: "key1"}, {Key: "key2"}}, nil)
+
+		shortCfg := &config.Config{
+			Proxy:     config.ProxyConfig{DisableKeyThreshold: 3},
+			Scheduler: config.SchedulerConfig{KeyReloadInterval: "10ms"},
+		}
+		km, err := NewKeyManager(mockDB, shortCfg, http.DefaultTransport, logger)
+		assert.NoError(t, err)
+		assert.Equal(t, 10*time.Millisecond, km.reloadInterval)
+
+		assert.Eventually(t, func() bool {
+			return km.GetAvailableKeyCount() == 2
+		}, time.Second, 5*time.Millisecond)
+		km.Close()
+	})
+
+	t.Run("reload interval defaults to one minute", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{}, nil).Once()
+
+		km, err := NewKeyManager(mockDB, cfg, http.DefaultTransport, logger)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Minute, km.reloadInterval)
+		km.Close()
+	})
 }
 
 func TestGetNextKey(t *testing.T) {
@@ -150,7 +260,7 @@ func TestGetNextKey(t *testing.T) {
 
 		mockDB.On("IncrementGeminiKeyUsageCount", "key2").Return(nil).Once()
 
-		key, err := km.GetNextKey()
+		key, err := km.GetNextKey("", "")
 		assert.NoError(t, err)
 		assert.Equal(t, "key2", key)
 
@@ -170,11 +280,300 @@ func TestGetNextKey(t *testing.T) {
 			db:     mockDB,
 		}
 
-		key, err := km.GetNextKey()
+		key, err := km.GetNextKey("", "")
 		assert.Error(t, err)
 		assert.Equal(t, "", key)
 	})
 }
+func TestDailyQuota(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "key1", UsageCount: 0, DailyQuota: 2, UsageToday: 1}},
+			{GeminiKey: model.GeminiKey{Key: "key2", UsageCount: 100}},
+		},
+		logger:      logger,
+		db:          new(MockDBService),
+		updateQueue: make(chan string, 100),
+	}
+	km.sortKeys()
+
+	// key1 has one request left today.
+	key, err := km.GetNextKey("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "key1", key)
+
+	// key1 is now at quota and skipped despite its lower total usage.
+	key, err = km.GetNextKey("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "key2", key)
+	assert.Equal(t, 1, km.GetAvailableKeyCount())
+
+	km.ResetDailyUsage()
+
+	assert.Equal(t, 2, km.GetAvailableKeyCount())
+	key, err = km.GetNextKey("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "key1", key)
+}
+
+func TestGetNextKey_Group(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newKM := func() *KeyManager {
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "a1", Group: "project-a"}},
+				{GeminiKey: model.GeminiKey{Key: "a2", Group: "project-a", UsageCount: 5}},
+				{GeminiKey: model.GeminiKey{Key: "b1", Group: "project-b", UsageCount: 10}},
+				{GeminiKey: model.GeminiKey{Key: "u1", UsageCount: 20}},
+			},
+			logger:          logger,
+			skipUsageWrites: true,
+			sessions:        make(map[string]sessionPin),
+			sessionTTL:      time.Minute,
+		}
+		km.sortKeys()
+		return km
+	}
+
+	t.Run("selection is scoped to the group", func(t *testing.T) {
+		km := newKM()
+		for i := 0; i < 20; i++ {
+			key, err := km.GetNextKey("project-a", "")
+			assert.NoError(t, err)
+			assert.Contains(t, []string{"a1", "a2"}, key)
+		}
+		key, err := km.GetNextKey("project-b", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "b1", key)
+	})
+
+	t.Run("empty group selects among all keys", func(t *testing.T) {
+		km := newKM()
+		seen := make(map[string]bool)
+		for i := 0; i < 60; i++ {
+			key, err := km.GetNextKey("", "")
+			assert.NoError(t, err)
+			seen[key] = true
+		}
+		assert.Len(t, seen, 4)
+	})
+
+	t.Run("unavailable group does not borrow keys from other groups", func(t *testing.T) {
+		km := newKM()
+		_, err := km.GetNextKey("project-c", "")
+		assert.ErrorContains(t, err, `"project-c"`)
+
+		for _, k := range km.keys {
+			if k.Group == "project-b" {
+				k.Disabled = true
+			}
+		}
+		_, err = km.GetNextKey("project-b", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("session pinned outside the group is re-pinned", func(t *testing.T) {
+		km := newKM()
+		km.sessions["session-a"] = sessionPin{key: "u1", expiresAt: time.Now().Add(time.Minute)}
+
+		key, err := km.GetKeyForSession("session-a", "project-b", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "b1", key)
+		assert.Equal(t, "b1", km.sessions["session-a"].key)
+	})
+}
+
+func TestGetNextKey_Model(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newKM := func() *KeyManager {
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "flash-only", Models: []string{"gemini-2.0-flash"}}},
+				{GeminiKey: model.GeminiKey{Key: "pro", Models: []string{"gemini-2.0-flash", "gemini-2.5-pro"}, UsageCount: 10}},
+				{GeminiKey: model.GeminiKey{Key: "untested", UsageCount: 20}},
+			},
+			logger:          logger,
+			skipUsageWrites: true,
+			sessions:        make(map[string]sessionPin),
+			sessionTTL:      time.Minute,
+		}
+		km.sortKeys()
+		return km
+	}
+
+	t.Run("prefers keys known to serve the model", func(t *testing.T) {
+		km := newKM()
+		for i := 0; i < 5; i++ {
+			key, err := km.GetNextKey("", "gemini-2.5-pro")
+			assert.NoError(t, err)
+			assert.Equal(t, "pro", key)
+		}
+		key, err := km.GetNextKey("", "models/gemini-2.5-pro")
+		assert.NoError(t, err)
+		assert.Equal(t, "pro", key)
+	})
+
+	t.Run("falls back to untested keys, then to all keys", func(t *testing.T) {
+		km := newKM()
+		key, err := km.GetNextKey("", "gemini-1.5-flash")
+		assert.NoError(t, err)
+		assert.Equal(t, "untested", key)
+
+		for _, k := range km.keys {
+			if k.Key == "untested" {
+				k.Disabled = true
+			}
+		}
+		key, err = km.GetNextKey("", "gemini-1.5-flash")
+		assert.NoError(t, err)
+		assert.Contains(t, []string{"flash-only", "pro"}, key)
+	})
+
+	t.Run("empty model ignores model lists", func(t *testing.T) {
+		km := newKM()
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "flash-only", key)
+	})
+
+	t.Run("session pinned to a key lacking the model is re-pinned", func(t *testing.T) {
+		km := newKM()
+		km.sessions["session-a"] = sessionPin{key: "flash-only", expiresAt: time.Now().Add(time.Minute)}
+
+		key, err := km.GetKeyForSession("session-a", "", "gemini-2.0-flash")
+		assert.NoError(t, err)
+		assert.Equal(t, "flash-only", key)
+
+		key, err = km.GetKeyForSession("session-a", "", "gemini-2.5-pro")
+		assert.NoError(t, err)
+		assert.Equal(t, "pro", key)
+		assert.Equal(t, "pro", km.sessions["session-a"].key)
+	})
+}
+
+func TestGetKeyForSession(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newKM := func() *KeyManager {
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "key1", UsageCount: 1}},
+				{GeminiKey: model.GeminiKey{Key: "key2", UsageCount: 2}},
+			},
+			logger:      logger,
+			db:          new(MockDBService),
+			updateQueue: make(chan string, 100),
+			sessions:    make(map[string]sessionPin),
+			sessionTTL:  time.Minute,
+		}
+		km.sortKeys()
+		return km
+	}
+
+	t.Run("pins a new session and reuses its key", func(t *testing.T) {
+		km := newKM()
+
+		key, err := km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+
+		// key1 now has the higher usage count, but the session stays on it.
+		for i := 0; i < 3; i++ {
+			key, err = km.GetKeyForSession("session-a", "", "")
+			assert.NoError(t, err)
+			assert.Equal(t, "key1", key)
+		}
+
+		// A different session gets the lowest-usage key.
+		key, err = km.GetKeyForSession("session-b", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+	})
+
+	t.Run("falls back and re-pins when the pinned key is disabled", func(t *testing.T) {
+		km := newKM()
+
+		key, err := km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+
+		for _, k := range km.keys {
+			if k.Key == "key1" {
+				k.Disabled = true
+			}
+		}
+
+		key, err = km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+		assert.Equal(t, "key2", km.sessions["session-a"].key)
+
+		// Re-enabling key1 does not move the session back.
+		for _, k := range km.keys {
+			k.Disabled = false
+		}
+		key, err = km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+	})
+
+	t.Run("retry re-pins the session to the retry key", func(t *testing.T) {
+		km := newKM()
+
+		key, err := km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+
+		// key1 stays available, but the retry picks key2 and the session moves with it.
+		km.keys[0].UsageCount = 10
+		key, err = km.GetRetryKey("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+
+		key, err = km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+	})
+
+	t.Run("expired pin is replaced", func(t *testing.T) {
+		km := newKM()
+		km.sessions["session-a"] = sessionPin{key: "key2", expiresAt: time.Now().Add(-time.Second)}
+
+		key, err := km.GetKeyForSession("session-a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+
+		km.sessions["session-b"] = sessionPin{key: "key2", expiresAt: time.Now().Add(-time.Second)}
+		km.pruneSessions()
+		assert.NotContains(t, km.sessions, "session-b")
+		assert.Contains(t, km.sessions, "session-a")
+	})
+
+	t.Run("empty session ID uses normal selection", func(t *testing.T) {
+		km := newKM()
+
+		key, err := km.GetKeyForSession("", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+		assert.Empty(t, km.sessions)
+	})
+
+	t.Run("no keys available", func(t *testing.T) {
+		km := newKM()
+		for _, k := range km.keys {
+			k.Disabled = true
+		}
+
+		_, err := km.GetKeyForSession("session-a", "", "")
+		assert.Error(t, err)
+		assert.NotContains(t, km.sessions, "session-a")
+	})
+}
+
 func TestHandleKeyFailure(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	cfg := &config.Config{Proxy: config.ProxyConfig{DisableKeyThreshold: 3}}
@@ -193,11 +592,11 @@ func TestHandleKeyFailure(t *testing.T) {
 		}
 
 		// Expect UpdateGeminiKey to be called with the updated key data
-		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
 			return k.Key == "key1" && k.FailureCount == 3 && k.Status == "disabled"
 		})).Return(nil).Once()
 
-		km.HandleKeyFailure("key1")
+		km.HandleKeyFailure("key1", http.StatusUnauthorized, "")
 
 		// Check internal state
 		assert.Equal(t, 3, km.keys[0].GetFailureCount())
@@ -223,12 +622,12 @@ func TestHandleKeyFailure(t *testing.T) {
 		}
 
 		// No DB call is expected
-		km.HandleKeyFailure("key1")
+		km.HandleKeyFailure("key1", http.StatusForbidden, "")
 
 		assert.Equal(t, 2, km.keys[0].GetFailureCount())
 		assert.False(t, km.keys[0].Disabled)
 		// We still expect a DB call to update the failure count
-		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
 			return k.Key == "key1" && k.FailureCount == 2
 		})).Return(nil).Once()
 
@@ -236,111 +635,516 @@ func TestHandleKeyFailure(t *testing.T) {
 		time.Sleep(50 * time.Millisecond)
 		mockDB.AssertExpectations(t)
 	})
-}
 
-func TestHandleKeySuccess(t *testing.T) {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-
-	t.Run("resets failure count and re-enables key", func(t *testing.T) {
+	t.Run("per-key thresholds override the global one", func(t *testing.T) {
 		mockDB := new(MockDBService)
-		keys := []*managedKey{
-			{
-				GeminiKey:  model.GeminiKey{Key: "key1", Status: "disabled", FailureCount: 3},
-				Disabled:   true,
-				DisabledAt: time.Now(),
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "throwaway", Status: "active", DisableThreshold: 1}},
+				{GeminiKey: model.GeminiKey{Key: "premium", Status: "active", DisableThreshold: 5}},
+				{GeminiKey: model.GeminiKey{Key: "default", Status: "active"}},
 			},
+			logger:           logger,
+			db:               mockDB,
+			disableThreshold: cfg.Proxy.DisableKeyThreshold,
+			syncDBUpdates:    true,
 		}
-		km := &KeyManager{
-			keys:        keys,
-			logger:      logger,
-			db:          mockDB,
-			updateQueue: make(chan string, 10),
+
+		km.HandleKeyFailure("throwaway", http.StatusForbidden, "")
+		assert.True(t, km.keys[0].Disabled, "a threshold of 1 disables on the first failure")
+
+		for i := 0; i < 3; i++ {
+			km.HandleKeyFailure("premium", http.StatusForbidden, "")
+			km.HandleKeyFailure("default", http.StatusForbidden, "")
 		}
+		assert.False(t, km.keys[1].Disabled, "3 failures are below the key's threshold of 5")
+		assert.True(t, km.keys[2].Disabled, "keys without a threshold use the global one")
 
-		// Expect UpdateGeminiKey to be called with the reset key data
-		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
-			return k.Key == "key1" && k.FailureCount == 0 && k.Status == "active"
-		})).Return(nil).Once()
+		km.HandleKeyFailure("premium", http.StatusForbidden, "")
+		km.HandleKeyFailure("premium", http.StatusForbidden, "")
+		assert.True(t, km.keys[1].Disabled)
+		assert.Equal(t, 5, km.Snapshot()[1].DisableThreshold)
+	})
+}
 
-		km.HandleKeySuccess("key1")
+func TestHandleKeyFailure_FatalErrorPatterns(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newKM := func(mockDB *MockDBService) *KeyManager {
+		return &KeyManager{
+			keys:                     []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1", Status: "active"}}},
+			logger:                   logger,
+			db:                       mockDB,
+			disableThreshold:         3,
+			temporaryDisableDuration: time.Minute,
+			fatalErrorPatterns:       []string{"API key not valid"},
+			syncDBUpdates:            true,
+		}
+	}
 
-		// Check internal state
-		assert.Equal(t, 0, km.keys[0].GetFailureCount())
-		assert.False(t, km.keys[0].Disabled)
+	t.Run("matching pattern disables the key permanently", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == "key1" && k.Status == "disabled" && k.FailureCount == 0 && k.DisabledReason == model.DisabledReasonFatalError
+		})).Return(nil).Once()
+		km := newKM(mockDB)
 
-		// Allow time for the async DB update to be called
-		time.Sleep(50 * time.Millisecond)
+		km.HandleKeyFailure("key1", http.StatusBadRequest, `{"error":{"message":"api key NOT VALID. Please pass a valid API key."}}`)
+
+		assert.True(t, km.keys[0].Disabled)
+		assert.Equal(t, "disabled", km.keys[0].Status)
+		// A fatal disable outlives the temporary disable duration.
+		km.reenableExpiredLocked(time.Now().Add(time.Hour))
+		assert.True(t, km.keys[0].Disabled)
 		mockDB.AssertExpectations(t)
 	})
 
-	t.Run("does nothing for a healthy key", func(t *testing.T) {
+	t.Run("non-matching error counts toward the threshold", func(t *testing.T) {
 		mockDB := new(MockDBService)
-		keys := []*managedKey{
-			{GeminiKey: model.GeminiKey{Key: "key1", Status: "active", FailureCount: 0}},
-		}
-		km := &KeyManager{
-			keys:        keys,
-			logger:      logger,
-			db:          mockDB,
-			updateQueue: make(chan string, 10),
-		}
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == "key1" && k.Status == "active" && k.FailureCount == 1
+		})).Return(nil).Once()
+		km := newKM(mockDB)
 
-		// No DB call is expected
-		km.HandleKeySuccess("key1")
+		km.HandleKeyFailure("key1", http.StatusInternalServerError, `{"error":{"message":"Internal error encountered."}}`)
 
-		assert.Equal(t, 0, km.keys[0].GetFailureCount())
 		assert.False(t, km.keys[0].Disabled)
-		t.Run("returns error when all keys are disabled", func(t *testing.T) {
-			mockDB := new(MockDBService)
-			keys := []*managedKey{
-				{GeminiKey: model.GeminiKey{Key: "key1"}, Disabled: true},
-				{GeminiKey: model.GeminiKey{Key: "key2"}, Disabled: true},
-			}
-			km := &KeyManager{
-				keys:   keys,
-				logger: logger,
-				db:     mockDB,
-			}
-
-			key, err := km.GetNextKey()
-			assert.Error(t, err)
-			assert.Equal(t, "all available Gemini keys are temporarily disabled", err.Error())
-			assert.Equal(t, "", key)
-		})
-
+		assert.Equal(t, 1, km.keys[0].GetFailureCount())
 		mockDB.AssertExpectations(t)
 	})
 }
 
-func TestReviveDisabledKeys(t *testing.T) {
+func TestHandleKeyFailure_RateLimit(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	t.Run("revives a valid key", func(t *testing.T) {
+	t.Run("a stream of 429s never permanently disables a key", func(t *testing.T) {
 		mockDB := new(MockDBService)
-		mockHTTP := new(MockHTTPClient)
-		validKey := "a-valid-key"
-
-		keys := []*managedKey{
-			{
-				GeminiKey:  model.GeminiKey{Key: validKey, Status: "disabled"},
-				Disabled:   true,
-				DisabledAt: time.Now().Add(-1 * time.Minute), // Disabled a minute ago
-			},
-		}
 		km := &KeyManager{
-			keys:          keys,
-			logger:        logger,
-			db:            mockDB,
-			httpClient:    mockHTTP,
-			updateQueue:   make(chan string, 10),
-			syncDBUpdates: true,
+			keys:             []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1", Status: "active"}}},
+			logger:           logger,
+			db:               mockDB,
+			disableThreshold: 3,
+			cooldownDuration: time.Minute,
+			updateQueue:      make(chan string, 10),
+			syncDBUpdates:    true,
 		}
 
-		// Mock the HTTP call to succeed
-		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("OK"))}, nil).Once()
-		// Expect the key to be marked as active in the DB after successful test
-		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
-			return k.Key == validKey && k.FailureCount == 0 && k.Status == "active"
+		for i := 0; i < 10; i++ {
+			km.HandleKeyFailure("key1", http.StatusTooManyRequests, "")
+		}
+
+		assert.Equal(t, 0, km.keys[0].GetFailureCount())
+		assert.False(t, km.keys[0].Disabled)
+		assert.Equal(t, "active", km.keys[0].Status)
+		assert.True(t, km.keys[0].CooldownUntil.After(time.Now()))
+		// No DB writes are expected for a cooldown.
+		mockDB.AssertNotCalled(t, "UpdateGeminiKeyState", mock.Anything)
+	})
+
+	t.Run("cooling down key is skipped until the cooldown expires", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("IncrementGeminiKeyUsageCount", mock.Anything).Return(nil)
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "key1"}},
+				{GeminiKey: model.GeminiKey{Key: "key2", UsageCount: 10}},
+			},
+			logger:           logger,
+			db:               mockDB,
+			disableThreshold: 3,
+			cooldownDuration: time.Minute,
+			updateQueue:      make(chan string, 10),
+		}
+
+		km.HandleKeyFailure("key1", http.StatusTooManyRequests, "")
+		assert.Equal(t, 1, km.GetAvailableKeyCount())
+
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+
+		// Once the cooldown has passed the key is handed out again.
+		for _, k := range km.keys {
+			if k.Key == "key1" {
+				k.CooldownUntil = time.Now().Add(-time.Second)
+			}
+		}
+		assert.Equal(t, 2, km.GetAvailableKeyCount())
+		key, err = km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+	})
+
+	t.Run("falls back to the soonest cooled key when all keys are cooling down", func(t *testing.T) {
+		now := time.Now()
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "key1"}, CooldownUntil: now.Add(time.Minute)},
+				{GeminiKey: model.GeminiKey{Key: "key2", UsageCount: 10}, CooldownUntil: now.Add(10 * time.Second)},
+				{GeminiKey: model.GeminiKey{Key: "key3"}, Disabled: true},
+			},
+			logger:           logger,
+			disableThreshold: 3,
+			cooldownDuration: time.Minute,
+			skipUsageWrites:  true,
+		}
+
+		// Cooling keys still count, so the circuit breaker lets requests through.
+		assert.Equal(t, 2, km.GetAvailableKeyCount())
+
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+
+		// Disabled keys are never used as a fallback.
+		km.keys = []*managedKey{{GeminiKey: model.GeminiKey{Key: "key3"}, Disabled: true}}
+		assert.Equal(t, 0, km.GetAvailableKeyCount())
+		_, err = km.GetNextKey("", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestTemporaryDisable(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newManager := func(mockDB *MockDBService) *KeyManager {
+		return &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "key1", Status: "active"}},
+				{GeminiKey: model.GeminiKey{Key: "key2", Status: "active", UsageCount: 10}},
+			},
+			logger:                   logger,
+			db:                       mockDB,
+			disableThreshold:         3,
+			temporaryDisableDuration: time.Minute,
+			skipUsageWrites:          true,
+			syncDBUpdates:            true,
+		}
+	}
+
+	t.Run("disabled key stays active in the database", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		km := newManager(mockDB)
+
+		for i := 0; i < 3; i++ {
+			km.HandleKeyFailure("key1", http.StatusInternalServerError, "")
+		}
+
+		assert.True(t, km.keys[0].Disabled)
+		assert.Equal(t, "active", km.keys[0].Status)
+		assert.Equal(t, 1, km.GetAvailableKeyCount())
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key2", key)
+	})
+
+	t.Run("key is re-enabled once the duration has passed", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		km := newManager(mockDB)
+
+		for i := 0; i < 3; i++ {
+			km.HandleKeyFailure("key1", http.StatusInternalServerError, "")
+		}
+		km.keys[0].DisabledAt = time.Now().Add(-2 * time.Minute)
+
+		assert.Equal(t, 2, km.GetAvailableKeyCount())
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key, "the re-enabled key has the lowest usage")
+		assert.False(t, km.keys[0].Disabled)
+		assert.Equal(t, 2, km.keys[0].FailureCount)
+
+		// The next real request acts as the test: one more failure disables it again.
+		km.HandleKeyFailure("key1", http.StatusInternalServerError, "")
+		assert.True(t, km.keys[0].Disabled)
+	})
+
+	t.Run("without a duration keys stay disabled", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		km := newManager(mockDB)
+		km.temporaryDisableDuration = 0
+
+		for i := 0; i < 3; i++ {
+			km.HandleKeyFailure("key1", http.StatusInternalServerError, "")
+		}
+		km.keys[0].DisabledAt = time.Now().Add(-time.Hour)
+
+		assert.Equal(t, "disabled", km.keys[0].Status)
+		assert.Equal(t, 1, km.GetAvailableKeyCount())
+	})
+
+	t.Run("survives a reload", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{
+			{Key: "key1", Status: "active", FailureCount: 3},
+			{Key: "key2", Status: "active"},
+		}, nil).Once()
+		km := newManager(mockDB)
+
+		for i := 0; i < 3; i++ {
+			km.HandleKeyFailure("key1", http.StatusInternalServerError, "")
+		}
+		_, err := km.ReloadKeys()
+		assert.NoError(t, err)
+
+		assert.True(t, km.keys[0].Disabled)
+		assert.Equal(t, 1, km.GetAvailableKeyCount())
+	})
+}
+
+func TestSuccessRatioDisable(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newManager := func() *KeyManager {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		return &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "flaky-key", Status: "active"}},
+			},
+			logger:           logger,
+			db:               mockDB,
+			disableThreshold: 3,
+			minSuccessRatio:  0.6,
+			minSamples:       10,
+			skipUsageWrites:  true,
+			syncDBUpdates:    true,
+		}
+	}
+
+	t.Run("flaky key is disabled once enough samples are collected", func(t *testing.T) {
+		km := newManager()
+
+		// Alternating results never reach three consecutive failures.
+		for i := 0; i < 4; i++ {
+			km.HandleKeySuccess("flaky-key")
+			km.HandleKeyFailure("flaky-key", http.StatusInternalServerError, "")
+		}
+		assert.False(t, km.keys[0].Disabled, "8 samples are below the minimum")
+		ratio, samples := km.keys[0].successRatio()
+		assert.Equal(t, 0.5, ratio)
+		assert.Equal(t, 8, samples)
+
+		km.HandleKeySuccess("flaky-key")
+		km.HandleKeyFailure("flaky-key", http.StatusInternalServerError, "")
+
+		assert.True(t, km.keys[0].Disabled)
+		assert.Equal(t, "disabled", km.keys[0].Status)
+		assert.Less(t, km.keys[0].FailureCount, km.disableThreshold)
+		_, samples = km.keys[0].successRatio()
+		assert.Zero(t, samples, "the window restarts after disabling")
+	})
+
+	t.Run("healthy key with occasional failures stays enabled", func(t *testing.T) {
+		km := newManager()
+
+		for i := 0; i < 30; i++ {
+			if i%5 == 0 {
+				km.HandleKeyFailure("flaky-key", http.StatusInternalServerError, "")
+			} else {
+				km.HandleKeySuccess("flaky-key")
+			}
+		}
+
+		assert.False(t, km.keys[0].Disabled)
+		ratio, samples := km.keys[0].successRatio()
+		assert.Equal(t, 10, samples, "only the last min_samples requests are kept")
+		assert.Equal(t, 0.8, ratio)
+	})
+
+	t.Run("rate limits do not count", func(t *testing.T) {
+		km := newManager()
+
+		for i := 0; i < 20; i++ {
+			km.HandleKeyFailure("flaky-key", http.StatusTooManyRequests, "")
+		}
+
+		assert.False(t, km.keys[0].Disabled)
+		_, samples := km.keys[0].successRatio()
+		assert.Zero(t, samples)
+	})
+
+	t.Run("disabled without a minimum ratio", func(t *testing.T) {
+		km := newManager()
+		km.minSuccessRatio = 0
+
+		for i := 0; i < 10; i++ {
+			km.HandleKeySuccess("flaky-key")
+			km.HandleKeyFailure("flaky-key", http.StatusInternalServerError, "")
+		}
+
+		assert.False(t, km.keys[0].Disabled)
+		infos := km.Snapshot()
+		require.NotNil(t, infos[0].SuccessRatio)
+		assert.Equal(t, 0.5, *infos[0].SuccessRatio)
+		assert.Equal(t, 10, infos[0].Samples)
+	})
+}
+
+func TestHandleKeySuccess(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	t.Run("resets failure count and re-enables key", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		keys := []*managedKey{
+			{
+				GeminiKey:  model.GeminiKey{Key: "key1", Status: "disabled", FailureCount: 3},
+				Disabled:   true,
+				DisabledAt: time.Now(),
+			},
+		}
+		km := &KeyManager{
+			keys:        keys,
+			logger:      logger,
+			db:          mockDB,
+			updateQueue: make(chan string, 10),
+		}
+
+		// Expect UpdateGeminiKey to be called with the reset key data
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == "key1" && k.FailureCount == 0 && k.Status == "active"
+		})).Return(nil).Once()
+
+		km.HandleKeySuccess("key1")
+
+		// Check internal state
+		assert.Equal(t, 0, km.keys[0].GetFailureCount())
+		assert.False(t, km.keys[0].Disabled)
+
+		// Allow time for the async DB update to be called
+		time.Sleep(50 * time.Millisecond)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("does nothing for a healthy key", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "key1", Status: "active", FailureCount: 0}},
+		}
+		km := &KeyManager{
+			keys:        keys,
+			logger:      logger,
+			db:          mockDB,
+			updateQueue: make(chan string, 10),
+		}
+
+		// No DB call is expected
+		km.HandleKeySuccess("key1")
+
+		assert.Equal(t, 0, km.keys[0].GetFailureCount())
+		assert.False(t, km.keys[0].Disabled)
+		t.Run("returns error when all keys are disabled", func(t *testing.T) {
+			mockDB := new(MockDBService)
+			keys := []*managedKey{
+				{GeminiKey: model.GeminiKey{Key: "key1"}, Disabled: true},
+				{GeminiKey: model.GeminiKey{Key: "key2"}, Disabled: true},
+			}
+			km := &KeyManager{
+				keys:   keys,
+				logger: logger,
+				db:     mockDB,
+			}
+
+			key, err := km.GetNextKey("", "")
+			assert.Error(t, err)
+			assert.Equal(t, "all available Gemini keys are temporarily disabled", err.Error())
+			assert.Equal(t, "", key)
+
+			var disabled *KeysDisabledError
+			require.ErrorAs(t, err, &disabled)
+			assert.Zero(t, disabled.RetryAfter, "keys without a temporary disable duration have no re-enable time")
+		})
+
+		t.Run("reports when the first temporarily disabled key is re-enabled", func(t *testing.T) {
+			now := time.Now()
+			km := &KeyManager{
+				keys: []*managedKey{
+					{GeminiKey: model.GeminiKey{Key: "key1"}, Disabled: true, DisabledAt: now.Add(-time.Minute)},
+					{GeminiKey: model.GeminiKey{Key: "key2"}, Disabled: true, DisabledAt: now.Add(-3 * time.Minute)},
+					{GeminiKey: model.GeminiKey{Key: "key3", Status: "disabled"}, Disabled: true, DisabledAt: now.Add(-4 * time.Minute)},
+				},
+				logger:                   logger,
+				temporaryDisableDuration: 5 * time.Minute,
+			}
+
+			_, err := km.GetNextKey("", "")
+			var disabled *KeysDisabledError
+			require.ErrorAs(t, err, &disabled)
+			assert.InDelta(t, (2 * time.Minute).Seconds(), disabled.RetryAfter.Seconds(), 1)
+		})
+
+		t.Run("returns ErrNoKeys without keys", func(t *testing.T) {
+			km := &KeyManager{logger: logger}
+			_, err := km.GetNextKey("", "")
+			assert.ErrorIs(t, err, ErrNoKeys)
+
+			km.keys = []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}}
+			_, err = km.GetNextKey("other", "")
+			assert.ErrorIs(t, err, ErrNoKeys)
+		})
+
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestAvailabilityError(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	km := &KeyManager{
+		keys:                     []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+		logger:                   logger,
+		temporaryDisableDuration: time.Minute,
+	}
+	assert.NoError(t, km.AvailabilityError())
+
+	km.keys[0].Disabled = true
+	km.keys[0].DisabledAt = time.Now()
+	var disabled *KeysDisabledError
+	require.ErrorAs(t, km.AvailabilityError(), &disabled)
+	assert.Positive(t, disabled.RetryAfter)
+	assert.Zero(t, km.keys[0].UsageCount, "checking availability records no usage")
+
+	km.keys[0].Disabled = false
+	assert.NoError(t, km.SuspendedError())
+	km.SetPaused(true)
+	assert.NoError(t, km.AvailabilityError(), "pausing is left out of the circuit breaker's check")
+	assert.ErrorIs(t, km.SuspendedError(), ErrPaused)
+	km.draining.Store(true)
+	assert.ErrorIs(t, km.SuspendedError(), ErrShuttingDown)
+}
+
+func TestReviveDisabledKeys(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	t.Run("revives a valid key", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockHTTP := new(MockHTTPClient)
+		validKey := "a-valid-key"
+
+		keys := []*managedKey{
+			{
+				GeminiKey:  model.GeminiKey{Key: validKey, Status: "disabled"},
+				Disabled:   true,
+				DisabledAt: time.Now().Add(-1 * time.Minute), // Disabled a minute ago
+			},
+		}
+		km := &KeyManager{
+			keys:          keys,
+			logger:        logger,
+			db:            mockDB,
+			httpClient:    mockHTTP,
+			updateQueue:   make(chan string, 10),
+			syncDBUpdates: true,
+		}
+
+		// Mock the HTTP call to succeed
+		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("OK"))}, nil).Once()
+		// Expect the key to be marked as active in the DB after successful test
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == validKey && k.FailureCount == 0 && k.Status == "active"
 		})).Return(nil).Once()
 
 		km.ReviveDisabledKeys()
@@ -375,15 +1179,67 @@ func TestReviveDisabledKeys(t *testing.T) {
 
 		// Mock the HTTP call to fail
 		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader("Bad Request"))}, nil).Once()
-		// We do NOT expect any DB calls because the key is invalid and should not be revived.
+		// The key is not revived; only the failed revival is recorded for the auto-prune job.
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.Key == invalidKey && k.Status == "disabled" && k.RevivalFailures == 1
+		})).Return(nil).Once()
 		km.ReviveDisabledKeys()
 
 		// Check internal state - key should remain disabled
 		assert.True(t, km.keys[0].Disabled)
+		assert.Equal(t, 1, km.keys[0].RevivalFailures)
 
 		mockHTTP.AssertExpectations(t)
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("temporarily disabled keys do not count failed revivals", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		km := &KeyManager{
+			keys: []*managedKey{{
+				GeminiKey:  model.GeminiKey{Key: "temporarily-disabled-key", Status: "active"},
+				Disabled:   true,
+				DisabledAt: time.Now().Add(-1 * time.Minute),
+			}},
+			logger: logger,
+			db:     mockDB,
+			httpClient: httpClientFunc(func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader("Bad Request"))}, nil
+			}),
+			syncDBUpdates: true,
+		}
+
+		km.ReviveDisabledKeys()
+
+		assert.True(t, km.keys[0].Disabled)
+		assert.Equal(t, 0, km.keys[0].RevivalFailures)
+		mockDB.AssertNotCalled(t, "UpdateGeminiKeyState", mock.Anything)
+	})
+}
+
+func TestKeyManager_DisabledPeriod(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mockDB := new(MockDBService)
+	mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+	key := &managedKey{GeminiKey: model.GeminiKey{Key: "failing-key", Status: "active", RevivalFailures: 4}}
+	km := &KeyManager{
+		keys:             []*managedKey{key},
+		logger:           logger,
+		db:               mockDB,
+		disableThreshold: 1,
+		syncDBUpdates:    true,
+	}
+
+	km.HandleKeyFailure("failing-key", http.StatusInternalServerError, "")
+	assert.Equal(t, "disabled", key.Status)
+	assert.WithinDuration(t, time.Now(), key.DisabledSince, time.Second)
+	assert.Equal(t, 0, key.RevivalFailures, "a new disabled period starts a new count")
+
+	key.RevivalFailures = 2
+	km.HandleKeySuccess("failing-key")
+	assert.Equal(t, "active", key.Status)
+	assert.True(t, key.DisabledSince.IsZero())
+	assert.Equal(t, 0, key.RevivalFailures)
 }
 
 func TestKeyManager_Misc(t *testing.T) {
@@ -422,10 +1278,46 @@ func TestKeyManager_Misc(t *testing.T) {
 		// This is hard to test without a more complex setup,
 		// but we can at least call it and ensure it doesn't panic.
 		// A more thorough test would involve mocks for the http client.
+		// The background check may outlive this test and persist failures, so allow it.
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil).Maybe()
 		km.TestAllKeysAsync()
 	})
 }
 
+func TestTestKeysAsync(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var mu sync.Mutex
+	var tested []string
+	client := httpClientFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		tested = append(tested, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+	})
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "key1", Status: "active"}},
+			{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 2}, Key: "key2", Status: "active"}},
+			{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 3}, Key: "key3", Status: "active"}},
+		},
+		logger:     logger,
+		db:         new(MockDBService),
+		httpClient: client,
+	}
+
+	km.TestKeysAsync([]uint{1, 3})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(tested) == 2
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // Make sure no other key is tested afterwards.
+	mu.Lock()
+	assert.ElementsMatch(t, []string{"key1", "key3"}, tested)
+	mu.Unlock()
+}
+
 func TestUpdateKeys(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	mockDB := new(MockDBService)
@@ -453,6 +1345,86 @@ func TestUpdateKeys(t *testing.T) {
 	mockDB.AssertExpectations(t)
 }
 
+func TestSnapshot(t *testing.T) {
+	disabledAt := time.Now().Add(-time.Minute)
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "secret-key-aaaa", Status: "active", UsageCount: 2, ProjectLabel: "project-foo"}},
+			{GeminiKey: model.GeminiKey{Key: "secret-key-bbbb", Status: "disabled", FailureCount: 3}, Disabled: true, DisabledAt: disabledAt},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	infos := km.Snapshot()
+	require.Len(t, infos, 2)
+	assert.Equal(t, "aaaa", infos[0].KeySuffix)
+	assert.Equal(t, "project-foo", infos[0].ProjectLabel)
+	assert.True(t, infos[0].Available)
+	assert.Nil(t, infos[0].DisabledAt)
+	assert.Equal(t, int64(2), infos[0].UsageCount)
+
+	assert.Equal(t, "bbbb", infos[1].KeySuffix)
+	assert.True(t, infos[1].Disabled)
+	assert.False(t, infos[1].Available)
+	require.NotNil(t, infos[1].DisabledAt)
+	assert.True(t, disabledAt.Equal(*infos[1].DisabledAt))
+
+	data, err := json.Marshal(infos)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret-key", "the full secret must never be exposed")
+}
+
+func TestKeyLogsIncludeProjectLabel(t *testing.T) {
+	var logBuf bytes.Buffer
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "secret-key-aaaa", ProjectLabel: "project-foo"}},
+			{GeminiKey: model.GeminiKey{Key: "secret-key-bbbb"}},
+		},
+		logger:                   slog.New(slog.NewTextHandler(&logBuf, nil)),
+		temporaryDisableDuration: time.Minute,
+	}
+
+	km.disableKeyLocked(km.keys[0], model.DisabledReasonFailureThreshold, "repeated failures")
+	assert.Contains(t, logBuf.String(), "key_suffix=aaaa project_label=project-foo")
+
+	logBuf.Reset()
+	km.disableKeyLocked(km.keys[1], model.DisabledReasonFailureThreshold, "repeated failures")
+	assert.Contains(t, logBuf.String(), "key_suffix=bbbb")
+	assert.NotContains(t, logBuf.String(), "project_label", "keys without a label are logged by suffix only")
+}
+
+func TestReloadKeys(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("returns the number of loaded keys", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		km := &KeyManager{logger: logger, db: mockDB}
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{{Key: "a"}, {Key: "b"}, {Key: "c"}}, nil).Once()
+
+		count, err := km.ReloadKeys()
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, 3, km.GetAvailableKeyCount())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("keeps existing keys on error", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		km := &KeyManager{
+			keys:   []*managedKey{{GeminiKey: model.GeminiKey{Key: "existing"}}},
+			logger: logger,
+			db:     mockDB,
+		}
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey(nil), errors.New("db down")).Once()
+
+		_, err := km.ReloadKeys()
+		assert.Error(t, err)
+		assert.Len(t, km.keys, 1)
+		mockDB.AssertExpectations(t)
+	})
+}
+
 func TestTestKeyByID(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
@@ -472,11 +1444,11 @@ func TestTestKeyByID(t *testing.T) {
 		// Mock the HTTP call to fail
 		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader("Bad Request"))}, nil).Once()
 		// Expect the DB to be updated with the failure
-		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
 			return k.ID == 1 && k.Status == "disabled"
 		})).Return(nil).Once()
 
-		err := km.TestKeyByID(1)
+		err := km.TestKeyByID(context.Background(), 1)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "test request returned non-200 status: 400")
 
@@ -500,17 +1472,82 @@ func TestTestKeyByID(t *testing.T) {
 		// Mock the HTTP call to succeed
 		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("OK"))}, nil).Once()
 		// Expect the DB to be updated with the success (re-activation)
-		mockDB.On("UpdateGeminiKey", mock.MatchedBy(func(k *model.GeminiKey) bool {
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
 			return k.ID == 1 && k.Status == "active"
 		})).Return(nil).Once()
 
-		err := km.TestKeyByID(1)
+		err := km.TestKeyByID(context.Background(), 1)
 		assert.NoError(t, err)
 
 		mockHTTP.AssertExpectations(t)
 		mockDB.AssertExpectations(t)
 	})
 
+	t.Run("successful test records the key's models", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockHTTP := new(MockHTTPClient)
+		km := &KeyManager{
+			keys:          []*managedKey{{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "in-memory-key", Status: "active"}}},
+			logger:        logger,
+			db:            mockDB,
+			httpClient:    mockHTTP,
+			updateQueue:   make(chan string, 10),
+			syncDBUpdates: true,
+		}
+
+		body := `{"object":"list","data":[{"id":"models/gemini-2.0-flash"},{"id":"models/gemini-2.5-pro"}]}`
+		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil).Once()
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.ID == 1 && assert.ObjectsAreEqual([]string{"gemini-2.0-flash", "gemini-2.5-pro"}, k.Models)
+		})).Return(nil).Once()
+
+		assert.NoError(t, km.TestKeyByID(context.Background(), 1))
+		assert.Equal(t, []string{"gemini-2.0-flash", "gemini-2.5-pro"}, km.Snapshot()[0].Models)
+
+		// Unchanged models are not written again.
+		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil).Once()
+		assert.NoError(t, km.TestKeyByID(context.Background(), 1))
+
+		mockHTTP.AssertExpectations(t)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("pending key is activated by a successful test only", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockHTTP := new(MockHTTPClient)
+		km := &KeyManager{
+			keys:             []*managedKey{},
+			logger:           logger,
+			db:               mockDB,
+			httpClient:       mockHTTP,
+			disableThreshold: 3,
+			updateQueue:      make(chan string, 10),
+			syncDBUpdates:    true,
+			skipUsageWrites:  true,
+		}
+
+		mockDB.On("GetGeminiKey", uint(4)).Return(&model.GeminiKey{Model: gorm.Model{ID: 4}, Key: "pending-key", Status: "pending"}, nil).Once()
+		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader("Server Error"))}, nil).Once()
+
+		assert.Error(t, km.TestKeyByID(context.Background(), 4))
+		assert.Empty(t, km.keys, "a pending key that failed its test must stay out of rotation")
+		mockDB.AssertNotCalled(t, "UpdateGeminiKeyState", mock.Anything)
+
+		mockDB.On("GetGeminiKey", uint(4)).Return(&model.GeminiKey{Model: gorm.Model{ID: 4}, Key: "pending-key", Status: "pending", FailureCount: 1}, nil).Once()
+		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("OK"))}, nil).Once()
+		mockDB.On("UpdateGeminiKeyState", mock.MatchedBy(func(k *model.GeminiKey) bool {
+			return k.ID == 4 && k.Status == "active" && k.FailureCount == 0
+		})).Return(nil).Once()
+
+		assert.NoError(t, km.TestKeyByID(context.Background(), 4))
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "pending-key", key)
+
+		mockHTTP.AssertExpectations(t)
+		mockDB.AssertExpectations(t)
+	})
+
 	t.Run("key not in memory, fetched from DB, test fails", func(t *testing.T) {
 		mockDB := new(MockDBService)
 		mockHTTP := new(MockHTTPClient)
@@ -527,9 +1564,9 @@ func TestTestKeyByID(t *testing.T) {
 		dbKey := &model.GeminiKey{Model: gorm.Model{ID: 3}, Key: "db-key"}
 		mockDB.On("GetGeminiKey", uint(3)).Return(dbKey, nil).Once()
 		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader("Server Error"))}, nil).Once()
-		mockDB.On("UpdateGeminiKey", mock.Anything).Return(nil).Once()
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil).Once()
 
-		err := km.TestKeyByID(3)
+		err := km.TestKeyByID(context.Background(), 3)
 		assert.Error(t, err)
 
 		mockHTTP.AssertExpectations(t)
@@ -548,7 +1585,7 @@ func TestTestKeyByID(t *testing.T) {
 
 		mockDB.On("GetGeminiKey", uint(99)).Return(nil, gorm.ErrRecordNotFound).Once()
 
-		err := km.TestKeyByID(99)
+		err := km.TestKeyByID(context.Background(), 99)
 		assert.Error(t, err)
 		assert.Equal(t, "failed to find key with ID 99 in DB: record not found", err.Error())
 
@@ -556,3 +1593,648 @@ func TestTestKeyByID(t *testing.T) {
 		mockDB.AssertExpectations(t)
 	})
 }
+
+func TestGetNextKey_SpreadsTiedKeys(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newManager := func() *KeyManager {
+		keys := make([]*managedKey, 5)
+		for i := range keys {
+			keys[i] = &managedKey{GeminiKey: model.GeminiKey{Key: fmt.Sprintf("key%d", i)}}
+		}
+		return &KeyManager{keys: keys, logger: logger, skipUsageWrites: true}
+	}
+
+	t.Run("100 selections are spread evenly", func(t *testing.T) {
+		km := newManager()
+		counts := make(map[string]int)
+		for i := 0; i < 100; i++ {
+			key, err := km.GetNextKey("", "")
+			assert.NoError(t, err)
+			counts[key]++
+		}
+		assert.Len(t, counts, 5)
+		for key, n := range counts {
+			assert.InDelta(t, 20, n, 5, "key %s was chosen %d times", key, n)
+		}
+	})
+
+	t.Run("first pick among zero-usage keys is random", func(t *testing.T) {
+		firsts := make(map[string]int)
+		for i := 0; i < 500; i++ {
+			key, err := newManager().GetNextKey("", "")
+			assert.NoError(t, err)
+			firsts[key]++
+		}
+		assert.Len(t, firsts, 5)
+		for key, n := range firsts {
+			// Expected 100 per key; the bounds are more than 4 standard deviations wide.
+			assert.InDelta(t, 100, n, 40, "key %s was picked first %d times", key, n)
+		}
+	})
+
+	t.Run("lower usage still wins over ties", func(t *testing.T) {
+		km := newManager()
+		for _, k := range km.keys {
+			k.UsageCount = 5
+		}
+		km.keys[3].UsageCount = 2
+		key, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key3", key)
+	})
+}
+
+func TestUsageUpdater_BatchesIncrements(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	mockDB := new(MockDBService)
+
+	var mu sync.Mutex
+	var total int64
+	var batches int
+	mockDB.On("BatchIncrementGeminiUsage", mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches++
+		total += args.Get(0).(map[string]int64)["key1"]
+	}).Return(nil)
+
+	km := &KeyManager{
+		keys:        []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+		logger:      logger,
+		db:          mockDB,
+		stopChan:    make(chan struct{}),
+		updateQueue: make(chan string, 100),
+	}
+	km.wg.Add(1)
+	go km.usageUpdater()
+
+	for i := 0; i < 100; i++ {
+		_, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+	}
+	km.Close() // Flushes whatever is still pending
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, int64(100), total)
+	assert.LessOrEqual(t, batches, 2, "100 rapid increments should be written in very few batches")
+	mockDB.AssertNotCalled(t, "IncrementGeminiKeyUsageCount", mock.Anything)
+}
+
+func TestRecordTokenUsage(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mockDB := new(MockDBService)
+
+	var mu sync.Mutex
+	var flushed map[string]int64
+	mockDB.On("BatchIncrementGeminiTokenUsage", mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed = args.Get(0).(map[string]int64)
+	}).Return(nil)
+
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "key1", TokenUsage: 100}},
+			{GeminiKey: model.GeminiKey{Key: "key2"}},
+		},
+		logger:      logger,
+		db:          mockDB,
+		stopChan:    make(chan struct{}),
+		updateQueue: make(chan string, 100),
+		selector:    tokenUsageSelector{},
+	}
+	km.wg.Add(1)
+	go km.usageUpdater()
+
+	km.RecordTokenUsage("key2", 300)
+	km.RecordTokenUsage("key2", 200)
+	km.RecordTokenUsage("unknown", 50)
+	km.RecordTokenUsage("key1", 0)
+
+	// key1 has now spent fewer tokens than key2, even though both served no counted requests.
+	key, err := km.PeekNextKey()
+	assert.NoError(t, err)
+	assert.Equal(t, safeKeySuffix("key1"), key)
+	assert.Equal(t, int64(500), km.Snapshot()[1].TokenUsage)
+
+	km.Close() // Flushes the pending token usage
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int64{"key2": 500}, flushed)
+}
+
+func TestUsageUpdater_QueueOverflow(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logBuf, nil))
+	mockDB := new(MockDBService)
+
+	var mu sync.Mutex
+	var total int64
+	mockDB.On("BatchIncrementGeminiUsage", mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		total += args.Get(0).(map[string]int64)["key1"]
+	}).Return(nil)
+
+	km := &KeyManager{
+		keys:        []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+		logger:      logger,
+		db:          mockDB,
+		stopChan:    make(chan struct{}),
+		updateQueue: make(chan string, 1),
+	}
+
+	// Saturate the queue before the worker starts draining it.
+	for i := 0; i < 50; i++ {
+		_, err := km.GetNextKey("", "")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(49), km.OverflowedUsageUpdates())
+	assert.Equal(t, 1, strings.Count(logBuf.String(), "Usage update queue is full"), "the warning should be rate limited")
+
+	km.wg.Add(1)
+	go km.usageUpdater()
+	km.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, int64(50), total, "no usage updates should be lost")
+}
+
+func TestKeyManager_ConcurrentAccess(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mockDB := new(MockDBService)
+	mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+	// A fresh response per call, since concurrent health checks each close the body.
+	rejectingHTTP := httpClientFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader("denied"))}, nil
+	})
+
+	keys := make([]*managedKey, 5)
+	for i := range keys {
+		keys[i] = &managedKey{GeminiKey: model.GeminiKey{Key: fmt.Sprintf("key-%d", i)}}
+	}
+	km := &KeyManager{
+		keys:             keys,
+		logger:           logger,
+		db:               mockDB,
+		httpClient:       rejectingHTTP,
+		disableThreshold: 3,
+		skipUsageWrites:  true,
+		syncDBUpdates:    true,
+		sessions:         make(map[string]sessionPin),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				km.GetNextKey("", "")
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				km.HandleKeyFailure(fmt.Sprintf("key-%d", (i+j)%5), 0, "")
+				km.HandleKeySuccess(fmt.Sprintf("key-%d", j%5))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				km.ReviveDisabledKeys()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			km.CheckAllKeysHealth()
+			km.GetAvailableKeyCount()
+		}()
+	}
+	wg.Wait()
+
+	// Disabled and Status are always updated together under the lock.
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	for _, k := range km.keys {
+		assert.Equal(t, k.Disabled, k.Status == "disabled", "key %s has inconsistent state", k.Key)
+	}
+}
+
+func TestValidateRawKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	t.Run("valid key", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockHTTP := new(MockHTTPClient)
+		km := &KeyManager{logger: logger, db: mockDB, httpClient: mockHTTP}
+
+		mockHTTP.On("Do", mock.MatchedBy(func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer raw-key"
+		})).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil).Once()
+
+		assert.NoError(t, km.ValidateRawKey("raw-key"))
+		assert.Empty(t, km.keys, "validated keys must not be added to the manager")
+		mockHTTP.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "UpdateGeminiKeyState", mock.Anything)
+	})
+
+	t.Run("rejected key reports the upstream status", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockHTTP := new(MockHTTPClient)
+		km := &KeyManager{logger: logger, db: mockDB, httpClient: mockHTTP}
+
+		mockHTTP.On("Do", mock.Anything).Return(&http.Response{StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader("denied"))}, nil).Once()
+
+		err := km.ValidateRawKey("bad-key")
+		var testErr *KeyTestError
+		assert.ErrorAs(t, err, &testErr)
+		assert.Equal(t, http.StatusForbidden, testErr.StatusCode)
+		assert.Equal(t, "denied", testErr.Body)
+		mockDB.AssertNotCalled(t, "UpdateGeminiKeyState", mock.Anything)
+	})
+
+	t.Run("targets the configured upstream", func(t *testing.T) {
+		var gotPath string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		mockDB := new(MockDBService)
+		mockDB.On("LoadActiveGeminiKeys").Return([]model.GeminiKey{}, nil).Once()
+		cfg := &config.Config{Upstream: config.UpstreamConfig{BaseURL: server.URL, HealthCheckPath: "/custom/models"}}
+		km, err := NewKeyManager(mockDB, cfg, http.DefaultTransport, logger)
+		assert.NoError(t, err)
+		defer km.Close()
+
+		assert.NoError(t, km.ValidateRawKey("raw-key"))
+		assert.Equal(t, "/custom/models", gotPath)
+	})
+}
+
+func TestDrain(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	mockDB := new(MockDBService)
+	mockDB.On("IncrementGeminiKeyUsageCount", mock.Anything).Return(nil)
+
+	km := &KeyManager{
+		keys:        []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+		logger:      logger,
+		db:          mockDB,
+		updateQueue: make(chan string, 10),
+	}
+
+	// A handler that takes a key up front and then blocks, simulating a slow upstream call.
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := km.GetNextKey("", ""); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	slowResult := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			slowResult <- 0
+			return
+		}
+		resp.Body.Close()
+		slowResult <- resp.StatusCode
+	}()
+	<-started
+
+	km.Drain()
+
+	// A new request is rejected while draining.
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	_, err = km.GetNextKey("", "")
+	assert.ErrorIs(t, err, ErrShuttingDown)
+
+	// Retries of in-flight requests still get keys.
+	key, err := km.GetRetryKey("", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "key1", key)
+
+	// The in-flight request is still allowed to complete.
+	close(release)
+	assert.Equal(t, http.StatusOK, <-slowResult)
+}
+
+func TestClose_LateRequestsDoNotPanic(t *testing.T) {
+	km := &KeyManager{
+		keys:        []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		stopChan:    make(chan struct{}),
+		updateQueue: make(chan string, 10),
+	}
+	km.Close()
+
+	// A retry racing the shutdown still selects a key, but its usage is no longer queued.
+	require.NotPanics(t, func() {
+		key, err := km.GetRetryKey("", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "key1", key)
+	})
+}
+
+func TestSetPaused(t *testing.T) {
+	km := &KeyManager{
+		keys:            []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		sessions:        make(map[string]sessionPin),
+		sessionTTL:      time.Minute,
+		skipUsageWrites: true,
+	}
+
+	km.SetPaused(true)
+	assert.True(t, km.IsPaused())
+	_, err := km.GetNextKey("", "")
+	assert.ErrorIs(t, err, ErrPaused)
+	_, err = km.GetKeyForSession("session", "", "")
+	assert.ErrorIs(t, err, ErrPaused)
+	_, err = km.PeekNextKey()
+	assert.ErrorIs(t, err, ErrPaused)
+	assert.Zero(t, km.keys[0].UsageCount, "paused requests must not count as usage")
+
+	km.SetPaused(false)
+	assert.False(t, km.IsPaused())
+	key, err := km.GetNextKey("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "key1", key)
+}
+
+func TestCheckAvailableKeys(t *testing.T) {
+	newKM := func(buf *bytes.Buffer, keys ...string) *KeyManager {
+		km := &KeyManager{
+			logger:           slog.New(slog.NewTextHandler(buf, nil)),
+			minAvailableKeys: 2,
+		}
+		for _, k := range keys {
+			km.keys = append(km.keys, &managedKey{GeminiKey: model.GeminiKey{Key: k}})
+		}
+		return km
+	}
+
+	t.Run("no warning at or above the threshold", func(t *testing.T) {
+		var logBuf bytes.Buffer
+		km := newKM(&logBuf, "key1", "key2")
+		km.checkAvailableKeys()
+		assert.Empty(t, logBuf.String())
+		assert.Equal(t, int64(0), km.LowAvailableKeyChecks())
+	})
+
+	t.Run("warns once when the count drops below the threshold", func(t *testing.T) {
+		var logBuf bytes.Buffer
+		km := newKM(&logBuf, "key1", "key2")
+		km.checkAvailableKeys()
+		km.keys[1].Disabled = true
+
+		km.checkAvailableKeys()
+		km.checkAvailableKeys()
+		assert.Equal(t, 1, strings.Count(logBuf.String(), "Available Gemini keys below threshold"), "the warning should be rate limited")
+		assert.Equal(t, int64(2), km.LowAvailableKeyChecks())
+
+		// Recovering resets the rate limit, so the next drop warns again.
+		km.keys[1].Disabled = false
+		km.checkAvailableKeys()
+		km.keys[1].Disabled = true
+		km.checkAvailableKeys()
+		assert.Equal(t, 2, strings.Count(logBuf.String(), "Available Gemini keys below threshold"))
+	})
+
+	t.Run("disabled when the threshold is zero", func(t *testing.T) {
+		var logBuf bytes.Buffer
+		km := newKM(&logBuf)
+		km.minAvailableKeys = 0
+		km.checkAvailableKeys()
+		assert.Empty(t, logBuf.String())
+	})
+}
+
+func TestGetNextKeyWeighted(t *testing.T) {
+	newKM := func() *KeyManager {
+		return &KeyManager{
+			keys:            []*managedKey{{GeminiKey: model.GeminiKey{Key: "key1"}}},
+			logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+			skipUsageWrites: true,
+		}
+	}
+
+	generate, countTokens := newKM(), newKM()
+	for i := 0; i < 10; i++ {
+		_, err := generate.GetNextKeyWeighted("", "", 1)
+		assert.NoError(t, err)
+		_, err = countTokens.GetNextKeyWeighted("", "", 0.1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(10), generate.keys[0].UsageCount)
+	assert.Equal(t, int64(1), countTokens.keys[0].UsageCount, "ten requests weighted 0.1 count as one")
+	assert.Equal(t, int64(1), countTokens.keys[0].UsageToday)
+
+	skipped := newKM()
+	_, err := skipped.GetNextKeyWeighted("", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), skipped.keys[0].UsageCount)
+}
+
+func TestPeekNextKey(t *testing.T) {
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "key-aaaa", UsageCount: 5}},
+			{GeminiKey: model.GeminiKey{Key: "key-bbbb", UsageCount: 1}},
+		},
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		updateQueue: make(chan string, 10),
+	}
+
+	suffix, err := km.PeekNextKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "bbbb", suffix)
+	assert.Equal(t, int64(1), km.keys[1].UsageCount, "peeking must not record usage")
+	assert.Empty(t, km.updateQueue)
+
+	key, err := km.GetNextKey("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "key-bbbb", key)
+
+	km.keys = nil
+	_, err = km.PeekNextKey()
+	assert.Error(t, err)
+}
+
+func TestKeyManager_SharedKeyState(t *testing.T) {
+	backend := coordination.NewMemory()
+	newInstance := func(id string) *KeyManager {
+		mockDB := new(MockDBService)
+		mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+		km := &KeyManager{
+			keys: []*managedKey{
+				{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "key-1111", Status: "active"}},
+				{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 2}, Key: "key-2222", Status: "active"}},
+			},
+			logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+			db:               mockDB,
+			stopChan:         make(chan struct{}),
+			disableThreshold: 1,
+			cooldownDuration: time.Minute,
+			syncDBUpdates:    true,
+			coordinator:      backend,
+			instanceID:       id,
+		}
+		go km.subscribeEvents()
+		t.Cleanup(func() { close(km.stopChan) })
+		return km
+	}
+	a, b := newInstance("a"), newInstance("b")
+	require.Eventually(t, func() bool { return backend.Subscribers() == 2 }, time.Second, time.Millisecond)
+
+	a.HandleKeyFailure("key-1111", http.StatusForbidden, "")
+	assert.Eventually(t, func() bool {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return b.keys[0].Disabled && b.keys[0].Status == "disabled"
+	}, time.Second, time.Millisecond, "a disable on one instance should reach the other")
+
+	a.HandleKeyFailure("key-2222", http.StatusTooManyRequests, "")
+	assert.Eventually(t, func() bool {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return b.keys[1].coolingDown(time.Now())
+	}, time.Second, time.Millisecond, "a cooldown on one instance should reach the other")
+	b.mutex.Lock()
+	assert.False(t, b.keys[1].Disabled)
+	b.mutex.Unlock()
+}
+
+func TestKeyManager_WebhookNotifications(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var mu sync.Mutex
+	var events []notify.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	mockDB := new(MockDBService)
+	mockDB.On("UpdateGeminiKeyState", mock.Anything).Return(nil)
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "secret-key-aaaa", Status: "active", FailureCount: 2}},
+			{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 2}, Key: "secret-key-bbbb", Status: "disabled"}, Disabled: true},
+		},
+		logger:           logger,
+		db:               mockDB,
+		disableThreshold: 3,
+		syncDBUpdates:    true,
+		notifier:         notify.NewWebhook(server.URL, logger),
+	}
+	defer km.notifier.Close()
+
+	km.HandleKeyFailure("secret-key-aaaa", http.StatusForbidden, "")
+	km.notifier.Wait()
+
+	mu.Lock()
+	require.Len(t, events, 2)
+	assert.Equal(t, notify.EventKeyDisabled, events[0].Type)
+	assert.Equal(t, uint(1), events[0].KeyID)
+	assert.Equal(t, "aaaa", events[0].KeySuffix)
+	assert.Equal(t, "reaching failure threshold", events[0].Reason)
+	assert.Equal(t, notify.EventNoKeysAvailable, events[1].Type, "the last enabled key was disabled")
+	events = nil
+	mu.Unlock()
+
+	km.HandleKeySuccess("secret-key-bbbb")
+	km.notifier.Wait()
+
+	mu.Lock()
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.EventKeyRevived, events[0].Type)
+	assert.Equal(t, "bbbb", events[0].KeySuffix)
+	mu.Unlock()
+}
+
+func TestKeyTests_Cancellation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// The upstream never answers, so only cancellation or the timeout can end a test.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	newManager := func() *KeyManager {
+		return &KeyManager{
+			keys:             []*managedKey{{GeminiKey: model.GeminiKey{Model: gorm.Model{ID: 1}, Key: "slow-key", Status: "active"}}},
+			logger:           logger,
+			db:               new(MockDBService),
+			httpClient:       server.Client(),
+			healthCheckURL:   server.URL,
+			disableThreshold: 1,
+			syncDBUpdates:    true,
+		}
+	}
+
+	t.Run("a cancelled context aborts TestKeyByID", func(t *testing.T) {
+		km := newManager()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		start := time.Now()
+		err := km.TestKeyByID(ctx, 1)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, time.Since(start), 2*time.Second)
+		assert.False(t, km.keys[0].Disabled, "an aborted test must not count as a failure")
+		assert.Zero(t, km.keys[0].FailureCount)
+	})
+
+	t.Run("the key test timeout bounds each test", func(t *testing.T) {
+		km := newManager()
+		km.keyTestTimeout = 20 * time.Millisecond
+
+		start := time.Now()
+		err := km.ValidateRawKey("slow-key")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+
+	t.Run("closing the manager aborts health checks", func(t *testing.T) {
+		km := newManager()
+		km.closing, km.cancelClosing = context.WithCancel(context.Background())
+
+		done := make(chan struct{})
+		go func() {
+			km.CheckAllKeysHealth()
+			close(done)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		km.cancelClosing()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("CheckAllKeysHealth did not return after the manager was closed")
+		}
+		assert.False(t, km.keys[0].Disabled, "an aborted check must not disable the key")
+	})
+}
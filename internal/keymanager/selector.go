@@ -0,0 +1,99 @@
+package keymanager
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+)
+
+// keySelector picks the next key to hand out among the keys available at now.
+// It is called with the manager lock held and returns nil if no key is available.
+type keySelector interface {
+	selectKey(keys []*managedKey, now time.Time) *managedKey
+}
+
+// newKeySelector returns the selector for a config.Proxy.SelectionStrategy value.
+func newKeySelector(strategy string) keySelector {
+	switch strategy {
+	case config.SelectionWeightedQuota:
+		return weightedQuotaSelector{}
+	case config.SelectionTokenWeighted:
+		return tokenUsageSelector{}
+	}
+	return lowestUsageSelector{}
+}
+
+// lowestUsageSelector picks the available key with the lowest usage. Ties are broken uniformly
+// at random (reservoir sampling) so equally used keys, e.g. all at zero after startup, share the load.
+type lowestUsageSelector struct{}
+
+func (lowestUsageSelector) selectKey(keys []*managedKey, now time.Time) *managedKey {
+	return selectLowest(keys, now, func(k *managedKey) int64 { return k.UsageCount })
+}
+
+// tokenUsageSelector picks the available key that has spent the fewest tokens, see
+// KeyManager.RecordTokenUsage, so a few large requests count as much as many small ones.
+// Ties are broken at random like lowestUsageSelector.
+type tokenUsageSelector struct{}
+
+func (tokenUsageSelector) selectKey(keys []*managedKey, now time.Time) *managedKey {
+	return selectLowest(keys, now, func(k *managedKey) int64 { return k.TokenUsage })
+}
+
+// selectLowest returns the available key with the lowest usage, choosing uniformly at random
+// among keys of equal usage.
+func selectLowest(keys []*managedKey, now time.Time, usage func(*managedKey) int64) *managedKey {
+	var chosen *managedKey
+	var lowest int64
+	ties := 0
+	for _, k := range keys {
+		if !k.available(now) {
+			continue
+		}
+		switch u := usage(k); {
+		case chosen == nil || u < lowest:
+			chosen = k
+			lowest = u
+			ties = 1
+		case u == lowest:
+			ties++
+			if rand.IntN(ties) == 0 {
+				chosen = k
+			}
+		}
+	}
+	return chosen
+}
+
+// weightedQuotaSelector picks an available key at random with probability proportional to its
+// remaining daily quota, so keys with more headroom absorb more traffic. Keys without a quota
+// weigh as much as the key with the most remaining quota, or 1 if no key has a quota.
+type weightedQuotaSelector struct{}
+
+func (weightedQuotaSelector) selectKey(keys []*managedKey, now time.Time) *managedKey {
+	var unlimitedWeight int64 = 1
+	for _, k := range keys {
+		if k.DailyQuota > 0 && k.available(now) {
+			unlimitedWeight = max(unlimitedWeight, k.DailyQuota-k.UsageToday)
+		}
+	}
+
+	// Weighted reservoir sampling: keep each key with probability weight/total-so-far.
+	var chosen *managedKey
+	var total int64
+	for _, k := range keys {
+		if !k.available(now) {
+			continue
+		}
+		weight := unlimitedWeight
+		if k.DailyQuota > 0 {
+			weight = k.DailyQuota - k.UsageToday
+		}
+		total += weight
+		if rand.Int64N(total) < weight {
+			chosen = k
+		}
+	}
+	return chosen
+}
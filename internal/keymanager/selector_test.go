@@ -0,0 +1,131 @@
+package keymanager
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeySelector(t *testing.T) {
+	assert.IsType(t, lowestUsageSelector{}, newKeySelector(""))
+	assert.IsType(t, lowestUsageSelector{}, newKeySelector(config.SelectionLowestUsage))
+	assert.IsType(t, weightedQuotaSelector{}, newKeySelector(config.SelectionWeightedQuota))
+	assert.IsType(t, tokenUsageSelector{}, newKeySelector(config.SelectionTokenWeighted))
+}
+
+func TestTokenUsageSelector(t *testing.T) {
+	now := time.Now()
+
+	t.Run("picks the key with the fewest tokens", func(t *testing.T) {
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "busy", UsageCount: 1, TokenUsage: 90000}},
+			{GeminiKey: model.GeminiKey{Key: "light", UsageCount: 50, TokenUsage: 2000}},
+			{GeminiKey: model.GeminiKey{Key: "medium", UsageCount: 10, TokenUsage: 8000}},
+		}
+		chosen := tokenUsageSelector{}.selectKey(keys, now)
+		require.NotNil(t, chosen)
+		assert.Equal(t, "light", chosen.Key)
+	})
+
+	t.Run("skips unavailable keys", func(t *testing.T) {
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "disabled"}, Disabled: true},
+			{GeminiKey: model.GeminiKey{Key: "cooling"}, CooldownUntil: now.Add(time.Minute)},
+			{GeminiKey: model.GeminiKey{Key: "available", TokenUsage: 500}},
+		}
+		chosen := tokenUsageSelector{}.selectKey(keys, now)
+		require.NotNil(t, chosen)
+		assert.Equal(t, "available", chosen.Key)
+	})
+
+	t.Run("breaks ties at random", func(t *testing.T) {
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "a", TokenUsage: 100}},
+			{GeminiKey: model.GeminiKey{Key: "b", TokenUsage: 100}},
+		}
+		seen := make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			seen[tokenUsageSelector{}.selectKey(keys, now).Key] = true
+		}
+		assert.Len(t, seen, 2)
+	})
+}
+
+func TestWeightedQuotaSelector(t *testing.T) {
+	now := time.Now()
+	pickCounts := func(keys []*managedKey, picks int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < picks; i++ {
+			chosen := weightedQuotaSelector{}.selectKey(keys, now)
+			require.NotNil(t, chosen)
+			counts[chosen.Key]++
+		}
+		return counts
+	}
+
+	t.Run("distribution follows remaining quota", func(t *testing.T) {
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "small", DailyQuota: 100}},
+			{GeminiKey: model.GeminiKey{Key: "medium", DailyQuota: 400, UsageToday: 100}},
+			{GeminiKey: model.GeminiKey{Key: "large", DailyQuota: 600}},
+		}
+		const picks = 20000
+		counts := pickCounts(keys, picks)
+
+		assert.InDelta(t, 0.1, float64(counts["small"])/picks, 0.03)
+		assert.InDelta(t, 0.3, float64(counts["medium"])/picks, 0.03)
+		assert.InDelta(t, 0.6, float64(counts["large"])/picks, 0.03)
+	})
+
+	t.Run("keys without a quota weigh like the largest remaining quota", func(t *testing.T) {
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "quota", DailyQuota: 300, UsageToday: 200}},
+			{GeminiKey: model.GeminiKey{Key: "unlimited"}},
+		}
+		const picks = 20000
+		counts := pickCounts(keys, picks)
+
+		assert.InDelta(t, 0.5, float64(counts["unlimited"])/picks, 0.03)
+	})
+
+	t.Run("skips unavailable keys", func(t *testing.T) {
+		keys := []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "exhausted", DailyQuota: 10, UsageToday: 10}},
+			{GeminiKey: model.GeminiKey{Key: "disabled", DailyQuota: 1000}, Disabled: true},
+			{GeminiKey: model.GeminiKey{Key: "ok", DailyQuota: 1}},
+		}
+		assert.Equal(t, map[string]int{"ok": 100}, pickCounts(keys, 100))
+
+		keys[2].Disabled = true
+		assert.Nil(t, weightedQuotaSelector{}.selectKey(keys, now))
+	})
+}
+
+func TestGetNextKey_WeightedQuota(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	km := &KeyManager{
+		keys: []*managedKey{
+			{GeminiKey: model.GeminiKey{Key: "key1", DailyQuota: 1}},
+			{GeminiKey: model.GeminiKey{Key: "key2", DailyQuota: 1}},
+		},
+		logger:          logger,
+		skipUsageWrites: true,
+		selector:        newKeySelector(config.SelectionWeightedQuota),
+	}
+
+	first, err := km.GetNextKey("", "")
+	require.NoError(t, err)
+	second, err := km.GetNextKey("", "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"key1", "key2"}, []string{first, second}, "each key has room for exactly one request")
+
+	_, err = km.GetNextKey("", "")
+	assert.Error(t, err)
+}
@@ -1,11 +1,31 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 )
 
+type requestIDKey struct{}
+
+// Log output formats accepted by Options.Format.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Options configures a logger built by NewWithOptions.
+type Options struct {
+	// Format is FormatJSON or FormatText; empty means FormatJSON.
+	Format string
+	// Level is the minimum level ("debug", "info", "warn" or "error"). When empty, Debug picks
+	// between debug and info.
+	Level string
+	Debug bool
+}
+
 // New creates a new slog.Logger instance that writes to os.Stdout.
 // If debug is true, the log level is set to Debug. Otherwise, it's set to Info.
 func New(debug bool) *slog.Logger {
@@ -14,13 +34,59 @@ func New(debug bool) *slog.Logger {
 
 // NewWithWriter creates a new slog.Logger instance with a specific writer.
 func NewWithWriter(w io.Writer, debug bool) *slog.Logger {
-	var level slog.Level
-	if debug {
+	l, _ := NewWithOptions(w, Options{Debug: debug})
+	return l
+}
+
+// NewWithOptions creates a slog.Logger writing to w in the given format and at the given level.
+func NewWithOptions(w io.Writer, opts Options) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if opts.Debug {
 		level = slog.LevelDebug
-	} else {
-		level = slog.LevelInfo
 	}
-	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
-		Level: level,
-	}))
+	if opts.Level != "" {
+		var err error
+		if level, err = ParseLevel(opts.Level); err != nil {
+			return nil, err
+		}
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch opts.Format {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %q or %q", opts.Format, FormatJSON, FormatText)
+	}
+}
+
+// ParseLevel parses a level name such as "debug", "info", "warn" or "error", in any case.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// ContextWithRequestID returns a copy of ctx that carries the given request ID.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// FromContext returns l annotated with the request ID stored in ctx, so that
+// log lines emitted while handling a request can be correlated.
+func FromContext(ctx context.Context, l *slog.Logger) *slog.Logger {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return l.With("request_id", requestID)
+	}
+	return l
 }
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"bytes"
+	"context"
 	"strings"
 	"testing"
 )
@@ -49,3 +50,89 @@ func TestNew_Info_With_Debug_False(t *testing.T) {
 		t.Errorf("Expected log output to not contain 'test debug message', but it did")
 	}
 }
+
+func TestNewWithOptions_TextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewWithOptions(&buf, Options{Format: FormatText})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	logger.Info("text message", "key", "value")
+
+	out := buf.String()
+	if !strings.Contains(out, `msg="text message"`) || !strings.Contains(out, "key=value") {
+		t.Errorf("Expected text formatted output, got %q", out)
+	}
+	if strings.HasPrefix(out, "{") {
+		t.Errorf("Expected text output, got JSON %q", out)
+	}
+}
+
+func TestNewWithOptions_Level(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    Options
+		logged  []string
+		dropped []string
+	}{
+		{"warn only", Options{Level: "warn"}, []string{"warn", "error"}, []string{"debug", "info"}},
+		{"level overrides debug", Options{Level: "ERROR", Debug: true}, []string{"error"}, []string{"debug", "info", "warn"}},
+		{"debug shortcut", Options{Debug: true}, []string{"debug", "info"}, nil},
+		{"info by default", Options{}, []string{"info"}, []string{"debug"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := NewWithOptions(&buf, tc.opts)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			logger.Debug("debug message")
+			logger.Info("info message")
+			logger.Warn("warn message")
+			logger.Error("error message")
+
+			for _, level := range tc.logged {
+				if !strings.Contains(buf.String(), level+" message") {
+					t.Errorf("Expected %s message to be logged", level)
+				}
+			}
+			for _, level := range tc.dropped {
+				if strings.Contains(buf.String(), level+" message") {
+					t.Errorf("Expected %s message to be filtered out", level)
+				}
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_Invalid(t *testing.T) {
+	if _, err := NewWithOptions(&bytes.Buffer{}, Options{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format, but got nil")
+	}
+	if _, err := NewWithOptions(&bytes.Buffer{}, Options{Level: "verbose"}); err == nil {
+		t.Error("Expected an error for an unknown level, but got nil")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	base := NewWithWriter(&buf, false)
+
+	// Without a request ID the logger is returned unchanged.
+	FromContext(context.Background(), base).Info("no id")
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("Expected log output to not contain 'request_id', but it did")
+	}
+
+	ctx := ContextWithRequestID(context.Background(), "req-123")
+	if got := RequestIDFromContext(ctx); got != "req-123" {
+		t.Errorf("Expected request ID 'req-123', got '%s'", got)
+	}
+
+	buf.Reset()
+	FromContext(ctx, base).Info("with id")
+	if !strings.Contains(buf.String(), `"request_id":"req-123"`) {
+		t.Errorf("Expected log output to contain the request ID, got %s", buf.String())
+	}
+}
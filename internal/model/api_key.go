@@ -15,4 +15,10 @@ type APIKey struct {
 	Permissions string    `gorm:"type:varchar(255);not null"`
 	RateLimit   int       `gorm:"default:0"`
 	ExpiresAt   time.Time `gorm:"default:null"`
+	// KeyGroup restricts the Gemini keys used for this client to one GeminiKey.Group; empty allows all.
+	KeyGroup string `gorm:"type:varchar(100);default:'';not null"`
+	// MonthlyQuota caps the requests made with this key per calendar month; 0 means unlimited.
+	MonthlyQuota int `gorm:"default:0;not null"`
+	// UsageThisMonth counts requests since the last monthly reset.
+	UsageThisMonth int `gorm:"default:0;not null"`
 }
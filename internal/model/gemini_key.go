@@ -1,6 +1,17 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reasons the key manager disables a Gemini key for, see GeminiKey.DisabledReason.
+const (
+	DisabledReasonFailureThreshold = "failure_threshold"
+	DisabledReasonLowSuccessRatio  = "low_success_ratio"
+	DisabledReasonFatalError       = "fatal_error"
+)
 
 // GeminiKey represents a Google Gemini API key stored in the database.
 type GeminiKey struct {
@@ -9,4 +20,30 @@ type GeminiKey struct {
 	Status       string `gorm:"type:varchar(50);default:'active';not null"`
 	FailureCount int    `gorm:"default:0;not null"`
 	UsageCount   int64  `gorm:"default:0;not null"`
+	// DailyQuota caps the requests sent with this key per day; 0 means unlimited.
+	DailyQuota int64 `gorm:"default:0;not null"`
+	// DisableThreshold overrides proxy.disable_key_threshold for this key; 0 means the global value.
+	DisableThreshold int `gorm:"default:0;not null"`
+	// TokenUsage counts the tokens upstream reported for responses served with this key.
+	TokenUsage int64 `gorm:"default:0;not null"`
+	// UsageToday counts requests since the last daily reset.
+	UsageToday int64 `gorm:"default:0;not null"`
+	// LastFailedAt records the most recent failure counted toward FailureCount.
+	LastFailedAt time.Time `gorm:"default:null"`
+	// DisabledSince records when the key was last disabled in the database.
+	DisabledSince time.Time `gorm:"default:null"`
+	// DisabledReason records why the key manager last disabled the key in the database, one of
+	// the DisabledReason constants; it is empty for keys an admin disabled.
+	DisabledReason string `gorm:"type:varchar(50);default:'';not null"`
+	// RevivalFailures counts the failed revival attempts since the key was last disabled.
+	RevivalFailures int `gorm:"default:0;not null"`
+	// Group assigns the key to a named pool, e.g. its Google Cloud project. Client keys
+	// with a KeyGroup are only served keys from that group.
+	Group string `gorm:"column:key_group;type:varchar(100);index;default:'';not null"`
+	// ProjectLabel is a human-readable name for the key, e.g. the Google Cloud project it was
+	// created in, shown in the admin UI and logs instead of only the key suffix.
+	ProjectLabel string `gorm:"type:varchar(100);default:'';not null"`
+	// Models lists the model IDs the key could access when it was last tested. Nil means
+	// the key has not been tested yet and its capabilities are unknown.
+	Models []string `gorm:"type:text;serializer:json"`
 }
@@ -0,0 +1,173 @@
+// Package notify reports Gemini key lifecycle events to a webhook, e.g. a Slack incoming
+// webhook, so operators learn about disabled keys without watching the logs.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types of events sent to the webhook.
+const (
+	// EventKeyDisabled is sent when a key is taken out of rotation.
+	EventKeyDisabled = "key_disabled"
+	// EventKeyRevived is sent when a disabled key is put back into rotation.
+	EventKeyRevived = "key_revived"
+	// EventNoKeysAvailable is sent when the last enabled key is disabled.
+	EventNoKeysAvailable = "no_keys_available"
+)
+
+// deliveryTimeout bounds a single webhook request.
+const deliveryTimeout = 10 * time.Second
+
+// queueSize is how many events can wait for delivery before new ones are dropped.
+const queueSize = 64
+
+// Event is the JSON payload posted to the webhook. Keys are identified by ID and suffix so
+// key values never leave the instance.
+type Event struct {
+	Type      string    `json:"type"`
+	KeyID     uint      `json:"key_id,omitempty"`
+	KeySuffix string    `json:"key_suffix,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+	// Text is a human-readable summary; Slack incoming webhooks display it as the message.
+	Text string `json:"text"`
+}
+
+// Webhook posts events to a URL in the background, one at a time and in the order they were
+// sent. A nil *Webhook drops every event.
+type Webhook struct {
+	url     string
+	client  *http.Client
+	logger  *slog.Logger
+	queue   chan Event
+	pending sync.WaitGroup
+	done    chan struct{}
+	// mu guards closed, so events sent during or after Close are dropped instead of being
+	// sent on the closed queue.
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewWebhook creates a Webhook posting to url, or returns nil when url is empty.
+func NewWebhook(url string, logger *slog.Logger) *Webhook {
+	if url == "" {
+		return nil
+	}
+	w := &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: deliveryTimeout},
+		logger: logger.With("component", "notify"),
+		queue:  make(chan Event, queueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Notify queues e for delivery. Delivery failures are logged and otherwise ignored; events
+// are dropped when the queue is full so a slow webhook never blocks the caller, and once the
+// Webhook is closed.
+func (w *Webhook) Notify(e Event) {
+	if w == nil {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if e.Text == "" {
+		e.Text = e.summary()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		w.logger.Debug("Dropping webhook notification sent after close", "type", e.Type)
+		return
+	}
+	w.pending.Add(1)
+	select {
+	case w.queue <- e:
+	default:
+		w.pending.Done()
+		w.logger.Warn("Dropping webhook notification, delivery queue is full", "type", e.Type)
+	}
+}
+
+// Wait blocks until the queued notifications have been delivered or have failed.
+func (w *Webhook) Wait() {
+	if w == nil {
+		return
+	}
+	w.pending.Wait()
+}
+
+// Close delivers the queued notifications and stops the Webhook. Later notifications are
+// dropped.
+func (w *Webhook) Close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		if err := w.deliver(e); err != nil {
+			w.logger.Warn("Failed to send webhook notification", "type", e.Type, "error", err)
+		}
+		w.pending.Done()
+	}
+}
+
+func (w *Webhook) deliver(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// summary describes e in one line.
+func (e Event) summary() string {
+	switch e.Type {
+	case EventKeyDisabled:
+		text := fmt.Sprintf("Gemini key ...%s was disabled", e.KeySuffix)
+		if e.Reason != "" {
+			text += " due to " + e.Reason
+		}
+		return text + "."
+	case EventKeyRevived:
+		return fmt.Sprintf("Gemini key ...%s is back in rotation.", e.KeySuffix)
+	case EventNoKeysAvailable:
+		return "No Gemini keys are available; requests will fail until a key is revived or added."
+	default:
+		return e.Type
+	}
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("posts the event as json", func(t *testing.T) {
+		received := make(chan map[string]any, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			var payload map[string]any
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			received <- payload
+		}))
+		defer server.Close()
+
+		w := NewWebhook(server.URL, logger)
+		w.Notify(Event{Type: EventKeyDisabled, KeyID: 4, KeySuffix: "abcd", Reason: "reaching failure threshold"})
+		w.Wait()
+
+		payload := <-received
+		assert.Equal(t, "key_disabled", payload["type"])
+		assert.Equal(t, float64(4), payload["key_id"])
+		assert.Equal(t, "abcd", payload["key_suffix"])
+		assert.Equal(t, "Gemini key ...abcd was disabled due to reaching failure threshold.", payload["text"])
+		assert.NotEmpty(t, payload["at"])
+	})
+
+	t.Run("failed deliveries are not fatal", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		w := NewWebhook(server.URL, logger)
+		require.NotPanics(t, func() {
+			w.Notify(Event{Type: EventNoKeysAvailable})
+			w.Wait()
+		})
+	})
+
+	t.Run("delivers events in order", func(t *testing.T) {
+		var types []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var e Event
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+			types = append(types, e.Type)
+		}))
+		defer server.Close()
+
+		w := NewWebhook(server.URL, logger)
+		w.Notify(Event{Type: EventKeyDisabled})
+		w.Notify(Event{Type: EventNoKeysAvailable})
+		w.Notify(Event{Type: EventKeyRevived})
+		w.Close()
+
+		assert.Equal(t, []string{EventKeyDisabled, EventNoKeysAvailable, EventKeyRevived}, types)
+	})
+
+	t.Run("drops events sent after close", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		w := NewWebhook(server.URL, logger)
+		w.Close()
+		require.NotPanics(t, func() {
+			w.Notify(Event{Type: EventKeyDisabled})
+			w.Close()
+		})
+		w.Wait()
+	})
+
+	t.Run("no-op without a url", func(t *testing.T) {
+		w := NewWebhook("", logger)
+		assert.Nil(t, w)
+		w.Notify(Event{Type: EventKeyRevived})
+		w.Wait()
+		w.Close()
+	})
+}
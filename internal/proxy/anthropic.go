@@ -0,0 +1,421 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ubuygold/gogemini/internal/bodylimit"
+)
+
+// Anthropic error types used in the Messages API error envelope.
+const (
+	anthropicInvalidRequest = "invalid_request_error"
+	anthropicAPIError       = "api_error"
+	anthropicOverloaded     = "overloaded_error"
+	anthropicRateLimit      = "rate_limit_error"
+	anthropicAuthentication = "authentication_error"
+	anthropicPermission     = "permission_error"
+	anthropicNotFound       = "not_found_error"
+	anthropicTooLarge       = "request_too_large"
+)
+
+// errStreamingUnsupported is returned for Messages requests that ask for a streamed response.
+var errStreamingUnsupported = errors.New("streaming is not supported for /v1/messages")
+
+// anthropicRequest is the subset of an Anthropic Messages API request that can be mapped to Gemini.
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        json.RawMessage    `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+// anthropicMessage holds a message whose content is either a string or a list of content blocks.
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+	URL       string `json:"url"`
+}
+
+// anthropicResponse is a non-streaming Anthropic Messages API response.
+type anthropicResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []anthropicContentBlock `json:"content"`
+	StopReason   string                  `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        anthropicUsage          `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type openAIChatRequest struct {
+	Model       string              `json:"model"`
+	Messages    []openAIChatMessage `json:"messages"`
+	MaxTokens   int                 `json:"max_tokens,omitempty"`
+	Temperature *float64            `json:"temperature,omitempty"`
+	TopP        *float64            `json:"top_p,omitempty"`
+	Stop        []string            `json:"stop,omitempty"`
+}
+
+// openAIChatMessage content is a string, or a list of parts when the message contains images.
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIChatResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// translateAnthropicRequest converts an Anthropic Messages API request body into an
+// OpenAI chat completion request body.
+func translateAnthropicRequest(body []byte) ([]byte, error) {
+	var req anthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if req.Stream {
+		return nil, errStreamingUnsupported
+	}
+	if req.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if len(req.Messages) == 0 {
+		return nil, errors.New("messages must not be empty")
+	}
+
+	out := openAIChatRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.StopSequences,
+	}
+
+	if len(req.System) > 0 {
+		blocks, err := parseAnthropicContent(req.System)
+		if err != nil {
+			return nil, fmt.Errorf("invalid system prompt: %w", err)
+		}
+		var system strings.Builder
+		for _, b := range blocks {
+			if b.Type != "text" {
+				return nil, fmt.Errorf("unsupported system content block type %q", b.Type)
+			}
+			if system.Len() > 0 {
+				system.WriteString("\n\n")
+			}
+			system.WriteString(b.Text)
+		}
+		if system.Len() > 0 {
+			out.Messages = append(out.Messages, openAIChatMessage{Role: "system", Content: system.String()})
+		}
+	}
+
+	for i, m := range req.Messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+		blocks, err := parseAnthropicContent(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		content, err := toOpenAIContent(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		out.Messages = append(out.Messages, openAIChatMessage{Role: m.Role, Content: content})
+	}
+
+	return json.Marshal(out)
+}
+
+// parseAnthropicContent accepts either a plain string or a list of content blocks.
+func parseAnthropicContent(raw json.RawMessage) ([]anthropicContentBlock, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []anthropicContentBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []anthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, errors.New("content must be a string or a list of content blocks")
+	}
+	return blocks, nil
+}
+
+// toOpenAIContent returns a plain string for text-only content and a list of parts otherwise.
+func toOpenAIContent(blocks []anthropicContentBlock) (any, error) {
+	parts := make([]openAIContentPart, 0, len(blocks))
+	textOnly := true
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			parts = append(parts, openAIContentPart{Type: "text", Text: b.Text})
+		case "image":
+			if b.Source == nil {
+				return nil, errors.New("image block is missing its source")
+			}
+			url := b.Source.URL
+			if b.Source.Type == "base64" {
+				url = "data:" + b.Source.MediaType + ";base64," + b.Source.Data
+			}
+			if url == "" {
+				return nil, fmt.Errorf("unsupported image source type %q", b.Source.Type)
+			}
+			parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+			textOnly = false
+		default:
+			return nil, fmt.Errorf("unsupported content block type %q", b.Type)
+		}
+	}
+
+	if !textOnly {
+		return parts, nil
+	}
+	var text strings.Builder
+	for i, p := range parts {
+		if i > 0 {
+			text.WriteString("\n\n")
+		}
+		text.WriteString(p.Text)
+	}
+	return text.String(), nil
+}
+
+// translateOpenAIResponse converts an OpenAI chat completion response body into an
+// Anthropic Messages API response body. model is the model name the client asked for.
+func translateOpenAIResponse(body []byte, model string) ([]byte, error) {
+	var resp openAIChatResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid upstream response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("upstream response has no choices")
+	}
+
+	choice := resp.Choices[0]
+	id := resp.ID
+	if id == "" {
+		id = "msg_gogemini"
+	}
+	out := anthropicResponse{
+		ID:         id,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		Content:    []anthropicContentBlock{{Type: "text", Text: choice.Message.Content}},
+		StopReason: anthropicStopReason(choice.FinishReason),
+		Usage: anthropicUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+	}
+	return json.Marshal(out)
+}
+
+// anthropicStopReason maps an OpenAI finish_reason to an Anthropic stop_reason.
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicErrorType picks the Anthropic error type for an HTTP status code.
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return anthropicInvalidRequest
+	case http.StatusUnauthorized:
+		return anthropicAuthentication
+	case http.StatusForbidden:
+		return anthropicPermission
+	case http.StatusNotFound:
+		return anthropicNotFound
+	case http.StatusRequestEntityTooLarge:
+		return anthropicTooLarge
+	case http.StatusTooManyRequests:
+		return anthropicRateLimit
+	case http.StatusServiceUnavailable:
+		return anthropicOverloaded
+	default:
+		return anthropicAPIError
+	}
+}
+
+// writeAnthropicError writes an Anthropic-style error envelope.
+func writeAnthropicError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	})
+}
+
+// upstreamErrorMessage extracts the message of an OpenAI-style error body, falling back to the status text.
+func upstreamErrorMessage(body []byte, status int) string {
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error.Message != "" {
+		return envelope.Error.Message
+	}
+	return http.StatusText(status)
+}
+
+// bufferedResponseWriter collects a response so it can be translated before reaching the client.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header)}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header { return w.header }
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// AnthropicHandler serves the Anthropic Messages API by translating requests to the
+// OpenAI-compatible chat endpoint and sending them through an OpenAIProxy.
+type AnthropicHandler struct {
+	proxy *OpenAIProxy
+}
+
+// NewAnthropicHandler creates an AnthropicHandler that forwards through p, reusing its
+// key balancing and retries.
+func NewAnthropicHandler(p *OpenAIProxy) *AnthropicHandler {
+	return &AnthropicHandler{proxy: p}
+}
+
+func (h *AnthropicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAnthropicError(w, http.StatusMethodNotAllowed, anthropicInvalidRequest, "Only POST is supported")
+		return
+	}
+	if !bodylimit.Limit(w, r, h.proxy.maxRequestBodyBytes) {
+		writeAnthropicError(w, http.StatusRequestEntityTooLarge, anthropicTooLarge, "Request body is too large")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if bodylimit.Exceeded(err) {
+			writeAnthropicError(w, http.StatusRequestEntityTooLarge, anthropicTooLarge, "Request body is too large")
+			return
+		}
+		writeAnthropicError(w, http.StatusBadRequest, anthropicInvalidRequest, "Failed to read request body")
+		return
+	}
+	chatBody, err := translateAnthropicRequest(body)
+	if err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, anthropicInvalidRequest, err.Error())
+		return
+	}
+	var model struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &model)
+
+	chatReq := r.Clone(r.Context())
+	chatReq.URL.Path = "/v1/chat/completions"
+	chatReq.URL.RawPath = ""
+	chatReq.Body = io.NopCloser(bytes.NewReader(chatBody))
+	chatReq.ContentLength = int64(len(chatBody))
+	chatReq.Header.Set("Content-Type", "application/json")
+	chatReq.Header.Del("Accept-Encoding") // The response body must be readable for translation.
+	chatReq.Header.Del("x-api-key")
+	chatReq.Header.Del("anthropic-version")
+	chatReq.Header.Del("anthropic-beta")
+
+	buf := newBufferedResponseWriter()
+	h.proxy.ServeHTTP(buf, chatReq)
+
+	for name, values := range buf.header {
+		if name == "Retry-After" || strings.HasPrefix(name, "X-Ratelimit-") {
+			w.Header()[name] = values
+		}
+	}
+	if buf.status != http.StatusOK {
+		if buf.status == 0 {
+			return // The client went away; nothing was written.
+		}
+		writeAnthropicError(w, buf.status, anthropicErrorType(buf.status), upstreamErrorMessage(buf.body.Bytes(), buf.status))
+		return
+	}
+
+	out, err := translateOpenAIResponse(buf.body.Bytes(), model.Model)
+	if err != nil {
+		h.proxy.requestLogger(r).Error("Failed to translate chat completion for Anthropic client", "error", err)
+		writeAnthropicError(w, http.StatusBadGateway, anthropicAPIError, "Failed to translate upstream response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ubuygold/gogemini/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateAnthropicRequest(t *testing.T) {
+	t.Run("system string and text content", func(t *testing.T) {
+		body := `{
+			"model": "gemini-2.0-flash",
+			"max_tokens": 256,
+			"temperature": 0.5,
+			"stop_sequences": ["END"],
+			"system": "Be brief.",
+			"messages": [
+				{"role": "user", "content": "Hello"},
+				{"role": "assistant", "content": [{"type": "text", "text": "Hi."}]},
+				{"role": "user", "content": [{"type": "text", "text": "One"}, {"type": "text", "text": "Two"}]}
+			]
+		}`
+		out, err := translateAnthropicRequest([]byte(body))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"model": "gemini-2.0-flash",
+			"max_tokens": 256,
+			"temperature": 0.5,
+			"stop": ["END"],
+			"messages": [
+				{"role": "system", "content": "Be brief."},
+				{"role": "user", "content": "Hello"},
+				{"role": "assistant", "content": "Hi."},
+				{"role": "user", "content": "One\n\nTwo"}
+			]
+		}`, string(out))
+	})
+
+	t.Run("system blocks and image content", func(t *testing.T) {
+		body := `{
+			"model": "gemini-2.0-flash",
+			"max_tokens": 64,
+			"system": [{"type": "text", "text": "A"}, {"type": "text", "text": "B"}],
+			"messages": [{"role": "user", "content": [
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}},
+				{"type": "text", "text": "What is this?"}
+			]}]
+		}`
+		out, err := translateAnthropicRequest([]byte(body))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"model": "gemini-2.0-flash",
+			"max_tokens": 64,
+			"messages": [
+				{"role": "system", "content": "A\n\nB"},
+				{"role": "user", "content": [
+					{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}},
+					{"type": "text", "text": "What is this?"}
+				]}
+			]
+		}`, string(out))
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		testCases := map[string]string{
+			"malformed json":    `{`,
+			"missing model":     `{"messages": [{"role": "user", "content": "hi"}]}`,
+			"no messages":       `{"model": "m", "messages": []}`,
+			"streaming":         `{"model": "m", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`,
+			"unsupported role":  `{"model": "m", "messages": [{"role": "tool", "content": "hi"}]}`,
+			"unsupported block": `{"model": "m", "messages": [{"role": "user", "content": [{"type": "tool_use"}]}]}`,
+			"bad content":       `{"model": "m", "messages": [{"role": "user", "content": 5}]}`,
+		}
+		for name, body := range testCases {
+			t.Run(name, func(t *testing.T) {
+				_, err := translateAnthropicRequest([]byte(body))
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestTranslateOpenAIResponse(t *testing.T) {
+	t.Run("maps content, stop reason and usage", func(t *testing.T) {
+		body := `{
+			"id": "chatcmpl-1",
+			"model": "gemini-2.0-flash",
+			"choices": [{"message": {"role": "assistant", "content": "Hello there"}, "finish_reason": "length"}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15}
+		}`
+		out, err := translateOpenAIResponse([]byte(body), "client-model")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"id": "chatcmpl-1",
+			"type": "message",
+			"role": "assistant",
+			"model": "client-model",
+			"content": [{"type": "text", "text": "Hello there"}],
+			"stop_reason": "max_tokens",
+			"stop_sequence": null,
+			"usage": {"input_tokens": 12, "output_tokens": 3}
+		}`, string(out))
+	})
+
+	t.Run("stop maps to end_turn", func(t *testing.T) {
+		out, err := translateOpenAIResponse([]byte(`{"choices": [{"message": {"content": "x"}, "finish_reason": "stop"}]}`), "m")
+		require.NoError(t, err)
+		var resp anthropicResponse
+		require.NoError(t, json.Unmarshal(out, &resp))
+		assert.Equal(t, "end_turn", resp.StopReason)
+		assert.NotEmpty(t, resp.ID)
+	})
+
+	t.Run("errors without choices", func(t *testing.T) {
+		_, err := translateOpenAIResponse([]byte(`{"choices": []}`), "m")
+		assert.Error(t, err)
+		_, err = translateOpenAIResponse([]byte(`not json`), "m")
+		assert.Error(t, err)
+	})
+}
+
+func TestAnthropicHandler(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 1}}
+	requestBody := `{"model": "gemini-2.0-flash", "max_tokens": 16, "messages": [{"role": "user", "content": "Hi"}]}`
+
+	t.Run("translates request and response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.True(t, strings.HasSuffix(r.URL.Path, "/chat/completions"), r.URL.Path)
+			assert.Equal(t, "Bearer key-good", r.Header.Get("Authorization"))
+			assert.Empty(t, r.Header.Get("x-api-key"))
+			var chat openAIChatRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&chat))
+			assert.Equal(t, "gemini-2.0-flash", chat.Model)
+			require.Len(t, chat.Messages, 1)
+			assert.Equal(t, "Hi", chat.Messages[0].Content)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": "c1", "choices": [{"message": {"content": "Hello"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 1, "completion_tokens": 1}}`))
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-good").Return().Once()
+
+		p, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", strings.NewReader(requestBody))
+		req.Header.Set("x-api-key", "client-key")
+		rr := httptest.NewRecorder()
+		NewAnthropicHandler(p).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var resp anthropicResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "message", resp.Type)
+		require.Len(t, resp.Content, 1)
+		assert.Equal(t, "Hello", resp.Content[0].Text)
+		assert.Equal(t, "end_turn", resp.StopReason)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("wraps upstream errors in the anthropic envelope", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "model not found"}}`))
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil)
+		mockKM.On("HandleKeyFailure", "key-good", http.StatusBadRequest, mock.Anything).Return().Maybe()
+		mockKM.On("HandleKeySuccess", "key-good").Return().Maybe()
+
+		p, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", strings.NewReader(requestBody))
+		rr := httptest.NewRecorder()
+		NewAnthropicHandler(p).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.JSONEq(t, `{"type": "error", "error": {"type": "invalid_request_error", "message": "model not found"}}`, rr.Body.String())
+	})
+
+	t.Run("rejects streaming requests", func(t *testing.T) {
+		p, err := newOpenAIProxyWithURL(new(MockKeyManager), testConfig, "http://127.0.0.1:1", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "m", "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		NewAnthropicHandler(p).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_request_error")
+	})
+}
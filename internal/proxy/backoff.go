@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+)
+
+// backoff computes the delay between upstream attempts, see config.ProxyConfig.RetryBackoff.
+// The zero value retries immediately.
+type backoff struct {
+	base       time.Duration
+	multiplier float64
+	max        time.Duration
+	jitter     bool
+}
+
+// newBackoff returns the backoff configured in cfg, filling in the default multiplier and cap.
+func newBackoff(cfg config.ProxyConfig) backoff {
+	b := backoff{
+		base:       max(cfg.RetryBackoff, 0),
+		multiplier: cfg.RetryBackoffMultiplier,
+		max:        cfg.RetryBackoffMax,
+		jitter:     cfg.RetryJitter,
+	}
+	if b.multiplier < 1 {
+		b.multiplier = config.DefaultRetryBackoffMultiplier
+	}
+	if b.max <= 0 {
+		b.max = config.DefaultRetryBackoffMax
+	}
+	return b
+}
+
+// delay returns how long to wait before the retry that follows failed attempt number attempt,
+// counted from zero. A 429 resp with a Retry-After header waits at least as long as it asks,
+// without jitter; every delay is capped at b.max.
+func (b backoff) delay(attempt int, resp *http.Response, now time.Time) time.Duration {
+	if b.base <= 0 {
+		return 0
+	}
+	d := time.Duration(math.Min(float64(b.base)*math.Pow(b.multiplier, float64(attempt)), float64(b.max)))
+	if b.jitter {
+		d = rand.N(d + 1)
+	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			d = max(d, retryAfter)
+		}
+	}
+	return min(d, b.max)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
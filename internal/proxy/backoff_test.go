@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackoff(t *testing.T) {
+	b := newBackoff(config.ProxyConfig{RetryBackoff: 100 * time.Millisecond})
+	assert.Equal(t, config.DefaultRetryBackoffMultiplier, b.multiplier)
+	assert.Equal(t, config.DefaultRetryBackoffMax, b.max)
+
+	assert.Zero(t, newBackoff(config.ProxyConfig{}).delay(3, nil, time.Now()), "backoff is disabled by default")
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	now := time.Now()
+	b := backoff{base: 100 * time.Millisecond, multiplier: 2, max: time.Second}
+
+	t.Run("grows exponentially up to the cap", func(t *testing.T) {
+		assert.Equal(t, 100*time.Millisecond, b.delay(0, nil, now))
+		assert.Equal(t, 200*time.Millisecond, b.delay(1, nil, now))
+		assert.Equal(t, 400*time.Millisecond, b.delay(2, nil, now))
+		assert.Equal(t, time.Second, b.delay(4, nil, now))
+		assert.Equal(t, time.Second, b.delay(60, nil, now))
+	})
+
+	t.Run("jitter stays within the delay", func(t *testing.T) {
+		jittered := b
+		jittered.jitter = true
+		for i := 0; i < 100; i++ {
+			d := jittered.delay(1, nil, now)
+			assert.GreaterOrEqual(t, d, time.Duration(0))
+			assert.LessOrEqual(t, d, 200*time.Millisecond)
+		}
+	})
+
+	t.Run("respects Retry-After on 429", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}}
+		assert.Equal(t, time.Second, b.delay(0, resp, now))
+
+		// HTTP dates have a resolution of one second.
+		at := now.Truncate(time.Second)
+		resp.Header.Set("Retry-After", at.Add(3*time.Second).UTC().Format(http.TimeFormat))
+		long := backoff{base: 100 * time.Millisecond, multiplier: 2, max: 10 * time.Second}
+		assert.Equal(t, 3*time.Second, long.delay(0, resp, at))
+	})
+
+	t.Run("caps Retry-After", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"120"}}}
+		assert.Equal(t, time.Second, b.delay(0, resp, now))
+	})
+
+	t.Run("ignores Retry-After on other statuses and invalid values", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"1"}}}
+		assert.Equal(t, 100*time.Millisecond, b.delay(0, resp, now))
+
+		resp = &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"soon"}}}
+		assert.Equal(t, 100*time.Millisecond, b.delay(0, resp, now))
+	})
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isModelsListRequest reports whether r lists models, e.g. GET /v1/models.
+func isModelsListRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.TrimPrefix(r.URL.Path, "/v1") == "/models"
+}
+
+// modelsCache holds the upstream model listing so repeated client calls don't spend key quota.
+// The listing is the same for every key, so a single entry is shared by all clients.
+type modelsCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	now       func() time.Time
+	header    http.Header
+	body      []byte
+	etag      string
+	expiresAt time.Time
+}
+
+func newModelsCache(ttl time.Duration) *modelsCache {
+	return &modelsCache{ttl: ttl, now: time.Now}
+}
+
+// get returns the cached response and its ETag if it has not expired.
+func (c *modelsCache) get() (http.Header, []byte, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.body == nil || !c.now().Before(c.expiresAt) {
+		return nil, nil, "", false
+	}
+	return c.header, c.body, c.etag, true
+}
+
+// set stores a successful response for the cache TTL. Compressed responses are not stored, as
+// the next client may not accept the encoding; the ETag is computed on the stored bytes.
+func (c *modelsCache) set(header http.Header, body []byte) {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header = header
+	c.body = body
+	c.etag = bodyETag(body)
+	c.expiresAt = c.now().Add(c.ttl)
+}
+
+// writeTo replays the cached response to w, reporting false on a miss. Clients that already
+// hold the cached listing, per r's If-None-Match, get 304 Not Modified without a body.
+func (c *modelsCache) writeTo(w http.ResponseWriter, r *http.Request) bool {
+	header, body, etag, ok := c.get()
+	if !ok {
+		return false
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+	return true
+}
+
+// bodyETag returns a strong entity tag derived from a hash of body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag. Comparison is weak,
+// as RFC 9110 requires for If-None-Match, so W/ prefixes are ignored.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// capturingResponseWriter passes a response through while keeping a copy of its status and body.
+type capturingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	// failed records a failed write to the client, after which the copy is incomplete.
+	failed bool
+}
+
+// complete reports whether the captured response is a whole 200 response, with as many bytes as
+// its Content-Length, if any, announced.
+func (w *capturingResponseWriter) complete() bool {
+	if w.status != http.StatusOK || w.failed {
+		return false
+	}
+	if length := w.Header().Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		return err == nil && n == w.body.Len()
+	}
+	return true
+}
+
+func (w *capturingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.body.Write(b[:n])
+	if err != nil {
+		w.failed = true
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *capturingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/contentencoding"
+)
+
+// modifyResponse is the reverse proxy's ModifyResponse when proxy.normalize_responses is enabled,
+// keys are balanced on token usage or response headers are filtered.
+func (p *OpenAIProxy) modifyResponse(resp *http.Response) error {
+	p.headerFilter.Apply(resp.Header)
+	if p.normalize {
+		if err := p.normalizeResponse(resp); err != nil {
+			return err
+		}
+	}
+	if p.tokenRecorder != nil {
+		p.recordTokenUsage(resp)
+	}
+	return nil
+}
+
+// recordTokenUsage reports the usage.total_tokens of a successful, non-streaming JSON response
+// to the token recorder, crediting the key that served it. Streamed responses and bodies that
+// cannot be decoded or carry no usage are not counted.
+func (p *OpenAIProxy) recordTokenUsage(resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || resp.Body == nil || resp.Request == nil {
+		return
+	}
+	key, _ := resp.Request.Context().Value(geminiKeyContextKey).(string)
+	if key == "" {
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return
+	}
+
+	body, err := contentencoding.DecodeBody(resp)
+	if err != nil {
+		p.logger.Debug("Not recording token usage of undecodable response", "error", err)
+		return
+	}
+	if tokens := totalTokens(body); tokens > 0 {
+		p.tokenRecorder.RecordTokenUsage(key, tokens)
+	}
+}
+
+// totalTokens returns the usage.total_tokens of a response body, falling back to the sum of
+// prompt_tokens and completion_tokens. Bodies without usage count as zero.
+func totalTokens(body []byte) int64 {
+	var response struct {
+		Usage map[string]any `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.Usage == nil {
+		return 0
+	}
+	if total := tokenCount(response.Usage, "total_tokens"); total > 0 {
+		return total
+	}
+	return tokenCount(response.Usage, "prompt_tokens") + tokenCount(response.Usage, "completion_tokens")
+}
+
+// normalizeResponse rewrites successful, non-streaming chat completion responses so strict OpenAI
+// clients find the id, object, created and usage fields they expect. Compressed responses are decompressed
+// and compressed again; responses it cannot decode or parse are left untouched.
+func (p *OpenAIProxy) normalizeResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK || resp.Body == nil || resp.Request == nil ||
+		!strings.HasSuffix(resp.Request.URL.Path, "/chat/completions") {
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return nil
+	}
+
+	body, err := contentencoding.DecodeBody(resp)
+	if errors.Is(err, contentencoding.ErrUndecodable) {
+		p.logger.Debug("Not normalizing response with undecodable body", "error", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if normalized, changed := normalizeChatCompletion(body, time.Now()); changed {
+		return contentencoding.ReplaceBody(resp, normalized)
+	}
+	return nil
+}
+
+// normalizeChatCompletion fills in missing id, object and created fields of a chat completion and
+// rewrites its usage object to hold integer prompt_tokens, completion_tokens and total_tokens.
+// It reports whether the body was changed; bodies that are not JSON objects are returned as is.
+func normalizeChatCompletion(body []byte, now time.Time) ([]byte, bool) {
+	var completion map[string]any
+	if err := json.Unmarshal(body, &completion); err != nil || completion == nil {
+		return body, false
+	}
+
+	changed := false
+	if id, _ := completion["id"].(string); id == "" {
+		completion["id"] = newCompletionID()
+		changed = true
+	}
+	if object, _ := completion["object"].(string); object == "" {
+		completion["object"] = "chat.completion"
+		changed = true
+	}
+	if _, ok := completion["created"].(float64); !ok {
+		completion["created"] = now.Unix()
+		changed = true
+	}
+
+	usage, _ := completion["usage"].(map[string]any)
+	prompt := tokenCount(usage, "prompt_tokens")
+	completionTokens := tokenCount(usage, "completion_tokens")
+	total := tokenCount(usage, "total_tokens")
+	if total == 0 {
+		total = prompt + completionTokens
+	}
+	normalizedUsage := map[string]any{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completionTokens,
+		"total_tokens":      total,
+	}
+	if usage == nil || !usageMatches(usage, normalizedUsage) {
+		if usage == nil {
+			usage = make(map[string]any, len(normalizedUsage))
+		}
+		for name, value := range normalizedUsage {
+			usage[name] = value
+		}
+		completion["usage"] = usage
+		changed = true
+	}
+
+	if !changed {
+		return body, false
+	}
+	out, err := json.Marshal(completion)
+	if err != nil {
+		return body, false
+	}
+	return out, true
+}
+
+// tokenCount reads an integer token count from a usage object, treating missing or non-numeric values as zero.
+func tokenCount(usage map[string]any, name string) int64 {
+	switch v := usage[name].(type) {
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	default:
+		return 0
+	}
+}
+
+// usageMatches reports whether usage already holds the normalized integer counts.
+func usageMatches(usage, normalized map[string]any) bool {
+	for name, value := range normalized {
+		v, ok := usage[name].(float64)
+		if !ok || v != float64(value.(int64)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newCompletionID returns an OpenAI-style chat completion id.
+func newCompletionID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "chatcmpl-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
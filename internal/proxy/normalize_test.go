@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeChatCompletion(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	t.Run("fills missing fields and usage", func(t *testing.T) {
+		body := `{"model": "gemini-2.0-flash", "choices": [{"index": 0, "message": {"role": "assistant", "content": "hi"}}]}`
+		out, changed := normalizeChatCompletion([]byte(body), now)
+		require.True(t, changed)
+
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(out, &got))
+		assert.True(t, strings.HasPrefix(got["id"].(string), "chatcmpl-"))
+		assert.Equal(t, "chat.completion", got["object"])
+		assert.Equal(t, float64(now.Unix()), got["created"])
+		assert.Equal(t, "gemini-2.0-flash", got["model"])
+		assert.Equal(t, map[string]any{"prompt_tokens": float64(0), "completion_tokens": float64(0), "total_tokens": float64(0)}, got["usage"])
+	})
+
+	t.Run("computes missing total and keeps extra usage fields", func(t *testing.T) {
+		body := `{"id": "x", "object": "chat.completion", "created": 1, "usage": {"prompt_tokens": 7, "completion_tokens": "5", "completion_tokens_details": {"reasoning_tokens": 2}}}`
+		out, changed := normalizeChatCompletion([]byte(body), now)
+		require.True(t, changed)
+		assert.JSONEq(t, `{
+			"id": "x",
+			"object": "chat.completion",
+			"created": 1,
+			"usage": {"prompt_tokens": 7, "completion_tokens": 5, "total_tokens": 12, "completion_tokens_details": {"reasoning_tokens": 2}}
+		}`, string(out))
+	})
+
+	t.Run("leaves complete responses untouched", func(t *testing.T) {
+		body := `{"id": "x", "object": "chat.completion", "created": 1, "usage": {"prompt_tokens": 1, "completion_tokens": 2, "total_tokens": 3}}`
+		out, changed := normalizeChatCompletion([]byte(body), now)
+		assert.False(t, changed)
+		assert.Equal(t, body, string(out))
+	})
+
+	t.Run("leaves non-object bodies untouched", func(t *testing.T) {
+		for _, body := range []string{`not json`, `[1, 2]`, `null`} {
+			out, changed := normalizeChatCompletion([]byte(body), now)
+			assert.False(t, changed, body)
+			assert.Equal(t, body, string(out))
+		}
+	})
+}
+
+// tokenRecordingKeyManager is a MockKeyManager that also balances on token usage.
+type tokenRecordingKeyManager struct {
+	MockKeyManager
+}
+
+func (m *tokenRecordingKeyManager) RecordTokenUsage(key string, tokens int64) {
+	m.Called(key, tokens)
+}
+
+func TestTotalTokens(t *testing.T) {
+	assert.Equal(t, int64(42), totalTokens([]byte(`{"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 42}}`)))
+	assert.Equal(t, int64(15), totalTokens([]byte(`{"usage": {"prompt_tokens": 10, "completion_tokens": 5}}`)))
+	assert.Equal(t, int64(7), totalTokens([]byte(`{"usage": {"total_tokens": "7"}}`)))
+	assert.Zero(t, totalTokens([]byte(`{"choices": []}`)))
+	assert.Zero(t, totalTokens([]byte(`not json`)))
+}
+
+func TestOpenAIProxy_RecordsTokenUsage(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	newProxy := func(t *testing.T, strategy string, normalize bool, contentType, body string) (*OpenAIProxy, *tokenRecordingKeyManager) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+
+		mockKM := new(tokenRecordingKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil)
+		mockKM.On("HandleKeySuccess", "key-good").Return()
+
+		cfg := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 1, SelectionStrategy: strategy, NormalizeResponses: normalize}}
+		p, err := newOpenAIProxyWithURL(mockKM, cfg, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		return p, mockKM
+	}
+	completion := `{"choices": [], "usage": {"prompt_tokens": 30, "completion_tokens": 12, "total_tokens": 42}}`
+
+	t.Run("records total tokens for the serving key", func(t *testing.T) {
+		p, mockKM := newProxy(t, config.SelectionTokenWeighted, false, "application/json", completion)
+		mockKM.On("RecordTokenUsage", "key-good", int64(42)).Return()
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, completion, rr.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("records normalized usage", func(t *testing.T) {
+		p, mockKM := newProxy(t, config.SelectionTokenWeighted, true, "application/json", `{"choices": [], "usage": {"prompt_tokens": 3, "completion_tokens": 4}}`)
+		mockKM.On("RecordTokenUsage", "key-good", int64(7)).Return()
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("ignores streaming responses", func(t *testing.T) {
+		p, mockKM := newProxy(t, config.SelectionTokenWeighted, false, "text/event-stream", "data: "+completion+"\n\n")
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"stream": true}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertNotCalled(t, "RecordTokenUsage", mock.Anything, mock.Anything)
+	})
+
+	t.Run("disabled for other strategies", func(t *testing.T) {
+		p, mockKM := newProxy(t, config.SelectionLowestUsage, false, "application/json", completion)
+		assert.Nil(t, p.reverseProxy.ModifyResponse)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertNotCalled(t, "RecordTokenUsage", mock.Anything, mock.Anything)
+	})
+}
+
+func TestOpenAIProxy_NormalizeResponses(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	newProxyWithHandler := func(t *testing.T, normalize bool, handler http.HandlerFunc) *OpenAIProxy {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil)
+		mockKM.On("HandleKeySuccess", "key-good").Return()
+
+		cfg := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 1, NormalizeResponses: normalize}}
+		p, err := newOpenAIProxyWithURL(mockKM, cfg, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		return p
+	}
+	newProxy := func(t *testing.T, normalize bool, contentType, body string) *OpenAIProxy {
+		return newProxyWithHandler(t, normalize, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		})
+	}
+	minimal := `{"choices": [{"message": {"content": "hi"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 3, "completion_tokens": 4}}`
+
+	t.Run("normalizes json chat completions", func(t *testing.T) {
+		p := newProxy(t, true, "application/json; charset=utf-8", minimal)
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		var got struct {
+			ID      string `json:"id"`
+			Object  string `json:"object"`
+			Created int64  `json:"created"`
+			Usage   struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+				TotalTokens      int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.NotEmpty(t, got.ID)
+		assert.Equal(t, "chat.completion", got.Object)
+		assert.NotZero(t, got.Created)
+		assert.Equal(t, 3, got.Usage.PromptTokens)
+		assert.Equal(t, 4, got.Usage.CompletionTokens)
+		assert.Equal(t, 7, got.Usage.TotalTokens)
+		assert.Equal(t, strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		p := newProxy(t, false, "application/json", minimal)
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+		assert.Equal(t, minimal, rr.Body.String())
+	})
+
+	t.Run("skips streaming responses", func(t *testing.T) {
+		stream := "data: {\"choices\": []}\n\n"
+		p := newProxy(t, true, "text/event-stream", stream)
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"stream": true}`)))
+		assert.Equal(t, stream, rr.Body.String())
+	})
+
+	t.Run("normalizes gzipped responses", func(t *testing.T) {
+		p := newProxyWithHandler(t, true, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(minimal))
+			gz.Close()
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+		gz, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+
+		var got struct {
+			Object string `json:"object"`
+			Usage  struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		require.NoError(t, json.Unmarshal(body, &got))
+		assert.Equal(t, "chat.completion", got.Object)
+		assert.Equal(t, 7, got.Usage.TotalTokens)
+	})
+
+	t.Run("passes on bodies with unsupported encodings", func(t *testing.T) {
+		p := newProxyWithHandler(t, true, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("brotli bytes"))
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("Accept-Encoding", "br")
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "br", rr.Header().Get("Content-Encoding"))
+		assert.Equal(t, "brotli bytes", rr.Body.String())
+	})
+}
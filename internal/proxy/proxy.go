@@ -11,79 +11,182 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/ubuygold/gogemini/internal/apierror"
+	"github.com/ubuygold/gogemini/internal/auth"
+	"github.com/ubuygold/gogemini/internal/bodylimit"
+	"github.com/ubuygold/gogemini/internal/circuitbreaker"
 	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/contentencoding"
+	"github.com/ubuygold/gogemini/internal/headerfilter"
+	"github.com/ubuygold/gogemini/internal/keymanager"
+	"github.com/ubuygold/gogemini/internal/logger"
+	"github.com/ubuygold/gogemini/internal/requestlog"
+	"github.com/ubuygold/gogemini/internal/timeout"
+	"github.com/ubuygold/gogemini/internal/unavailable"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer returns a tracer from the global provider, which is a no-op until tracing is set up.
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/ubuygold/gogemini/internal/proxy")
+}
+
 // Manager defines the interface for a key manager that the proxy can use.
 type Manager interface {
-	GetNextKey() (string, error)
-	HandleKeyFailure(key string)
+	GetNextKey(group, model string) (string, error)
+	GetKeyForSession(sessionID, group, model string) (string, error)
+	HandleKeyFailure(key string, statusCode int, errorBody string)
 	HandleKeySuccess(key string)
 	GetAvailableKeyCount() int
+	AvailabilityError() error
+	SuspendedError() error
+}
+
+// RetryKeySource is implemented by key managers that hand out keys for retries differently
+// from keys for new requests, such as keymanager.KeyManager, which keeps serving retries
+// while it drains and moves the request's session, if any, to the retry key. Other managers
+// are asked for retry keys through GetNextKey.
+type RetryKeySource interface {
+	GetRetryKey(sessionID, group, model string) (string, error)
+}
+
+// TokenRecorder is implemented by key managers that balance keys on token usage, see
+// config.SelectionTokenWeighted.
+type TokenRecorder interface {
+	RecordTokenUsage(key string, tokens int64)
 }
 
 // retryingTransport is a custom http.RoundTripper that implements retry logic.
 type retryingTransport struct {
-	keyManager Manager
-	logger     *slog.Logger
-	transport  http.RoundTripper
+	keyManager       Manager
+	logger           *slog.Logger
+	transport        http.RoundTripper
+	maxRetryAttempts int
+	// retryableStatus holds the upstream statuses that are retried with another key.
+	retryableStatus map[int]bool
+	// backoff spaces out the attempts; the zero value retries immediately.
+	backoff backoff
+	// maxRetryBodyBytes caps the request body buffered to resend on retries; zero disables the cap.
+	maxRetryBodyBytes int64
+	// fatalErrorPatterns mark error bodies that condemn the key, whatever the status.
+	fatalErrorPatterns []string
 }
 
-const maxRetryAttempts = 5
-
-// RoundTrip executes a single HTTP transaction, but adds retry logic.
+// RoundTrip executes a single HTTP transaction, but adds retry logic. Retries wait for the
+// configured backoff unless that would outlast the request deadline.
 func (rt *retryingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// The first key is already attached to the request by the Director.
 	if _, ok := req.Context().Value(geminiKeyContextKey).(string); !ok {
 		return nil, errors.New("gemini key not found in request context for transport")
 	}
 
+	log := logger.FromContext(req.Context(), rt.logger)
 	numAvailableKeys := rt.keyManager.GetAvailableKeyCount()
 	numAttempts := numAvailableKeys
-	if numAttempts > maxRetryAttempts {
-		numAttempts = maxRetryAttempts
+	if numAttempts > rt.maxRetryAttempts {
+		numAttempts = rt.maxRetryAttempts
 	}
 	var lastErr error
 
+	// Every attempt resends the body, so keep a copy unless it is too large to retry.
+	body, retryable, err := bufferBodyForRetry(req, rt.maxRetryBodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if !retryable && numAttempts > 1 {
+		log.Debug("Request body exceeds the retry buffer, sending it once", "limit", rt.maxRetryBodyBytes)
+		numAttempts = 1
+	}
+
 	for i := 0; i < numAttempts; i++ {
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
 		currentKey := req.Context().Value(geminiKeyContextKey).(string)
-		rt.logger.Debug("Attempting request", "attempt", i+1, "key_suffix", safeKeySuffix(currentKey))
+		log.Debug("Attempting request", "attempt", i+1, "key_suffix", safeKeySuffix(currentKey))
+		if entry := requestlog.FromContext(req.Context()); entry != nil {
+			entry.KeySuffix = safeKeySuffix(currentKey)
+			entry.Retries = i
+		}
 
-		resp, err := rt.transport.RoundTrip(req)
+		attemptCtx, span := tracer().Start(req.Context(), "proxy.attempt", trace.WithAttributes(
+			attribute.Int("attempt", i+1),
+			attribute.String("key_suffix", safeKeySuffix(currentKey)),
+		))
+		resp, err := rt.transport.RoundTrip(req.WithContext(attemptCtx))
+		endAttemptSpan(span, resp, err)
+
+		// A cancelled or timed-out request says nothing about the key, so stop without retrying.
+		if err != nil && req.Context().Err() != nil {
+			return nil, err
+		}
 
 		// Check if the response is successful or a non-retryable error.
 		if err == nil && resp.StatusCode < 400 {
 			rt.keyManager.HandleKeySuccess(currentKey)
 			return resp, nil // Success
 		}
-		if err == nil && !isRetryableStatusCode(resp.StatusCode) {
-			// Not a key-related failure (e.g., 400 Bad Request), so don't retry.
-			rt.logger.Warn("Received non-retryable error status", "status", resp.StatusCode, "key_suffix", safeKeySuffix(currentKey))
-			return resp, nil
+		statusCode := 0
+		var errorBody string
+		if err == nil && !rt.retryableStatus[resp.StatusCode] {
+			// Usually not a key-related failure (e.g., 400 Bad Request), so don't retry, unless
+			// the body matches a fatal error pattern, as Gemini's 400 for an invalid key does.
+			if len(rt.fatalErrorPatterns) > 0 {
+				errorBody = captureErrorBody(resp)
+			}
+			if keymanager.MatchFatalPattern(rt.fatalErrorPatterns, errorBody) == "" {
+				log.Warn("Received non-retryable error status", "status", resp.StatusCode, "key_suffix", safeKeySuffix(currentKey))
+				return resp, nil
+			}
 		}
 
-		// It's a retryable error (either transport error or HTTP status), so handle the failure.
+		// It's a key failure (transport error, retryable or fatal status), so handle it and retry.
 		if err != nil {
 			lastErr = err
-			rt.logger.Warn("Request failed with transport error, will retry", "key_suffix", safeKeySuffix(currentKey), "error", err)
+			log.Warn("Request failed with transport error, will retry", "key_suffix", safeKeySuffix(currentKey), "error", err)
 		} else {
+			statusCode = resp.StatusCode
+			if errorBody == "" {
+				errorBody = captureErrorBody(resp)
+			}
 			lastErr = fmt.Errorf("received status code %d", resp.StatusCode)
-			rt.logger.Warn("Request failed with retryable status, will retry", "status", resp.StatusCode, "key_suffix", safeKeySuffix(currentKey))
+			log.Warn("Request failed with retryable status, will retry", "status", resp.StatusCode, "key_suffix", safeKeySuffix(currentKey))
 		}
-		rt.keyManager.HandleKeyFailure(currentKey)
+		rt.keyManager.HandleKeyFailure(currentKey, statusCode, errorBody)
 
 		// If this was the last retry, return the last known response/error, wrapping the error for context.
 		if i == numAttempts-1 {
-			return resp, fmt.Errorf("last attempt failed: %w", lastErr)
+			return nil, newUpstreamError(resp, fmt.Errorf("last attempt failed: %w", lastErr))
+		}
+
+		// Release the failed response, keeping its rate-limit headers in case no retry follows.
+		delay := rt.backoff.delay(i, resp, time.Now())
+		failure := newUpstreamError(resp, lastErr)
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Warn("Retry backoff would outlast the request deadline, giving up", "delay", delay)
+			return nil, failure
+		}
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
 		}
 
 		// Get the next key for the retry.
-		nextKey, keyErr := rt.keyManager.GetNextKey()
+		requestModel, _ := req.Context().Value(requestModelContextKey).(string)
+		sessionID, _ := req.Context().Value(sessionIDContextKey).(string)
+		nextKey, keyErr := rt.retryKey(sessionID, auth.KeyGroupFromContext(req.Context()), requestModel)
 		if keyErr != nil {
-			rt.logger.Error("Failed to get next key for retry", "error", keyErr)
-			return resp, lastErr // Return the last response and error
+			log.Error("Failed to get next key for retry", "error", keyErr)
+			return nil, failure
 		}
 
 		// Update the request with the new key for the next iteration.
@@ -94,42 +197,247 @@ func (rt *retryingTransport) RoundTrip(req *http.Request) (*http.Response, error
 	return nil, fmt.Errorf("all retries failed; last error: %w", lastErr)
 }
 
-func isRetryableStatusCode(code int) bool {
-	switch code {
-	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
-		return true
-	// Also retry on server-side errors
-	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
-		return true
-	default:
-		return false
+// retryKey returns the key for another attempt at a request, see RetryKeySource.
+func (rt *retryingTransport) retryKey(sessionID, group, model string) (string, error) {
+	if source, ok := rt.keyManager.(RetryKeySource); ok {
+		return source.GetRetryKey(sessionID, group, model)
+	}
+	return rt.keyManager.GetNextKey(group, model)
+}
+
+// bufferedBody is a request body held in memory, as set by bufferRequestBody and
+// ModifyRequestBody, whose bytes later steps read without copying them, see bufferedBytes.
+type bufferedBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newBufferedBody(data []byte) io.ReadCloser {
+	return &bufferedBody{Reader: bytes.NewReader(data), data: data}
+}
+
+func (b *bufferedBody) Close() error { return nil }
+
+// bufferedBytes returns the bytes of body if it is an unread bufferedBody.
+func bufferedBytes(body io.ReadCloser) ([]byte, bool) {
+	buffered, ok := body.(*bufferedBody)
+	if !ok || buffered.Len() != len(buffered.data) {
+		return nil, false
+	}
+	return buffered.data, true
+}
+
+// bufferBodyForRetry returns req's body so retries can send it again, and sets req.GetBody to
+// match. Bodies already buffered by the proxy are reused; others are read into memory. It
+// returns a nil body for requests without one. Bodies larger than limit are put back unread
+// apart from their start and reported as not retryable; a non-positive limit buffers any body.
+func bufferBodyForRetry(req *http.Request, limit int64) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	body, ok := bufferedBytes(req.Body)
+	if ok && limit > 0 && int64(len(body)) > limit {
+		return nil, false, nil
+	}
+	if !ok {
+		reader := io.Reader(req.Body)
+		if limit > 0 {
+			reader = io.LimitReader(req.Body, limit+1)
+		}
+		var err error
+		if body, err = io.ReadAll(reader); err != nil {
+			return nil, false, err
+		}
+		if limit > 0 && int64(len(body)) > limit {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			return nil, false, nil
+		}
+	}
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, true, nil
+}
+
+// sleepContext waits for d, returning early with ctx's error if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// maxCapturedErrorBody caps how much of an upstream error body is read for HandleKeyFailure.
+const maxCapturedErrorBody = 4 << 10
+
+// captureErrorBody reads the start of resp's body for HandleKeyFailure and puts it back in
+// front of the rest, so the response can still be returned to the client unchanged. Compressed
+// bodies are decompressed as far as the captured bytes allow.
+func captureErrorBody(resp *http.Response) string {
+	captured, _ := io.ReadAll(io.LimitReader(resp.Body, maxCapturedErrorBody))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(captured), resp.Body), resp.Body}
+
+	if encoding := contentencoding.Of(resp); encoding != "" {
+		r, err := contentencoding.NewDecompressor(encoding, captured)
+		if err != nil {
+			return ""
+		}
+		defer r.Close()
+		decoded, _ := io.ReadAll(io.LimitReader(r, maxCapturedErrorBody))
+		return string(decoded)
+	}
+	return string(captured)
+}
+
+// upstreamError is returned by retryingTransport when every attempt failed. It keeps the
+// rate-limit headers of the last upstream response so clients can back off.
+type upstreamError struct {
+	err    error
+	header http.Header
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+func (e *upstreamError) Unwrap() error { return e.err }
+
+// newUpstreamError wraps err with the rate-limit headers of resp, if any, and closes resp's body.
+func newUpstreamError(resp *http.Response, err error) error {
+	if resp == nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	header := make(http.Header)
+	for name, values := range resp.Header {
+		if name == "Retry-After" || strings.HasPrefix(name, "X-Ratelimit-") {
+			header[name] = values
+		}
+	}
+	return &upstreamError{err: err, header: header}
+}
+
+// endAttemptSpan records the outcome of a single upstream attempt and ends its span.
+func endAttemptSpan(span trace.Span, resp *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+}
+
+// statusSet converts a list of status codes into a lookup set.
+func statusSet(codes []int) map[int]bool {
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
 type OpenAIProxy struct {
 	keyManager   Manager
 	reverseProxy *httputil.ReverseProxy
+	breaker      *circuitbreaker.Breaker
 	targetURL    *url.URL
 	debug        bool
 	logger       *slog.Logger
+	modelAliases map[string]string
+	// defaultModel is the model of chat completion requests that name none; empty disables it.
+	defaultModel string
+	// stripFields are removed from chat completion requests, see config.ProxyConfig.FieldsToStrip.
+	stripFields []string
+	// toolModels are the model patterns that keep toolFields, see config.ProxyConfig.ToolModels.
+	toolModels []string
+	stripTopK  bool
+	stripNulls bool
+	// requestTimeout bounds the time until the upstream response starts; zero disables it.
+	requestTimeout time.Duration
+	// maxRequestBodyBytes caps request bodies; zero disables the limit.
+	maxRequestBodyBytes int64
+	// verbatimBodyBytes is the body size above which requests are forwarded verbatim; zero
+	// only forwards multimodal requests verbatim.
+	verbatimBodyBytes int64
+	// tokenRecorder receives the token usage of responses; nil when keys are not balanced on tokens.
+	tokenRecorder TokenRecorder
+	normalize     bool
+	// headerFilter removes upstream response headers before they reach clients; nil passes all.
+	headerFilter *headerfilter.Filter
+	// modelsCache is nil when caching of the model listing is disabled.
+	modelsCache *modelsCache
+	// RequestLog, when set, records every request served by the proxy.
+	RequestLog *requestlog.Buffer
 }
 
 type contextKey string
 
 const geminiKeyContextKey = contextKey("geminiKey")
 
+// requestModelContextKey holds the upstream model a request asks for, so retries prefer keys
+// that serve it too.
+const requestModelContextKey = contextKey("requestModel")
+
+// sessionIDContextKey holds the X-Session-ID of a request, which the director strips, so
+// retries can move the session to the retry key.
+const sessionIDContextKey = contextKey("sessionID")
+
 // newOpenAIProxyWithURL is the internal constructor that allows for custom target URLs, making it testable.
-func newOpenAIProxyWithURL(km Manager, cfg *config.Config, target string, logger *slog.Logger) (*OpenAIProxy, error) {
+func newOpenAIProxyWithURL(km Manager, cfg *config.Config, target string, transport http.RoundTripper, logger *slog.Logger) (*OpenAIProxy, error) {
 	targetURL, err := url.Parse(target)
 	if err != nil {
 		return nil, err
 	}
 
+	maxRetryAttempts := cfg.Proxy.MaxRetryAttempts
+	if maxRetryAttempts < 1 {
+		maxRetryAttempts = config.DefaultMaxRetryAttempts
+	}
+
+	proxyLogger := logger.With("component", "proxy")
 	proxy := &OpenAIProxy{
-		keyManager: km,
-		targetURL:  targetURL,
-		debug:      cfg.Debug,
-		logger:     logger.With("component", "proxy"),
+		keyManager:          km,
+		breaker:             circuitbreaker.New(km.AvailabilityError, circuitbreaker.DefaultCooldown, proxyLogger),
+		targetURL:           targetURL,
+		debug:               cfg.Debug,
+		logger:              proxyLogger,
+		modelAliases:        cfg.Proxy.ModelAliases,
+		defaultModel:        cfg.Proxy.DefaultModel,
+		stripFields:         cfg.Proxy.FieldsToStrip(),
+		toolModels:          cfg.Proxy.ToolCallingModels(),
+		stripTopK:           cfg.Proxy.TopKStrippingEnabled(),
+		stripNulls:          cfg.Proxy.NullStrippingEnabled(),
+		maxRequestBodyBytes: cfg.Proxy.RequestBodyLimit(),
+		verbatimBodyBytes:   cfg.Proxy.VerbatimBodyThreshold(),
+		normalize:           cfg.Proxy.NormalizeResponses,
+		headerFilter:        headerfilter.New(cfg.Proxy.StripResponseHeaders, cfg.Proxy.AllowResponseHeaders),
+	}
+	if recorder, ok := km.(TokenRecorder); ok && cfg.Proxy.SelectionStrategy == config.SelectionTokenWeighted {
+		proxy.tokenRecorder = recorder
+	}
+	if ttl := cfg.Proxy.ModelsCacheTTL; ttl > 0 {
+		proxy.modelsCache = newModelsCache(ttl)
+	} else if ttl == 0 {
+		proxy.modelsCache = newModelsCache(config.DefaultModelsCacheTTL)
+	}
+	if d := cfg.Proxy.RequestTimeout; d > 0 {
+		proxy.requestTimeout = d
 	}
 
 	proxy.reverseProxy = &httputil.ReverseProxy{
@@ -146,54 +454,228 @@ func newOpenAIProxyWithURL(km Manager, cfg *config.Config, target string, logger
 			// The transport will use this key for the first attempt.
 			key := req.Context().Value(geminiKeyContextKey).(string)
 			req.Header.Set("Authorization", "Bearer "+key)
+			req.Header.Del(sessionIDHeader)
 
 			// Sanitize the request body to remove OpenAI-specific fields.
 			if err := proxy.ModifyRequestBody(req); err != nil {
-				proxy.logger.Error("Failed to modify request body", "error", err)
+				proxy.requestLogger(req).Error("Failed to modify request body", "error", err)
 				// We can't easily fail the request here, but logging is important.
 			}
 
 			if proxy.debug {
-				proxy.logger.Debug("Proxying request", "path", req.URL.Path)
+				proxy.requestLogger(req).Debug("Proxying request", "path", req.URL.Path)
 			}
 		},
 		Transport: &retryingTransport{
-			keyManager: km,
-			logger:     logger.With("component", "transport"),
-			transport:  http.DefaultTransport,
+			keyManager:         km,
+			logger:             logger.With("component", "transport"),
+			transport:          transport,
+			maxRetryAttempts:   maxRetryAttempts,
+			retryableStatus:    statusSet(cfg.Proxy.RetryableStatuses()),
+			backoff:            newBackoff(cfg.Proxy),
+			maxRetryBodyBytes:  cfg.Proxy.RetryBodyLimit(),
+			fatalErrorPatterns: cfg.Proxy.FatalErrorPatterns,
 		},
-		// ModifyResponse is no longer needed as success/failure is handled in the transport.
+		// Key success/failure is handled in the transport; ModifyResponse only normalizes
+		// response bodies, records token usage and filters headers, see modifyResponse.
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if timeout.Exceeded(r) {
+				proxy.requestLogger(r).Warn("Upstream did not respond before the request timeout", "timeout", proxy.requestTimeout)
+				apierror.Write(w, http.StatusGatewayTimeout, apierror.TypeServerError, apierror.CodeUpstreamTimeout, "Upstream did not respond in time")
+				return
+			}
 			if errors.Is(err, context.Canceled) || errors.Is(err, http.ErrAbortHandler) {
-				proxy.logger.Warn("Client disconnected", "error", err)
+				proxy.requestLogger(r).Warn("Client disconnected", "error", err)
 				return
 			}
-			proxy.logger.Error("Proxy error after all retries", "error", err)
-			http.Error(w, "Service unavailable after multiple retries", http.StatusServiceUnavailable)
+			proxy.requestLogger(r).Error("Proxy error after all retries", "error", err)
+			var upstreamErr *upstreamError
+			if errors.As(err, &upstreamErr) {
+				proxy.headerFilter.Apply(upstreamErr.header)
+				for name, values := range upstreamErr.header {
+					w.Header()[name] = values
+				}
+			}
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeUpstreamUnavailable, "Service unavailable after multiple retries")
 		},
 	}
 
+	if proxy.normalize || proxy.tokenRecorder != nil || proxy.headerFilter != nil {
+		proxy.reverseProxy.ModifyResponse = proxy.modifyResponse
+	}
+
 	return proxy, nil
 }
 
-// NewOpenAIProxy creates a new OpenAIProxy with the default Google API target.
-func NewOpenAIProxy(km Manager, cfg *config.Config, logger *slog.Logger) (*OpenAIProxy, error) {
-	return newOpenAIProxyWithURL(km, cfg, "https://generativelanguage.googleapis.com", logger)
+// NewOpenAIProxy creates a new OpenAIProxy targeting the configured upstream base URL.
+// Upstream requests are sent through the given transport.
+func NewOpenAIProxy(km Manager, cfg *config.Config, transport http.RoundTripper, logger *slog.Logger) (*OpenAIProxy, error) {
+	return newOpenAIProxyWithURL(km, cfg, cfg.Upstream.BaseURLOrDefault(), transport, logger)
 }
 
+// sessionIDHeader lets clients pin a multi-turn conversation to a single Gemini key.
+const sessionIDHeader = "X-Session-ID"
+
 func (p *OpenAIProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	key, err := p.keyManager.GetNextKey()
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "OpenAIProxy.ServeHTTP", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.method", r.Method),
+		attribute.String("http.path", r.URL.Path),
+	))
+	defer span.End()
+
+	start := time.Now()
+	entry := requestlog.Begin(ctx, start, r.Method, r.URL.Path)
+	sw := requestlog.NewStatusWriter(w)
+	w = sw
+	defer func() {
+		entry.Status = sw.Status()
+		entry.LatencyMS = time.Since(start).Milliseconds()
+		p.RequestLog.Add(*entry)
+	}()
+	ctx = requestlog.WithEntry(ctx, entry)
+	r = r.WithContext(ctx)
+
+	// The model listing doesn't depend on the key or client, so serve it from cache when possible.
+	var capture *capturingResponseWriter
+	if p.modelsCache != nil && isModelsListRequest(r) {
+		if p.modelsCache.writeTo(w, r) {
+			span.SetAttributes(attribute.Bool("cache_hit", true))
+			return
+		}
+		capture = &capturingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		w = capture
+		// Let the transport negotiate compression and decode it, so the cached bytes are plain
+		// and can be served to any client.
+		r.Header.Del("Accept-Encoding")
+	}
+
+	// Reject oversized bodies before a key is used.
+	body, ok := p.bufferRequestBody(w, r)
+	if !ok {
+		span.SetStatus(codes.Error, "invalid request body")
+		return
+	}
+	model := p.requestedModel(body)
+	if model == "" {
+		model = p.resolveModel(p.defaultModelFor(r.URL.Path))
+	}
+
+	// Paused and draining managers turn requests away without tripping the breaker, so
+	// requests are served again as soon as selection resumes.
+	if err := p.keyManager.SuspendedError(); err != nil {
+		span.SetStatus(codes.Error, "key selection suspended")
+		unavailable.Write(w, err)
+		return
+	}
+
+	// Fail fast without per-request logging while no keys are available.
+	if err := p.breaker.Allow(); err != nil {
+		span.SetStatus(codes.Error, "circuit open")
+		unavailable.Write(w, err)
+		return
+	}
+
+	var key string
+	var err error
+	group := auth.KeyGroupFromContext(r.Context())
+	if sessionID := r.Header.Get(sessionIDHeader); sessionID != "" {
+		key, err = p.keyManager.GetKeyForSession(sessionID, group, model)
+	} else {
+		key, err = p.keyManager.GetNextKey(group, model)
+	}
 	if err != nil {
-		p.logger.Error("Failed to get next available key for proxy", "error", err)
-		http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "no available key")
+		p.requestLogger(r).Error("Failed to get next available key for proxy", "error", err)
+		unavailable.Write(w, err)
 		return
 	}
+	span.SetAttributes(attribute.String("key_suffix", safeKeySuffix(key)))
 
 	// Store the key in the request context to access it in Director and ModifyResponse
-	ctx := context.WithValue(r.Context(), geminiKeyContextKey, key)
+	ctx = context.WithValue(ctx, geminiKeyContextKey, key)
+	ctx = context.WithValue(ctx, requestModelContextKey, model)
+	if sessionID := r.Header.Get(sessionIDHeader); sessionID != "" {
+		ctx = context.WithValue(ctx, sessionIDContextKey, sessionID)
+	}
 	req := r.WithContext(ctx)
 
+	w, req, cancel := timeout.FirstByte(w, req, p.requestTimeout)
+	defer cancel()
 	p.reverseProxy.ServeHTTP(w, req)
+
+	// A response cut short by the client or upstream is not cached.
+	if capture != nil && capture.complete() && req.Context().Err() == nil {
+		p.modelsCache.set(capture.Header().Clone(), capture.body.Bytes())
+	}
+}
+
+// bufferRequestBody reads r's body into memory within the configured size limit, which
+// ModifyRequestBody would buffer anyway, and writes 413 for oversized bodies. It returns the
+// body and reports whether the request should be proxied.
+func (p *OpenAIProxy) bufferRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if !bodylimit.Limit(w, r, p.maxRequestBodyBytes) {
+		writeRequestTooLarge(w)
+		return nil, false
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		if bodylimit.Exceeded(err) {
+			writeRequestTooLarge(w)
+		} else {
+			apierror.Write(w, http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeInvalidRequestBody, "Failed to read request body")
+		}
+		return nil, false
+	}
+	r.Body = newBufferedBody(body)
+	return body, true
+}
+
+// requestedModel returns the upstream model named by a chat or embeddings request body,
+// applying the same "models/" prefix removal and aliasing as ModifyRequestBody. It returns
+// "" when the body names no model.
+func (p *OpenAIProxy) requestedModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return p.resolveModel(req.Model)
+}
+
+// resolveModel removes the "models/" prefix from a model name and rewrites it if it is a
+// configured alias (e.g. an OpenAI model name).
+func (p *OpenAIProxy) resolveModel(model string) string {
+	model = strings.TrimPrefix(model, "models/")
+	if alias, ok := p.modelAliases[model]; ok {
+		return alias
+	}
+	return model
+}
+
+// defaultModelFor returns the model set on requests to path that name none, or "" when
+// requests to path are left unchanged. Only chat completions get proxy.default_model.
+func (p *OpenAIProxy) defaultModelFor(path string) string {
+	if strings.HasSuffix(path, "/chat/completions") {
+		return p.defaultModel
+	}
+	return ""
+}
+
+// writeRequestTooLarge answers a request whose body exceeds proxy.max_request_body_bytes.
+func writeRequestTooLarge(w http.ResponseWriter) {
+	apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.TypeInvalidRequest, apierror.CodeRequestTooLarge, "Request body is too large")
+}
+
+// requestLogger returns the proxy's logger annotated with the request ID of r, if any.
+func (p *OpenAIProxy) requestLogger(r *http.Request) *slog.Logger {
+	return logger.FromContext(r.Context(), p.logger)
 }
 
 // safeKeySuffix returns the last 4 characters of a key for logging.
@@ -209,48 +691,98 @@ func (p *OpenAIProxy) Close() {
 	p.logger.Info("Proxy shutdown.")
 }
 
+// endpointFieldRule lists the OpenAI-specific fields that Gemini rejects for a given endpoint.
+type endpointFieldRule struct {
+	pathSuffix     string
+	fieldsToRemove []string
+}
+
+// endpointFieldRules holds the endpoint-specific rules, matched against the upstream path suffix.
+var endpointFieldRules = []endpointFieldRule{
+	{
+		pathSuffix:     "/embeddings",
+		fieldsToRemove: []string{"encoding_format", "user"},
+	},
+}
+
+// fieldsToRemoveFor returns the fields to strip for a request to the given path.
+// The configured strip list applies to chat completions and any endpoint without a more specific rule.
+func (p *OpenAIProxy) fieldsToRemoveFor(path string) []string {
+	for _, rule := range endpointFieldRules {
+		if strings.HasSuffix(path, rule.pathSuffix) {
+			return rule.fieldsToRemove
+		}
+	}
+	return p.stripFields
+}
+
+// toolFields are the tool and function calling fields kept for models matching toolModels.
+var toolFields = []string{"tools", "tool_choice", "functions", "function_call"}
+
+// supportsTools reports whether model matches one of the configured tool model patterns.
+func (p *OpenAIProxy) supportsTools(model string) bool {
+	if model == "" {
+		return false
+	}
+	for _, pattern := range p.toolModels {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // ModifyRequestBody reads the request body, removes OpenAI-specific fields,
-// and replaces the request body with the modified version.
+// and replaces the request body with the modified version. Large and multimodal bodies are
+// forwarded verbatim apart from the model name, see forwardVerbatim.
 func (p *OpenAIProxy) ModifyRequestBody(req *http.Request) error {
 	if req.Body == nil {
 		return nil
 	}
+	log := p.requestLogger(req)
 
-	bodyBytes, err := io.ReadAll(req.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read request body: %w", err)
+	bodyBytes, ok := bufferedBytes(req.Body)
+	if !ok {
+		var err error
+		if bodyBytes, err = io.ReadAll(req.Body); err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		// Restore the body so it can be read again if needed
+		req.Body = newBufferedBody(bodyBytes)
 	}
-	// Restore the body so it can be read again if needed
-	req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 	if len(bodyBytes) == 0 {
 		return nil
 	}
+	if p.forwardVerbatim(bodyBytes) {
+		log.Debug("Forwarding request body verbatim", "bytes", len(bodyBytes))
+		return p.rewriteModelVerbatim(req, bodyBytes)
+	}
 
 	var bodyJSON map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &bodyJSON); err != nil {
 		// If it's not valid JSON, we don't touch it.
-		p.logger.Debug("Request body is not valid JSON, skipping modification", "error", err)
+		log.Debug("Request body is not valid JSON, skipping modification", "error", err)
 		return nil
 	}
 
-	// List of OpenAI-specific fields to remove.
-	// Sourced from OpenAI API documentation and common client libraries.
-	fieldsToRemove := []string{
-		"frequency_penalty",
-		"presence_penalty",
-		"logit_bias",
-		"logprobs",
-		"top_logprobs",
-		"reasoning_effort",
-		"max_completion_tokens",
-		"n",
-		"tools",
-		"function_call",
-		"functions",
+	// Fill in proxy.default_model for clients that leave the model out, before it is resolved below.
+	modified := false
+	if model, _ := bodyJSON["model"].(string); model == "" {
+		if defaultModel := p.defaultModelFor(req.URL.Path); defaultModel != "" {
+			log.Debug("Setting default model on request body", "model", defaultModel)
+			bodyJSON["model"] = defaultModel
+			modified = true
+		}
+	}
+
+	fieldsToRemove := p.fieldsToRemoveFor(req.URL.Path)
+	if model, _ := bodyJSON["model"].(string); p.supportsTools(p.resolveModel(model)) {
+		fieldsToRemove = slices.DeleteFunc(slices.Clone(fieldsToRemove), func(field string) bool {
+			return slices.Contains(toolFields, field)
+		})
 	}
 
-	modified := false
 	for _, field := range fieldsToRemove {
 		if _, ok := bodyJSON[field]; ok {
 			delete(bodyJSON, field)
@@ -258,36 +790,45 @@ func (p *OpenAIProxy) ModifyRequestBody(req *http.Request) error {
 		}
 	}
 
-	// 1. Unconditionally remove "top_k"
-	if _, ok := bodyJSON["top_k"]; ok {
+	// 1. Remove "top_k", unless disabled by proxy.strip_top_k.
+	if _, ok := bodyJSON["top_k"]; ok && p.stripTopK {
 		delete(bodyJSON, "top_k")
 		modified = true
 	}
 
-	// 2. Remove any field that has a null value.
-	for key, value := range bodyJSON {
-		if value == nil {
-			delete(bodyJSON, key)
-			modified = true
+	// 2. Remove any field that has a null value, unless disabled by proxy.strip_null_fields.
+	if p.stripNulls {
+		for key, value := range bodyJSON {
+			if value == nil {
+				delete(bodyJSON, key)
+				modified = true
+			}
 		}
 	}
 
-	// Also, remove "models/" prefix from the model name if it exists.
+	// Also, remove "models/" prefix from the model name if it exists,
+	// then rewrite it if the client used a configured alias (e.g. an OpenAI model name).
 	if model, ok := bodyJSON["model"].(string); ok {
 		if strings.HasPrefix(model, "models/") {
-			bodyJSON["model"] = strings.TrimPrefix(model, "models/")
+			model = strings.TrimPrefix(model, "models/")
+			bodyJSON["model"] = model
+			modified = true
+		}
+		if alias, ok := p.modelAliases[model]; ok {
+			log.Debug("Rewriting aliased model name", "from", model, "to", alias)
+			bodyJSON["model"] = alias
 			modified = true
 		}
 	}
 
 	if modified {
-		p.logger.Debug("Removed OpenAI-specific fields from request body", "fields", fieldsToRemove)
+		log.Debug("Removed OpenAI-specific fields from request body", "fields", fieldsToRemove)
 		newBodyBytes, err := json.Marshal(bodyJSON)
 		if err != nil {
 			return fmt.Errorf("failed to marshal modified request body: %w", err)
 		}
-		p.logger.Debug("Modified request body for proxying", "body", string(newBodyBytes))
-		req.Body = io.NopCloser(bytes.NewBuffer(newBodyBytes))
+		log.Debug("Modified request body for proxying", "body", string(newBodyBytes))
+		req.Body = newBufferedBody(newBodyBytes)
 		req.ContentLength = int64(len(newBodyBytes))
 	}
 
@@ -1,36 +1,56 @@
 package proxy
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/ubuygold/gogemini/internal/apierror"
 	"github.com/ubuygold/gogemini/internal/config"
+	"github.com/ubuygold/gogemini/internal/keymanager"
+	"github.com/ubuygold/gogemini/internal/requestlog"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // MockKeyManager is a mock implementation of the keymanager.Manager interface.
 type MockKeyManager struct {
 	mock.Mock
+	// suspended is returned by SuspendedError, standing in for a paused or draining manager.
+	suspended error
 }
 
-func (m *MockKeyManager) GetNextKey() (string, error) {
-	args := m.Called()
+func (m *MockKeyManager) GetNextKey(group, model string) (string, error) {
+	args := m.Called(group, model)
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockKeyManager) HandleKeyFailure(key string) {
-	m.Called(key)
+func (m *MockKeyManager) GetKeyForSession(sessionID, group, model string) (string, error) {
+	args := m.Called(sessionID, group, model)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockKeyManager) HandleKeyFailure(key string, statusCode int, errorBody string) {
+	m.Called(key, statusCode, errorBody)
 }
 
 func (m *MockKeyManager) HandleKeySuccess(key string) {
@@ -42,9 +62,85 @@ func (m *MockKeyManager) GetAvailableKeyCount() int {
 	return args.Int(0)
 }
 
+// AvailabilityError follows the GetAvailableKeyCount expectation, like the real manager, which
+// only fails when no key is available.
+func (m *MockKeyManager) AvailabilityError() error {
+	if m.GetAvailableKeyCount() == 0 {
+		return keymanager.ErrNoKeys
+	}
+	return nil
+}
+
+func (m *MockKeyManager) SuspendedError() error { return m.suspended }
+
+func TestOpenAIProxy_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockKM := new(MockKeyManager)
+	mockKM.On("GetAvailableKeyCount").Return(2)
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-bad-1111", nil).Once()
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-good-2222", nil).Once()
+	mockKM.On("HandleKeyFailure", "key-bad-1111", http.StatusTooManyRequests, mock.Anything).Return().Once()
+	mockKM.On("HandleKeySuccess", "key-good-2222").Return().Once()
+
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 5}}
+	proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	spans := recorder.Ended()
+	var parent sdktrace.ReadOnlySpan
+	var attempts []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		switch s.Name() {
+		case "OpenAIProxy.ServeHTTP":
+			parent = s
+		case "proxy.attempt":
+			attempts = append(attempts, s)
+		}
+	}
+	require.NotNil(t, parent)
+	require.Len(t, attempts, 2)
+
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	for i, attempt := range attempts {
+		assert.Equal(t, parent.SpanContext().SpanID(), attempt.Parent().SpanID(), "attempt should be a child of the request span")
+		assert.Equal(t, int64(i+1), attrs(attempt)["attempt"].AsInt64())
+	}
+	assert.Equal(t, "1111", attrs(attempts[0])["key_suffix"].AsString())
+	assert.Equal(t, int64(http.StatusTooManyRequests), attrs(attempts[0])["http.status_code"].AsInt64())
+	assert.Equal(t, "2222", attrs(attempts[1])["key_suffix"].AsString())
+	assert.Equal(t, int64(http.StatusOK), attrs(attempts[1])["http.status_code"].AsInt64())
+	mockKM.AssertExpectations(t)
+}
+
 func TestOpenAIProxy_RetryLogic(t *testing.T) {
 	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-	testConfig := &config.Config{Debug: false}
+	testConfig := &config.Config{Debug: false, Proxy: config.ProxyConfig{MaxRetryAttempts: 5}}
 
 	t.Run("successfully proxies on first attempt", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -56,10 +152,10 @@ func TestOpenAIProxy_RetryLogic(t *testing.T) {
 
 		mockKM := new(MockKeyManager)
 		mockKM.On("GetAvailableKeyCount").Return(1) // Only one key, so max 1 attempt
-		mockKM.On("GetNextKey").Return("key-good", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil).Once()
 		mockKM.On("HandleKeySuccess", "key-good").Return().Once()
 
-		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, testLogger)
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
@@ -89,14 +185,14 @@ func TestOpenAIProxy_RetryLogic(t *testing.T) {
 		mockKM := new(MockKeyManager)
 		mockKM.On("GetAvailableKeyCount").Return(2)
 		// First call in ServeHTTP
-		mockKM.On("GetNextKey").Return("key-bad-1", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-bad-1", nil).Once()
 		// Second call for retry
-		mockKM.On("GetNextKey").Return("key-good-2", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good-2", nil).Once()
 
-		mockKM.On("HandleKeyFailure", "key-bad-1").Return().Once()
+		mockKM.On("HandleKeyFailure", "key-bad-1", http.StatusTooManyRequests, mock.Anything).Return().Once()
 		mockKM.On("HandleKeySuccess", "key-good-2").Return().Once()
 
-		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, testLogger)
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
@@ -119,12 +215,12 @@ func TestOpenAIProxy_RetryLogic(t *testing.T) {
 
 		mockKM := new(MockKeyManager)
 		mockKM.On("GetAvailableKeyCount").Return(2)
-		mockKM.On("GetNextKey").Return("key-bad-1", nil).Once()
-		mockKM.On("GetNextKey").Return("key-bad-2", nil).Once()
-		mockKM.On("HandleKeyFailure", "key-bad-1").Return().Once()
-		mockKM.On("HandleKeyFailure", "key-bad-2").Return().Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-bad-1", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-bad-2", nil).Once()
+		mockKM.On("HandleKeyFailure", "key-bad-1", http.StatusForbidden, mock.Anything).Return().Once()
+		mockKM.On("HandleKeyFailure", "key-bad-2", http.StatusForbidden, mock.Anything).Return().Once()
 
-		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, testLogger)
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
@@ -147,11 +243,11 @@ func TestOpenAIProxy_RetryLogic(t *testing.T) {
 
 		mockKM := new(MockKeyManager)
 		mockKM.On("GetAvailableKeyCount").Return(1)
-		mockKM.On("GetNextKey").Return("key-good", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil).Once()
 		// HandleKeyFailure should NOT be called
 		// HandleKeySuccess should NOT be called
 
-		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, testLogger)
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
 		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
@@ -165,131 +261,1097 @@ func TestOpenAIProxy_RetryLogic(t *testing.T) {
 
 	t.Run("handles key manager error on first attempt", func(t *testing.T) {
 		mockKM := new(MockKeyManager)
-		mockKM.On("GetNextKey").Return("", errors.New("no keys available")).Once()
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("", errors.New("no keys available")).Once()
+
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://dummy.url", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.JSONEq(t, `{"error": {"message": "Service temporarily unavailable", "type": "server_error", "param": null, "code": "no_available_keys"}}`, rr.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("distinguishes missing keys from temporarily disabled ones", func(t *testing.T) {
+		for _, tc := range []struct {
+			err        error
+			code       string
+			retryAfter string
+		}{
+			{keymanager.ErrNoKeys, apierror.CodeNoKeysConfigured, ""},
+			{fmt.Errorf("%w in group %q", keymanager.ErrNoKeys, "team"), apierror.CodeNoKeysConfigured, ""},
+			{&keymanager.KeysDisabledError{RetryAfter: 90*time.Second + time.Millisecond}, apierror.CodeKeysDisabled, "91"},
+			{&keymanager.KeysDisabledError{}, apierror.CodeKeysDisabled, ""},
+		} {
+			mockKM := new(MockKeyManager)
+			mockKM.On("GetAvailableKeyCount").Return(1)
+			mockKM.On("GetNextKey", "", mock.Anything).Return("", tc.err).Once()
+
+			proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://dummy.url", http.DefaultTransport, testLogger)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code, tc.err.Error())
+			var body apierror.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Equal(t, tc.code, body.Error.Code, tc.err.Error())
+			assert.Equal(t, tc.retryAfter, rr.Header().Get("Retry-After"), tc.err.Error())
+			mockKM.AssertExpectations(t)
+		}
+	})
+
+	t.Run("fails fast while key selection is paused", func(t *testing.T) {
+		var upstreamHits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamHits.Add(1)
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("", keymanager.ErrPaused).Once()
+
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{}`))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Zero(t, upstreamHits.Load())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("circuit breaker short-circuits when no keys are available", func(t *testing.T) {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(0).Once()
+
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://dummy.url", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		}
+
+		// Only the first request probes the key manager; the rest hit the open breaker.
+		mockKM.AssertExpectations(t)
+		mockKM.AssertNotCalled(t, "GetNextKey")
+	})
+
+	t.Run("stops after 5 retries even if more keys are available", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			w.WriteHeader(http.StatusForbidden) // Always fail
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		// We have 10 keys, but should only try 5 times.
+		mockKM.On("GetAvailableKeyCount").Return(10)
+		// Initial key + 4 retries = 5 attempts
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Times(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-2", nil).Times(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-3", nil).Times(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-4", nil).Times(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-5", nil).Times(1)
+
+		mockKM.On("HandleKeyFailure", mock.Anything, http.StatusForbidden, mock.Anything).Times(5)
+
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		// After all retries fail, the proxy's ErrorHandler should be called, which returns a 503.
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Service unavailable after multiple retries")
+		assert.Equal(t, int32(5), requestCount, "Server should have been called exactly 5 times")
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("respects configured max retry attempts", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			w.WriteHeader(http.StatusTooManyRequests) // Always fail
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(10)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-2", nil).Once()
+		mockKM.On("HandleKeyFailure", mock.Anything, http.StatusTooManyRequests, mock.Anything).Times(2)
+
+		cfg := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 2}}
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, int32(2), requestCount, "Server should have been called exactly 2 times")
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("propagates upstream rate-limit headers after final failure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-Upstream-Internal", "secret")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(2)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-2", nil).Once()
+		mockKM.On("HandleKeyFailure", mock.Anything, http.StatusTooManyRequests, mock.Anything).Times(2)
+
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+		assert.Empty(t, rr.Header().Get("X-Upstream-Internal"), "only rate-limit headers should be forwarded")
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"error": {"message": "Service unavailable after multiple retries", "type": "server_error", "param": null, "code": "upstream_unavailable"}}`, rr.Body.String())
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("uses the configured retryable status codes", func(t *testing.T) {
+		customConfig := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 5, RetryableStatusCodes: []int{http.StatusConflict}}}
+
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch atomic.AddInt32(&requestCount, 1) {
+			case 1:
+				w.WriteHeader(http.StatusConflict) // Retried with the custom set
+			default:
+				w.WriteHeader(http.StatusInternalServerError) // No longer retried
+				w.Write([]byte("deterministic failure"))
+			}
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(3)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once()
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-2", nil).Once()
+		mockKM.On("HandleKeyFailure", "key-1", http.StatusConflict, mock.Anything).Return().Once()
+
+		proxy, err := newOpenAIProxyWithURL(mockKM, customConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Equal(t, "deterministic failure", rr.Body.String())
+		assert.Equal(t, int32(2), requestCount)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("selects a key for the requested model", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", "gemini-1.5-pro").Return("key-good", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-good").Return().Once()
+
+		cfg := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 5, ModelAliases: map[string]string{"gpt-4o": "gemini-1.5-pro"}}}
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": []}`))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+	})
+}
+
+func TestOpenAIProxy_ModelsCache(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	var upstreamHits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamHits, 1)
+		assert.Equal(t, "/v1beta/openai/models", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"gemini-pro"}]}`))
+	}))
+	defer server.Close()
+
+	newProxy := func(t *testing.T, mockKM *MockKeyManager) *OpenAIProxy {
+		cfg := &config.Config{Proxy: config.ProxyConfig{ModelsCacheTTL: time.Minute}}
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		return proxy
+	}
+
+	t.Run("second request within the TTL is served from cache", func(t *testing.T) {
+		atomic.StoreInt32(&upstreamHits, 0)
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-1").Once()
+		proxy := newProxy(t, mockKM)
+
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/models", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			assert.JSONEq(t, `{"object":"list","data":[{"id":"gemini-pro"}]}`, rr.Body.String())
+		}
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&upstreamHits))
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("expired entry is fetched again", func(t *testing.T) {
+		atomic.StoreInt32(&upstreamHits, 0)
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Twice()
+		mockKM.On("HandleKeySuccess", "key-1").Twice()
+		proxy := newProxy(t, mockKM)
+
+		now := time.Now()
+		proxy.modelsCache.now = func() time.Time { return now }
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/models", nil))
+		now = now.Add(2 * time.Minute)
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/models", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&upstreamHits))
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("cached listing honors If-None-Match", func(t *testing.T) {
+		atomic.StoreInt32(&upstreamHits, 0)
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-1").Once()
+		proxy := newProxy(t, mockKM)
+
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/models", nil))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/models", nil))
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			req := httptest.NewRequest("GET", "/v1/models", nil)
+			req.Header.Set("If-None-Match", ifNoneMatch)
+			rr = httptest.NewRecorder()
+			proxy.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusNotModified, rr.Code, ifNoneMatch)
+			assert.Equal(t, etag, rr.Header().Get("ETag"))
+			assert.Empty(t, rr.Body.String())
+		}
+
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		req.Header.Set("If-None-Match", `"stale"`)
+		rr = httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+		assert.JSONEq(t, `{"object":"list","data":[{"id":"gemini-pro"}]}`, rr.Body.String())
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&upstreamHits))
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("error responses are not cached", func(t *testing.T) {
+		var hits int32
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer failing.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Twice()
+		cfg := &config.Config{Proxy: config.ProxyConfig{ModelsCacheTTL: time.Minute}}
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, failing.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/models", nil))
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		}
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("compressed listing is cached decoded", func(t *testing.T) {
+		var hits int32
+		gzipped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set("Content-Type", "application/json")
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				w.Write([]byte(`{"object":"list","data":[]}`))
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`{"object":"list","data":[]}`))
+			gz.Close()
+		}))
+		defer gzipped.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-1").Once()
+		cfg := &config.Config{Proxy: config.ProxyConfig{ModelsCacheTTL: time.Minute}}
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, gzipped.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+		// A client that does not accept gzip gets the cached listing as plain JSON.
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/models", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "HIT", rr.Header().Get("X-Cache"))
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.JSONEq(t, `{"object":"list","data":[]}`, rr.Body.String())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("truncated responses are not cached", func(t *testing.T) {
+		var hits int32
+		truncating := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(`{"object":"list",`))
+		}))
+		defer truncating.Close()
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Twice()
+		mockKM.On("HandleKeySuccess", "key-1")
+		cfg := &config.Config{Proxy: config.ProxyConfig{ModelsCacheTTL: time.Minute}}
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, truncating.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/models", nil))
+		}
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("compressed bodies are never stored", func(t *testing.T) {
+		cache := newModelsCache(time.Minute)
+		cache.set(http.Header{"Content-Encoding": {"br"}}, []byte("compressed"))
+		_, _, _, ok := cache.get()
+		assert.False(t, ok)
+	})
+}
+
+func TestNewOpenAIProxyWithURL_Error(t *testing.T) {
+	mockKM := new(MockKeyManager)
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Debug: false}
+
+	// Invalid URL with a control character
+	_, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://\x7f.invalid", http.DefaultTransport, testLogger)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid control character in URL")
+}
+
+func TestRetryingTransport_RoundTrip_ContextError(t *testing.T) {
+	mockKM := new(MockKeyManager)
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	transport := &retryingTransport{
+		keyManager:       mockKM,
+		logger:           testLogger,
+		transport:        http.DefaultTransport,
+		maxRetryAttempts: 5,
+	}
+
+	// Create a request without the geminiKey in the context
+	req := httptest.NewRequest("GET", "/", nil)
+	_, err := transport.RoundTrip(req)
+
+	assert.Error(t, err)
+	assert.EqualError(t, err, "gemini key not found in request context for transport")
+}
+
+func TestRetryingTransport_RetriesForRequestedModel(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockKM := new(MockKeyManager)
+	mockKM.On("GetAvailableKeyCount").Return(2)
+	mockKM.On("HandleKeyFailure", "key-1", http.StatusTooManyRequests, mock.Anything).Return().Once()
+	mockKM.On("GetNextKey", "", "gemini-2.5-pro").Return("key-2", nil).Once()
+	mockKM.On("HandleKeySuccess", "key-2").Return().Once()
+
+	transport := &retryingTransport{
+		keyManager:       mockKM,
+		logger:           slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		transport:        http.DefaultTransport,
+		maxRetryAttempts: 5,
+		retryableStatus:  statusSet(config.DefaultRetryableStatusCodes),
+	}
+
+	ctx := context.WithValue(context.Background(), geminiKeyContextKey, "key-1")
+	ctx = context.WithValue(ctx, requestModelContextKey, "gemini-2.5-pro")
+	req := httptest.NewRequest("GET", server.URL, nil).WithContext(ctx)
+	req.RequestURI = ""
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	mockKM.AssertExpectations(t)
+}
+
+func TestRetryingTransport_PassesErrorBody(t *testing.T) {
+	const errorBody = `{"error":{"code":403,"message":"API key not valid. Please pass a valid API key."}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(errorBody))
+	}))
+	defer server.Close()
+
+	mockKM := new(MockKeyManager)
+	mockKM.On("GetAvailableKeyCount").Return(1)
+	mockKM.On("HandleKeyFailure", "key-1", http.StatusForbidden, errorBody).Return().Once()
+
+	transport := &retryingTransport{
+		keyManager:       mockKM,
+		logger:           slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		transport:        http.DefaultTransport,
+		maxRetryAttempts: 5,
+		retryableStatus:  statusSet(config.DefaultRetryableStatusCodes),
+	}
+
+	ctx := context.WithValue(context.Background(), geminiKeyContextKey, "key-1")
+	req := httptest.NewRequest("GET", server.URL, nil).WithContext(ctx)
+	req.RequestURI = ""
+	_, err := transport.RoundTrip(req)
+	assert.Error(t, err)
+	mockKM.AssertExpectations(t)
+}
+
+func TestRetryingTransport_FatalNonRetryableStatus(t *testing.T) {
+	const errorBody = `{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}`
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "Bearer key-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(errorBody))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	newTransport := func(km *MockKeyManager, patterns []string) *retryingTransport {
+		return &retryingTransport{
+			keyManager:         km,
+			logger:             slog.New(slog.NewJSONHandler(io.Discard, nil)),
+			transport:          http.DefaultTransport,
+			maxRetryAttempts:   5,
+			retryableStatus:    statusSet(config.DefaultRetryableStatusCodes),
+			fatalErrorPatterns: patterns,
+		}
+	}
+	newRequest := func() *http.Request {
+		ctx := context.WithValue(context.Background(), geminiKeyContextKey, "key-1")
+		req := httptest.NewRequest("GET", server.URL, nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer key-1")
+		req.RequestURI = ""
+		return req
+	}
+
+	t.Run("disables the key and retries with another", func(t *testing.T) {
+		keys = nil
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(2)
+		mockKM.On("HandleKeyFailure", "key-1", http.StatusBadRequest, errorBody).Return().Once()
+		mockKM.On("GetNextKey", "", "").Return("key-2", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-2").Return().Once()
+
+		resp, err := newTransport(mockKM, []string{"api key not valid"}).RoundTrip(newRequest())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"Bearer key-1", "Bearer key-2"}, keys)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("returns other bodies to the client untouched", func(t *testing.T) {
+		keys = nil
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(2)
+
+		resp, err := newTransport(mockKM, []string{"quota exceeded"}).RoundTrip(newRequest())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, errorBody, string(body))
+		assert.Len(t, keys, 1)
+		mockKM.AssertNotCalled(t, "HandleKeyFailure", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestRetryingTransport_Backoff(t *testing.T) {
+	// newServer fails the first failures requests with status and header, then succeeds, and
+	// records when each request arrived.
+	newServer := func(t *testing.T, failures int, status int, header http.Header) (*httptest.Server, *[]time.Time) {
+		var mu sync.Mutex
+		var hits []time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits = append(hits, time.Now())
+			n := len(hits)
+			mu.Unlock()
+			if n <= failures {
+				for name, values := range header {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(status)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(server.Close)
+		return server, &hits
+	}
+	newTransport := func(b backoff) (*retryingTransport, *MockKeyManager) {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(5)
+		mockKM.On("HandleKeyFailure", "key-1", mock.Anything, mock.Anything).Return()
+		mockKM.On("GetNextKey", "", "").Return("key-1", nil)
+		mockKM.On("HandleKeySuccess", "key-1").Return()
+		return &retryingTransport{
+			keyManager:       mockKM,
+			logger:           slog.New(slog.NewJSONHandler(io.Discard, nil)),
+			transport:        http.DefaultTransport,
+			maxRetryAttempts: 5,
+			retryableStatus:  statusSet(config.DefaultRetryableStatusCodes),
+			backoff:          b,
+		}, mockKM
+	}
+	newRequest := func(ctx context.Context, url string) *http.Request {
+		req := httptest.NewRequest("GET", url, nil).WithContext(context.WithValue(ctx, geminiKeyContextKey, "key-1"))
+		req.RequestURI = ""
+		return req
+	}
+
+	t.Run("waits between attempts", func(t *testing.T) {
+		server, hits := newServer(t, 2, http.StatusServiceUnavailable, nil)
+		transport, _ := newTransport(backoff{base: 50 * time.Millisecond, multiplier: 2, max: time.Second})
+
+		resp, err := transport.RoundTrip(newRequest(context.Background(), server.URL))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		require.Len(t, *hits, 3)
+		assert.GreaterOrEqual(t, (*hits)[1].Sub((*hits)[0]), 50*time.Millisecond)
+		assert.GreaterOrEqual(t, (*hits)[2].Sub((*hits)[1]), 100*time.Millisecond)
+	})
+
+	t.Run("respects Retry-After", func(t *testing.T) {
+		server, hits := newServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+		transport, _ := newTransport(backoff{base: 10 * time.Millisecond, multiplier: 2, max: 5 * time.Second})
+
+		resp, err := transport.RoundTrip(newRequest(context.Background(), server.URL))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		require.Len(t, *hits, 2)
+		assert.GreaterOrEqual(t, (*hits)[1].Sub((*hits)[0]), time.Second)
+	})
+
+	t.Run("gives up when the backoff would outlast the deadline", func(t *testing.T) {
+		server, hits := newServer(t, 5, http.StatusTooManyRequests, http.Header{"Retry-After": {"5"}})
+		transport, _ := newTransport(backoff{base: 10 * time.Millisecond, multiplier: 2, max: 10 * time.Second})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := transport.RoundTrip(newRequest(ctx, server.URL))
+
+		var upstreamErr *upstreamError
+		require.ErrorAs(t, err, &upstreamErr)
+		assert.Equal(t, "5", upstreamErr.header.Get("Retry-After"))
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+		assert.Len(t, *hits, 1)
+	})
+
+	t.Run("stops waiting when the request is cancelled", func(t *testing.T) {
+		server, hits := newServer(t, 5, http.StatusServiceUnavailable, nil)
+		transport, _ := newTransport(backoff{base: 5 * time.Second, multiplier: 2, max: 10 * time.Second})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := transport.RoundTrip(newRequest(ctx, server.URL))
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Len(t, *hits, 1)
+	})
+}
+
+func TestCaptureErrorBody(t *testing.T) {
+	body := strings.Repeat("x", maxCapturedErrorBody+10)
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(body))}
+
+	captured := captureErrorBody(resp)
+	assert.Len(t, captured, maxCapturedErrorBody)
+
+	rest, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(rest), "the full body should still be readable")
+
+	t.Run("gzipped body", func(t *testing.T) {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write([]byte(`{"error": {"message": "API key not valid"}}`))
+		gz.Close()
+		raw := compressed.Bytes()
+		resp := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, Body: io.NopCloser(bytes.NewReader(raw))}
+
+		assert.Equal(t, `{"error": {"message": "API key not valid"}}`, captureErrorBody(resp))
+		rest, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, raw, rest, "the compressed body is passed on unchanged")
+	})
+}
+
+func TestRetryingTransport_ResendsBody(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	// newServer answers 429 to the first request and 200 afterwards, recording every body.
+	newServer := func(t *testing.T) (*httptest.Server, func() []string) {
+		var mu sync.Mutex
+		var bodies []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			bodies = append(bodies, string(body))
+			n := len(bodies)
+			mu.Unlock()
+			if n == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(server.Close)
+		return server, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Clone(bodies)
+		}
+	}
+	newKeyManager := func() *MockKeyManager {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(2)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil)
+		mockKM.On("HandleKeyFailure", "key-1", http.StatusTooManyRequests, mock.Anything).Return()
+		mockKM.On("HandleKeySuccess", "key-1").Return()
+		return mockKM
+	}
+
+	for name, body := range map[string]string{
+		"modified JSON body": `{"model": "gemini-2.5-flash", "n": 1, "messages": [{"role": "user", "content": "hi"}]}`,
+		"non-JSON body":      "not json at all",
+		"verbatim body":      `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}]}]}`,
+	} {
+		t.Run("retry receives the same body: "+name, func(t *testing.T) {
+			server, bodies := newServer(t)
+			proxy, err := newOpenAIProxyWithURL(newKeyManager(), &config.Config{}, server.URL, http.DefaultTransport, testLogger)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			got := bodies()
+			require.Len(t, got, 2)
+			assert.NotEmpty(t, got[0])
+			assert.Equal(t, got[0], got[1])
+		})
+	}
+
+	t.Run("bodies over the cap are sent once", func(t *testing.T) {
+		server, bodies := newServer(t)
+		cfg := &config.Config{Proxy: config.ProxyConfig{MaxRetryBodyBytes: 16}}
+		mockKM := newKeyManager()
+		proxy, err := newOpenAIProxyWithURL(mockKM, cfg, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := "a body longer than sixteen bytes"
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+
+		assert.Equal(t, []string{body}, bodies())
+		mockKM.AssertNotCalled(t, "HandleKeySuccess", "key-1")
+	})
+
+	t.Run("bodies buffered by the proxy are not copied", func(t *testing.T) {
+		data := []byte(`{"model": "gemini-2.5-flash"}`)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.Body = newBufferedBody(data)
+
+		body, retryable, err := bufferBodyForRetry(req, 64)
+		require.NoError(t, err)
+		assert.True(t, retryable)
+		assert.Same(t, &data[0], &body[0])
+
+		req.Body = newBufferedBody(data)
+		body, retryable, err = bufferBodyForRetry(req, 16)
+		require.NoError(t, err)
+		assert.False(t, retryable)
+		assert.Nil(t, body)
+		sent, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, data, sent)
+	})
+}
+
+func TestRetryingTransport_GetNextKeyError(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.WriteHeader(http.StatusTooManyRequests) // Always fail to trigger retry
+	}))
+	defer server.Close()
+
+	mockKM := new(MockKeyManager)
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Debug: false, Proxy: config.ProxyConfig{MaxRetryAttempts: 5}}
+
+	mockKM.On("GetAvailableKeyCount").Return(2)
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-1", nil).Once() // For ServeHTTP
+	// This error occurs when trying to get a key for the retry
+	mockKM.On("GetNextKey", "", mock.Anything).Return("", errors.New("no more keys")).Once()
+	mockKM.On("HandleKeyFailure", "key-1", http.StatusTooManyRequests, mock.Anything).Once()
+
+	proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	// When GetNextKey fails, the loop terminates and the ErrorHandler is triggered.
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Service unavailable after multiple retries")
+	assert.Equal(t, int32(1), requestCount, "Server should have been called only once")
+	mockKM.AssertExpectations(t)
+}
+
+// retryKeyManager is a MockKeyManager that hands out retry keys through GetRetryKey.
+type retryKeyManager struct {
+	*MockKeyManager
+}
+
+func (m retryKeyManager) GetRetryKey(sessionID, group, model string) (string, error) {
+	args := m.Called(sessionID, group, model)
+	return args.String(0), args.Error(1)
+}
+
+func TestRetryingTransport_UsesRetryKeySource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer key-1" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockKM := new(MockKeyManager)
+	mockKM.On("GetAvailableKeyCount").Return(2)
+	mockKM.On("GetKeyForSession", "session-1", "", mock.Anything).Return("key-1", nil).Once()
+	mockKM.On("GetRetryKey", "session-1", "", mock.Anything).Return("key-2", nil).Once()
+	mockKM.On("HandleKeyFailure", "key-1", http.StatusTooManyRequests, mock.Anything).Once()
+	mockKM.On("HandleKeySuccess", "key-2").Once()
+
+	proxy, err := newOpenAIProxyWithURL(retryKeyManager{mockKM}, &config.Config{}, server.URL, http.DefaultTransport, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	require.NoError(t, err)
+
+	// The session travels to the retry key source even though the header is not forwarded.
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{}`))
+	req.Header.Set(sessionIDHeader, "session-1")
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockKM.AssertExpectations(t)
+}
+
+func TestErrorHandler_ContextCanceled(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Debug: false}
+	mockKM := new(MockKeyManager)
+
+	// We need a fully initialized proxy to test the error handler
+	proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://dummy.url", http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+
+	// The ErrorHandler should not write to the response writer for a canceled context
+	proxy.reverseProxy.ErrorHandler(rr, req, context.Canceled)
+	assert.Equal(t, http.StatusOK, rr.Code) // Default status, nothing written
+	assert.Equal(t, "", rr.Body.String())
+}
+
+func TestOpenAIProxy_RequestTimeout(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 5, RequestTimeout: 50 * time.Millisecond}}
+
+	t.Run("returns 504 without penalizing the key when the upstream is slow", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(3)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-slow", nil).Once()
 
-		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://dummy.url", testLogger)
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
-		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
 		rr := httptest.NewRecorder()
-		proxy.ServeHTTP(rr, req)
+		proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", nil))
 
-		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+		assert.Contains(t, rr.Body.String(), "upstream_timeout")
 		mockKM.AssertExpectations(t)
+		mockKM.AssertNotCalled(t, "HandleKeyFailure", mock.Anything, mock.Anything, mock.Anything)
 	})
 
-	t.Run("stops after 5 retries even if more keys are available", func(t *testing.T) {
-		var requestCount int32
+	t.Run("does not cut a stream that started in time", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			atomic.AddInt32(&requestCount, 1)
-			w.WriteHeader(http.StatusForbidden) // Always fail
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for i := 0; i < 3; i++ {
+				time.Sleep(40 * time.Millisecond)
+				w.Write([]byte("data: chunk\n\n"))
+				w.(http.Flusher).Flush()
+			}
 		}))
 		defer server.Close()
 
 		mockKM := new(MockKeyManager)
-		// We have 10 keys, but should only try 5 times.
-		mockKM.On("GetAvailableKeyCount").Return(10)
-		// Initial key + 4 retries = 5 attempts
-		mockKM.On("GetNextKey").Return("key-1", nil).Times(1)
-		mockKM.On("GetNextKey").Return("key-2", nil).Times(1)
-		mockKM.On("GetNextKey").Return("key-3", nil).Times(1)
-		mockKM.On("GetNextKey").Return("key-4", nil).Times(1)
-		mockKM.On("GetNextKey").Return("key-5", nil).Times(1)
-
-		mockKM.On("HandleKeyFailure", mock.Anything).Times(5)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-good").Return().Once()
 
-		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, testLogger)
+		proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 		require.NoError(t, err)
 
-		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
 		rr := httptest.NewRecorder()
-		proxy.ServeHTTP(rr, req)
+		proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", nil))
 
-		// After all retries fail, the proxy's ErrorHandler should be called, which returns a 503.
-		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
-		assert.Contains(t, rr.Body.String(), "Service unavailable after multiple retries")
-		assert.Equal(t, int32(5), requestCount, "Server should have been called exactly 5 times")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, strings.Repeat("data: chunk\n\n", 3), rr.Body.String())
 		mockKM.AssertExpectations(t)
 	})
 }
 
-func TestNewOpenAIProxyWithURL_Error(t *testing.T) {
-	mockKM := new(MockKeyManager)
-	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-	testConfig := &config.Config{Debug: false}
-
-	// Invalid URL with a control character
-	_, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://\x7f.invalid", testLogger)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "invalid control character in URL")
-}
+func TestOpenAIProxy_ServesRequestsRightAfterResume(t *testing.T) {
+	var logBuf bytes.Buffer
+	testLogger := slog.New(slog.NewJSONHandler(&logBuf, nil))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
 
-func TestRetryingTransport_RoundTrip_ContextError(t *testing.T) {
-	mockKM := new(MockKeyManager)
-	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	mockKM := &MockKeyManager{suspended: keymanager.ErrPaused}
+	mockKM.On("GetAvailableKeyCount").Return(1)
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil).Once()
+	mockKM.On("HandleKeySuccess", "key-good").Return().Once()
 
-	transport := &retryingTransport{
-		keyManager: mockKM,
-		logger:     testLogger,
-		transport:  http.DefaultTransport,
-	}
+	proxy, err := newOpenAIProxyWithURL(mockKM, &config.Config{}, server.URL, http.DefaultTransport, testLogger)
+	require.NoError(t, err)
 
-	// Create a request without the geminiKey in the context
-	req := httptest.NewRequest("GET", "/", nil)
-	_, err := transport.RoundTrip(req)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "key_selection_paused")
 
-	assert.Error(t, err)
-	assert.EqualError(t, err, "gemini key not found in request context for transport")
+	mockKM.suspended = nil
+	rr = httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, logBuf.String(), "Circuit breaker opened")
+	mockKM.AssertExpectations(t)
 }
 
-func TestRetryingTransport_GetNextKeyError(t *testing.T) {
+func TestOpenAIProxy_RequestLog(t *testing.T) {
 	var requestCount int32
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		atomic.AddInt32(&requestCount, 1)
-		w.WriteHeader(http.StatusTooManyRequests) // Always fail to trigger retry
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
 	mockKM := new(MockKeyManager)
-	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-	testConfig := &config.Config{Debug: false}
-
 	mockKM.On("GetAvailableKeyCount").Return(2)
-	mockKM.On("GetNextKey").Return("key-1", nil).Once() // For ServeHTTP
-	// This error occurs when trying to get a key for the retry
-	mockKM.On("GetNextKey").Return("", errors.New("no more keys")).Once()
-	mockKM.On("HandleKeyFailure", "key-1").Once()
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-bad-1111", nil).Once()
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-good-2222", nil).Once()
+	mockKM.On("HandleKeyFailure", "key-bad-1111", http.StatusTooManyRequests, mock.Anything).Return().Once()
+	mockKM.On("HandleKeySuccess", "key-good-2222").Return().Once()
+	mockKM.On("GetNextKey", "", mock.Anything).Return("key-good-2222", nil).Once()
+	mockKM.On("HandleKeySuccess", "key-good-2222").Return().Once()
 
-	proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, testLogger)
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	testConfig := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 5}}
+	proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
 	require.NoError(t, err)
-
-	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
-	rr := httptest.NewRecorder()
-	proxy.ServeHTTP(rr, req)
-
-	// When GetNextKey fails, the loop terminates and the ErrorHandler is triggered.
-	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
-	assert.Contains(t, rr.Body.String(), "Service unavailable after multiple retries")
-	assert.Equal(t, int32(1), requestCount, "Server should have been called only once")
+	proxy.RequestLog = requestlog.NewBuffer(10)
+
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/chat/completions", nil))
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/embeddings", nil))
+
+	recent := proxy.RequestLog.Recent()
+	require.Len(t, recent, 2)
+	assert.Equal(t, "/v1/embeddings", recent[0].Path)
+	assert.Equal(t, 0, recent[0].Retries)
+	assert.Equal(t, "/v1/chat/completions", recent[1].Path)
+	assert.Equal(t, "POST", recent[1].Method)
+	assert.Equal(t, http.StatusOK, recent[1].Status)
+	assert.Equal(t, "2222", recent[1].KeySuffix)
+	assert.Equal(t, 1, recent[1].Retries)
 	mockKM.AssertExpectations(t)
 }
 
-func TestErrorHandler_ContextCanceled(t *testing.T) {
+func TestOpenAIProxy_RequestBodyLimit(t *testing.T) {
 	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-	testConfig := &config.Config{Debug: false}
-	mockKM := new(MockKeyManager)
+	testConfig := &config.Config{Proxy: config.ProxyConfig{MaxRetryAttempts: 1, MaxRequestBodyBytes: 64}}
+	body := func(size int) string {
+		prefix := `{"model": "m", "pad": "`
+		return prefix + strings.Repeat("a", size-len(prefix)-2) + `"}`
+	}
 
-	// We need a fully initialized proxy to test the error handler
-	proxy, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://dummy.url", testLogger)
-	require.NoError(t, err)
+	t.Run("proxies a body just under the limit", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
 
-	rr := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "/", nil)
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil).Once()
+		mockKM.On("HandleKeySuccess", "key-good").Return().Once()
 
-	// The ErrorHandler should not write to the response writer for a canceled context
-	proxy.reverseProxy.ErrorHandler(rr, req, context.Canceled)
-	assert.Equal(t, http.StatusOK, rr.Code) // Default status, nothing written
-	assert.Equal(t, "", rr.Body.String())
+		p, err := newOpenAIProxyWithURL(mockKM, testConfig, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body(63))))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("rejects a body just over the limit without using a key", func(t *testing.T) {
+		mockKM := new(MockKeyManager)
+		p, err := newOpenAIProxyWithURL(mockKM, testConfig, "http://127.0.0.1:1", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		for name, newRequest := range map[string]func() *http.Request{
+			"declared length": func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body(65)))
+			},
+			"chunked": func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", io.NopCloser(strings.NewReader(body(65))))
+				req.ContentLength = -1
+				return req
+			},
+		} {
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, newRequest())
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, name)
+			assert.Contains(t, rr.Body.String(), "request_too_large", name)
+		}
+		mockKM.AssertNotCalled(t, "GetNextKey", mock.Anything)
+	})
+
+	t.Run("anthropic handler rejects oversized bodies", func(t *testing.T) {
+		p, err := newOpenAIProxyWithURL(new(MockKeyManager), testConfig, "http://127.0.0.1:1", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		NewAnthropicHandler(p).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", strings.NewReader(body(65))))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.JSONEq(t, `{"type": "error", "error": {"type": "request_too_large", "message": "Request body is too large"}}`, rr.Body.String())
+	})
 }
 
 func TestSafeKeySuffix(t *testing.T) {
@@ -313,7 +1375,7 @@ func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 
 func TestModifyRequestBody(t *testing.T) {
 	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
-	proxy := &OpenAIProxy{logger: testLogger}
+	proxy := &OpenAIProxy{logger: testLogger, stripFields: config.DefaultStripFields, stripTopK: true, stripNulls: true}
 
 	t.Run("removes unsupported fields", func(t *testing.T) {
 		// Original body with unsupported fields
@@ -581,4 +1643,384 @@ func TestModifyRequestBody(t *testing.T) {
 		assert.JSONEq(t, expectedBody, string(modifiedBodyBytes))
 		assert.Equal(t, int64(len(modifiedBodyBytes)), req.ContentLength, "ContentLength was not updated correctly")
 	})
+
+	t.Run("rewrites aliased model name", func(t *testing.T) {
+		aliasProxy := &OpenAIProxy{logger: testLogger, modelAliases: map[string]string{"gpt-4o": "gemini-1.5-pro"}}
+		body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`
+		expectedBody := `{"model": "gemini-1.5-pro", "messages": [{"role": "user", "content": "hello"}]}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		err := aliasProxy.ModifyRequestBody(req)
+		require.NoError(t, err)
+		modifiedBodyBytes, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, expectedBody, string(modifiedBodyBytes))
+		assert.Equal(t, int64(len(modifiedBodyBytes)), req.ContentLength, "ContentLength was not updated correctly")
+	})
+
+	t.Run("passes through unmapped model name", func(t *testing.T) {
+		aliasProxy := &OpenAIProxy{logger: testLogger, modelAliases: map[string]string{"gpt-4o": "gemini-1.5-pro"}}
+		body := `{"model": "gemini-pro", "messages": [{"role": "user", "content": "hello"}]}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		err := aliasProxy.ModifyRequestBody(req)
+		require.NoError(t, err)
+		modifiedBodyBytes, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, body, string(modifiedBodyBytes))
+	})
+
+	t.Run("ignores aliases when model field is missing", func(t *testing.T) {
+		aliasProxy := &OpenAIProxy{logger: testLogger, modelAliases: map[string]string{"gpt-4o": "gemini-1.5-pro"}}
+		body := `{"messages": [{"role": "user", "content": "hello"}]}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		err := aliasProxy.ModifyRequestBody(req)
+		require.NoError(t, err)
+		modifiedBodyBytes, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, body, string(modifiedBodyBytes))
+	})
+
+	t.Run("strips unsupported embeddings fields", func(t *testing.T) {
+		originalBody := `{
+			"model": "text-embedding-004",
+			"input": "hello",
+			"dimensions": 256,
+			"encoding_format": "base64",
+			"user": "user-123"
+		}`
+		expectedBody := `{
+			"model": "text-embedding-004",
+			"input": "hello",
+			"dimensions": 256
+		}`
+
+		req := httptest.NewRequest("POST", "/v1beta/openai/embeddings", strings.NewReader(originalBody))
+		err := proxy.ModifyRequestBody(req)
+		require.NoError(t, err)
+
+		modifiedBodyBytes, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		assert.JSONEq(t, expectedBody, string(modifiedBodyBytes))
+		assert.Equal(t, int64(len(modifiedBodyBytes)), req.ContentLength, "ContentLength was not updated correctly")
+	})
+
+	t.Run("chat rules do not apply to embeddings", func(t *testing.T) {
+		body := `{"model": "text-embedding-004", "input": "hello", "n": 1}`
+		req := httptest.NewRequest("POST", "/v1beta/openai/embeddings", strings.NewReader(body))
+		err := proxy.ModifyRequestBody(req)
+		require.NoError(t, err)
+
+		modifiedBodyBytes, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, body, string(modifiedBodyBytes))
+	})
+
+	t.Run("embeddings rules do not apply to chat completions", func(t *testing.T) {
+		body := `{"model": "gemini-pro", "messages": [{"role": "user", "content": "hello"}], "user": "user-123"}`
+		req := httptest.NewRequest("POST", "/v1beta/openai/chat/completions", strings.NewReader(body))
+		err := proxy.ModifyRequestBody(req)
+		require.NoError(t, err)
+
+		modifiedBodyBytes, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, body, string(modifiedBodyBytes))
+	})
+}
+
+func TestModifyRequestBody_Configured(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	disabled := false
+
+	t.Run("custom strip list", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{StripFields: []string{"seed", "user"}}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-pro", "seed": 1, "user": "u", "frequency_penalty": 0.5, "top_k": 3}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"model": "gemini-pro", "frequency_penalty": 0.5}`, string(modified))
+	})
+
+	t.Run("empty strip list keeps fields", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{StripFields: []string{}}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-pro", "frequency_penalty": 0.5, "n": 2}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, body, string(modified))
+	})
+
+	t.Run("top_k and null stripping disabled", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{StripTopK: &disabled, StripNullFields: &disabled}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-pro", "top_k": 40, "max_tokens": null, "logprobs": true}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"model": "gemini-pro", "top_k": 40, "max_tokens": null}`, string(modified))
+	})
+
+	t.Run("tool fields kept only for tool models", func(t *testing.T) {
+		cfg := &config.Config{}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		for model, keep := range map[string]bool{
+			"gemini-2.5-flash":      true,
+			"models/gemini-1.5-pro": true,
+			"gemini-pro":            false,
+			"gemini-1.0-pro-vision": false,
+			"":                      false,
+		} {
+			body := `{"model": "` + model + `", "tools": [{"type": "function"}], "tool_choice": "auto", "n": 2}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			require.NoError(t, proxy.ModifyRequestBody(req))
+			modified, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+
+			var got map[string]interface{}
+			require.NoError(t, json.Unmarshal(modified, &got))
+			assert.NotContains(t, got, "n", model)
+			if keep {
+				assert.Contains(t, got, "tools", model)
+				assert.Contains(t, got, "tool_choice", model)
+			} else {
+				assert.NotContains(t, got, "tools", model)
+			}
+		}
+	})
+
+	t.Run("empty tool model list strips tools everywhere", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{ToolModels: []string{}}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-2.5-flash", "tools": [{"type": "function"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"model": "gemini-2.5-flash"}`, string(modified))
+	})
+}
+
+func TestModifyRequestBody_DefaultModel(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	cfg := &config.Config{Proxy: config.ProxyConfig{DefaultModel: "gemini-2.5-flash"}}
+	proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+	require.NoError(t, err)
+
+	modify := func(path, body string) string {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		return string(modified)
+	}
+
+	t.Run("injects the default when the model is missing", func(t *testing.T) {
+		for _, body := range []string{
+			`{"messages": [{"role": "user", "content": "hi"}]}`,
+			`{"model": "", "messages": [{"role": "user", "content": "hi"}]}`,
+			`{"model": null, "messages": [{"role": "user", "content": "hi"}]}`,
+		} {
+			assert.JSONEq(t, `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "hi"}]}`, modify("/v1beta/openai/chat/completions", body), body)
+		}
+	})
+
+	t.Run("injects the default into verbatim bodies", func(t *testing.T) {
+		body := `{"messages": [{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}]}]}`
+		var got map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal([]byte(modify("/v1beta/openai/chat/completions", body)), &got))
+		assert.Equal(t, `"gemini-2.5-flash"`, string(got["model"]))
+	})
+
+	t.Run("no-op when the model is present", func(t *testing.T) {
+		body := `{"model": "gemini-2.5-pro", "messages": [{"role": "user", "content": "hi"}]}`
+		assert.Equal(t, body, modify("/v1beta/openai/chat/completions", body))
+	})
+
+	t.Run("no-op for other endpoints", func(t *testing.T) {
+		body := `{"input": "hello"}`
+		assert.Equal(t, body, modify("/v1beta/openai/embeddings", body))
+	})
+
+	t.Run("no-op when not configured", func(t *testing.T) {
+		unconfigured, err := newOpenAIProxyWithURL(new(MockKeyManager), &config.Config{}, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		body := `{"messages": [{"role": "user", "content": "hi"}]}`
+		req := httptest.NewRequest("POST", "/v1beta/openai/chat/completions", strings.NewReader(body))
+		require.NoError(t, unconfigured.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(modified))
+	})
+}
+
+func TestModifyRequestBody_Verbatim(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	image := "data:image/png;base64," + strings.Repeat("iVBORw0KGgo+/=", 1<<16)
+
+	t.Run("large multimodal body passes through unmodified", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{VerbatimBodyBytes: 1024}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-2.5-flash", "top_k": 3, "n": 1, "seed": 12345678901234567890, "messages": [{"role": "user", "content": [` +
+			`{"type": "text", "text": "What is in <this> image?"}, {"type": "image_url", "image_url": {"url": "` + image + `"}}]}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(modified))
+	})
+
+	t.Run("small multimodal body passes through unmodified", func(t *testing.T) {
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), &config.Config{}, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-2.5-flash", "n": 1, "messages": [{"role": "user", "content": [{"type": "input_audio", "input_audio": {"data": "AAAA", "format": "wav"}}]}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(modified))
+	})
+
+	t.Run("media part type outside message content is still modified", func(t *testing.T) {
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), &config.Config{}, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		tools := `[{"type": "function", "function": {"name": "read", "parameters": {"type": "object", "properties": {"file": {"type": "string"}}}}}]`
+		messages := `[{"role": "user", "content": [{"type": "text", "text": "image_url"}]}]`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model": "gemini-2.5-flash", "n": 1, "tools": `+tools+`, "messages": `+messages+`}`))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"model": "gemini-2.5-flash", "tools": `+tools+`, "messages": `+messages+`}`, string(modified))
+	})
+
+	t.Run("large text body passes through unmodified", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{VerbatimBodyBytes: 64}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		body := `{"model": "gemini-2.5-flash", "n": 1, "messages": [{"role": "user", "content": "` + strings.Repeat("a", 128) + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(modified))
+	})
+
+	t.Run("disabled size check modifies large text bodies", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{VerbatimBodyBytes: -1}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		text := strings.Repeat("a", 8<<20)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model": "gemini-2.5-flash", "n": 1, "content": "`+text+`"}`))
+		require.NoError(t, proxy.ModifyRequestBody(req))
+		modified, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"model": "gemini-2.5-flash", "content": "`+text+`"}`, string(modified))
+	})
+
+	t.Run("model name is still rewritten", func(t *testing.T) {
+		cfg := &config.Config{Proxy: config.ProxyConfig{ModelAliases: map[string]string{"gpt-4o": "gemini-2.5-flash"}}}
+		proxy, err := newOpenAIProxyWithURL(new(MockKeyManager), cfg, "http://localhost", http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+
+		messages := `[{"role":"user","content":[{"type":"text","text":"<b>&</b>"},{"type":"image_url","image_url":{"url":"` + image + `"}}]}]`
+		for _, model := range []string{"gpt-4o", "models/gpt-4o"} {
+			body := `{"model": "` + model + `", "n": 1, "messages": ` + messages + `}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			require.NoError(t, proxy.ModifyRequestBody(req))
+			modified, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+
+			var got map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(modified, &got))
+			assert.Equal(t, `"gemini-2.5-flash"`, string(got["model"]), model)
+			assert.Equal(t, `1`, string(got["n"]), model)
+			assert.Equal(t, messages, string(got["messages"]), model)
+			assert.Equal(t, int64(len(modified)), req.ContentLength, model)
+		}
+	})
+}
+
+func TestOpenAIProxy_ResponseHeaders(t *testing.T) {
+	testLogger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Server", "scaffolding on HTTPServer2")
+		w.Header().Set("X-Goog-Request-Id", "abc")
+		if r.URL.Path == "/v1beta/openai/limited" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	newProxy := func(t *testing.T, proxyCfg config.ProxyConfig) *OpenAIProxy {
+		mockKM := new(MockKeyManager)
+		mockKM.On("GetAvailableKeyCount").Return(1)
+		mockKM.On("GetNextKey", "", mock.Anything).Return("key-good", nil)
+		mockKM.On("HandleKeySuccess", "key-good").Return()
+		mockKM.On("HandleKeyFailure", "key-good", http.StatusTooManyRequests, mock.Anything).Return()
+
+		proxyCfg.MaxRetryAttempts = 1
+		p, err := newOpenAIProxyWithURL(mockKM, &config.Config{Proxy: proxyCfg}, server.URL, http.DefaultTransport, testLogger)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("passes headers through by default", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newProxy(t, config.ProxyConfig{}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "scaffolding on HTTPServer2", rr.Header().Get("Server"))
+		assert.Equal(t, "abc", rr.Header().Get("X-Goog-Request-Id"))
+	})
+
+	t.Run("strips configured headers", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newProxy(t, config.ProxyConfig{StripResponseHeaders: []string{"server", "x-goog-*"}}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Server"))
+		assert.Empty(t, rr.Header().Get("X-Goog-Request-Id"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	})
+
+	t.Run("keeps only allowed headers", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newProxy(t, config.ProxyConfig{AllowResponseHeaders: []string{"X-Goog-Request-Id"}}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "abc", rr.Header().Get("X-Goog-Request-Id"))
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.Empty(t, rr.Header().Get("Server"))
+	})
+
+	t.Run("filters the headers of a failed retry", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newProxy(t, config.ProxyConfig{StripResponseHeaders: []string{"Retry-After"}}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/limited", strings.NewReader(`{}`)))
+
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Empty(t, rr.Header().Get("Retry-After"))
+	})
 }
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// mediaPartTypes are the OpenAI message content part types that carry inline media, usually
+// base64 encoded.
+var mediaPartTypes = map[string]bool{"image_url": true, "input_audio": true, "file": true}
+
+// forwardVerbatim reports whether body should skip the field stripping of ModifyRequestBody,
+// which decodes and re-encodes the whole body: bodies larger than verbatimBodyBytes and bodies
+// with inline media.
+func (p *OpenAIProxy) forwardVerbatim(body []byte) bool {
+	if p.verbatimBodyBytes > 0 && int64(len(body)) > p.verbatimBodyBytes {
+		return true
+	}
+	return hasMediaContent(body)
+}
+
+// contentPart is the part of an OpenAI message content part that hasMediaContent looks at.
+type contentPart struct {
+	Type string `json:"type"`
+}
+
+// contentParts decodes the types of a message's content parts. Plain string content has no
+// parts.
+type contentParts []contentPart
+
+func (c *contentParts) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '[' {
+		return nil
+	}
+	return json.Unmarshal(data, (*[]contentPart)(c))
+}
+
+// hasMediaContent reports whether any messages[].content[].type of body is a media part type.
+// Only the part types are decoded; bodies that are not valid JSON count as text.
+func hasMediaContent(body []byte) bool {
+	var request struct {
+		Messages []struct {
+			Content contentParts `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return false
+	}
+	for _, message := range request.Messages {
+		for _, part := range message.Content {
+			if mediaPartTypes[part.Type] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rewriteModelVerbatim applies the default model, "models/" prefix removal and aliasing of
+// ModifyRequestBody to a body forwarded verbatim. Bodies whose model needs no rewrite are left
+// untouched; otherwise only the top level is decoded, so the other fields, inline media
+// included, keep their bytes.
+func (p *OpenAIProxy) rewriteModelVerbatim(req *http.Request, body []byte) error {
+	var named struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &named); err != nil {
+		return nil
+	}
+	model := named.Model
+	if model == "" {
+		model = p.defaultModelFor(req.URL.Path)
+	}
+	model = p.resolveModel(model)
+	if model == "" || model == named.Model {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	rawModel, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to marshal model name: %w", err)
+	}
+	fields["model"] = rawModel
+
+	var buf bytes.Buffer
+	buf.Grow(len(body))
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return fmt.Errorf("failed to marshal modified request body: %w", err)
+	}
+	newBody := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	p.requestLogger(req).Debug("Rewrote model name of verbatim request body", "from", named.Model, "to", model)
+	req.Body = newBufferedBody(newBody)
+	req.ContentLength = int64(len(newBody))
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package requestlog keeps the most recent proxied requests in memory for troubleshooting.
+package requestlog
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Entry describes a single proxied request.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	KeySuffix string    `json:"key_suffix"`
+	Retries   int       `json:"retries"`
+	LatencyMS int64     `json:"latency_ms"`
+}
+
+// Buffer is a fixed-size ring buffer of entries, safe for concurrent use.
+// A nil *Buffer records nothing.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewBuffer returns a Buffer holding the last size entries, or nil if size is not positive.
+func NewBuffer(size int) *Buffer {
+	if size <= 0 {
+		return nil
+	}
+	return &Buffer{entries: make([]Entry, size)}
+}
+
+// Add records e, evicting the oldest entry when the buffer is full.
+func (b *Buffer) Add(e Entry) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Recent returns the recorded entries, newest first.
+func (b *Buffer) Recent() []Entry {
+	if b == nil {
+		return []Entry{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.next
+	if b.full {
+		n = len(b.entries)
+	}
+	recent := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		recent = append(recent, b.entries[(b.next-i+len(b.entries))%len(b.entries)])
+	}
+	return recent
+}
+
+type entryContextKey struct{}
+
+// WithEntry returns a copy of ctx carrying e, so transports can fill in key and retry details.
+func WithEntry(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, entryContextKey{}, e)
+}
+
+// FromContext returns the entry stored by WithEntry, or nil.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(entryContextKey{}).(*Entry)
+	return e
+}
+
+// Begin returns the entry an outer handler, such as the access log, stored in ctx with
+// WithEntry, or a new one, set up to describe a request to method and path made at start.
+func Begin(ctx context.Context, start time.Time, method, path string) *Entry {
+	e := FromContext(ctx)
+	if e == nil {
+		e = &Entry{}
+	}
+	*e = Entry{Time: start, Method: method, Path: path}
+	return e
+}
+
+// StatusWriter remembers the status code written to the wrapped ResponseWriter.
+type StatusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// NewStatusWriter wraps w.
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+// Status returns the status code written so far, or 0 if nothing was written.
+func (w *StatusWriter) Status() int {
+	return w.status
+}
+
+func (w *StatusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,89 @@
+package requestlog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuffer(t *testing.T) {
+	t.Run("returns entries newest first", func(t *testing.T) {
+		b := NewBuffer(5)
+		b.Add(Entry{Path: "/a"})
+		b.Add(Entry{Path: "/b"})
+
+		recent := b.Recent()
+		assert.Len(t, recent, 2)
+		assert.Equal(t, "/b", recent[0].Path)
+		assert.Equal(t, "/a", recent[1].Path)
+	})
+
+	t.Run("caps at the configured size", func(t *testing.T) {
+		b := NewBuffer(3)
+		for _, p := range []string{"/1", "/2", "/3", "/4", "/5"} {
+			b.Add(Entry{Path: p})
+		}
+
+		recent := b.Recent()
+		assert.Len(t, recent, 3)
+		assert.Equal(t, []string{"/5", "/4", "/3"}, []string{recent[0].Path, recent[1].Path, recent[2].Path})
+	})
+
+	t.Run("nil buffer is a no-op", func(t *testing.T) {
+		b := NewBuffer(0)
+		assert.Nil(t, b)
+		b.Add(Entry{Path: "/a"})
+		assert.Empty(t, b.Recent())
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		b := NewBuffer(10)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.Add(Entry{Path: "/x"})
+				b.Recent()
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, b.Recent(), 10)
+	})
+}
+
+func TestEntryContext(t *testing.T) {
+	assert.Nil(t, FromContext(context.Background()))
+
+	e := &Entry{}
+	FromContext(WithEntry(context.Background(), e)).Retries = 2
+	assert.Equal(t, 2, e.Retries)
+}
+
+func TestBegin(t *testing.T) {
+	start := time.Now()
+
+	e := Begin(context.Background(), start, "GET", "/a")
+	assert.Equal(t, Entry{Time: start, Method: "GET", Path: "/a"}, *e)
+
+	outer := &Entry{KeySuffix: "stale", Retries: 3}
+	e = Begin(WithEntry(context.Background(), outer), start, "POST", "/b")
+	assert.Same(t, outer, e, "the entry of an outer handler should be reused")
+	assert.Equal(t, Entry{Time: start, Method: "POST", Path: "/b"}, *outer)
+}
+
+func TestStatusWriter(t *testing.T) {
+	w := NewStatusWriter(httptest.NewRecorder())
+	assert.Equal(t, 0, w.Status())
+	w.Write([]byte("ok"))
+	assert.Equal(t, http.StatusOK, w.Status())
+
+	w = NewStatusWriter(httptest.NewRecorder())
+	w.WriteHeader(http.StatusBadGateway)
+	assert.Equal(t, http.StatusBadGateway, w.Status())
+}
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"log"
+	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
@@ -13,6 +14,8 @@ import (
 type Manager interface {
 	ReviveDisabledKeys()
 	CheckAllKeysHealth()
+	ResetDailyUsage()
+	ReloadKeys() (int, error)
 }
 
 type Scheduler struct {
@@ -31,6 +34,39 @@ func NewScheduler(db db.Service, cfg *config.Config, keyManager Manager) *Schedu
 	}
 }
 
+// Names of the jobs that can be triggered manually through Job.
+const (
+	JobRevival         = "revival"
+	JobHealthCheck     = "health-check"
+	JobResetUsage      = "reset-usage"
+	JobFailureDecay    = "failure-decay"
+	JobClientKeyExpiry = "client-key-expiry"
+	JobMonthlyReset    = "monthly-reset"
+	JobAutoPrune       = "auto-prune"
+)
+
+// Job returns the scheduled job with the given name so it can be run outside its schedule.
+func (s *Scheduler) Job(name string) (func(), bool) {
+	switch name {
+	case JobRevival:
+		return s.runKeyRevivalJob, true
+	case JobHealthCheck:
+		return s.runDailyHealthCheckJob, true
+	case JobResetUsage:
+		return s.runDailyUsageResetJob, true
+	case JobFailureDecay:
+		return s.runFailureDecayJob, true
+	case JobClientKeyExpiry:
+		return s.runClientKeyExpiryJob, true
+	case JobMonthlyReset:
+		return s.runMonthlyUsageResetJob, true
+	case JobAutoPrune:
+		return s.runAutoPruneJob, true
+	default:
+		return nil, false
+	}
+}
+
 func (s *Scheduler) Start() {
 	// Schedule periodic check to revive disabled Gemini keys
 	revivalInterval := "@every 10m" // Default to every 10 minutes
@@ -48,6 +84,56 @@ func (s *Scheduler) Start() {
 		log.Fatalf("Error scheduling daily health check job: %v", err)
 	}
 
+	// Schedule daily reset of per-key usage quotas
+	usageResetInterval := "@daily"
+	if s.config.Scheduler.UsageResetInterval != "" {
+		usageResetInterval = s.config.Scheduler.UsageResetInterval
+	}
+	_, err = s.c.AddFunc(usageResetInterval, s.runDailyUsageResetJob)
+	if err != nil {
+		log.Fatalf("Error scheduling daily usage reset job: %v", err)
+	}
+
+	// Schedule decay of failure counts for keys that have stopped failing
+	decayInterval := "@every 1h"
+	if s.config.Scheduler.FailureDecayInterval != "" {
+		decayInterval = s.config.Scheduler.FailureDecayInterval
+	}
+	_, err = s.c.AddFunc(decayInterval, s.runFailureDecayJob)
+	if err != nil {
+		log.Fatalf("Error scheduling failure decay job: %v", err)
+	}
+
+	// Schedule periodic expiry of client keys past their expiration date
+	expiryInterval := "@every 5m"
+	if s.config.Scheduler.ClientKeyExpiryInterval != "" {
+		expiryInterval = s.config.Scheduler.ClientKeyExpiryInterval
+	}
+	_, err = s.c.AddFunc(expiryInterval, s.runClientKeyExpiryJob)
+	if err != nil {
+		log.Fatalf("Error scheduling client key expiry job: %v", err)
+	}
+
+	// Schedule monthly reset of client key usage quotas, at midnight on the 1st. This is not
+	// configurable: MonthlyQuota is measured per calendar month, so scheduler.usage_reset_interval
+	// only applies to the daily Gemini key reset above.
+	_, err = s.c.AddFunc("@monthly", s.runMonthlyUsageResetJob)
+	if err != nil {
+		log.Fatalf("Error scheduling monthly usage reset job: %v", err)
+	}
+
+	// Schedule pruning of keys that keep failing revival, if enabled
+	if s.config.Scheduler.AutoPruneFailures > 0 {
+		pruneInterval := config.DefaultAutoPruneInterval
+		if s.config.Scheduler.AutoPruneInterval != "" {
+			pruneInterval = s.config.Scheduler.AutoPruneInterval
+		}
+		_, err = s.c.AddFunc(pruneInterval, s.runAutoPruneJob)
+		if err != nil {
+			log.Fatalf("Error scheduling auto-prune job: %v", err)
+		}
+	}
+
 	s.c.Start()
 }
 
@@ -61,6 +147,72 @@ func (s *Scheduler) runDailyHealthCheckJob() {
 	s.keyManager.CheckAllKeysHealth()
 }
 
+func (s *Scheduler) runDailyUsageResetJob() {
+	log.Println("Running daily job: Resetting daily key usage.")
+	if err := s.db.ResetDailyGeminiUsage(); err != nil {
+		log.Printf("Error resetting daily gemini usage: %v", err)
+		return
+	}
+	s.keyManager.ResetDailyUsage()
+}
+
+func (s *Scheduler) runMonthlyUsageResetJob() {
+	log.Println("Running monthly job: Resetting client key usage.")
+	if err := s.db.ResetMonthlyAPIUsage(); err != nil {
+		log.Printf("Error resetting monthly api usage: %v", err)
+	}
+}
+
+func (s *Scheduler) runFailureDecayJob() {
+	log.Println("Running scheduled job: Decaying failure counts of recovered keys.")
+	if err := s.db.DecayGeminiFailureCounts(s.config.Scheduler.FailureDecayWindowDuration()); err != nil {
+		log.Printf("Error decaying gemini failure counts: %v", err)
+		return
+	}
+	// Reload so the in-memory counts don't overwrite the decayed ones on the next failure.
+	if _, err := s.keyManager.ReloadKeys(); err != nil {
+		log.Printf("Error reloading keys after failure decay: %v", err)
+	}
+}
+
+func (s *Scheduler) runClientKeyExpiryJob() {
+	expired, err := s.db.ExpireStaleAPIKeys()
+	if err != nil {
+		log.Printf("Error expiring client keys: %v", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("Marked %d client key(s) as expired.", expired)
+	}
+}
+
+// runAutoPruneJob retires disabled keys that have failed scheduler.auto_prune_failures revivals
+// and have been disabled for scheduler.auto_prune_days.
+func (s *Scheduler) runAutoPruneJob() {
+	cfg := s.config.Scheduler
+	if cfg.AutoPruneFailures <= 0 {
+		return
+	}
+	days := cfg.AutoPruneDays
+	if days == 0 {
+		days = config.DefaultAutoPruneDays
+	}
+	disabledBefore := time.Now().AddDate(0, 0, -days)
+	pruned, err := s.db.PruneGeminiKeys(cfg.AutoPruneFailures, disabledBefore, cfg.AutoPruneAction == config.AutoPruneActionDelete)
+	if err != nil {
+		log.Printf("Error pruning gemini keys: %v", err)
+		return
+	}
+	if pruned == 0 {
+		return
+	}
+	log.Printf("Pruned %d gemini key(s) that kept failing revival.", pruned)
+	// Reload so the pruned keys are no longer tested, or written back, from memory.
+	if _, err := s.keyManager.ReloadKeys(); err != nil {
+		log.Printf("Error reloading keys after auto-prune: %v", err)
+	}
+}
+
 func (s *Scheduler) Stop() {
 	s.c.Stop()
 }
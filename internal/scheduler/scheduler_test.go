@@ -2,11 +2,13 @@ package scheduler
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ubuygold/gogemini/internal/config"
 	"github.com/ubuygold/gogemini/internal/db"
 	"github.com/ubuygold/gogemini/internal/model"
 
+	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -24,6 +26,15 @@ func (m *MockKeyManager) CheckAllKeysHealth() {
 	m.Called()
 }
 
+func (m *MockKeyManager) ResetDailyUsage() {
+	m.Called()
+}
+
+func (m *MockKeyManager) ReloadKeys() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
 // MockDBService is a mock implementation of the db.Service interface.
 type MockDBService struct {
 	mock.Mock
@@ -36,13 +47,22 @@ func (m *MockDBService) HandleGeminiKeyFailure(key string, threshold int) (bool,
 	return false, nil
 }
 func (m *MockDBService) CreateGeminiKey(key *model.GeminiKey) error { return nil }
-func (m *MockDBService) BatchAddGeminiKeys(keys []string) error     { return nil }
-func (m *MockDBService) BatchDeleteGeminiKeys(ids []uint) error     { return nil }
-func (m *MockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int) ([]model.GeminiKey, int64, error) {
+func (m *MockDBService) BatchAddGeminiKeys(keys []string, status string) (int64, error) {
+	return 0, nil
+}
+func (m *MockDBService) BatchUpdateGeminiKeyStatus(ids []uint, status string) (int64, error) {
+	return 0, nil
+}
+func (m *MockDBService) BatchDeleteGeminiKeys(ids []uint) error { return nil }
+func (m *MockDBService) ListGeminiKeys(page, limit int, statusFilter string, minFailureCount int, sortBy, sortOrder string) ([]model.GeminiKey, int64, error) {
 	return nil, 0, nil
 }
+func (m *MockDBService) GeminiKeyStatusCounts() (db.GeminiKeyCounts, error) {
+	return db.GeminiKeyCounts{}, nil
+}
 func (m *MockDBService) GetGeminiKey(id uint) (*model.GeminiKey, error)    { return nil, nil }
 func (m *MockDBService) UpdateGeminiKey(key *model.GeminiKey) error        { return nil }
+func (m *MockDBService) UpdateGeminiKeyState(key *model.GeminiKey) error   { return nil }
 func (m *MockDBService) DeleteGeminiKey(id uint) error                     { return nil }
 func (m *MockDBService) IncrementGeminiKeyUsageCount(key string) error     { return nil }
 func (m *MockDBService) UpdateGeminiKeyStatus(key, status string) error    { return nil }
@@ -53,6 +73,42 @@ func (m *MockDBService) UpdateAPIKey(key *model.APIKey) error              { ret
 func (m *MockDBService) DeleteAPIKey(id uint) error                        { return nil }
 func (m *MockDBService) IncrementAPIKeyUsageCount(key string) error        { return nil }
 func (m *MockDBService) FindAPIKeyByKey(key string) (*model.APIKey, error) { return nil, nil }
+func (m *MockDBService) ListDeletedGeminiKeys() ([]model.GeminiKey, error) { return nil, nil }
+func (m *MockDBService) RestoreGeminiKey(id uint) error                    { return nil }
+func (m *MockDBService) PurgeGeminiKey(id uint) error                      { return nil }
+func (m *MockDBService) ListAPIKeysPaged(page, limit int, statusFilter string) ([]model.APIKey, int64, error) {
+	return nil, 0, nil
+}
+func (m *MockDBService) StreamGeminiKeys(fn func(model.GeminiKey) error) error { return nil }
+func (m *MockDBService) ResetDailyGeminiUsage() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockDBService) DecayGeminiFailureCounts(olderThan time.Duration) error {
+	args := m.Called(olderThan)
+	return args.Error(0)
+}
+func (m *MockDBService) BatchIncrementGeminiUsage(counts map[string]int64) error      { return nil }
+func (m *MockDBService) BatchIncrementGeminiTokenUsage(tokens map[string]int64) error { return nil }
+func (m *MockDBService) ExpireStaleAPIKeys() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDBService) ResetAllGeminiFailureCounts() error     { return nil }
+func (m *MockDBService) Ping() error                            { return nil }
+func (m *MockDBService) PurgeDeletedGeminiKeys() (int64, error) { return 0, nil }
+func (m *MockDBService) ResetMonthlyAPIUsage() error {
+	args := m.Called()
+	return args.Error(0)
+}
+func (m *MockDBService) FindGeminiKeysBySuffix(suffix string) ([]model.GeminiKey, error) {
+	return nil, nil
+}
+func (m *MockDBService) FindAPIKeysBySuffix(suffix string) ([]model.APIKey, error) { return nil, nil }
+func (m *MockDBService) PruneGeminiKeys(minRevivalFailures int, disabledBefore time.Time, softDelete bool) (int64, error) {
+	args := m.Called(minRevivalFailures, disabledBefore, softDelete)
+	return args.Get(0).(int64), args.Error(1)
+}
 
 func TestScheduler_RunKeyRevivalJob(t *testing.T) {
 	mockDB := new(MockDBService)
@@ -82,13 +138,31 @@ func TestScheduler_StartStop(t *testing.T) {
 	scheduler.Start()
 	assert.NotNil(t, scheduler.c)
 	entries := scheduler.c.Entries()
-	assert.Len(t, entries, 2)
+	assert.Len(t, entries, 6)
 
 	scheduler.Stop()
 	// After stopping, the context of the cron scheduler should be done.
 	<-scheduler.c.Stop().Done()
 }
 
+func TestScheduler_UsageResetInterval(t *testing.T) {
+	testConfig := &config.Config{
+		Scheduler: config.SchedulerConfig{UsageResetInterval: "@every 90m"},
+	}
+	scheduler := NewScheduler(new(MockDBService), testConfig, new(MockKeyManager))
+
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	var found bool
+	for _, entry := range scheduler.c.Entries() {
+		if every, ok := entry.Schedule.(cron.ConstantDelaySchedule); ok && every.Delay == 90*time.Minute {
+			found = true
+		}
+	}
+	assert.True(t, found, "the usage reset job should run on the configured schedule")
+}
+
 func TestScheduler_RunDailyHealthCheckJob(t *testing.T) {
 	mockDB := new(MockDBService)
 	mockKM := new(MockKeyManager)
@@ -102,3 +176,157 @@ func TestScheduler_RunDailyHealthCheckJob(t *testing.T) {
 
 	mockKM.AssertExpectations(t)
 }
+
+func TestScheduler_RunDailyUsageResetJob(t *testing.T) {
+	testConfig := &config.Config{}
+
+	t.Run("resets database and in-memory usage", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockKM := new(MockKeyManager)
+		scheduler := NewScheduler(mockDB, testConfig, mockKM)
+
+		mockDB.On("ResetDailyGeminiUsage").Return(nil).Once()
+		mockKM.On("ResetDailyUsage").Return().Once()
+
+		scheduler.runDailyUsageResetJob()
+
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("skips in-memory reset when database reset fails", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockKM := new(MockKeyManager)
+		scheduler := NewScheduler(mockDB, testConfig, mockKM)
+
+		mockDB.On("ResetDailyGeminiUsage").Return(assert.AnError).Once()
+
+		scheduler.runDailyUsageResetJob()
+
+		mockDB.AssertExpectations(t)
+		mockKM.AssertNotCalled(t, "ResetDailyUsage")
+	})
+}
+
+func TestScheduler_RunFailureDecayJob(t *testing.T) {
+	t.Run("decays with the configured window and reloads keys", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockKM := new(MockKeyManager)
+		testConfig := &config.Config{Scheduler: config.SchedulerConfig{FailureDecayWindow: "6h"}}
+		scheduler := NewScheduler(mockDB, testConfig, mockKM)
+
+		mockDB.On("DecayGeminiFailureCounts", 6*time.Hour).Return(nil).Once()
+		mockKM.On("ReloadKeys").Return(2, nil).Once()
+
+		scheduler.runFailureDecayJob()
+
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("uses the default window and skips reload on error", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockKM := new(MockKeyManager)
+		scheduler := NewScheduler(mockDB, &config.Config{}, mockKM)
+
+		mockDB.On("DecayGeminiFailureCounts", config.DefaultFailureDecayWindow).Return(assert.AnError).Once()
+
+		scheduler.runFailureDecayJob()
+
+		mockDB.AssertExpectations(t)
+		mockKM.AssertNotCalled(t, "ReloadKeys")
+	})
+}
+
+func TestScheduler_RunClientKeyExpiryJob(t *testing.T) {
+	mockDB := new(MockDBService)
+	mockKM := new(MockKeyManager)
+	scheduler := NewScheduler(mockDB, &config.Config{}, mockKM)
+
+	mockDB.On("ExpireStaleAPIKeys").Return(int64(2), nil).Once()
+
+	scheduler.runClientKeyExpiryJob()
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestScheduler_RunMonthlyUsageResetJob(t *testing.T) {
+	mockDB := new(MockDBService)
+	scheduler := NewScheduler(mockDB, &config.Config{}, new(MockKeyManager))
+
+	mockDB.On("ResetMonthlyAPIUsage").Return(nil).Once()
+
+	scheduler.runMonthlyUsageResetJob()
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestScheduler_RunAutoPruneJob(t *testing.T) {
+	t.Run("prunes with the configured criteria and reloads keys", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockKM := new(MockKeyManager)
+		testConfig := &config.Config{Scheduler: config.SchedulerConfig{AutoPruneFailures: 3, AutoPruneDays: 5, AutoPruneAction: config.AutoPruneActionDelete}}
+		scheduler := NewScheduler(mockDB, testConfig, mockKM)
+
+		cutoff := time.Now().AddDate(0, 0, -5)
+		withinCutoff := mock.MatchedBy(func(before time.Time) bool {
+			return before.Sub(cutoff).Abs() < time.Minute
+		})
+		mockDB.On("PruneGeminiKeys", 3, withinCutoff, true).Return(int64(1), nil).Once()
+		mockKM.On("ReloadKeys").Return(4, nil).Once()
+
+		scheduler.runAutoPruneJob()
+
+		mockDB.AssertExpectations(t)
+		mockKM.AssertExpectations(t)
+	})
+
+	t.Run("skips reload when nothing was pruned", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		mockKM := new(MockKeyManager)
+		testConfig := &config.Config{Scheduler: config.SchedulerConfig{AutoPruneFailures: 3, AutoPruneAction: config.AutoPruneActionDead}}
+		scheduler := NewScheduler(mockDB, testConfig, mockKM)
+
+		mockDB.On("PruneGeminiKeys", 3, mock.Anything, false).Return(int64(0), nil).Once()
+
+		scheduler.runAutoPruneJob()
+
+		mockDB.AssertExpectations(t)
+		mockKM.AssertNotCalled(t, "ReloadKeys")
+	})
+
+	t.Run("does nothing when disabled", func(t *testing.T) {
+		mockDB := new(MockDBService)
+		scheduler := NewScheduler(mockDB, &config.Config{}, new(MockKeyManager))
+
+		scheduler.runAutoPruneJob()
+
+		mockDB.AssertNotCalled(t, "PruneGeminiKeys", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("is scheduled only when enabled", func(t *testing.T) {
+		scheduler := NewScheduler(new(MockDBService), &config.Config{Scheduler: config.SchedulerConfig{AutoPruneFailures: 3}}, new(MockKeyManager))
+		scheduler.Start()
+		defer scheduler.Stop()
+		assert.Len(t, scheduler.c.Entries(), 7)
+	})
+}
+
+func TestScheduler_Job(t *testing.T) {
+	mockKM := new(MockKeyManager)
+	scheduler := NewScheduler(new(MockDBService), &config.Config{}, mockKM)
+
+	mockKM.On("ReviveDisabledKeys").Return().Once()
+	job, ok := scheduler.Job(JobRevival)
+	assert.True(t, ok)
+	job()
+	mockKM.AssertExpectations(t)
+
+	for _, name := range []string{JobHealthCheck, JobResetUsage, JobFailureDecay, JobClientKeyExpiry, JobMonthlyReset, JobAutoPrune} {
+		_, ok := scheduler.Job(name)
+		assert.True(t, ok, name)
+	}
+
+	_, ok = scheduler.Job("unknown")
+	assert.False(t, ok)
+}
@@ -0,0 +1,61 @@
+// Package timeout bounds how long the proxies wait for an upstream response to start.
+package timeout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrFirstByte is the cancellation cause of a request whose upstream response did not start in time.
+var ErrFirstByte = errors.New("upstream did not respond before the request timeout")
+
+// FirstByte cancels r's context with ErrFirstByte unless a response starts within d.
+// The deadline is lifted as soon as the returned writer sees the response headers, so
+// long-running streams are not cut off. The returned cancel func must be called when the
+// request is done. A non-positive d disables the timeout.
+func FirstByte(w http.ResponseWriter, r *http.Request, d time.Duration) (http.ResponseWriter, *http.Request, context.CancelFunc) {
+	if d <= 0 {
+		return w, r, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	timer := time.AfterFunc(d, func() { cancel(ErrFirstByte) })
+	tw := &firstByteWriter{ResponseWriter: w, timer: timer}
+	return tw, r.WithContext(ctx), func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Exceeded reports whether r was cancelled because its upstream response did not start in time.
+func Exceeded(r *http.Request) bool {
+	return errors.Is(context.Cause(r.Context()), ErrFirstByte)
+}
+
+// firstByteWriter stops the first-byte timer once the response starts.
+type firstByteWriter struct {
+	http.ResponseWriter
+	timer *time.Timer
+	once  sync.Once
+}
+
+func (w *firstByteWriter) started() {
+	w.once.Do(func() { w.timer.Stop() })
+}
+
+func (w *firstByteWriter) WriteHeader(status int) {
+	w.started()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *firstByteWriter) Write(b []byte) (int, error) {
+	w.started()
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *firstByteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,45 @@
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstByte(t *testing.T) {
+	t.Run("cancels when no response starts in time", func(t *testing.T) {
+		_, r, cancel := FirstByte(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), 10*time.Millisecond)
+		defer cancel()
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("context was not cancelled")
+		}
+		assert.True(t, Exceeded(r))
+	})
+
+	t.Run("lifts the deadline once headers are written", func(t *testing.T) {
+		w, r, cancel := FirstByte(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), 10*time.Millisecond)
+		defer cancel()
+
+		w.WriteHeader(http.StatusOK)
+		time.Sleep(30 * time.Millisecond)
+
+		assert.NoError(t, r.Context().Err())
+		assert.False(t, Exceeded(r))
+	})
+
+	t.Run("disabled for non-positive durations", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w, r, cancel := FirstByte(rec, req, 0)
+		cancel()
+
+		assert.Same(t, rec, w)
+		assert.Same(t, req, r)
+	})
+}
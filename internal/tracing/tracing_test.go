@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ubuygold/gogemini/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	t.Run("disabled leaves the global provider untouched", func(t *testing.T) {
+		before := otel.GetTracerProvider()
+
+		shutdown, err := Setup(context.Background(), config.TracingConfig{Enabled: false})
+		require.NoError(t, err)
+		assert.Equal(t, before, otel.GetTracerProvider())
+		assert.NoError(t, shutdown(context.Background()))
+	})
+
+	t.Run("enabled installs a provider", func(t *testing.T) {
+		before := otel.GetTracerProvider()
+		t.Cleanup(func() { otel.SetTracerProvider(before) })
+
+		shutdown, err := Setup(context.Background(), config.TracingConfig{
+			Enabled:     true,
+			Endpoint:    "127.0.0.1:4318",
+			Insecure:    true,
+			ServiceName: "gogemini-test",
+		})
+		require.NoError(t, err)
+		assert.NotEqual(t, before, otel.GetTracerProvider())
+		// Nothing was recorded, so shutting down must not need to reach the collector.
+		assert.NoError(t, shutdown(context.Background()))
+	})
+}
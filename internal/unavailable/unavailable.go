@@ -0,0 +1,35 @@
+// Package unavailable answers proxy requests that could not be given a Gemini key.
+package unavailable
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ubuygold/gogemini/internal/apierror"
+	"github.com/ubuygold/gogemini/internal/keymanager"
+)
+
+// Write answers a request for which the key manager returned err instead of a key. Having no
+// keys at all is reported apart from keys that are only disabled for a while, which come with a
+// Retry-After hint when the manager knows when the first one is re-enabled, from key selection
+// being paused by an operator and from the server shutting down.
+func Write(w http.ResponseWriter, err error) {
+	var disabled *keymanager.KeysDisabledError
+	switch {
+	case errors.As(err, &disabled):
+		if disabled.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(disabled.RetryAfter.Seconds()))))
+		}
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeKeysDisabled, "All Gemini API keys are temporarily disabled, retry later")
+	case errors.Is(err, keymanager.ErrNoKeys):
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeNoKeysConfigured, "No active Gemini API keys are configured; add or activate keys through the admin API")
+	case errors.Is(err, keymanager.ErrPaused):
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeKeySelectionPaused, "Key selection is paused by an operator, retry later")
+	case errors.Is(err, keymanager.ErrShuttingDown):
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeShuttingDown, "The server is shutting down, retry on another instance")
+	default:
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.TypeServerError, apierror.CodeNoAvailableKeys, "Service temporarily unavailable")
+	}
+}
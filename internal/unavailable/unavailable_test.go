@@ -0,0 +1,45 @@
+package unavailable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ubuygold/gogemini/internal/apierror"
+	"github.com/ubuygold/gogemini/internal/keymanager"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		code       string
+		retryAfter string
+	}{
+		{"no keys", fmt.Errorf("%w in group %q", keymanager.ErrNoKeys, "team-a"), apierror.CodeNoKeysConfigured, ""},
+		{"disabled keys", &keymanager.KeysDisabledError{RetryAfter: 1500 * time.Millisecond}, apierror.CodeKeysDisabled, "2"},
+		{"disabled keys without re-enable time", &keymanager.KeysDisabledError{}, apierror.CodeKeysDisabled, ""},
+		{"paused", keymanager.ErrPaused, apierror.CodeKeySelectionPaused, ""},
+		{"shutting down", keymanager.ErrShuttingDown, apierror.CodeShuttingDown, ""},
+		{"other", errors.New("database is locked"), apierror.CodeNoAvailableKeys, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			Write(rr, tc.err)
+
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+			assert.Equal(t, tc.retryAfter, rr.Header().Get("Retry-After"))
+			var resp apierror.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, tc.code, resp.Error.Code)
+		})
+	}
+}